@@ -0,0 +1,246 @@
+package ahandlers
+
+import (
+	"github.com/dropbox/godropbox/errors"
+	"github.com/gin-gonic/gin"
+	"github.com/pritunl/pritunl-cloud/audit"
+	"github.com/pritunl/pritunl-cloud/authorizer"
+	"github.com/pritunl/pritunl-cloud/console"
+	"github.com/pritunl/pritunl-cloud/database"
+	"github.com/pritunl/pritunl-cloud/demo"
+	"github.com/pritunl/pritunl-cloud/errortypes"
+	"github.com/pritunl/pritunl-cloud/instance"
+	"github.com/pritunl/pritunl-cloud/utils"
+)
+
+type consoleTokenData struct {
+	Token string `json:"token"`
+}
+
+func instanceConsoleGet(c *gin.Context) {
+	if demo.Blocked(c) {
+		return
+	}
+
+	db := c.MustGet("db").(*database.Database)
+	authr := c.MustGet("authorizer").(*authorizer.Authorizer)
+
+	instanceId, ok := utils.ParseObjectId(c.Param("instance_id"))
+	if !ok {
+		utils.AbortWithStatus(c, 400)
+		return
+	}
+
+	usr, err := authr.GetUser(db)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	inst, err := instance.Get(db, instanceId)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	conn, err := console.Connect(db, console.Serial, inst)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	err = audit.New(
+		db,
+		c.Request,
+		usr.Id,
+		audit.AdminConsole,
+		audit.Fields{
+			"console":     console.Serial,
+			"instance_id": inst.Id,
+		},
+	)
+	if err != nil {
+		conn.Close()
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	wsConn, err := console.Upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		conn.Close()
+		err = &errortypes.RequestError{
+			errors.Wrap(err, "ahandlers: Failed to upgrade request"),
+		}
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	console.Bridge(wsConn, conn)
+}
+
+func instanceConsoleLogGet(c *gin.Context) {
+	if demo.Blocked(c) {
+		return
+	}
+
+	db := c.MustGet("db").(*database.Database)
+	authr := c.MustGet("authorizer").(*authorizer.Authorizer)
+
+	instanceId, ok := utils.ParseObjectId(c.Param("instance_id"))
+	if !ok {
+		utils.AbortWithStatus(c, 400)
+		return
+	}
+
+	usr, err := authr.GetUser(db)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	inst, err := instance.Get(db, instanceId)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	data, err := console.ReadSerialLog(db, inst)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	err = audit.New(
+		db,
+		c.Request,
+		usr.Id,
+		audit.AdminConsole,
+		audit.Fields{
+			"console":     console.SerialLog,
+			"instance_id": inst.Id,
+		},
+	)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	c.Data(200, "text/plain; charset=utf-8", data)
+}
+
+func instanceVncPost(c *gin.Context) {
+	if demo.Blocked(c) {
+		return
+	}
+
+	db := c.MustGet("db").(*database.Database)
+	authr := c.MustGet("authorizer").(*authorizer.Authorizer)
+
+	instanceId, ok := utils.ParseObjectId(c.Param("instance_id"))
+	if !ok {
+		utils.AbortWithStatus(c, 400)
+		return
+	}
+
+	usr, err := authr.GetUser(db)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	inst, err := instance.Get(db, instanceId)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	if !inst.Vnc {
+		errData := &errortypes.ErrorData{
+			Error:   "vnc_disabled",
+			Message: "Instance VNC is not enabled",
+		}
+		c.JSON(400, errData)
+		return
+	}
+
+	tkn, err := console.NewSessionToken(db, inst.Id, usr.Id)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	c.JSON(200, &consoleTokenData{
+		Token: tkn.Id,
+	})
+}
+
+func instanceVncGet(c *gin.Context) {
+	if demo.Blocked(c) {
+		return
+	}
+
+	db := c.MustGet("db").(*database.Database)
+	authr := c.MustGet("authorizer").(*authorizer.Authorizer)
+
+	instanceId, ok := utils.ParseObjectId(c.Param("instance_id"))
+	if !ok {
+		utils.AbortWithStatus(c, 400)
+		return
+	}
+
+	usr, err := authr.GetUser(db)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	_, err = console.ConsumeSession(db, c.Query("token"), instanceId, usr.Id)
+	if err != nil {
+		if _, ok := err.(*database.NotFoundError); ok {
+			utils.AbortWithStatus(c, 401)
+		} else {
+			utils.AbortWithError(c, 500, err)
+		}
+		return
+	}
+
+	inst, err := instance.Get(db, instanceId)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	conn, err := console.Connect(db, console.Vnc, inst)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	err = audit.New(
+		db,
+		c.Request,
+		usr.Id,
+		audit.AdminConsole,
+		audit.Fields{
+			"console":     console.Vnc,
+			"instance_id": inst.Id,
+		},
+	)
+	if err != nil {
+		conn.Close()
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	wsConn, err := console.Upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		conn.Close()
+		err = &errortypes.RequestError{
+			errors.Wrap(err, "ahandlers: Failed to upgrade request"),
+		}
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	console.Bridge(wsConn, conn)
+}
@@ -37,6 +37,9 @@ type diskData struct {
 	State            string             `json:"state"`
 	Size             int                `json:"size"`
 	Backup           bool               `json:"backup"`
+	BackupPolicy     primitive.ObjectID `json:"backup_policy"`
+	IopsLimit        int                `json:"iops_limit"`
+	BandwidthLimit   int                `json:"bandwidth_limit"`
 }
 
 type disksMultiData struct {
@@ -85,6 +88,9 @@ func diskPut(c *gin.Context) {
 		"delete_protection",
 		"index",
 		"backup",
+		"backup_policy",
+		"iops_limit",
+		"bandwidth_limit",
 	)
 
 	dsk.Name = dta.Name
@@ -93,13 +99,16 @@ func diskPut(c *gin.Context) {
 	dsk.DeleteProtection = dta.DeleteProtection
 	dsk.Index = dta.Index
 	dsk.Backup = dta.Backup
+	dsk.BackupPolicy = dta.BackupPolicy
+	dsk.IopsLimit = dta.IopsLimit
+	dsk.BandwidthLimit = dta.BandwidthLimit
 
 	if dsk.State == disk.Available && dta.State == disk.Snapshot {
 		dsk.State = disk.Snapshot
 	} else if dsk.State == disk.Available && dta.State == disk.Backup {
 		dsk.State = disk.Backup
 	} else if dta.State == disk.Restore {
-		if dsk.State != disk.Available {
+		if dsk.State != disk.Available && dsk.State != disk.Failed {
 			errData := &errortypes.ErrorData{
 				Error:   "disk_restore_active",
 				Message: "Disk restore already active",
@@ -220,6 +229,9 @@ func diskPost(c *gin.Context) {
 		Backing:          dta.Backing,
 		Size:             dta.Size,
 		Backup:           dta.Backup,
+		BackupPolicy:     dta.BackupPolicy,
+		IopsLimit:        dta.IopsLimit,
+		BandwidthLimit:   dta.BandwidthLimit,
 	}
 
 	errData, err := dsk.Validate(db)
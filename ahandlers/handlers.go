@@ -115,6 +115,20 @@ func Register(engine *gin.Engine) {
 	csrfGroup.DELETE("/firewall", firewallsDelete)
 	csrfGroup.DELETE("/firewall/:firewall_id", firewallDelete)
 
+	csrfGroup.GET("/backup_policy", backupPoliciesGet)
+	csrfGroup.GET("/backup_policy/:backup_policy_id", backupPolicyGet)
+	csrfGroup.PUT("/backup_policy/:backup_policy_id", backupPolicyPut)
+	csrfGroup.POST("/backup_policy", backupPolicyPost)
+	csrfGroup.DELETE("/backup_policy", backupPoliciesDelete)
+	csrfGroup.DELETE("/backup_policy/:backup_policy_id", backupPolicyDelete)
+
+	csrfGroup.GET("/placement", placementsGet)
+	csrfGroup.GET("/placement/:placement_id", placementGet)
+	csrfGroup.PUT("/placement/:placement_id", placementPut)
+	csrfGroup.POST("/placement", placementPost)
+	csrfGroup.DELETE("/placement", placementsDelete)
+	csrfGroup.DELETE("/placement/:placement_id", placementDelete)
+
 	csrfGroup.GET("/image", imagesGet)
 	csrfGroup.GET("/image/:image_id", imageGet)
 	csrfGroup.PUT("/image/:image_id", imagePut)
@@ -124,7 +138,15 @@ func Register(engine *gin.Engine) {
 	csrfGroup.GET("/instance", instancesGet)
 	csrfGroup.PUT("/instance", instancesPut)
 	csrfGroup.GET("/instance/:instance_id", instanceGet)
+	csrfGroup.GET("/instance/:instance_id/metrics", instanceMetricsGet)
+	csrfGroup.GET("/instance/:instance_id/console", instanceConsoleGet)
+	csrfGroup.GET("/instance/:instance_id/console/log",
+		instanceConsoleLogGet)
+	csrfGroup.POST("/instance/:instance_id/vnc", instanceVncPost)
+	csrfGroup.GET("/instance/:instance_id/vnc", instanceVncGet)
+	csrfGroup.POST("/instance/:instance_id/agent", instanceAgentPost)
 	csrfGroup.PUT("/instance/:instance_id", instancePut)
+	csrfGroup.PUT("/instance/:instance_id/migrate", instanceMigratePut)
 	csrfGroup.POST("/instance", instancePost)
 	csrfGroup.DELETE("/instance", instancesDelete)
 	csrfGroup.DELETE("/instance/:instance_id", instanceDelete)
@@ -145,6 +167,9 @@ func Register(engine *gin.Engine) {
 	csrfGroup.PUT("/organization/:org_id", organizationPut)
 	csrfGroup.POST("/organization", organizationPost)
 	csrfGroup.DELETE("/organization/:org_id", organizationDelete)
+	csrfGroup.POST("/organization/:org_id/data_key", organizationDataKeyPost)
+
+	csrfGroup.POST("/data_key/master", dataKeyMasterPost)
 
 	csrfGroup.GET("/policy", policiesGet)
 	csrfGroup.GET("/policy/:policy_id", policyGet)
@@ -21,8 +21,9 @@ import (
 type imageData struct {
 	Id           primitive.ObjectID `json:"id"`
 	Name         string             `json:"name"`
-	Comment        string             `json:"comment"`
+	Comment      string             `json:"comment"`
 	Organization primitive.ObjectID `json:"organization"`
+	Firmware     string             `json:"firmware"`
 }
 
 type imagesData struct {
@@ -59,11 +60,13 @@ func imagePut(c *gin.Context) {
 	img.Name = dta.Name
 	img.Comment = dta.Comment
 	img.Organization = dta.Organization
+	img.Firmware = dta.Firmware
 
 	fields := set.NewSet(
 		"name",
 		"comment",
 		"organization",
+		"firmware",
 	)
 
 	errData, err := img.Validate(db)
@@ -101,12 +104,19 @@ func imageDelete(c *gin.Context) {
 		return
 	}
 
-	err := data.DeleteImage(db, imageId)
+	chain := c.Query("chain") == "true"
+
+	errData, err := data.DeleteImage(db, imageId, chain)
 	if err != nil {
 		utils.AbortWithError(c, 500, err)
 		return
 	}
 
+	if errData != nil {
+		c.JSON(400, errData)
+		return
+	}
+
 	event.PublishDispatch(db, "image.change")
 
 	c.JSON(200, nil)
@@ -126,12 +136,19 @@ func imagesDelete(c *gin.Context) {
 		return
 	}
 
-	err = data.DeleteImages(db, dta)
+	chain := c.Query("chain") == "true"
+
+	errData, err := data.DeleteImages(db, dta, chain)
 	if err != nil {
 		utils.AbortWithError(c, 500, err)
 		return
 	}
 
+	if errData != nil {
+		c.JSON(400, errData)
+		return
+	}
+
 	event.PublishDispatch(db, "image.change")
 
 	c.JSON(200, nil)
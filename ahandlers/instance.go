@@ -17,6 +17,9 @@ import (
 	"github.com/pritunl/pritunl-cloud/event"
 	"github.com/pritunl/pritunl-cloud/image"
 	"github.com/pritunl/pritunl-cloud/instance"
+	"github.com/pritunl/pritunl-cloud/metric"
+	"github.com/pritunl/pritunl-cloud/node"
+	"github.com/pritunl/pritunl-cloud/scheduler"
 	"github.com/pritunl/pritunl-cloud/storage"
 	"github.com/pritunl/pritunl-cloud/usb"
 	"github.com/pritunl/pritunl-cloud/utils"
@@ -24,28 +27,53 @@ import (
 )
 
 type instanceData struct {
-	Id               primitive.ObjectID `json:"id"`
-	Organization     primitive.ObjectID `json:"organization"`
-	Zone             primitive.ObjectID `json:"zone"`
-	Vpc              primitive.ObjectID `json:"vpc"`
-	Subnet           primitive.ObjectID `json:"subnet"`
-	Node             primitive.ObjectID `json:"node"`
-	Image            primitive.ObjectID `json:"image"`
-	ImageBacking     bool               `json:"image_backing"`
-	Domain           primitive.ObjectID `json:"domain"`
-	Name             string             `json:"name"`
-	Comment          string             `json:"comment"`
-	State            string             `json:"state"`
-	DeleteProtection bool               `json:"delete_protection"`
-	InitDiskSize     int                `json:"init_disk_size"`
-	Memory           int                `json:"memory"`
-	Processors       int                `json:"processors"`
-	NetworkRoles     []string           `json:"network_roles"`
-	UsbDevices       []*usb.Device      `json:"usb_devices"`
-	Vnc              bool               `json:"vnc"`
-	NoPublicAddress  bool               `json:"no_public_address"`
-	NoHostAddress    bool               `json:"no_host_address"`
-	Count            int                `json:"count"`
+	Id               primitive.ObjectID  `json:"id"`
+	Organization     primitive.ObjectID  `json:"organization"`
+	Datacenter       primitive.ObjectID  `json:"datacenter"`
+	Zone             primitive.ObjectID  `json:"zone"`
+	Vpc              primitive.ObjectID  `json:"vpc"`
+	Subnet           primitive.ObjectID  `json:"subnet"`
+	Node             primitive.ObjectID  `json:"node"`
+	Image            primitive.ObjectID  `json:"image"`
+	ImageBacking     bool                `json:"image_backing"`
+	Domain           primitive.ObjectID  `json:"domain"`
+	Placement        primitive.ObjectID  `json:"placement"`
+	Strategy         string              `json:"strategy"`
+	Hypervisor       string              `json:"hypervisor"`
+	HighAvailability bool                `json:"high_availability"`
+	DrainPolicy      string              `json:"drain_policy"`
+	Name             string              `json:"name"`
+	Comment          string              `json:"comment"`
+	UserData         string              `json:"user_data"`
+	PreFreezeHook    string              `json:"pre_freeze_hook"`
+	PostThawHook     string              `json:"post_thaw_hook"`
+	State            string              `json:"state"`
+	DeleteProtection bool                `json:"delete_protection"`
+	InitDiskSize     int                 `json:"init_disk_size"`
+	Memory           int                 `json:"memory"`
+	Processors       int                 `json:"processors"`
+	MaxMemory        int                 `json:"max_memory"`
+	MaxProcessors    int                 `json:"max_processors"`
+	NetworkRoles     []string            `json:"network_roles"`
+	Adapters         []*instance.Adapter `json:"adapters"`
+	UsbDevices       []*usb.Device       `json:"usb_devices"`
+	Vnc              bool                `json:"vnc"`
+	Firmware         string              `json:"firmware"`
+	Tpm              bool                `json:"tpm"`
+	CpuModel         string              `json:"cpu_model"`
+	MachineType      string              `json:"machine_type"`
+	Sockets          int                 `json:"sockets"`
+	Cores            int                 `json:"cores"`
+	Threads          int                 `json:"threads"`
+	NestedVirt       bool                `json:"nested_virt"`
+	DedicatedCpus    bool                `json:"dedicated_cpus"`
+	NumaLocal        bool                `json:"numa_local"`
+	Hugepages        bool                `json:"hugepages"`
+	NetworkRateIn    int                 `json:"network_rate_in"`
+	NetworkRateOut   int                 `json:"network_rate_out"`
+	NoPublicAddress  bool                `json:"no_public_address"`
+	NoHostAddress    bool                `json:"no_host_address"`
+	Count            int                 `json:"count"`
 }
 
 type instanceMultiData struct {
@@ -53,6 +81,10 @@ type instanceMultiData struct {
 	State string               `json:"state"`
 }
 
+type instanceMigrateData struct {
+	Node primitive.ObjectID `json:"node"`
+}
+
 type instancesData struct {
 	Instances []*aggregate.InstanceAggregate `json:"instances"`
 	Count     int64                          `json:"count"`
@@ -84,10 +116,22 @@ func instancePut(c *gin.Context) {
 		return
 	}
 
+	if dta.State == instance.Migrate && inst.State != instance.Migrate {
+		errData := &errortypes.ErrorData{
+			Error:   "invalid_state",
+			Message: "Invalid instance state",
+		}
+		c.JSON(400, errData)
+		return
+	}
+
 	inst.PreCommit()
 
 	inst.Name = dta.Name
 	inst.Comment = dta.Comment
+	inst.UserData = dta.UserData
+	inst.PreFreezeHook = dta.PreFreezeHook
+	inst.PostThawHook = dta.PostThawHook
 	inst.Vpc = dta.Vpc
 	inst.Subnet = dta.Subnet
 	if dta.State != "" {
@@ -96,16 +140,38 @@ func instancePut(c *gin.Context) {
 	inst.DeleteProtection = dta.DeleteProtection
 	inst.Memory = dta.Memory
 	inst.Processors = dta.Processors
+	inst.MaxMemory = dta.MaxMemory
+	inst.MaxProcessors = dta.MaxProcessors
 	inst.NetworkRoles = dta.NetworkRoles
+	inst.Adapters = dta.Adapters
 	inst.UsbDevices = dta.UsbDevices
 	inst.Vnc = dta.Vnc
+	inst.Firmware = dta.Firmware
+	inst.Tpm = dta.Tpm
+	inst.CpuModel = dta.CpuModel
+	inst.MachineType = dta.MachineType
+	inst.Sockets = dta.Sockets
+	inst.Cores = dta.Cores
+	inst.Threads = dta.Threads
+	inst.NestedVirt = dta.NestedVirt
+	inst.DedicatedCpus = dta.DedicatedCpus
+	inst.NumaLocal = dta.NumaLocal
+	inst.Hugepages = dta.Hugepages
+	inst.NetworkRateIn = dta.NetworkRateIn
+	inst.NetworkRateOut = dta.NetworkRateOut
 	inst.Domain = dta.Domain
+	inst.Placement = dta.Placement
+	inst.HighAvailability = dta.HighAvailability
+	inst.DrainPolicy = dta.DrainPolicy
 	inst.NoPublicAddress = dta.NoPublicAddress
 	inst.NoHostAddress = dta.NoHostAddress
 
 	fields := set.NewSet(
 		"name",
 		"comment",
+		"user_data",
+		"pre_freeze_hook",
+		"post_thaw_hook",
 		"vpc",
 		"subnet",
 		"state",
@@ -114,12 +180,31 @@ func instancePut(c *gin.Context) {
 		"delete_protection",
 		"memory",
 		"processors",
+		"max_memory",
+		"max_processors",
 		"network_roles",
+		"adapters",
 		"usb_devices",
 		"vnc",
 		"vnc_display",
 		"vnc_password",
+		"firmware",
+		"tpm",
+		"cpu_model",
+		"machine_type",
+		"sockets",
+		"cores",
+		"threads",
+		"nested_virt",
+		"dedicated_cpus",
+		"numa_local",
+		"hugepages",
+		"network_rate_in",
+		"network_rate_out",
 		"domain",
+		"placement",
+		"high_availability",
+		"drain_policy",
 		"no_public_address",
 		"no_host_address",
 	)
@@ -155,6 +240,60 @@ func instancePut(c *gin.Context) {
 	c.JSON(200, inst)
 }
 
+func instanceMigratePut(c *gin.Context) {
+	if demo.Blocked(c) {
+		return
+	}
+
+	db := c.MustGet("db").(*database.Database)
+	dta := &instanceMigrateData{}
+
+	instanceId, ok := utils.ParseObjectId(c.Param("instance_id"))
+	if !ok {
+		utils.AbortWithStatus(c, 400)
+		return
+	}
+
+	err := c.Bind(dta)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	inst, err := instance.Get(db, instanceId)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	errData, err := inst.Migrate(db, dta.Node)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	if errData != nil {
+		c.JSON(400, errData)
+		return
+	}
+
+	err = inst.CommitFields(db, set.NewSet(
+		"state",
+		"migrate_node",
+		"migrate_addr",
+		"migrate_port",
+		"migrate_nbd_port",
+	))
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	event.PublishDispatch(db, "instance.change")
+
+	c.JSON(200, inst)
+}
+
 func instancePost(c *gin.Context) {
 	if demo.Blocked(c) {
 		return
@@ -213,6 +352,27 @@ func instancePost(c *gin.Context) {
 		return
 	}
 
+	if dta.Firmware == "" {
+		dta.Firmware = img.Firmware
+	}
+
+	if !dta.Node.IsZero() {
+		nde, err := node.Get(db, dta.Node)
+		if err != nil {
+			utils.AbortWithError(c, 500, err)
+			return
+		}
+
+		if nde.Maintenance {
+			errData := &errortypes.ErrorData{
+				Error:   "node_maintenance",
+				Message: "Node is in maintenance",
+			}
+			c.JSON(400, errData)
+			return
+		}
+	}
+
 	insts := []*instance.Instance{}
 
 	if dta.Count == 0 {
@@ -227,25 +387,78 @@ func instancePost(c *gin.Context) {
 			name = dta.Name
 		}
 
+		zneId := dta.Zone
+		ndeId := dta.Node
+		if ndeId.IsZero() {
+			nde, errData, err := scheduler.Schedule(db, &scheduler.Spec{
+				Organization:  dta.Organization,
+				Placement:     dta.Placement,
+				Strategy:      dta.Strategy,
+				Hypervisor:    dta.Hypervisor,
+				Datacenter:    dta.Datacenter,
+				Zone:          dta.Zone,
+				Processors:    dta.Processors,
+				Memory:        dta.Memory,
+				UsbDevices:    dta.UsbDevices,
+				NetworkRoles:  dta.NetworkRoles,
+				DedicatedCpus: dta.DedicatedCpus,
+				Hugepages:     dta.Hugepages,
+			})
+			if err != nil {
+				utils.AbortWithError(c, 500, err)
+				return
+			}
+
+			if errData != nil {
+				c.JSON(400, errData)
+				return
+			}
+
+			zneId = nde.Zone
+			ndeId = nde.Id
+		}
+
 		inst := &instance.Instance{
 			State:            dta.State,
 			Organization:     dta.Organization,
-			Zone:             dta.Zone,
+			Zone:             zneId,
 			Vpc:              dta.Vpc,
 			Subnet:           dta.Subnet,
-			Node:             dta.Node,
+			Node:             ndeId,
 			Image:            dta.Image,
 			ImageBacking:     dta.ImageBacking,
 			DeleteProtection: dta.DeleteProtection,
 			Name:             name,
 			Comment:          dta.Comment,
+			UserData:         dta.UserData,
+			PreFreezeHook:    dta.PreFreezeHook,
+			PostThawHook:     dta.PostThawHook,
 			InitDiskSize:     dta.InitDiskSize,
 			Memory:           dta.Memory,
 			Processors:       dta.Processors,
+			MaxMemory:        dta.MaxMemory,
+			MaxProcessors:    dta.MaxProcessors,
 			NetworkRoles:     dta.NetworkRoles,
+			Adapters:         dta.Adapters,
 			UsbDevices:       dta.UsbDevices,
 			Vnc:              dta.Vnc,
+			Firmware:         dta.Firmware,
+			Tpm:              dta.Tpm,
+			CpuModel:         dta.CpuModel,
+			MachineType:      dta.MachineType,
+			Sockets:          dta.Sockets,
+			Cores:            dta.Cores,
+			Threads:          dta.Threads,
+			NestedVirt:       dta.NestedVirt,
+			DedicatedCpus:    dta.DedicatedCpus,
+			NumaLocal:        dta.NumaLocal,
+			Hugepages:        dta.Hugepages,
+			NetworkRateIn:    dta.NetworkRateIn,
+			NetworkRateOut:   dta.NetworkRateOut,
 			Domain:           dta.Domain,
+			Placement:        dta.Placement,
+			HighAvailability: dta.HighAvailability,
+			DrainPolicy:      dta.DrainPolicy,
 			NoPublicAddress:  dta.NoPublicAddress,
 			NoHostAddress:    dta.NoHostAddress,
 		}
@@ -293,6 +506,15 @@ func instancesPut(c *gin.Context) {
 		return
 	}
 
+	if dta.State == instance.Migrate {
+		errData := &errortypes.ErrorData{
+			Error:   "invalid_state",
+			Message: "Invalid instance state",
+		}
+		c.JSON(400, errData)
+		return
+	}
+
 	doc := bson.M{
 		"state": dta.State,
 	}
@@ -424,6 +646,24 @@ func instanceGet(c *gin.Context) {
 	c.JSON(200, inst)
 }
 
+func instanceMetricsGet(c *gin.Context) {
+	db := c.MustGet("db").(*database.Database)
+
+	instanceId, ok := utils.ParseObjectId(c.Param("instance_id"))
+	if !ok {
+		utils.AbortWithStatus(c, 400)
+		return
+	}
+
+	metrics, err := metric.GetInstance(db, instanceId, c.Query("period"))
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	c.JSON(200, metrics)
+}
+
 func instancesGet(c *gin.Context) {
 	db := c.MustGet("db").(*database.Database)
 
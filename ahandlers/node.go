@@ -193,19 +193,41 @@ func nodeOperationPut(c *gin.Context) {
 		return
 	}
 
-	operation := c.Param("operation")
-	if operation != node.Restart {
-		utils.AbortWithStatus(c, 400)
-		return
-	}
-
 	nde, err := node.Get(db, nodeId)
 	if err != nil {
 		utils.AbortWithError(c, 500, err)
 		return
 	}
 
-	nde.Operation = node.Restart
+	fields := set.NewSet()
+
+	switch c.Param("operation") {
+	case node.Restart:
+		nde.Operation = node.Restart
+		fields.Add("operation")
+		break
+	case node.Maintenance:
+		nde.Maintenance = true
+		fields.Add("maintenance")
+		break
+	case node.Drain:
+		nde.Maintenance = true
+		nde.Draining = true
+		fields.Add("maintenance")
+		fields.Add("draining")
+		break
+	case node.Active:
+		nde.Maintenance = false
+		nde.Draining = false
+		nde.DrainRemaining = 0
+		fields.Add("maintenance")
+		fields.Add("draining")
+		fields.Add("drain_remaining")
+		break
+	default:
+		utils.AbortWithStatus(c, 400)
+		return
+	}
 
 	errData, err := nde.Validate(db)
 	if err != nil {
@@ -218,12 +240,14 @@ func nodeOperationPut(c *gin.Context) {
 		return
 	}
 
-	err = nde.CommitFields(db, set.NewSet("operation"))
+	err = nde.CommitFields(db, fields)
 	if err != nil {
 		utils.AbortWithError(c, 500, err)
 		return
 	}
 
+	event.PublishDispatch(db, "node.change")
+
 	c.JSON(200, nde)
 }
 
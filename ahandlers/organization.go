@@ -12,10 +12,15 @@ import (
 )
 
 type organizationData struct {
-	Id      primitive.ObjectID `json:"id"`
-	Name    string             `json:"name"`
-	Comment string             `json:"comment"`
-	Roles   []string           `json:"roles"`
+	Id                 primitive.ObjectID `json:"id"`
+	Name               string             `json:"name"`
+	Comment            string             `json:"comment"`
+	Roles              []string           `json:"roles"`
+	DiskIopsLimit      int                `json:"disk_iops_limit"`
+	DiskBandwidthLimit int                `json:"disk_bandwidth_limit"`
+	NetworkRateIn      int                `json:"network_rate_in"`
+	NetworkRateOut     int                `json:"network_rate_out"`
+	VendorData         string             `json:"vendor_data"`
 }
 
 func organizationPut(c *gin.Context) {
@@ -47,11 +52,21 @@ func organizationPut(c *gin.Context) {
 	org.Name = data.Name
 	org.Comment = data.Comment
 	org.Roles = data.Roles
+	org.DiskIopsLimit = data.DiskIopsLimit
+	org.DiskBandwidthLimit = data.DiskBandwidthLimit
+	org.NetworkRateIn = data.NetworkRateIn
+	org.NetworkRateOut = data.NetworkRateOut
+	org.VendorData = data.VendorData
 
 	fields := set.NewSet(
 		"name",
 		"comment",
 		"roles",
+		"disk_iops_limit",
+		"disk_bandwidth_limit",
+		"network_rate_in",
+		"network_rate_out",
+		"vendor_data",
 	)
 
 	errData, err := org.Validate(db)
@@ -93,9 +108,14 @@ func organizationPost(c *gin.Context) {
 	}
 
 	org := &organization.Organization{
-		Name:    data.Name,
-		Comment: data.Comment,
-		Roles:   data.Roles,
+		Name:               data.Name,
+		Comment:            data.Comment,
+		Roles:              data.Roles,
+		DiskIopsLimit:      data.DiskIopsLimit,
+		DiskBandwidthLimit: data.DiskBandwidthLimit,
+		NetworkRateIn:      data.NetworkRateIn,
+		NetworkRateOut:     data.NetworkRateOut,
+		VendorData:         data.VendorData,
 	}
 
 	errData, err := org.Validate(db)
@@ -0,0 +1,249 @@
+package ahandlers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/dropbox/godropbox/container/set"
+	"github.com/gin-gonic/gin"
+	"github.com/pritunl/mongo-go-driver/bson"
+	"github.com/pritunl/mongo-go-driver/bson/primitive"
+	"github.com/pritunl/pritunl-cloud/database"
+	"github.com/pritunl/pritunl-cloud/demo"
+	"github.com/pritunl/pritunl-cloud/event"
+	"github.com/pritunl/pritunl-cloud/placement"
+	"github.com/pritunl/pritunl-cloud/utils"
+)
+
+type placementData struct {
+	Id           primitive.ObjectID `json:"id"`
+	Name         string             `json:"name"`
+	Comment      string             `json:"comment"`
+	Organization primitive.ObjectID `json:"organization"`
+	Type         string             `json:"type"`
+	Scope        string             `json:"scope"`
+	Enforcement  string             `json:"enforcement"`
+}
+
+type placementsData struct {
+	Placements []*placement.Placement `json:"placements"`
+	Count      int64                  `json:"count"`
+}
+
+func placementPut(c *gin.Context) {
+	if demo.Blocked(c) {
+		return
+	}
+
+	db := c.MustGet("db").(*database.Database)
+	data := &placementData{}
+
+	placementId, ok := utils.ParseObjectId(c.Param("placement_id"))
+	if !ok {
+		utils.AbortWithStatus(c, 400)
+		return
+	}
+
+	err := c.Bind(data)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	plc, err := placement.Get(db, placementId)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	plc.Name = data.Name
+	plc.Comment = data.Comment
+	plc.Type = data.Type
+	plc.Scope = data.Scope
+	plc.Enforcement = data.Enforcement
+
+	fields := set.NewSet(
+		"name",
+		"comment",
+		"type",
+		"scope",
+		"enforcement",
+	)
+
+	errData, err := plc.Validate(db)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	if errData != nil {
+		c.JSON(400, errData)
+		return
+	}
+
+	err = plc.CommitFields(db, fields)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	event.PublishDispatch(db, "placement.change")
+
+	c.JSON(200, plc)
+}
+
+func placementPost(c *gin.Context) {
+	if demo.Blocked(c) {
+		return
+	}
+
+	db := c.MustGet("db").(*database.Database)
+	data := &placementData{
+		Name: "New Placement Group",
+	}
+
+	err := c.Bind(data)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	plc := &placement.Placement{
+		Name:         data.Name,
+		Comment:      data.Comment,
+		Organization: data.Organization,
+		Type:         data.Type,
+		Scope:        data.Scope,
+		Enforcement:  data.Enforcement,
+	}
+
+	errData, err := plc.Validate(db)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	if errData != nil {
+		c.JSON(400, errData)
+		return
+	}
+
+	err = plc.Insert(db)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	event.PublishDispatch(db, "placement.change")
+
+	c.JSON(200, plc)
+}
+
+func placementDelete(c *gin.Context) {
+	if demo.Blocked(c) {
+		return
+	}
+
+	db := c.MustGet("db").(*database.Database)
+
+	placementId, ok := utils.ParseObjectId(c.Param("placement_id"))
+	if !ok {
+		utils.AbortWithStatus(c, 400)
+		return
+	}
+
+	err := placement.Remove(db, placementId)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	event.PublishDispatch(db, "placement.change")
+
+	c.JSON(200, nil)
+}
+
+func placementsDelete(c *gin.Context) {
+	if demo.Blocked(c) {
+		return
+	}
+
+	db := c.MustGet("db").(*database.Database)
+	data := []primitive.ObjectID{}
+
+	err := c.Bind(&data)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	err = placement.RemoveMulti(db, data)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	event.PublishDispatch(db, "placement.change")
+
+	c.JSON(200, nil)
+}
+
+func placementGet(c *gin.Context) {
+	db := c.MustGet("db").(*database.Database)
+
+	placementId, ok := utils.ParseObjectId(c.Param("placement_id"))
+	if !ok {
+		utils.AbortWithStatus(c, 400)
+		return
+	}
+
+	plc, err := placement.Get(db, placementId)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	c.JSON(200, plc)
+}
+
+func placementsGet(c *gin.Context) {
+	db := c.MustGet("db").(*database.Database)
+
+	page, _ := strconv.ParseInt(c.Query("page"), 10, 0)
+	pageCount, _ := strconv.ParseInt(c.Query("page_count"), 10, 0)
+
+	query := bson.M{}
+
+	placementId, ok := utils.ParseObjectId(c.Query("id"))
+	if ok {
+		query["_id"] = placementId
+	}
+
+	name := strings.TrimSpace(c.Query("name"))
+	if name != "" {
+		query["name"] = &bson.M{
+			"$regex":   fmt.Sprintf(".*%s.*", name),
+			"$options": "i",
+		}
+	}
+
+	organization, ok := utils.ParseObjectId(c.Query("organization"))
+	if ok {
+		query["organization"] = organization
+	}
+
+	placements, count, err := placement.GetAllPaged(db, &query, page,
+		pageCount)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	data := &placementsData{
+		Placements: placements,
+		Count:      count,
+	}
+
+	c.JSON(200, data)
+}
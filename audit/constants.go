@@ -10,6 +10,8 @@ const (
 	AdminDeviceApprove         = "admin_device_approve"
 	AdminDeviceRegisterRequest = "admin_device_register_request"
 	AdminDeviceRegister        = "admin_device_register"
+	AdminConsole               = "admin_console"
+	AdminAgent                 = "admin_agent"
 
 	ProxyLogin                 = "proxy_login"
 	ProxyLoginFailed           = "proxy_login_failed"
@@ -32,6 +34,10 @@ const (
 	UserDeviceRegisterRequest = "user_device_register_request"
 	UserDeviceRegister        = "user_device_register"
 	UserAccountDisable        = "user_account_disable"
+	UserConsole               = "user_console"
+	UserAgent                 = "user_agent"
+
+	InstanceFailover = "instance_failover"
 
 	DeviceRegister       = "device_register"
 	DeviceRegisterFailed = "device_register_failed"
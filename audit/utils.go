@@ -79,6 +79,27 @@ func GetAll(db *database.Database, userId primitive.ObjectID,
 	return
 }
 
+func NewSystem(db *database.Database, typ string, fields Fields) (
+	err error) {
+
+	if settings.System.Demo {
+		return
+	}
+
+	adt := &Audit{
+		Timestamp: time.Now(),
+		Type:      typ,
+		Fields:    fields,
+	}
+
+	err = adt.Insert(db)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
 func New(db *database.Database, r *http.Request,
 	userId primitive.ObjectID, typ string, fields Fields) (
 	err error) {
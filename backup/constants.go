@@ -0,0 +1,23 @@
+package backup
+
+import (
+	"github.com/dropbox/godropbox/container/set"
+)
+
+const (
+	Hourly  = "hourly"
+	Daily   = "daily"
+	Weekly  = "weekly"
+	Monthly = "monthly"
+
+	MaxRetain = 1000
+)
+
+var (
+	ValidFrequencies = set.NewSet(
+		Hourly,
+		Daily,
+		Weekly,
+		Monthly,
+	)
+)
@@ -0,0 +1,276 @@
+package backup
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/dropbox/godropbox/container/set"
+	"github.com/dropbox/godropbox/errors"
+	"github.com/pritunl/mongo-go-driver/bson/primitive"
+	"github.com/pritunl/pritunl-cloud/database"
+	"github.com/pritunl/pritunl-cloud/errortypes"
+	"github.com/pritunl/pritunl-cloud/image"
+)
+
+type Policy struct {
+	Id            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name          string             `bson:"name" json:"name"`
+	Comment       string             `bson:"comment" json:"comment"`
+	Organization  primitive.ObjectID `bson:"organization" json:"organization"`
+	Frequency     string             `bson:"frequency" json:"frequency"`
+	StartHour     int                `bson:"start_hour" json:"start_hour"`
+	Window        int                `bson:"window" json:"window"`
+	Weekday       int                `bson:"weekday" json:"weekday"`
+	MonthDay      int                `bson:"month_day" json:"month_day"`
+	RetainHourly  int                `bson:"retain_hourly" json:"retain_hourly"`
+	RetainDaily   int                `bson:"retain_daily" json:"retain_daily"`
+	RetainWeekly  int                `bson:"retain_weekly" json:"retain_weekly"`
+	RetainMonthly int                `bson:"retain_monthly" json:"retain_monthly"`
+}
+
+func (p *Policy) Validate(db *database.Database) (
+	errData *errortypes.ErrorData, err error) {
+
+	if p.Organization.IsZero() {
+		errData = &errortypes.ErrorData{
+			Error:   "organization_required",
+			Message: "Missing required organization",
+		}
+		return
+	}
+
+	if p.Frequency == "" {
+		p.Frequency = Daily
+	}
+
+	if !ValidFrequencies.Contains(p.Frequency) {
+		errData = &errortypes.ErrorData{
+			Error:   "backup_policy_frequency_invalid",
+			Message: "Invalid backup policy frequency",
+		}
+		return
+	}
+
+	if p.StartHour < 0 || p.StartHour > 23 {
+		errData = &errortypes.ErrorData{
+			Error:   "backup_policy_start_hour_invalid",
+			Message: "Backup policy start hour must be between 0 and 23",
+		}
+		return
+	}
+
+	if p.Window == 0 {
+		p.Window = 6
+	}
+
+	if p.Window < 1 || p.Window > 24 {
+		errData = &errortypes.ErrorData{
+			Error:   "backup_policy_window_invalid",
+			Message: "Backup policy window must be between 1 and 24 hours",
+		}
+		return
+	}
+
+	if p.Weekday < 0 || p.Weekday > 6 {
+		errData = &errortypes.ErrorData{
+			Error:   "backup_policy_weekday_invalid",
+			Message: "Backup policy weekday must be between 0 and 6",
+		}
+		return
+	}
+
+	if p.MonthDay == 0 {
+		p.MonthDay = 1
+	}
+
+	if p.MonthDay < 1 || p.MonthDay > 28 {
+		errData = &errortypes.ErrorData{
+			Error:   "backup_policy_month_day_invalid",
+			Message: "Backup policy day of month must be between 1 and 28",
+		}
+		return
+	}
+
+	retains := []int{
+		p.RetainHourly,
+		p.RetainDaily,
+		p.RetainWeekly,
+		p.RetainMonthly,
+	}
+	total := 0
+	for _, retain := range retains {
+		if retain < 0 || retain > MaxRetain {
+			errData = &errortypes.ErrorData{
+				Error:   "backup_policy_retain_invalid",
+				Message: "Backup policy retention count out of range",
+			}
+			return
+		}
+		total += retain
+	}
+
+	if total == 0 {
+		errData = &errortypes.ErrorData{
+			Error:   "backup_policy_retain_required",
+			Message: "Backup policy must retain at least one backup",
+		}
+		return
+	}
+
+	return
+}
+
+func (p *Policy) InWindow(now time.Time) bool {
+	now = now.UTC()
+
+	switch p.Frequency {
+	case Weekly:
+		if int(now.Weekday()) != p.Weekday {
+			return false
+		}
+		break
+	case Monthly:
+		if now.Day() != p.MonthDay {
+			return false
+		}
+		break
+	}
+
+	hour := (now.Hour() - p.StartHour + 24) % 24
+	return hour < p.Window
+}
+
+func (p *Policy) Due(lastBackup, now time.Time) bool {
+	if !p.InWindow(now) {
+		return false
+	}
+
+	since := now.Sub(lastBackup)
+
+	switch p.Frequency {
+	case Hourly:
+		return !now.Truncate(time.Hour).Equal(lastBackup.Truncate(time.Hour))
+	case Daily:
+		return since >= 12*time.Hour
+	case Weekly, Monthly:
+		return since >= 24*time.Hour
+	}
+
+	return false
+}
+
+func (p *Policy) Expired(imgs []*image.Image) (expired []*image.Image) {
+	expired = []*image.Image{}
+
+	sorted := make([]*image.Image, len(imgs))
+	copy(sorted, imgs)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Id.Timestamp().After(sorted[j].Id.Timestamp())
+	})
+
+	tiers := []struct {
+		retain int
+		period func(t time.Time) string
+	}{
+		{p.RetainHourly, func(t time.Time) string {
+			return t.Format("2006-01-02T15")
+		}},
+		{p.RetainDaily, func(t time.Time) string {
+			return t.Format("2006-01-02")
+		}},
+		{p.RetainWeekly, func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-%d", year, week)
+		}},
+		{p.RetainMonthly, func(t time.Time) string {
+			return t.Format("2006-01")
+		}},
+	}
+
+	retained := set.NewSet()
+	for _, tier := range tiers {
+		periods := set.NewSet()
+
+		for _, img := range sorted {
+			if periods.Len() >= tier.retain {
+				break
+			}
+
+			period := tier.period(img.Id.Timestamp().UTC())
+			if periods.Contains(period) {
+				continue
+			}
+			periods.Add(period)
+			retained.Add(img.Id)
+		}
+	}
+
+	imgsMap := map[primitive.ObjectID]*image.Image{}
+	for _, img := range sorted {
+		imgsMap[img.Id] = img
+	}
+
+	for _, img := range sorted {
+		if !retained.Contains(img.Id) {
+			continue
+		}
+
+		parent := imgsMap[img.Parent]
+		for parent != nil && !retained.Contains(parent.Id) {
+			retained.Add(parent.Id)
+			parent = imgsMap[parent.Parent]
+		}
+	}
+
+	for _, img := range sorted {
+		if !retained.Contains(img.Id) {
+			expired = append(expired, img)
+		}
+	}
+
+	return
+}
+
+func (p *Policy) Commit(db *database.Database) (err error) {
+	coll := db.BackupPolicies()
+
+	err = coll.Commit(p.Id, p)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func (p *Policy) CommitFields(db *database.Database, fields set.Set) (
+	err error) {
+
+	coll := db.BackupPolicies()
+
+	err = coll.CommitFields(p.Id, p, fields)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func (p *Policy) Insert(db *database.Database) (err error) {
+	coll := db.BackupPolicies()
+
+	if !p.Id.IsZero() {
+		err = &errortypes.DatabaseError{
+			errors.New("backup: Policy already exists"),
+		}
+		return
+	}
+
+	_, err = coll.InsertOne(db, p)
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
+	return
+}
@@ -0,0 +1,280 @@
+package backup
+
+import (
+	"github.com/pritunl/mongo-go-driver/bson"
+	"github.com/pritunl/mongo-go-driver/bson/primitive"
+	"github.com/pritunl/mongo-go-driver/mongo/options"
+	"github.com/pritunl/pritunl-cloud/database"
+	"github.com/pritunl/pritunl-cloud/utils"
+)
+
+func Get(db *database.Database, plcyId primitive.ObjectID) (
+	plcy *Policy, err error) {
+
+	coll := db.BackupPolicies()
+	plcy = &Policy{}
+
+	err = coll.FindOneId(plcyId, plcy)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func GetOrg(db *database.Database, orgId, plcyId primitive.ObjectID) (
+	plcy *Policy, err error) {
+
+	coll := db.BackupPolicies()
+	plcy = &Policy{}
+
+	err = coll.FindOne(db, &bson.M{
+		"_id":          plcyId,
+		"organization": orgId,
+	}).Decode(plcy)
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
+	return
+}
+
+func ExistsOrg(db *database.Database, orgId, plcyId primitive.ObjectID) (
+	exists bool, err error) {
+
+	coll := db.BackupPolicies()
+
+	count, err := coll.CountDocuments(db, &bson.M{
+		"_id":          plcyId,
+		"organization": orgId,
+	})
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
+	if count > 0 {
+		exists = true
+	}
+
+	return
+}
+
+func GetAll(db *database.Database, query *bson.M) (
+	plcys []*Policy, err error) {
+
+	coll := db.BackupPolicies()
+	plcys = []*Policy{}
+
+	cursor, err := coll.Find(db, query)
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+	defer cursor.Close(db)
+
+	for cursor.Next(db) {
+		plcy := &Policy{}
+		err = cursor.Decode(plcy)
+		if err != nil {
+			err = database.ParseError(err)
+			return
+		}
+
+		plcys = append(plcys, plcy)
+	}
+
+	err = cursor.Err()
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
+	return
+}
+
+func GetAllPaged(db *database.Database, query *bson.M,
+	page, pageCount int64) (plcys []*Policy, count int64, err error) {
+
+	coll := db.BackupPolicies()
+	plcys = []*Policy{}
+
+	count, err = coll.CountDocuments(db, query)
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
+	page = utils.Min64(page, count/pageCount)
+	skip := utils.Min64(page*pageCount, count)
+
+	cursor, err := coll.Find(
+		db,
+		query,
+		&options.FindOptions{
+			Sort: &bson.D{
+				{"name", 1},
+			},
+			Skip:  &skip,
+			Limit: &pageCount,
+		},
+	)
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+	defer cursor.Close(db)
+
+	for cursor.Next(db) {
+		plcy := &Policy{}
+		err = cursor.Decode(plcy)
+		if err != nil {
+			err = database.ParseError(err)
+			return
+		}
+
+		plcys = append(plcys, plcy)
+	}
+
+	err = cursor.Err()
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
+	return
+}
+
+func clearDisks(db *database.Database, query *bson.M) (err error) {
+	coll := db.Disks()
+
+	_, err = coll.UpdateMany(db, query, &bson.M{
+		"$unset": &bson.M{
+			"backup_policy": 1,
+		},
+	})
+	if err != nil {
+		err = database.ParseError(err)
+		switch err.(type) {
+		case *database.NotFoundError:
+			err = nil
+		default:
+			return
+		}
+	}
+
+	return
+}
+
+func Remove(db *database.Database, plcyId primitive.ObjectID) (err error) {
+	coll := db.BackupPolicies()
+
+	_, err = coll.DeleteOne(db, &bson.M{
+		"_id": plcyId,
+	})
+	if err != nil {
+		err = database.ParseError(err)
+		switch err.(type) {
+		case *database.NotFoundError:
+			err = nil
+		default:
+			return
+		}
+	}
+
+	err = clearDisks(db, &bson.M{
+		"backup_policy": plcyId,
+	})
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func RemoveOrg(db *database.Database, orgId, plcyId primitive.ObjectID) (
+	err error) {
+
+	coll := db.BackupPolicies()
+
+	_, err = coll.DeleteOne(db, &bson.M{
+		"_id":          plcyId,
+		"organization": orgId,
+	})
+	if err != nil {
+		err = database.ParseError(err)
+		switch err.(type) {
+		case *database.NotFoundError:
+			err = nil
+		default:
+			return
+		}
+	}
+
+	err = clearDisks(db, &bson.M{
+		"backup_policy": plcyId,
+		"organization":  orgId,
+	})
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func RemoveMulti(db *database.Database, plcyIds []primitive.ObjectID) (
+	err error) {
+
+	coll := db.BackupPolicies()
+
+	_, err = coll.DeleteMany(db, &bson.M{
+		"_id": &bson.M{
+			"$in": plcyIds,
+		},
+	})
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
+	err = clearDisks(db, &bson.M{
+		"backup_policy": &bson.M{
+			"$in": plcyIds,
+		},
+	})
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func RemoveMultiOrg(db *database.Database, orgId primitive.ObjectID,
+	plcyIds []primitive.ObjectID) (err error) {
+
+	coll := db.BackupPolicies()
+
+	_, err = coll.DeleteMany(db, &bson.M{
+		"_id": &bson.M{
+			"$in": plcyIds,
+		},
+		"organization": orgId,
+	})
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
+	err = clearDisks(db, &bson.M{
+		"backup_policy": &bson.M{
+			"$in": plcyIds,
+		},
+		"organization": orgId,
+	})
+	if err != nil {
+		return
+	}
+
+	return
+}
@@ -19,8 +19,10 @@ import (
 	"github.com/pritunl/pritunl-cloud/errortypes"
 	"github.com/pritunl/pritunl-cloud/instance"
 	"github.com/pritunl/pritunl-cloud/node"
+	"github.com/pritunl/pritunl-cloud/organization"
 	"github.com/pritunl/pritunl-cloud/paths"
 	"github.com/pritunl/pritunl-cloud/settings"
+	"github.com/pritunl/pritunl-cloud/userdata"
 	"github.com/pritunl/pritunl-cloud/utils"
 	"github.com/pritunl/pritunl-cloud/vm"
 	"github.com/pritunl/pritunl-cloud/vpc"
@@ -37,22 +39,23 @@ MIME-Version: 1.0
 
 const netConfigTmpl = `version: 1
 config:
-  - type: physical
-    name: eth0
-    mac_address: {{.Mac}}{{.Mtu}}
+{{range .Adapters}}  - type: physical
+    name: {{.Name}}
+    mac_address: {{.MacAddress}}{{$.Mtu}}
     subnets:
       - type: static
         address: {{.Address}}
         netmask: {{.Netmask}}
-        network: {{.Network}}
-        gateway: {{.Gateway}}
-        dns_nameservers:
-          - 8.8.8.8
-          - 8.8.4.4
+        network: {{.Network}}{{if .Gateway}}
+        gateway: {{.Gateway}}{{end}}{{if .Nameservers}}
+        dns_nameservers:{{range .Nameservers}}
+          - {{.}}{{end}}{{end}}{{if .SearchDomains}}
+        dns_search:{{range .SearchDomains}}
+          - {{.}}{{end}}{{end}}
       - type: static
-        address: {{.Address6}}
-        gateway: {{.Gateway6}}
-`
+        address: {{.Address6}}{{if .Gateway6}}
+        gateway: {{.Gateway6}}{{end}}
+{{end}}`
 
 const netMtu = `
     mtu: %d`
@@ -79,6 +82,13 @@ users:
 {{range .Keys}}      - {{.}}
 {{end}}`
 
+const ntpConfigTmpl = `#cloud-config
+ntp:
+  enabled: true
+  servers:
+{{range .}}    - {{.}}
+{{end}}`
+
 const cloudScriptTmpl = `#!/bin/bash
 %s`
 
@@ -90,17 +100,25 @@ EOF
 var (
 	cloudConfig = template.Must(template.New("cloud").Parse(cloudConfigTmpl))
 	netConfig   = template.Must(template.New("net").Parse(netConfigTmpl))
+	ntpConfig   = template.Must(template.New("ntp").Parse(ntpConfigTmpl))
 )
 
 type netConfigData struct {
-	Mac      string
 	Mtu      string
-	Address  string
-	Netmask  string
-	Network  string
-	Gateway  string
-	Address6 string
-	Gateway6 string
+	Adapters []*NetAdapter
+}
+
+type NetAdapter struct {
+	Name          string
+	MacAddress    string
+	Address       string
+	Netmask       string
+	Network       string
+	Gateway       string
+	Address6      string
+	Gateway6      string
+	Nameservers   []string
+	SearchDomains []string
 }
 
 type cloudConfigData struct {
@@ -108,7 +126,60 @@ type cloudConfigData struct {
 	Keys       []string
 }
 
-func getUserData(db *database.Database, inst *instance.Instance,
+func GetHostname(inst *instance.Instance) string {
+	return strings.Replace(inst.Name, " ", "_", -1)
+}
+
+func GetAuthorizedKeys(db *database.Database, inst *instance.Instance) (
+	keys []string, err error) {
+
+	keys = []string{}
+
+	authrs, err := authority.GetOrgRoles(db, inst.Organization,
+		inst.NetworkRoles)
+	if err != nil {
+		return
+	}
+
+	for _, authr := range authrs {
+		if authr.Type != authority.SshKey {
+			continue
+		}
+
+		for _, key := range strings.Split(authr.Key, "\n") {
+			key = strings.TrimSpace(key)
+			if key != "" {
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	return
+}
+
+func getNtpServers(db *database.Database, virt *vm.VirtualMachine) (
+	ntpServers []string, err error) {
+
+	if len(virt.NetworkAdapters) == 0 {
+		return
+	}
+
+	adapter := virt.NetworkAdapters[0]
+	if adapter.Vpc.IsZero() {
+		return
+	}
+
+	vc, err := vpc.Get(db, adapter.Vpc)
+	if err != nil {
+		return
+	}
+
+	ntpServers = vc.GetNtpServers(adapter.Subnet)
+
+	return
+}
+
+func GetUserData(db *database.Database, inst *instance.Instance,
 	virt *vm.VirtualMachine, initial bool) (usrData string, err error) {
 
 	authrs, err := authority.GetOrgRoles(db, inst.Organization,
@@ -117,7 +188,17 @@ func getUserData(db *database.Database, inst *instance.Instance,
 		return
 	}
 
-	if len(authrs) == 0 {
+	userParts, err := userdata.Parse(inst.UserData)
+	if err != nil {
+		return
+	}
+
+	ntpServers, err := getNtpServers(db, virt)
+	if err != nil {
+		return
+	}
+
+	if len(authrs) == 0 && len(userParts) == 0 && len(ntpServers) == 0 {
 		return
 	}
 
@@ -149,29 +230,56 @@ func getUserData(db *database.Database, inst *instance.Instance,
 		}
 	}
 
-	items := []string{}
+	items := []*userdata.Part{}
 
-	output := &bytes.Buffer{}
-	err = cloudConfig.Execute(output, data)
-	if err != nil {
-		err = &errortypes.ParseError{
-			errors.Wrap(err, "cloudinit: Failed to exec cloud template"),
+	if len(authrs) != 0 {
+		output := &bytes.Buffer{}
+		err = cloudConfig.Execute(output, data)
+		if err != nil {
+			err = &errortypes.ParseError{
+				errors.Wrap(err, "cloudinit: Failed to exec cloud template"),
+			}
+			return
+		}
+		items = append(items, &userdata.Part{
+			ContentType: userdata.CloudConfig,
+			Content:     output.String(),
+		})
+
+		if trusted != "" {
+			cloudScript += fmt.Sprintf(teeTmpl,
+				"/etc/ssh/trusted", trusted)
+		}
+		if principals != "" {
+			cloudScript += fmt.Sprintf(teeTmpl,
+				"/etc/ssh/principals", principals)
 		}
-		return
-	}
-	items = append(items, output.String())
 
-	if trusted != "" {
-		cloudScript += fmt.Sprintf(teeTmpl, "/etc/ssh/trusted", trusted)
-	}
-	if principals != "" {
-		cloudScript += fmt.Sprintf(teeTmpl, "/etc/ssh/principals", principals)
+		if cloudScript != "" {
+			items = append(items, &userdata.Part{
+				ContentType: userdata.ShellScript,
+				Content:     fmt.Sprintf(cloudScriptTmpl, cloudScript),
+			})
+		}
 	}
 
-	if cloudScript != "" {
-		items = append(items, fmt.Sprintf(cloudScriptTmpl, cloudScript))
+	if len(ntpServers) != 0 {
+		output := &bytes.Buffer{}
+		err = ntpConfig.Execute(output, ntpServers)
+		if err != nil {
+			err = &errortypes.ParseError{
+				errors.Wrap(err, "cloudinit: Failed to exec ntp template"),
+			}
+			return
+		}
+		items = append(items, &userdata.Part{
+			ContentType: userdata.CloudConfig,
+			Content:     output.String(),
+		})
 	}
 
+	items = append(items, userParts...)
+
 	buffer := &bytes.Buffer{}
 	message := multipart.NewWriter(buffer)
 	for _, item := range items {
@@ -179,14 +287,8 @@ func getUserData(db *database.Database, inst *instance.Instance,
 
 		header.Set("Content-Transfer-Encoding", "base64")
 		header.Set("MIME-Version", "1.0")
-
-		if strings.HasPrefix(item, "#!") {
-			header.Set("Content-Type",
-				"text/x-shellscript; charset=\"utf-8\"")
-		} else {
-			header.Set("Content-Type",
-				"text/cloud-config; charset=\"utf-8\"")
-		}
+		header.Set("Content-Type",
+			fmt.Sprintf("%s; charset=\"utf-8\"", item.ContentType))
 
 		part, e := message.CreatePart(header)
 		if e != nil {
@@ -196,8 +298,8 @@ func getUserData(db *database.Database, inst *instance.Instance,
 			return
 		}
 
-		_, err = part.Write(
-			[]byte(base64.StdEncoding.EncodeToString([]byte(item)) + "\n"))
+		_, err = part.Write([]byte(base64.StdEncoding.EncodeToString(
+			[]byte(item.Content)) + "\n"))
 		if err != nil {
 			err = &errortypes.WriteError{
 				errors.Wrap(err, "cloudinit: Failed to write part"),
@@ -223,84 +325,166 @@ func getUserData(db *database.Database, inst *instance.Instance,
 	return
 }
 
-func getNetData(db *database.Database, inst *instance.Instance,
-	virt *vm.VirtualMachine) (netData string, err error) {
+func GetVendorData(db *database.Database, inst *instance.Instance) (
+	vendorData string, err error) {
 
-	if len(virt.NetworkAdapters) == 0 {
-		err = &errortypes.NotFoundError{
-			errors.Wrap(err, "cloudinit: Instance missing network adapters"),
+	org, err := organization.Get(db, inst.Organization)
+	if err != nil {
+		if _, ok := err.(*database.NotFoundError); ok {
+			err = nil
 		}
 		return
 	}
 
-	adapter := virt.NetworkAdapters[0]
+	if org.VendorData == "" {
+		return
+	}
+
+	data := &userdata.TemplateData{
+		InstanceId:   inst.Id.Hex(),
+		Name:         inst.Name,
+		Hostname:     GetHostname(inst),
+		Organization: org.Name,
+	}
+	if len(inst.PrivateIps) != 0 {
+		data.PrivateIp = inst.PrivateIps[0]
+	}
+	if len(inst.PrivateIps6) != 0 {
+		data.PrivateIp6 = inst.PrivateIps6[0]
+	}
+	if len(inst.PublicIps) != 0 {
+		data.PublicIp = inst.PublicIps[0]
+	}
+	if len(inst.PublicIps6) != 0 {
+		data.PublicIp6 = inst.PublicIps6[0]
+	}
+
+	vendorData, err = userdata.Render(org.VendorData, data)
+	if err != nil {
+		return
+	}
+
+	_, err = userdata.Parse(vendorData)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func getAdapter(db *database.Database, inst *instance.Instance,
+	adapter *vm.NetworkAdapter, index int) (
+	netAdapter *NetAdapter, err error) {
 
 	if adapter.Vpc.IsZero() {
 		err = &errortypes.NotFoundError{
-			errors.Wrap(err, "cloudinit: Instance missing VPC"),
+			errors.New("cloudinit: Instance missing VPC"),
 		}
 		return
 	}
 
 	if adapter.Subnet.IsZero() {
 		err = &errortypes.NotFoundError{
-			errors.Wrap(err, "cloudinit: Instance missing VPC subnet"),
+			errors.New("cloudinit: Instance missing VPC subnet"),
 		}
 		return
 	}
 
-	zne, err := zone.Get(db, node.Self.Zone)
+	vc, err := vpc.Get(db, adapter.Vpc)
 	if err != nil {
 		return
 	}
 
-	vxlan := false
-	if zne.NetworkMode == zone.VxlanVlan {
-		vxlan = true
+	vcNet, err := vc.GetNetwork()
+	if err != nil {
+		return
 	}
 
-	vc, err := vpc.Get(db, adapter.Vpc)
+	addr, gatewayAddr, err := vc.GetIp(db, adapter.Subnet, inst.Id)
 	if err != nil {
 		return
 	}
 
-	vcNet, err := vc.GetNetwork()
-	if err != nil {
+	netAdapter = &NetAdapter{
+		Name:       fmt.Sprintf("eth%d", index),
+		MacAddress: adapter.MacAddress,
+		Address:    addr.String(),
+		Netmask:    net.IP(vcNet.Mask).String(),
+		Network:    vcNet.IP.String(),
+		Address6:   vc.GetIp6(addr).String(),
+	}
+
+	if index == 0 {
+		netAdapter.Gateway = gatewayAddr.String()
+		netAdapter.Gateway6 = vc.GetIp6(gatewayAddr).String()
+		netAdapter.Nameservers = vc.GetDnsServers(adapter.Subnet)
+		netAdapter.SearchDomains = vc.GetSearchDomains(adapter.Subnet)
+	}
+
+	return
+}
+
+func GetNetAdapters(db *database.Database, inst *instance.Instance,
+	virt *vm.VirtualMachine) (adapters []*NetAdapter, mtu int, err error) {
+
+	if len(virt.NetworkAdapters) == 0 {
+		err = &errortypes.NotFoundError{
+			errors.New("cloudinit: Instance missing network adapters"),
+		}
 		return
 	}
 
-	addr, gatewayAddr, err := vc.GetIp(db, inst.Subnet, inst.Id)
+	zne, err := zone.Get(db, node.Self.Zone)
 	if err != nil {
 		return
 	}
 
-	addr6 := vc.GetIp6(addr)
-	gatewayAddr6 := vc.GetIp6(gatewayAddr)
+	vxlan := false
+	if zne.NetworkMode == zone.VxlanVlan {
+		vxlan = true
+	}
 
-	data := netConfigData{
-		Mac:      adapter.MacAddress,
-		Address:  addr.String(),
-		Netmask:  net.IP(vcNet.Mask).String(),
-		Network:  vcNet.IP.String(),
-		Gateway:  gatewayAddr.String(),
-		Address6: addr6.String(),
-		Gateway6: gatewayAddr6.String(),
+	adapters = []*NetAdapter{}
+	for i, adapter := range virt.NetworkAdapters {
+		netAdapter, e := getAdapter(db, inst, adapter, i)
+		if e != nil {
+			err = e
+			return
+		}
+
+		adapters = append(adapters, netAdapter)
 	}
 
 	jumboFrames := node.Self.JumboFrames
 	if jumboFrames || vxlan {
-		mtuSize := 0
 		if jumboFrames {
-			mtuSize = settings.Hypervisor.JumboMtu
+			mtu = settings.Hypervisor.JumboMtu
 		} else {
-			mtuSize = settings.Hypervisor.NormalMtu
+			mtu = settings.Hypervisor.NormalMtu
 		}
 
 		if vxlan {
-			mtuSize -= 54
+			mtu -= 54
 		}
+	}
+
+	return
+}
+
+func GetNetData(db *database.Database, inst *instance.Instance,
+	virt *vm.VirtualMachine) (netData string, err error) {
+
+	adapters, mtu, err := GetNetAdapters(db, inst, virt)
+	if err != nil {
+		return
+	}
 
-		data.Mtu = fmt.Sprintf(netMtu, mtuSize)
+	data := netConfigData{
+		Adapters: adapters,
+	}
+
+	if mtu != 0 {
+		data.Mtu = fmt.Sprintf(netMtu, mtu)
 	}
 
 	output := &bytes.Buffer{}
@@ -323,6 +507,7 @@ func Write(db *database.Database, inst *instance.Instance,
 	tempDir := paths.GetTempDir()
 	metaPath := path.Join(tempDir, "meta-data")
 	userPath := path.Join(tempDir, "user-data")
+	vendorPath := path.Join(tempDir, "vendor-data")
 	netPath := path.Join(tempDir, "network-config")
 	initPath := paths.GetInitPath(inst.Id)
 
@@ -338,14 +523,14 @@ func Write(db *database.Database, inst *instance.Instance,
 		return
 	}
 
-	usrData, err := getUserData(db, inst, virt, initial)
+	usrData, err := GetUserData(db, inst, virt, initial)
 	if err != nil {
 		return
 	}
 
 	metaData := fmt.Sprintf(metaDataTmpl,
 		primitive.NewObjectID().Hex(),
-		strings.Replace(inst.Name, " ", "_", -1),
+		GetHostname(inst),
 	)
 
 	err = utils.CreateWrite(metaPath, metaData, 0644)
@@ -353,7 +538,7 @@ func Write(db *database.Database, inst *instance.Instance,
 		return
 	}
 
-	netData, err := getNetData(db, inst, virt)
+	netData, err := GetNetData(db, inst, virt)
 	if err != nil {
 		return
 	}
@@ -368,6 +553,16 @@ func Write(db *database.Database, inst *instance.Instance,
 		return
 	}
 
+	vendorData, err := GetVendorData(db, inst)
+	if err != nil {
+		return
+	}
+
+	err = utils.CreateWrite(vendorPath, vendorData, 0644)
+	if err != nil {
+		return
+	}
+
 	_, err = utils.ExecCombinedOutputLoggedDir(
 		nil, tempDir,
 		"genisoimage",
@@ -376,6 +571,7 @@ func Write(db *database.Database, inst *instance.Instance,
 		"-joliet",
 		"-rock",
 		"user-data",
+		"vendor-data",
 		"meta-data",
 		"network-config",
 	)
@@ -10,8 +10,11 @@ import (
 	"github.com/dropbox/godropbox/errors"
 	"github.com/pritunl/mongo-go-driver/bson/primitive"
 	"github.com/pritunl/pritunl-cloud/config"
+	"github.com/pritunl/pritunl-cloud/console"
 	"github.com/pritunl/pritunl-cloud/constants"
 	"github.com/pritunl/pritunl-cloud/errortypes"
+	"github.com/pritunl/pritunl-cloud/exporter"
+	"github.com/pritunl/pritunl-cloud/metadata"
 	"github.com/pritunl/pritunl-cloud/node"
 	"github.com/pritunl/pritunl-cloud/router"
 	"github.com/pritunl/pritunl-cloud/setup"
@@ -43,6 +46,10 @@ func Node() (err error) {
 
 	sync.Init()
 
+	console.Init()
+
+	metadata.Init()
+
 	logrus.WithFields(logrus.Fields{
 		"production": constants.Production,
 		"types":      nde.Types,
@@ -53,6 +60,8 @@ func Node() (err error) {
 
 	task.Init()
 
+	exporter.Init()
+
 	go func() {
 		err = routr.Run()
 		if err != nil {
@@ -0,0 +1,306 @@
+package console
+
+import (
+	"encoding/json"
+	"net"
+	"path"
+	"regexp"
+	"time"
+
+	"github.com/dropbox/godropbox/errors"
+	"github.com/pritunl/pritunl-cloud/audit"
+	"github.com/pritunl/pritunl-cloud/database"
+	"github.com/pritunl/pritunl-cloud/errortypes"
+	"github.com/pritunl/pritunl-cloud/instance"
+	"github.com/pritunl/pritunl-cloud/qga"
+)
+
+var usernameRe = regexp.MustCompile(`^[a-z_][a-z0-9_-]{0,31}$`)
+
+type AgentRequest struct {
+	Operation string   `json:"operation"`
+	Path      string   `json:"path,omitempty"`
+	Args      []string `json:"args,omitempty"`
+	Input     string   `json:"input,omitempty"`
+	Timeout   int      `json:"timeout,omitempty"`
+	Data      []byte   `json:"data,omitempty"`
+	Username  string   `json:"username,omitempty"`
+	Password  string   `json:"password,omitempty"`
+}
+
+func (r *AgentRequest) Validate() (errData *errortypes.ErrorData) {
+	switch r.Operation {
+	case qga.Exec, qga.FileRead, qga.FileWrite:
+		if !path.IsAbs(r.Path) {
+			errData = &errortypes.ErrorData{
+				Error:   "agent_path_invalid",
+				Message: "Path must be absolute",
+			}
+			return
+		}
+
+		if r.Operation == qga.FileWrite && len(r.Data) > qga.MaxFileSize {
+			errData = &errortypes.ErrorData{
+				Error:   "agent_data_invalid",
+				Message: "File data exceeds maximum size",
+			}
+			return
+		}
+
+		if r.Operation == qga.Exec && len(r.Input) > qga.MaxOutput {
+			errData = &errortypes.ErrorData{
+				Error:   "agent_input_invalid",
+				Message: "Command input exceeds maximum size",
+			}
+			return
+		}
+	case qga.Password:
+		if !usernameRe.MatchString(r.Username) {
+			errData = &errortypes.ErrorData{
+				Error:   "agent_username_invalid",
+				Message: "Username invalid",
+			}
+			return
+		}
+
+		if len(r.Password) < 8 || len(r.Password) > 128 {
+			errData = &errortypes.ErrorData{
+				Error:   "agent_password_invalid",
+				Message: "Password must be between 8 and 128 characters",
+			}
+			return
+		}
+	case qga.OsInfo, qga.FsInfo, qga.Users, qga.Freeze, qga.Thaw,
+		qga.FreezeStat:
+	default:
+		errData = &errortypes.ErrorData{
+			Error:   "agent_operation_invalid",
+			Message: "Unknown agent operation",
+		}
+		return
+	}
+
+	return
+}
+
+func (r *AgentRequest) AuditFields(fields audit.Fields,
+	result json.RawMessage, errData *errortypes.ErrorData, err error) {
+
+	fields["operation"] = r.Operation
+	if r.Path != "" {
+		fields["path"] = r.Path
+	}
+	if len(r.Args) > 0 {
+		fields["args"] = r.Args
+	}
+	if r.Username != "" {
+		fields["username"] = r.Username
+	}
+
+	if err != nil {
+		fields["status"] = "failed"
+		if dErr, ok := err.(errors.DropboxError); ok {
+			fields["error"] = dErr.GetMessage()
+		} else {
+			fields["error"] = err.Error()
+		}
+		return
+	}
+
+	if errData != nil {
+		fields["status"] = "failed"
+		fields["error"] = errData.Message
+		return
+	}
+
+	fields["status"] = "success"
+
+	if r.Operation == qga.Exec {
+		execResult := &qga.ExecResult{}
+		e := json.Unmarshal(result, execResult)
+		if e == nil {
+			fields["exited"] = execResult.Exited
+			fields["exit_code"] = execResult.ExitCode
+		}
+	}
+}
+
+type agentResponse struct {
+	Error  string          `json:"error,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+}
+
+type agentCount struct {
+	Count int `json:"count"`
+}
+
+type agentStatus struct {
+	Status string `json:"status"`
+}
+
+type agentFile struct {
+	Data []byte `json:"data"`
+}
+
+func runAgent(inst *instance.Instance, req *AgentRequest) (
+	result interface{}, err error) {
+
+	switch req.Operation {
+	case qga.Exec:
+		result, err = qga.ExecCommand(inst.Id, req.Path, req.Args,
+			req.Input, time.Duration(req.Timeout)*time.Second)
+	case qga.FileRead:
+		data, e := qga.ReadFile(inst.Id, req.Path)
+		if e != nil {
+			err = e
+			return
+		}
+		result = &agentFile{
+			Data: data,
+		}
+	case qga.FileWrite:
+		err = qga.WriteFile(inst.Id, req.Path, req.Data)
+	case qga.Password:
+		err = qga.SetUserPassword(inst.Id, req.Username, req.Password)
+	case qga.OsInfo:
+		result, err = qga.GetOsInfo(inst.Id)
+	case qga.FsInfo:
+		result, err = qga.GetFsInfo(inst.Id)
+	case qga.Users:
+		result, err = qga.GetUsers(inst.Id)
+	case qga.Freeze:
+		count, e := qga.FreezeFs(inst.Id)
+		if e != nil {
+			err = e
+			return
+		}
+		result = &agentCount{
+			Count: count,
+		}
+	case qga.Thaw:
+		count, e := qga.ThawFs(inst.Id)
+		if e != nil {
+			err = e
+			return
+		}
+		result = &agentCount{
+			Count: count,
+		}
+	case qga.FreezeStat:
+		status, e := qga.GetFreezeStatus(inst.Id)
+		if e != nil {
+			err = e
+			return
+		}
+		result = &agentStatus{
+			Status: status,
+		}
+	default:
+		err = &errortypes.ParseError{
+			errors.Newf("console: Unknown agent operation '%s'",
+				req.Operation),
+		}
+	}
+
+	return
+}
+
+func handleAgent(inst *instance.Instance, conn net.Conn) (err error) {
+	err = conn.SetDeadline(time.Now().Add(10 * time.Second))
+	if err != nil {
+		return
+	}
+
+	req := &AgentRequest{}
+	err = json.NewDecoder(conn).Decode(req)
+	if err != nil {
+		err = &errortypes.ParseError{
+			errors.Wrap(err, "console: Failed to parse agent request"),
+		}
+		return
+	}
+
+	err = conn.SetDeadline(time.Now().Add(90 * time.Second))
+	if err != nil {
+		return
+	}
+
+	resp := &agentResponse{}
+
+	result, e := runAgent(inst, req)
+	if e != nil {
+		if dErr, ok := e.(errors.DropboxError); ok {
+			resp.Error = dErr.GetMessage()
+		} else {
+			resp.Error = e.Error()
+		}
+	} else if result != nil {
+		resp.Result, err = json.Marshal(result)
+		if err != nil {
+			err = &errortypes.ParseError{
+				errors.Wrap(err, "console: Failed to marshal agent result"),
+			}
+			return
+		}
+	}
+
+	err = json.NewEncoder(conn).Encode(resp)
+	if err != nil {
+		err = &errortypes.WriteError{
+			errors.Wrap(err, "console: Failed to write agent response"),
+		}
+		return
+	}
+
+	err = e
+
+	return
+}
+
+func RunAgent(db *database.Database, inst *instance.Instance,
+	req *AgentRequest) (result json.RawMessage,
+	errData *errortypes.ErrorData, err error) {
+
+	conn, err := Connect(db, Agent, inst)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	err = conn.SetDeadline(time.Now().Add(100 * time.Second))
+	if err != nil {
+		return
+	}
+
+	err = json.NewEncoder(conn).Encode(req)
+	if err != nil {
+		err = &errortypes.WriteError{
+			errors.Wrap(err, "console: Failed to write agent request"),
+		}
+		return
+	}
+
+	resp := &agentResponse{}
+	err = json.NewDecoder(conn).Decode(resp)
+	if err != nil {
+		err = &errortypes.ReadError{
+			errors.Wrap(err, "console: Failed to read agent response"),
+		}
+		return
+	}
+
+	if resp.Error != "" {
+		errData = &errortypes.ErrorData{
+			Error:   "agent_failed",
+			Message: resp.Error,
+		}
+		return
+	}
+
+	result = resp.Result
+	if result == nil {
+		result = json.RawMessage("{}")
+	}
+
+	return
+}
@@ -0,0 +1,93 @@
+package console
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"time"
+
+	"github.com/dropbox/godropbox/errors"
+	"github.com/pritunl/pritunl-cloud/database"
+	"github.com/pritunl/pritunl-cloud/errortypes"
+	"github.com/pritunl/pritunl-cloud/instance"
+	"github.com/pritunl/pritunl-cloud/node"
+	"github.com/pritunl/pritunl-cloud/settings"
+)
+
+func Connect(db *database.Database, typ string, inst *instance.Instance) (
+	conn net.Conn, err error) {
+
+	if inst.Node.IsZero() {
+		err = &errortypes.NotFoundError{
+			errors.New("console: Instance missing node"),
+		}
+		return
+	}
+
+	nde, err := node.Get(db, inst.Node)
+	if err != nil {
+		return
+	}
+
+	addr := getNodeAddr(nde)
+	if addr == "" {
+		err = &errortypes.NotFoundError{
+			errors.New("console: Node missing internal address"),
+		}
+		return
+	}
+
+	tkn, err := NewToken(db, typ, inst.Id, nde.Id)
+	if err != nil {
+		return
+	}
+
+	conn, err = net.DialTimeout(
+		"tcp",
+		fmt.Sprintf("%s:%d", addr, settings.Hypervisor.ConsolePort),
+		5*time.Second,
+	)
+	if err != nil {
+		err = &errortypes.ConnectionError{
+			errors.Wrap(err, "console: Failed to connect to node"),
+		}
+		return
+	}
+
+	_, err = conn.Write([]byte(tkn.Id + "\n"))
+	if err != nil {
+		conn.Close()
+		conn = nil
+		err = &errortypes.WriteError{
+			errors.Wrap(err, "console: Failed to write token"),
+		}
+		return
+	}
+
+	return
+}
+
+func ReadSerialLog(db *database.Database, inst *instance.Instance) (
+	data []byte, err error) {
+
+	conn, err := Connect(db, SerialLog, inst)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	err = conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	if err != nil {
+		return
+	}
+
+	data, err = ioutil.ReadAll(conn)
+	if err != nil {
+		err = &errortypes.ReadError{
+			errors.Wrap(err, "console: Failed to read serial log"),
+		}
+		return
+	}
+
+	return
+}
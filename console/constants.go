@@ -0,0 +1,9 @@
+package console
+
+const (
+	Serial     = "serial"
+	SerialLog  = "serial_log"
+	Vnc        = "vnc"
+	VncSession = "vnc_session"
+	Agent      = "agent"
+)
@@ -0,0 +1,305 @@
+package console
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/dropbox/godropbox/errors"
+	"github.com/pritunl/pritunl-cloud/constants"
+	"github.com/pritunl/pritunl-cloud/database"
+	"github.com/pritunl/pritunl-cloud/errortypes"
+	"github.com/pritunl/pritunl-cloud/instance"
+	"github.com/pritunl/pritunl-cloud/node"
+	"github.com/pritunl/pritunl-cloud/paths"
+	"github.com/pritunl/pritunl-cloud/settings"
+)
+
+const serialLogMax = 256 * 1024
+
+var (
+	listener    net.Listener
+	listenerKey = ""
+)
+
+func getNodeAddr(nde *node.Node) string {
+	if nde.PrivateIps != nil {
+		for _, iface := range nde.InternalInterfaces {
+			addr := nde.PrivateIps[iface]
+			if addr != "" {
+				return addr
+			}
+		}
+	}
+
+	return ""
+}
+
+func writeSerialLog(tkn *Token, conn net.Conn) (err error) {
+	file, err := os.Open(paths.GetSerialLogPath(tkn.Instance))
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+			return
+		}
+
+		err = &errortypes.ReadError{
+			errors.Wrap(err, "console: Failed to open serial log"),
+		}
+		return
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		err = &errortypes.ReadError{
+			errors.Wrap(err, "console: Failed to stat serial log"),
+		}
+		return
+	}
+
+	if stat.Size() > serialLogMax {
+		_, err = file.Seek(-serialLogMax, io.SeekEnd)
+		if err != nil {
+			err = &errortypes.ReadError{
+				errors.Wrap(err, "console: Failed to seek serial log"),
+			}
+			return
+		}
+	}
+
+	_, err = io.Copy(conn, file)
+	if err != nil {
+		err = &errortypes.WriteError{
+			errors.Wrap(err, "console: Failed to write serial log"),
+		}
+		return
+	}
+
+	return
+}
+
+func bridgeSerial(tkn *Token, conn net.Conn) (err error) {
+	serialConn, err := net.DialTimeout(
+		"unix",
+		paths.GetSerialPath(tkn.Instance),
+		3*time.Second,
+	)
+	if err != nil {
+		err = &errortypes.ConnectionError{
+			errors.Wrap(err, "console: Failed to open serial socket"),
+		}
+		return
+	}
+
+	pipe(conn, serialConn)
+
+	return
+}
+
+func bridgeVnc(inst *instance.Instance, conn net.Conn) (err error) {
+	if !inst.Vnc || inst.VncDisplay == 0 {
+		err = &errortypes.NotFoundError{
+			errors.New("console: Instance VNC not enabled"),
+		}
+		return
+	}
+
+	vncConn, err := net.DialTimeout(
+		"tcp",
+		fmt.Sprintf("127.0.0.1:%d", 5900+inst.VncDisplay),
+		3*time.Second,
+	)
+	if err != nil {
+		err = &errortypes.ConnectionError{
+			errors.Wrap(err, "console: Failed to connect to VNC server"),
+		}
+		return
+	}
+
+	pipe(conn, vncConn)
+
+	return
+}
+
+func pipe(conn, target net.Conn) {
+	done := make(chan bool, 2)
+
+	go func() {
+		_, _ = io.Copy(target, conn)
+		done <- true
+	}()
+	go func() {
+		_, _ = io.Copy(conn, target)
+		done <- true
+	}()
+
+	<-done
+	_ = conn.Close()
+	_ = target.Close()
+}
+
+func readToken(conn net.Conn) (tknId string, err error) {
+	buf := make([]byte, 1)
+	line := []byte{}
+
+	for len(line) < 128 {
+		_, err = conn.Read(buf)
+		if err != nil {
+			err = &errortypes.ReadError{
+				errors.Wrap(err, "console: Failed to read token"),
+			}
+			return
+		}
+
+		if buf[0] == '\n' {
+			tknId = strings.TrimSpace(string(line))
+			return
+		}
+		line = append(line, buf[0])
+	}
+
+	err = &errortypes.ParseError{
+		errors.New("console: Console token too long"),
+	}
+	return
+}
+
+func handleConn(conn net.Conn) (err error) {
+	defer conn.Close()
+
+	err = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err != nil {
+		return
+	}
+
+	tknId, err := readToken(conn)
+	if err != nil {
+		return
+	}
+
+	db := database.GetDatabase()
+	defer db.Close()
+
+	tkn, err := Consume(db, tknId, node.Self.Id)
+	if err != nil {
+		if _, ok := err.(*database.NotFoundError); ok {
+			err = &errortypes.AuthenticationError{
+				errors.New("console: Invalid console token"),
+			}
+		}
+		return
+	}
+
+	inst, err := instance.Get(db, tkn.Instance)
+	if err != nil {
+		return
+	}
+
+	if inst.Node != node.Self.Id {
+		err = &errortypes.AuthenticationError{
+			errors.New("console: Instance not on node"),
+		}
+		return
+	}
+
+	err = conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		return
+	}
+
+	switch tkn.Type {
+	case Serial:
+		err = bridgeSerial(tkn, conn)
+	case SerialLog:
+		err = writeSerialLog(tkn, conn)
+	case Vnc:
+		err = bridgeVnc(inst, conn)
+	case Agent:
+		err = handleAgent(inst, conn)
+	default:
+		err = &errortypes.ParseError{
+			errors.Newf("console: Unknown console type '%s'", tkn.Type),
+		}
+	}
+
+	return
+}
+
+func serve(lstn net.Listener) {
+	for {
+		conn, err := lstn.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			e := handleConn(conn)
+			if e != nil {
+				logrus.WithFields(logrus.Fields{
+					"remote": conn.RemoteAddr().String(),
+					"error":  e,
+				}).Warning("console: Console connection failed")
+			}
+		}()
+	}
+}
+
+func update() {
+	addr := ""
+	if node.Self.IsHypervisor() {
+		addr = getNodeAddr(node.Self)
+	}
+
+	key := ""
+	if addr != "" {
+		key = fmt.Sprintf("%s:%d", addr, settings.Hypervisor.ConsolePort)
+	}
+
+	if key == listenerKey {
+		return
+	}
+
+	if listener != nil {
+		_ = listener.Close()
+		listener = nil
+	}
+
+	listenerKey = key
+
+	if key == "" {
+		return
+	}
+
+	lstn, err := net.Listen("tcp", key)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"address": key,
+			"error":   err,
+		}).Error("console: Failed to start console server")
+		return
+	}
+	listener = lstn
+
+	go serve(lstn)
+}
+
+func runner() {
+	for {
+		if constants.Interrupt {
+			return
+		}
+
+		update()
+
+		time.Sleep(5 * time.Second)
+	}
+}
+
+func Init() {
+	go runner()
+}
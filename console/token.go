@@ -0,0 +1,121 @@
+package console
+
+import (
+	"time"
+
+	"github.com/pritunl/mongo-go-driver/bson"
+	"github.com/pritunl/mongo-go-driver/bson/primitive"
+	"github.com/pritunl/pritunl-cloud/database"
+	"github.com/pritunl/pritunl-cloud/utils"
+)
+
+const tokenTtl = 30 * time.Second
+
+type Token struct {
+	Id        string             `bson:"_id"`
+	Type      string             `bson:"type"`
+	Instance  primitive.ObjectID `bson:"instance"`
+	Node      primitive.ObjectID `bson:"node,omitempty"`
+	User      primitive.ObjectID `bson:"user,omitempty"`
+	Timestamp time.Time          `bson:"timestamp"`
+}
+
+func NewToken(db *database.Database, typ string,
+	instId, ndeId primitive.ObjectID) (tkn *Token, err error) {
+
+	coll := db.ConsoleTokens()
+
+	tknId, err := utils.RandStr(48)
+	if err != nil {
+		return
+	}
+
+	tkn = &Token{
+		Id:        tknId,
+		Type:      typ,
+		Instance:  instId,
+		Node:      ndeId,
+		Timestamp: time.Now(),
+	}
+
+	_, err = coll.InsertOne(db, tkn)
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
+	return
+}
+
+func NewSessionToken(db *database.Database,
+	instId, usrId primitive.ObjectID) (tkn *Token, err error) {
+
+	coll := db.ConsoleTokens()
+
+	tknId, err := utils.RandStr(48)
+	if err != nil {
+		return
+	}
+
+	tkn = &Token{
+		Id:        tknId,
+		Type:      VncSession,
+		Instance:  instId,
+		User:      usrId,
+		Timestamp: time.Now(),
+	}
+
+	_, err = coll.InsertOne(db, tkn)
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
+	return
+}
+
+func Consume(db *database.Database, tknId string,
+	ndeId primitive.ObjectID) (tkn *Token, err error) {
+
+	coll := db.ConsoleTokens()
+	tkn = &Token{}
+
+	err = coll.FindOneAndDelete(db, &bson.M{
+		"_id":  tknId,
+		"node": ndeId,
+		"timestamp": &bson.M{
+			"$gte": time.Now().Add(-tokenTtl),
+		},
+	}).Decode(tkn)
+	if err != nil {
+		err = database.ParseError(err)
+		tkn = nil
+		return
+	}
+
+	return
+}
+
+func ConsumeSession(db *database.Database, tknId string,
+	instId, usrId primitive.ObjectID) (tkn *Token, err error) {
+
+	coll := db.ConsoleTokens()
+	tkn = &Token{}
+
+	err = coll.FindOneAndDelete(db, &bson.M{
+		"_id":      tknId,
+		"type":     VncSession,
+		"instance": instId,
+		"user":     usrId,
+		"timestamp": &bson.M{
+			"$gte": time.Now().Add(-tokenTtl),
+		},
+	}).Decode(tkn)
+	if err != nil {
+		err = database.ParseError(err)
+		tkn = nil
+		return
+	}
+
+	return
+}
@@ -0,0 +1,111 @@
+package console
+
+import (
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	writeTimeout = 10 * time.Second
+	pingInterval = 30 * time.Second
+	pingWait     = 40 * time.Second
+)
+
+var (
+	Upgrader = websocket.Upgrader{
+		HandshakeTimeout: 30 * time.Second,
+		ReadBufferSize:   4096,
+		WriteBufferSize:  4096,
+		Subprotocols:     []string{"binary"},
+		CheckOrigin: func(r *http.Request) bool {
+			return true
+		},
+	}
+)
+
+func Bridge(wsConn *websocket.Conn, conn net.Conn) {
+	done := make(chan bool, 2)
+	stop := make(chan bool)
+
+	defer func() {
+		close(stop)
+		_ = conn.Close()
+		_ = wsConn.Close()
+	}()
+
+	wsConn.SetReadDeadline(time.Now().Add(pingWait))
+	wsConn.SetPongHandler(func(x string) (err error) {
+		wsConn.SetReadDeadline(time.Now().Add(pingWait))
+		return
+	})
+
+	go func() {
+		defer func() {
+			done <- true
+		}()
+
+		for {
+			_, data, err := wsConn.ReadMessage()
+			if err != nil {
+				return
+			}
+
+			_, err = conn.Write(data)
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	output := make(chan []byte, 16)
+	go func() {
+		defer func() {
+			close(output)
+		}()
+
+		for {
+			buf := make([]byte, 4096)
+			n, err := conn.Read(buf)
+			if err != nil {
+				return
+			}
+
+			select {
+			case output <- buf[:n]:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case data, ok := <-output:
+			if !ok {
+				wsConn.WriteControl(websocket.CloseMessage, []byte{},
+					time.Now().Add(writeTimeout))
+				return
+			}
+
+			wsConn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			err := wsConn.WriteMessage(websocket.BinaryMessage, data)
+			if err != nil {
+				return
+			}
+		case <-ticker.C:
+			err := wsConn.WriteControl(websocket.PingMessage, []byte{},
+				time.Now().Add(writeTimeout))
+			if err != nil {
+				return
+			}
+		}
+	}
+}
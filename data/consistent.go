@@ -0,0 +1,234 @@
+package data
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/dropbox/godropbox/errors"
+	"github.com/pritunl/mongo-go-driver/bson/primitive"
+	"github.com/pritunl/pritunl-cloud/database"
+	"github.com/pritunl/pritunl-cloud/disk"
+	"github.com/pritunl/pritunl-cloud/errortypes"
+	"github.com/pritunl/pritunl-cloud/image"
+	"github.com/pritunl/pritunl-cloud/instance"
+	"github.com/pritunl/pritunl-cloud/paths"
+	"github.com/pritunl/pritunl-cloud/qga"
+	"github.com/pritunl/pritunl-cloud/qms"
+	"github.com/pritunl/pritunl-cloud/settings"
+	"github.com/pritunl/pritunl-cloud/systemd"
+	"github.com/pritunl/pritunl-cloud/utils"
+)
+
+func getFreezeTimeout() time.Duration {
+	return time.Duration(settings.Hypervisor.FreezeTimeout) * time.Second
+}
+
+func runFreezeHook(inst *instance.Instance, hookPath string) (ok bool) {
+	if hookPath == "" {
+		ok = true
+		return
+	}
+
+	result, err := qga.ExecCommand(inst.Id, hookPath, nil, "",
+		getFreezeTimeout())
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"instance_id": inst.Id.Hex(),
+			"hook_path":   hookPath,
+			"error":       err,
+		}).Warning("data: Failed to run instance freeze hook")
+		return
+	}
+
+	if !result.Exited || result.ExitCode != 0 {
+		logrus.WithFields(logrus.Fields{
+			"instance_id": inst.Id.Hex(),
+			"hook_path":   hookPath,
+			"exited":      result.Exited,
+			"exit_code":   result.ExitCode,
+			"output":      result.Error,
+		}).Warning("data: Instance freeze hook failed")
+		return
+	}
+
+	ok = true
+	return
+}
+
+func thawGuest(inst *instance.Instance) {
+	_, err := qga.ThawFs(inst.Id)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"instance_id": inst.Id.Hex(),
+			"error":       err,
+		}).Error("data: Failed to thaw instance filesystems")
+	}
+
+	runFreezeHook(inst, inst.PostThawHook)
+}
+
+func freezeGuest(inst *instance.Instance) (frozen bool) {
+	if !runFreezeHook(inst, inst.PreFreezeHook) {
+		runFreezeHook(inst, inst.PostThawHook)
+		return
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, e := qga.FreezeFs(inst.Id)
+		done <- e
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"instance_id": inst.Id.Hex(),
+				"error":       err,
+			}).Warning("data: Failed to freeze instance filesystems")
+			thawGuest(inst)
+			return
+		}
+	case <-time.After(getFreezeTimeout()):
+		logrus.WithFields(logrus.Fields{
+			"instance_id": inst.Id.Hex(),
+		}).Warning("data: Timed out freezing instance filesystems")
+		thawGuest(inst)
+		return
+	}
+
+	frozen = true
+	return
+}
+
+func resetBitmap(dskPth string) (err error) {
+	_, _ = utils.ExecCombinedOutput("",
+		"qemu-img", "bitmap", "--remove", "-f", "qcow2", dskPth, qms.Bitmap)
+
+	_, err = utils.ExecCombinedOutputLogged(nil,
+		"qemu-img", "bitmap", "--add", "-f", "qcow2", dskPth, qms.Bitmap)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func copyDisk(db *database.Database, dsk *disk.Disk,
+	copyId primitive.ObjectID, dstPath, mode string) (
+	consistency string, err error) {
+
+	dskPth := paths.GetDiskPath(dsk.Id)
+
+	// Images of running virtual machines are locked and must only be
+	// copied offline once the unit is confirmed stopped
+	live := false
+	if !dsk.Instance.IsZero() {
+		state, _, e := systemd.GetState(paths.GetUnitName(dsk.Instance))
+		if e != nil {
+			err = e
+			return
+		}
+
+		if state != "inactive" && state != "failed" {
+			live = true
+		}
+	}
+
+	if !live {
+		if mode == qms.BackupIncremental {
+			err = &errortypes.ReadError{
+				errors.New("data: Incremental backup of stopped disk"),
+			}
+			return
+		}
+
+		err = utils.Exec("", "qemu-img", "convert", "-f", "qcow2",
+			"-O", "qcow2", "-c", dskPth, dstPath)
+		if err != nil {
+			return
+		}
+
+		if mode == qms.BackupReset {
+			err = resetBitmap(dskPth)
+			if err != nil {
+				return
+			}
+		}
+
+		consistency = image.Offline
+		return
+	}
+
+	index, e := strconv.Atoi(dsk.Index)
+	if e != nil {
+		err = &errortypes.ParseError{
+			errors.Wrap(e, "data: Failed to parse disk index"),
+		}
+		return
+	}
+
+	legacy, err := qms.IsLegacy(dsk.Instance)
+	if err != nil {
+		return
+	}
+
+	if legacy {
+		err = &errortypes.ExecError{
+			errors.New("data: Instance must be restarted to backup " +
+				"disks while running"),
+		}
+		return
+	}
+
+	inst, err := instance.Get(db, dsk.Instance)
+	if err != nil {
+		return
+	}
+
+	size, err := qms.GetDiskSize(inst.Id, index)
+	if err != nil {
+		return
+	}
+
+	err = utils.Exec("", "qemu-img", "create", "-f", "qcow2",
+		dstPath, strconv.FormatInt(size, 10))
+	if err != nil {
+		return
+	}
+
+	frozen := freezeGuest(inst)
+
+	err = qms.StartBackup(inst.Id, index, copyId, dstPath, mode)
+	if frozen {
+		thawGuest(inst)
+	}
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		e := qms.StopBackup(inst.Id, copyId)
+		if e != nil {
+			logrus.WithFields(logrus.Fields{
+				"instance_id": inst.Id.Hex(),
+				"disk_id":     dsk.Id.Hex(),
+				"error":       e,
+			}).Error("data: Failed to stop disk backup job")
+		}
+	}()
+
+	err = qms.WaitBackup(inst.Id, copyId)
+	if err != nil {
+		return
+	}
+
+	if frozen {
+		consistency = image.Application
+	} else {
+		consistency = image.Crash
+	}
+
+	return
+}
@@ -0,0 +1,71 @@
+package data
+
+import (
+	"github.com/pritunl/mongo-go-driver/bson/primitive"
+	"github.com/pritunl/pritunl-cloud/database"
+	"github.com/pritunl/pritunl-cloud/datakey"
+	"github.com/pritunl/pritunl-cloud/settings"
+	"github.com/pritunl/pritunl-cloud/utils"
+)
+
+func getDataKey(db *database.Database, orgId primitive.ObjectID) (
+	dkeyId primitive.ObjectID, key []byte, err error) {
+
+	if !settings.System.BackupEncryption {
+		return
+	}
+
+	dkey, err := datakey.GetActive(db, orgId)
+	if err != nil {
+		return
+	}
+
+	key, err = dkey.Unwrap()
+	if err != nil {
+		return
+	}
+
+	dkeyId = dkey.Id
+	return
+}
+
+func encryptFile(key []byte, pth string) (err error) {
+	encPth := pth + ".enc"
+	defer utils.Remove(encPth)
+
+	err = datakey.EncryptFile(key, pth, encPth)
+	if err != nil {
+		return
+	}
+
+	err = utils.Exec("", "mv", "-f", encPth, pth)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func decryptFile(db *database.Database, dkeyId primitive.ObjectID,
+	pth string) (err error) {
+
+	key, err := datakey.GetKey(db, dkeyId)
+	if err != nil {
+		return
+	}
+
+	decPth := pth + ".dec"
+	defer utils.Remove(decPth)
+
+	err = datakey.DecryptFile(key, pth, decPth)
+	if err != nil {
+		return
+	}
+
+	err = utils.Exec("", "mv", "-f", decPth, pth)
+	if err != nil {
+		return
+	}
+
+	return
+}
@@ -5,10 +5,12 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/Sirupsen/logrus"
+	"github.com/dropbox/godropbox/container/set"
 	"github.com/dropbox/godropbox/errors"
 	minio "github.com/minio/minio-go"
 	"github.com/minio/minio-go/pkg/credentials"
@@ -16,12 +18,15 @@ import (
 	"github.com/pritunl/pritunl-cloud/constants"
 	"github.com/pritunl/pritunl-cloud/database"
 	"github.com/pritunl/pritunl-cloud/datacenter"
+	"github.com/pritunl/pritunl-cloud/datakey"
 	"github.com/pritunl/pritunl-cloud/disk"
 	"github.com/pritunl/pritunl-cloud/errortypes"
 	"github.com/pritunl/pritunl-cloud/event"
 	"github.com/pritunl/pritunl-cloud/image"
 	"github.com/pritunl/pritunl-cloud/node"
 	"github.com/pritunl/pritunl-cloud/paths"
+	"github.com/pritunl/pritunl-cloud/qms"
+	"github.com/pritunl/pritunl-cloud/settings"
 	"github.com/pritunl/pritunl-cloud/storage"
 	"github.com/pritunl/pritunl-cloud/utils"
 	"github.com/pritunl/pritunl-cloud/zone"
@@ -33,6 +38,93 @@ var (
 	backingImageLock = utils.NewMultiTimeoutLock(5 * time.Minute)
 )
 
+func getBackupChain(db *database.Database, img *image.Image) (
+	chain []*image.Image, err error) {
+
+	chain = []*image.Image{img}
+
+	for !img.Parent.IsZero() {
+		parent, e := image.Get(db, img.Parent)
+		if e != nil {
+			err = e
+			if _, ok := err.(*database.NotFoundError); ok {
+				err = &errortypes.NotFoundError{
+					errors.Wrap(err, "data: Backup chain parent missing"),
+				}
+			}
+			return
+		}
+
+		if parent.Disk != img.Disk || parent.Storage != img.Storage ||
+			parent.Chain != img.Chain-1 {
+
+			err = &errortypes.VerificationError{
+				errors.New("data: Backup chain invalid"),
+			}
+			return
+		}
+
+		chain = append([]*image.Image{parent}, chain...)
+		img = parent
+	}
+
+	return
+}
+
+func downloadChain(db *database.Database, client *minio.Client,
+	store *storage.Storage, chain []*image.Image, pth string) (err error) {
+
+	chainPaths := []string{}
+	defer func() {
+		for _, chainPath := range chainPaths {
+			_ = utils.Remove(chainPath)
+		}
+	}()
+
+	for i, chainImg := range chain {
+		chainPath := pth
+		if len(chain) > 1 {
+			chainPath = fmt.Sprintf("%s-%d", pth, i)
+			chainPaths = append(chainPaths, chainPath)
+		}
+
+		err = client.FGetObject(context.Background(), store.Bucket,
+			chainImg.Key, chainPath, minio.GetObjectOptions{})
+		if err != nil {
+			err = &errortypes.ReadError{
+				errors.Wrap(err, "data: Failed to download image"),
+			}
+			return
+		}
+
+		if !chainImg.DataKey.IsZero() {
+			err = decryptFile(db, chainImg.DataKey, chainPath)
+			if err != nil {
+				return
+			}
+		}
+
+		if i > 0 {
+			err = utils.Exec("", "qemu-img", "rebase", "-u",
+				"-f", "qcow2", "-b", chainPaths[i-1], "-F", "qcow2",
+				chainPath)
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	if len(chain) > 1 {
+		err = utils.Exec("", "qemu-img", "convert", "-f", "qcow2",
+			"-O", "qcow2", chainPaths[len(chainPaths)-1], pth)
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
 func getImage(db *database.Database, img *image.Image,
 	pth string) (err error) {
 
@@ -81,14 +173,17 @@ func getImage(db *database.Database, img *image.Image,
 		return
 	}
 
-	err = client.FGetObject(context.Background(), store.Bucket,
-		img.Key, tmpPth, minio.GetObjectOptions{})
+	chain := []*image.Image{img}
+	if !img.Parent.IsZero() {
+		chain, err = getBackupChain(db, img)
+		if err != nil {
+			return
+		}
+	}
+
+	err = downloadChain(db, client, store, chain, tmpPth)
 	if err != nil {
 		os.Remove(tmpPth)
-
-		err = &errortypes.ReadError{
-			errors.Wrap(err, "data: Failed to download image"),
-		}
 		return
 	}
 
@@ -385,7 +480,9 @@ func WriteImage(db *database.Database, imgId, dskId primitive.ObjectID,
 	return
 }
 
-func DeleteImage(db *database.Database, imgId primitive.ObjectID) (err error) {
+func DeleteImage(db *database.Database, imgId primitive.ObjectID,
+	chain bool) (errData *errortypes.ErrorData, err error) {
+
 	img, err := image.Get(db, imgId)
 	if err != nil {
 		return
@@ -395,6 +492,26 @@ func DeleteImage(db *database.Database, imgId primitive.ObjectID) (err error) {
 		return
 	}
 
+	children, err := image.GetChildren(db, img.Id)
+	if err != nil {
+		return
+	}
+
+	if len(children) > 0 && !chain {
+		errData = &errortypes.ErrorData{
+			Error:   "image_has_children",
+			Message: "Image has incremental backups that depend on it",
+		}
+		return
+	}
+
+	for _, child := range children {
+		_, err = DeleteImage(db, child.Id, true)
+		if err != nil {
+			return
+		}
+	}
+
 	store, err := storage.Get(db, img.Storage)
 	if err != nil {
 		return
@@ -417,6 +534,14 @@ func DeleteImage(db *database.Database, imgId primitive.ObjectID) (err error) {
 		return
 	}
 
+	if img.NvramKey != "" {
+		err = client.RemoveObject(context.Background(),
+			store.Bucket, img.NvramKey, minio.RemoveObjectOptions{})
+		if err != nil {
+			return
+		}
+	}
+
 	err = image.Remove(db, img.Id)
 	if err != nil {
 		return
@@ -425,12 +550,12 @@ func DeleteImage(db *database.Database, imgId primitive.ObjectID) (err error) {
 	return
 }
 
-func DeleteImages(db *database.Database, imgIds []primitive.ObjectID) (
-	err error) {
+func DeleteImages(db *database.Database, imgIds []primitive.ObjectID,
+	chain bool) (errData *errortypes.ErrorData, err error) {
 
 	for _, imgId := range imgIds {
-		err = DeleteImage(db, imgId)
-		if err != nil {
+		errData, err = DeleteImage(db, imgId, chain)
+		if err != nil || errData != nil {
 			return
 		}
 	}
@@ -438,8 +563,8 @@ func DeleteImages(db *database.Database, imgIds []primitive.ObjectID) (
 	return
 }
 
-func DeleteImageOrg(db *database.Database, orgId, imgId primitive.ObjectID) (
-	err error) {
+func DeleteImageOrg(db *database.Database, orgId, imgId primitive.ObjectID,
+	chain bool) (errData *errortypes.ErrorData, err error) {
 
 	img, err := image.GetOrg(db, orgId, imgId)
 	if err != nil {
@@ -450,6 +575,26 @@ func DeleteImageOrg(db *database.Database, orgId, imgId primitive.ObjectID) (
 		return
 	}
 
+	children, err := image.GetChildren(db, img.Id)
+	if err != nil {
+		return
+	}
+
+	if len(children) > 0 && !chain {
+		errData = &errortypes.ErrorData{
+			Error:   "image_has_children",
+			Message: "Image has incremental backups that depend on it",
+		}
+		return
+	}
+
+	for _, child := range children {
+		_, err = DeleteImageOrg(db, orgId, child.Id, true)
+		if err != nil {
+			return
+		}
+	}
+
 	store, err := storage.Get(db, img.Storage)
 	if err != nil {
 		return
@@ -472,6 +617,14 @@ func DeleteImageOrg(db *database.Database, orgId, imgId primitive.ObjectID) (
 		return
 	}
 
+	if img.NvramKey != "" {
+		err = client.RemoveObject(context.Background(),
+			store.Bucket, img.NvramKey, minio.RemoveObjectOptions{})
+		if err != nil {
+			return
+		}
+	}
+
 	err = image.Remove(db, img.Id)
 	if err != nil {
 		return
@@ -481,11 +634,12 @@ func DeleteImageOrg(db *database.Database, orgId, imgId primitive.ObjectID) (
 }
 
 func DeleteImagesOrg(db *database.Database, orgId primitive.ObjectID,
-	imgIds []primitive.ObjectID) (err error) {
+	imgIds []primitive.ObjectID, chain bool) (
+	errData *errortypes.ErrorData, err error) {
 
 	for _, imgId := range imgIds {
-		err = DeleteImageOrg(db, orgId, imgId)
-		if err != nil {
+		errData, err = DeleteImageOrg(db, orgId, imgId, chain)
+		if err != nil || errData != nil {
 			return
 		}
 	}
@@ -550,8 +704,7 @@ func CreateSnapshot(db *database.Database, dsk *disk.Disk) (err error) {
 	}
 
 	defer utils.Remove(tmpPath)
-	err = utils.Exec("", "qemu-img", "convert", "-f", "qcow2",
-		"-O", "qcow2", "-c", dskPth, tmpPath)
+	img.Consistency, err = copyDisk(db, dsk, imgId, tmpPath, qms.BackupFull)
 	if err != nil {
 		return
 	}
@@ -561,11 +714,26 @@ func CreateSnapshot(db *database.Database, dsk *disk.Disk) (err error) {
 		return
 	}
 
+	dkeyId, key, err := getDataKey(db, dsk.Organization)
+	if err != nil {
+		return
+	}
+
+	if key != nil {
+		err = encryptFile(key, tmpPath)
+		if err != nil {
+			return
+		}
+		img.DataKey = dkeyId
+	}
+
 	logrus.WithFields(logrus.Fields{
-		"disk_id":    dsk.Id.Hex(),
-		"disk_path":  dskPth,
-		"storage_id": store.Id.Hex(),
-		"object_key": img.Key,
+		"disk_id":     dsk.Id.Hex(),
+		"disk_path":   dskPth,
+		"storage_id":  store.Id.Hex(),
+		"object_key":  img.Key,
+		"consistency": img.Consistency,
+		"encrypted":   !img.DataKey.IsZero(),
 	}).Info("data: Uploading disk snapshot")
 
 	client, err := minio.New(store.Endpoint, &minio.Options{
@@ -625,7 +793,64 @@ func CreateSnapshot(db *database.Database, dsk *disk.Disk) (err error) {
 	return
 }
 
+func getBackupParent(db *database.Database, dsk *disk.Disk,
+	store *storage.Storage) (parent *image.Image, err error) {
+
+	if dsk.BackupImage.IsZero() || dsk.Instance.IsZero() {
+		return
+	}
+
+	img, err := image.Get(db, dsk.BackupImage)
+	if err != nil {
+		if _, ok := err.(*database.NotFoundError); ok {
+			err = nil
+		}
+		return
+	}
+
+	if img.Disk != dsk.Id || img.Storage != store.Id ||
+		img.Chain >= settings.System.DiskBackupChain {
+
+		return
+	}
+
+	index, e := strconv.Atoi(dsk.Index)
+	if e != nil {
+		return
+	}
+
+	exists, e := qms.HasBitmap(dsk.Instance, index)
+	if e != nil || !exists {
+		return
+	}
+
+	parent = img
+	return
+}
+
 func CreateBackup(db *database.Database, dsk *disk.Disk) (err error) {
+	err = createBackup(db, dsk, false)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func CreateIncrementalBackup(db *database.Database, dsk *disk.Disk) (
+	err error) {
+
+	err = createBackup(db, dsk, true)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func createBackup(db *database.Database, dsk *disk.Disk,
+	incremental bool) (err error) {
+
 	dskPth := paths.GetDiskPath(dsk.Id)
 	cacheDir := node.Self.GetCachePath()
 
@@ -669,10 +894,24 @@ func CreateBackup(db *database.Database, dsk *disk.Disk) (err error) {
 		return
 	}
 
+	var parent *image.Image
+	if incremental {
+		parent, err = getBackupParent(db, dsk, store)
+		if err != nil {
+			return
+		}
+	}
+
+	mode := qms.BackupReset
+	if parent != nil {
+		mode = qms.BackupIncremental
+	}
+
 	logrus.WithFields(logrus.Fields{
 		"disk_id":    dsk.Id.Hex(),
 		"storage_id": store.Id.Hex(),
 		"disk_path":  dskPth,
+		"mode":       mode,
 	}).Info("data: Creating disk backup")
 
 	imgId := primitive.NewObjectID()
@@ -689,9 +928,21 @@ func CreateBackup(db *database.Database, dsk *disk.Disk) (err error) {
 		Key:          fmt.Sprintf("backup/%s.qcow2", imgId.Hex()),
 	}
 
+	if parent != nil {
+		img.Parent = parent.Id
+		img.Chain = parent.Chain + 1
+	}
+
+	if !dsk.BackupImage.IsZero() {
+		dsk.BackupImage = primitive.NilObjectID
+		err = dsk.CommitFields(db, set.NewSet("backup_image"))
+		if err != nil {
+			return
+		}
+	}
+
 	defer utils.Remove(tmpPath)
-	err = utils.Exec("", "qemu-img", "convert", "-f", "qcow2",
-		"-O", "qcow2", "-c", dskPth, tmpPath)
+	img.Consistency, err = copyDisk(db, dsk, imgId, tmpPath, mode)
 	if err != nil {
 		return
 	}
@@ -701,11 +952,26 @@ func CreateBackup(db *database.Database, dsk *disk.Disk) (err error) {
 		return
 	}
 
+	dkeyId, key, err := getDataKey(db, dsk.Organization)
+	if err != nil {
+		return
+	}
+
+	if key != nil {
+		err = encryptFile(key, tmpPath)
+		if err != nil {
+			return
+		}
+		img.DataKey = dkeyId
+	}
+
 	logrus.WithFields(logrus.Fields{
-		"disk_id":    dsk.Id.Hex(),
-		"disk_path":  dskPth,
-		"storage_id": store.Id.Hex(),
-		"object_key": img.Key,
+		"disk_id":     dsk.Id.Hex(),
+		"disk_path":   dskPth,
+		"storage_id":  store.Id.Hex(),
+		"object_key":  img.Key,
+		"consistency": img.Consistency,
+		"encrypted":   !img.DataKey.IsZero(),
 	}).Info("data: Uploading disk backup")
 
 	client, err := minio.New(store.Endpoint, &minio.Options{
@@ -735,6 +1001,47 @@ func CreateBackup(db *database.Database, dsk *disk.Disk) (err error) {
 		return
 	}
 
+	nvramPth := ""
+	if dsk.Index == "0" && !dsk.Instance.IsZero() {
+		nvramPth = paths.GetNvramPath(dsk.Instance)
+
+		exists, e := utils.Exists(nvramPth)
+		if e != nil {
+			err = e
+			return
+		}
+
+		if !exists {
+			nvramPth = ""
+		}
+	}
+
+	if nvramPth != "" && key != nil {
+		nvramEncPath := path.Join(cacheDir,
+			fmt.Sprintf("backup-%s.fd", imgId.Hex()))
+		defer utils.Remove(nvramEncPath)
+
+		err = datakey.EncryptFile(key, nvramPth, nvramEncPath)
+		if err != nil {
+			return
+		}
+		nvramPth = nvramEncPath
+	}
+
+	if nvramPth != "" {
+		img.NvramKey = fmt.Sprintf("backup/%s.fd", imgId.Hex())
+
+		_, err = client.FPutObject(context.Background(),
+			store.Bucket, img.NvramKey, nvramPth, putOpts)
+		if err != nil {
+			err = &errortypes.WriteError{
+				errors.Wrap(err, "data: Failed to write nvram object"),
+			}
+
+			return
+		}
+	}
+
 	time.Sleep(3 * time.Second)
 
 	obj, err := client.StatObject(context.Background(),
@@ -760,6 +1067,12 @@ func CreateBackup(db *database.Database, dsk *disk.Disk) (err error) {
 		return
 	}
 
+	dsk.BackupImage = img.Id
+	err = dsk.CommitFields(db, set.NewSet("backup_image"))
+	if err != nil {
+		return
+	}
+
 	event.PublishDispatch(db, "image.change")
 
 	return
@@ -786,11 +1099,17 @@ func RestoreBackup(db *database.Database, dsk *disk.Disk) (err error) {
 		return
 	}
 
+	chain, err := getBackupChain(db, img)
+	if err != nil {
+		return
+	}
+
 	logrus.WithFields(logrus.Fields{
 		"disk_id":    dsk.Id.Hex(),
 		"image_id":   img.Id.Hex(),
 		"storage_id": store.Id.Hex(),
 		"disk_path":  dskPth,
+		"chain":      len(chain),
 	}).Info("data: Restoring disk backup")
 
 	client, err := minio.New(store.Endpoint, &minio.Options{
@@ -807,14 +1126,10 @@ func RestoreBackup(db *database.Database, dsk *disk.Disk) (err error) {
 	imgId := primitive.NewObjectID()
 	tmpPath := path.Join(cacheDir,
 		fmt.Sprintf("restore-%s", imgId.Hex()))
-
 	defer utils.Remove(tmpPath)
-	err = client.FGetObject(context.Background(), store.Bucket,
-		img.Key, tmpPath, minio.GetObjectOptions{})
+
+	err = downloadChain(db, client, store, chain, tmpPath)
 	if err != nil {
-		err = &errortypes.ReadError{
-			errors.Wrap(err, "data: Failed to download restore image"),
-		}
 		return
 	}
 
@@ -828,6 +1143,52 @@ func RestoreBackup(db *database.Database, dsk *disk.Disk) (err error) {
 		return
 	}
 
+	if !dsk.BackupImage.IsZero() {
+		dsk.BackupImage = primitive.NilObjectID
+		err = dsk.CommitFields(db, set.NewSet("backup_image"))
+		if err != nil {
+			return
+		}
+	}
+
+	if img.NvramKey != "" && !dsk.Instance.IsZero() {
+		nvramTmpPath := path.Join(cacheDir,
+			fmt.Sprintf("restore-%s.fd", imgId.Hex()))
+
+		defer utils.Remove(nvramTmpPath)
+		err = client.FGetObject(context.Background(), store.Bucket,
+			img.NvramKey, nvramTmpPath, minio.GetObjectOptions{})
+		if err != nil {
+			err = &errortypes.ReadError{
+				errors.Wrap(err, "data: Failed to download restore nvram"),
+			}
+			return
+		}
+
+		if !img.DataKey.IsZero() {
+			err = decryptFile(db, img.DataKey, nvramTmpPath)
+			if err != nil {
+				return
+			}
+		}
+
+		err = utils.Chmod(nvramTmpPath, 0600)
+		if err != nil {
+			return
+		}
+
+		err = utils.Exec("", "mv", "-f", nvramTmpPath,
+			paths.GetNvramPath(dsk.Instance))
+		if err != nil {
+			return
+		}
+
+		err = utils.RemoveAll(paths.GetNvramFirmwarePath(dsk.Instance))
+		if err != nil {
+			return
+		}
+	}
+
 	return
 }
 
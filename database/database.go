@@ -191,6 +191,21 @@ func (d *Database) Firewalls() (coll *Collection) {
 	return
 }
 
+func (d *Database) BackupPolicies() (coll *Collection) {
+	coll = d.getCollection("backup_policies")
+	return
+}
+
+func (d *Database) DataKeys() (coll *Collection) {
+	coll = d.getCollection("data_keys")
+	return
+}
+
+func (d *Database) Placements() (coll *Collection) {
+	coll = d.getCollection("placements")
+	return
+}
+
 func (d *Database) Vpcs() (coll *Collection) {
 	coll = d.getCollection("vpcs")
 	return
@@ -236,6 +251,21 @@ func (d *Database) Audits() (coll *Collection) {
 	return
 }
 
+func (d *Database) ConsoleTokens() (coll *Collection) {
+	coll = d.getCollection("console_tokens")
+	return
+}
+
+func (d *Database) Metrics() (coll *Collection) {
+	coll = d.getCollection("metrics")
+	return
+}
+
+func (d *Database) MetricsHourly() (coll *Collection) {
+	coll = d.getCollection("metrics_hourly")
+	return
+}
+
 func (d *Database) Geo() (coll *Collection) {
 	coll = d.getCollection("geo")
 	return
@@ -590,6 +620,16 @@ func addIndexes() (err error) {
 	if err != nil {
 		return
 	}
+	index = &Index{
+		Collection: db.Images(),
+		Keys: &bson.D{
+			{"parent", 1},
+		},
+	}
+	err = index.Create()
+	if err != nil {
+		return
+	}
 
 	index = &Index{
 		Collection: db.Disks(),
@@ -603,6 +643,16 @@ func addIndexes() (err error) {
 	if err != nil {
 		return
 	}
+	index = &Index{
+		Collection: db.Disks(),
+		Keys: &bson.D{
+			{"backup_policy", 1},
+		},
+	}
+	err = index.Create()
+	if err != nil {
+		return
+	}
 	index = &Index{
 		Collection: db.Disks(),
 		Keys: &bson.D{
@@ -844,6 +894,46 @@ func addIndexes() (err error) {
 		return
 	}
 
+	index = &Index{
+		Collection: db.Placements(),
+		Keys: &bson.D{
+			{"organization", 1},
+			{"name", 1},
+		},
+	}
+	err = index.Create()
+	if err != nil {
+		return
+	}
+
+	index = &Index{
+		Collection: db.BackupPolicies(),
+		Keys: &bson.D{
+			{"organization", 1},
+			{"name", 1},
+		},
+	}
+	err = index.Create()
+	if err != nil {
+		return
+	}
+
+	index = &Index{
+		Collection: db.DataKeys(),
+		Keys: &bson.D{
+			{"organization", 1},
+			{"active", 1},
+		},
+		Partial: &bson.M{
+			"active": true,
+		},
+		Unique: true,
+	}
+	err = index.Create()
+	if err != nil {
+		return
+	}
+
 	index = &Index{
 		Collection: db.Zones(),
 		Keys: &bson.D{
@@ -919,6 +1009,16 @@ func addIndexes() (err error) {
 	if err != nil {
 		return
 	}
+	index = &Index{
+		Collection: db.Instances(),
+		Keys: &bson.D{
+			{"placement", 1},
+		},
+	}
+	err = index.Create()
+	if err != nil {
+		return
+	}
 	index = &Index{
 		Collection: db.Instances(),
 		Keys: &bson.D{
@@ -992,6 +1092,67 @@ func addIndexes() (err error) {
 		return
 	}
 
+	index = &Index{
+		Collection: db.ConsoleTokens(),
+		Keys: &bson.D{
+			{"timestamp", 1},
+		},
+		Expire: 3 * time.Minute,
+	}
+	err = index.Create()
+	if err != nil {
+		return
+	}
+
+	index = &Index{
+		Collection: db.Metrics(),
+		Keys: &bson.D{
+			{"i", 1},
+			{"t", 1},
+		},
+	}
+	err = index.Create()
+	if err != nil {
+		return
+	}
+
+	index = &Index{
+		Collection: db.Metrics(),
+		Keys: &bson.D{
+			{"t", 1},
+		},
+		Expire: 24 * time.Hour,
+	}
+	err = index.Create()
+	if err != nil {
+		return
+	}
+
+	index = &Index{
+		Collection: db.MetricsHourly(),
+		Keys: &bson.D{
+			{"i", 1},
+			{"t", 1},
+		},
+		Unique: true,
+	}
+	err = index.Create()
+	if err != nil {
+		return
+	}
+
+	index = &Index{
+		Collection: db.MetricsHourly(),
+		Keys: &bson.D{
+			{"t", 1},
+		},
+		Expire: 720 * time.Hour,
+	}
+	err = index.Create()
+	if err != nil {
+		return
+	}
+
 	index = &Index{
 		Collection: db.Geo(),
 		Keys: &bson.D{
@@ -0,0 +1,104 @@
+package datakey
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"time"
+
+	"github.com/dropbox/godropbox/container/set"
+	"github.com/dropbox/godropbox/errors"
+	"github.com/pritunl/mongo-go-driver/bson/primitive"
+	"github.com/pritunl/pritunl-cloud/database"
+	"github.com/pritunl/pritunl-cloud/errortypes"
+	"github.com/pritunl/pritunl-cloud/utils"
+)
+
+type DataKey struct {
+	Id           primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Organization primitive.ObjectID `bson:"organization" json:"organization"`
+	MasterKey    string             `bson:"master_key" json:"master_key"`
+	Key          []byte             `bson:"key" json:"-"`
+	Active       bool               `bson:"active" json:"active"`
+	Timestamp    time.Time          `bson:"timestamp" json:"timestamp"`
+}
+
+func newGcm(key []byte) (gcm cipher.AEAD, err error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		err = &errortypes.ParseError{
+			errors.Wrap(err, "datakey: Failed to load cipher"),
+		}
+		return
+	}
+
+	gcm, err = cipher.NewGCM(block)
+	if err != nil {
+		err = &errortypes.ParseError{
+			errors.Wrap(err, "datakey: Failed to load gcm"),
+		}
+		return
+	}
+
+	return
+}
+
+func (d *DataKey) wrap(masterKey, key []byte) (err error) {
+	gcm, err := newGcm(masterKey)
+	if err != nil {
+		return
+	}
+
+	nonce, err := utils.RandBytes(gcm.NonceSize())
+	if err != nil {
+		return
+	}
+
+	d.MasterKey = getFingerprint(masterKey)
+	d.Key = gcm.Seal(nonce, nonce, key, []byte(d.Id.Hex()))
+
+	return
+}
+
+func (d *DataKey) Unwrap() (key []byte, err error) {
+	masterKey, err := getMasterKey(d.MasterKey)
+	if err != nil {
+		return
+	}
+
+	gcm, err := newGcm(masterKey)
+	if err != nil {
+		return
+	}
+
+	if len(d.Key) < gcm.NonceSize() {
+		err = &errortypes.ParseError{
+			errors.New("datakey: Wrapped data key invalid"),
+		}
+		return
+	}
+
+	nonce := d.Key[:gcm.NonceSize()]
+	key, err = gcm.Open(nil, nonce, d.Key[gcm.NonceSize():],
+		[]byte(d.Id.Hex()))
+	if err != nil {
+		err = &errortypes.VerificationError{
+			errors.Wrap(err, "datakey: Failed to unwrap data key"),
+		}
+		return
+	}
+
+	return
+}
+
+func (d *DataKey) CommitFields(db *database.Database, fields set.Set) (
+	err error) {
+
+	coll := db.DataKeys()
+
+	err = coll.CommitFields(d.Id, d, fields)
+	if err != nil {
+		return
+	}
+
+	return
+}
@@ -0,0 +1,140 @@
+package datakey
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io/ioutil"
+	"strings"
+
+	"github.com/dropbox/godropbox/errors"
+	"github.com/pritunl/mongo-go-driver/bson"
+	"github.com/pritunl/pritunl-cloud/database"
+	"github.com/pritunl/pritunl-cloud/errortypes"
+	"github.com/pritunl/pritunl-cloud/requires"
+	"github.com/pritunl/pritunl-cloud/settings"
+	"github.com/pritunl/pritunl-cloud/utils"
+)
+
+const keySize = 32
+
+func getFingerprint(key []byte) string {
+	hash := sha256.Sum256(key)
+	return hex.EncodeToString(hash[:])[:16]
+}
+
+func readKeyFile(pth string) (keys [][]byte, err error) {
+	data, err := ioutil.ReadFile(pth)
+	if err != nil {
+		err = &errortypes.ReadError{
+			errors.Wrap(err, "datakey: Failed to read master key file"),
+		}
+		return
+	}
+
+	keys = [][]byte{}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, e := base64.StdEncoding.DecodeString(line)
+		if e != nil || len(key) != keySize {
+			err = &errortypes.ParseError{
+				errors.New("datakey: Invalid key in master key file"),
+			}
+			return
+		}
+
+		keys = append(keys, key)
+	}
+
+	return
+}
+
+func getMasterKeys() (keys [][]byte, err error) {
+	keyPath := settings.Hypervisor.BackupKeyPath
+	if keyPath != "" {
+		keys, err = readKeyFile(keyPath)
+		if err != nil {
+			return
+		}
+	} else {
+		keys = settings.System.BackupMasterKeys
+	}
+
+	if len(keys) == 0 {
+		err = &errortypes.NotFoundError{
+			errors.New("datakey: No master keys available"),
+		}
+		return
+	}
+
+	return
+}
+
+func getMasterKey(fingerprint string) (key []byte, err error) {
+	keys, err := getMasterKeys()
+	if err != nil {
+		return
+	}
+
+	keys = append(keys, settings.System.BackupMasterKeys...)
+
+	for _, k := range keys {
+		if getFingerprint(k) == fingerprint {
+			key = k
+			return
+		}
+	}
+
+	err = &errortypes.NotFoundError{
+		errors.Newf("datakey: Master key '%s' not found", fingerprint),
+	}
+	return
+}
+
+func init() {
+	module := requires.New("datakey")
+	module.After("settings")
+
+	module.Handler = func() (err error) {
+		if len(settings.System.BackupMasterKeys) != 0 {
+			return
+		}
+
+		db := database.GetDatabase()
+		defer db.Close()
+
+		key, err := utils.RandBytes(keySize)
+		if err != nil {
+			return
+		}
+
+		// Only set if missing to prevent nodes starting at the same time
+		// from generating different keys
+		coll := db.Settings()
+		_, err = coll.UpdateOne(db, &bson.M{
+			"_id": "system",
+			"backup_master_keys": &bson.M{
+				"$exists": false,
+			},
+		}, &bson.M{
+			"$set": &bson.M{
+				"backup_master_keys": [][]byte{key},
+			},
+		})
+		if err != nil {
+			err = database.ParseError(err)
+			return
+		}
+
+		err = settings.Update("system")
+		if err != nil {
+			return
+		}
+
+		return
+	}
+}
@@ -0,0 +1,218 @@
+package datakey
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"encoding/binary"
+	"io"
+	"math"
+	"os"
+
+	"github.com/dropbox/godropbox/errors"
+	"github.com/pritunl/pritunl-cloud/errortypes"
+	"github.com/pritunl/pritunl-cloud/utils"
+)
+
+const (
+	magic       = "PCENC1"
+	prefixSize  = 7
+	chunkSize   = 1024 * 1024
+	counterSize = 4
+)
+
+func getNonce(prefix []byte, counter uint32, last bool) (nonce []byte) {
+	nonce = make([]byte, prefixSize+counterSize+1)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[prefixSize:], counter)
+	if last {
+		nonce[prefixSize+counterSize] = 1
+	}
+	return
+}
+
+func readChunk(reader io.Reader, buf []byte) (n int, err error) {
+	n, err = io.ReadFull(reader, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	if err != nil {
+		err = &errortypes.ReadError{
+			errors.Wrap(err, "datakey: Failed to read file"),
+		}
+		return
+	}
+
+	return
+}
+
+func openFiles(srcPth, dstPth string) (src, dst *os.File, err error) {
+	src, err = os.Open(srcPth)
+	if err != nil {
+		err = &errortypes.ReadError{
+			errors.Wrap(err, "datakey: Failed to open file"),
+		}
+		return
+	}
+
+	dst, err = os.OpenFile(dstPth, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		src.Close()
+		err = &errortypes.WriteError{
+			errors.Wrap(err, "datakey: Failed to open file"),
+		}
+		return
+	}
+
+	return
+}
+
+func transform(src io.Reader, dst io.Writer, gcm cipher.AEAD,
+	prefix []byte, inSize int, seal bool) (err error) {
+
+	cur := make([]byte, inSize)
+	next := make([]byte, inSize)
+	out := make([]byte, 0, chunkSize+gcm.Overhead())
+
+	n, err := readChunk(src, cur)
+	if err != nil {
+		return
+	}
+
+	for counter := uint32(0); ; counter++ {
+		m, e := readChunk(src, next)
+		if e != nil {
+			err = e
+			return
+		}
+		last := m == 0
+
+		nonce := getNonce(prefix, counter, last)
+		if seal {
+			out = gcm.Seal(out[:0], nonce, cur[:n], nil)
+		} else {
+			out, err = gcm.Open(out[:0], nonce, cur[:n], nil)
+			if err != nil {
+				err = &errortypes.VerificationError{
+					errors.Wrap(err, "datakey: File authentication failed"),
+				}
+				return
+			}
+		}
+
+		_, err = dst.Write(out)
+		if err != nil {
+			err = &errortypes.WriteError{
+				errors.Wrap(err, "datakey: Failed to write file"),
+			}
+			return
+		}
+
+		if last {
+			return
+		}
+
+		if counter == math.MaxUint32 {
+			err = &errortypes.WriteError{
+				errors.New("datakey: File too large"),
+			}
+			return
+		}
+
+		cur, next = next, cur
+		n = m
+	}
+}
+
+func closeFile(file *os.File) (err error) {
+	err = file.Close()
+	if err != nil {
+		err = &errortypes.WriteError{
+			errors.Wrap(err, "datakey: Failed to close file"),
+		}
+		return
+	}
+
+	return
+}
+
+func EncryptFile(key []byte, srcPth, dstPth string) (err error) {
+	gcm, err := newGcm(key)
+	if err != nil {
+		return
+	}
+
+	prefix, err := utils.RandBytes(prefixSize)
+	if err != nil {
+		return
+	}
+
+	src, dst, err := openFiles(srcPth, dstPth)
+	if err != nil {
+		return
+	}
+	defer src.Close()
+
+	_, err = dst.Write(append([]byte(magic), prefix...))
+	if err != nil {
+		dst.Close()
+		err = &errortypes.WriteError{
+			errors.Wrap(err, "datakey: Failed to write file"),
+		}
+		return
+	}
+
+	err = transform(src, dst, gcm, prefix, chunkSize, true)
+	if err != nil {
+		dst.Close()
+		return
+	}
+
+	err = closeFile(dst)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func DecryptFile(key []byte, srcPth, dstPth string) (err error) {
+	gcm, err := newGcm(key)
+	if err != nil {
+		return
+	}
+
+	src, dst, err := openFiles(srcPth, dstPth)
+	if err != nil {
+		return
+	}
+	defer src.Close()
+
+	header := make([]byte, len(magic)+prefixSize)
+	n, err := readChunk(src, header)
+	if err != nil {
+		dst.Close()
+		return
+	}
+
+	if n != len(header) || !bytes.Equal(header[:len(magic)], []byte(magic)) {
+		dst.Close()
+		err = &errortypes.VerificationError{
+			errors.New("datakey: File not encrypted"),
+		}
+		return
+	}
+
+	err = transform(src, dst, gcm, header[len(magic):],
+		chunkSize+gcm.Overhead(), false)
+	if err != nil {
+		dst.Close()
+		return
+	}
+
+	err = closeFile(dst)
+	if err != nil {
+		return
+	}
+
+	return
+}
@@ -0,0 +1,230 @@
+package datakey
+
+import (
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/dropbox/godropbox/container/set"
+	"github.com/dropbox/godropbox/errors"
+	"github.com/pritunl/mongo-go-driver/bson"
+	"github.com/pritunl/mongo-go-driver/bson/primitive"
+	"github.com/pritunl/mongo-go-driver/mongo/options"
+	"github.com/pritunl/pritunl-cloud/database"
+	"github.com/pritunl/pritunl-cloud/errortypes"
+	"github.com/pritunl/pritunl-cloud/settings"
+	"github.com/pritunl/pritunl-cloud/utils"
+)
+
+func Get(db *database.Database, dkeyId primitive.ObjectID) (
+	dkey *DataKey, err error) {
+
+	coll := db.DataKeys()
+	dkey = &DataKey{}
+
+	err = coll.FindOneId(dkeyId, dkey)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func GetActive(db *database.Database, orgId primitive.ObjectID) (
+	dkey *DataKey, err error) {
+
+	coll := db.DataKeys()
+
+	masterKeys, err := getMasterKeys()
+	if err != nil {
+		return
+	}
+
+	key, err := utils.RandBytes(keySize)
+	if err != nil {
+		return
+	}
+
+	dkey = &DataKey{
+		Id:           primitive.NewObjectID(),
+		Organization: orgId,
+		Active:       true,
+		Timestamp:    time.Now(),
+	}
+
+	err = dkey.wrap(masterKeys[0], key)
+	if err != nil {
+		return
+	}
+
+	opts := &options.FindOneAndUpdateOptions{}
+	opts.SetUpsert(true)
+	opts.SetReturnDocument(options.After)
+
+	// Concurrent upserts can conflict on the unique index, the retry will
+	// return the key inserted by the other upsert
+	for i := 0; i < 3; i++ {
+		err = coll.FindOneAndUpdate(
+			db,
+			&bson.M{
+				"organization": orgId,
+				"active":       true,
+			},
+			&bson.M{
+				"$setOnInsert": dkey,
+			},
+			opts,
+		).Decode(dkey)
+		if err != nil {
+			err = database.ParseError(err)
+			if _, ok := err.(*database.DuplicateKeyError); ok {
+				continue
+			}
+			return
+		}
+
+		return
+	}
+
+	return
+}
+
+func Rotate(db *database.Database, orgId primitive.ObjectID) (
+	dkey *DataKey, err error) {
+
+	coll := db.DataKeys()
+
+	_, err = coll.UpdateMany(db, &bson.M{
+		"organization": orgId,
+		"active":       true,
+	}, &bson.M{
+		"$set": &bson.M{
+			"active": false,
+		},
+	})
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
+	dkey, err = GetActive(db, orgId)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func RotateMaster(db *database.Database) (err error) {
+	coll := db.DataKeys()
+
+	var masterKey []byte
+	clusterKeys := settings.System.BackupMasterKeys
+
+	if settings.Hypervisor.BackupKeyPath != "" {
+		keys, e := getMasterKeys()
+		if e != nil {
+			err = e
+			return
+		}
+		masterKey = keys[0]
+	} else {
+		masterKey, err = utils.RandBytes(keySize)
+		if err != nil {
+			return
+		}
+
+		clusterKeys = append([][]byte{masterKey}, clusterKeys...)
+		settings.System.BackupMasterKeys = clusterKeys
+
+		err = settings.Commit(db, settings.System,
+			set.NewSet("backup_master_keys"))
+		if err != nil {
+			return
+		}
+	}
+
+	fingerprint := getFingerprint(masterKey)
+
+	cursor, err := coll.Find(db, &bson.M{
+		"master_key": &bson.M{
+			"$ne": fingerprint,
+		},
+	})
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+	defer cursor.Close(db)
+
+	count := 0
+	for cursor.Next(db) {
+		dkey := &DataKey{}
+		err = cursor.Decode(dkey)
+		if err != nil {
+			err = database.ParseError(err)
+			return
+		}
+
+		key, e := dkey.Unwrap()
+		if e != nil {
+			err = e
+			return
+		}
+
+		err = dkey.wrap(masterKey, key)
+		if err != nil {
+			return
+		}
+
+		err = dkey.CommitFields(db, set.NewSet("master_key", "key"))
+		if err != nil {
+			return
+		}
+
+		count += 1
+	}
+
+	err = cursor.Err()
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
+	if settings.Hypervisor.BackupKeyPath == "" && len(clusterKeys) > 2 {
+		settings.System.BackupMasterKeys = clusterKeys[:2]
+
+		err = settings.Commit(db, settings.System,
+			set.NewSet("backup_master_keys"))
+		if err != nil {
+			return
+		}
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"master_key": fingerprint,
+		"data_keys":  count,
+	}).Info("datakey: Rotated backup master key")
+
+	return
+}
+
+func GetKey(db *database.Database, dkeyId primitive.ObjectID) (
+	key []byte, err error) {
+
+	dkey, err := Get(db, dkeyId)
+	if err != nil {
+		if _, ok := err.(*database.NotFoundError); ok {
+			err = &errortypes.NotFoundError{
+				errors.Wrap(err, "datakey: Image data key missing"),
+			}
+		}
+		return
+	}
+
+	key, err = dkey.Unwrap()
+	if err != nil {
+		return
+	}
+
+	return
+}
@@ -0,0 +1,96 @@
+package deploy
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/dropbox/godropbox/container/set"
+	"github.com/pritunl/mongo-go-driver/bson/primitive"
+	"github.com/pritunl/pritunl-cloud/database"
+	"github.com/pritunl/pritunl-cloud/event"
+	"github.com/pritunl/pritunl-cloud/instance"
+	"github.com/pritunl/pritunl-cloud/qemu"
+	"github.com/pritunl/pritunl-cloud/vm"
+)
+
+var (
+	adaptersFailed     = map[primitive.ObjectID]string{}
+	adaptersFailedLock = sync.Mutex{}
+)
+
+func getAdaptersKey(virt *vm.VirtualMachine) string {
+	key := []string{}
+	for _, adapter := range virt.NetworkAdapters {
+		key = append(key, adapter.Vpc.Hex()+":"+adapter.Subnet.Hex())
+	}
+	return strings.Join(key, ",")
+}
+
+func (s *Instances) checkAdapters(inst *instance.Instance,
+	curVirt *vm.VirtualMachine) (addAdapters, remAdapters []int,
+	restart bool) {
+
+	adaptersFailedLock.Lock()
+	defer adaptersFailedLock.Unlock()
+
+	addAdapters, remAdapters = inst.AdapterChanged(curVirt)
+	if len(addAdapters) == 0 && len(remAdapters) == 0 {
+		delete(adaptersFailed, inst.Id)
+		return
+	}
+
+	if adaptersFailed[inst.Id] == getAdaptersKey(inst.Virt) {
+		addAdapters = nil
+		remAdapters = nil
+		restart = true
+		return
+	}
+
+	return
+}
+
+func (s *Instances) adapters(inst *instance.Instance,
+	curVirt *vm.VirtualMachine, addAdapters, remAdapters []int) {
+
+	acquired, lockId := instancesLock.LockOpen(inst.Id.Hex())
+	if !acquired {
+		return
+	}
+
+	go func() {
+		defer func() {
+			time.Sleep(3 * time.Second)
+			instancesLock.Unlock(inst.Id.Hex(), lockId)
+		}()
+
+		db := database.GetDatabase()
+		defer db.Close()
+
+		err := qemu.UpdateAdapters(db, inst.Virt, curVirt,
+			addAdapters, remAdapters)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"instance_id": inst.Id.Hex(),
+				"error":       err,
+			}).Error("deploy: Failed to update instance network " +
+				"adapters, restart required")
+
+			adaptersFailedLock.Lock()
+			adaptersFailed[inst.Id] = getAdaptersKey(inst.Virt)
+			adaptersFailedLock.Unlock()
+
+			inst.Restart = true
+			err = inst.CommitFields(db, set.NewSet("restart"))
+			if err != nil {
+				logrus.WithFields(logrus.Fields{
+					"instance_id": inst.Id.Hex(),
+					"error":       err,
+				}).Error("deploy: Failed to commit instance restart")
+			}
+		}
+
+		event.PublishDispatch(db, "instance.change")
+	}()
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/pritunl/pritunl-cloud/disk"
 	"github.com/pritunl/pritunl-cloud/event"
 	"github.com/pritunl/pritunl-cloud/instance"
+	"github.com/pritunl/pritunl-cloud/paths"
 	"github.com/pritunl/pritunl-cloud/settings"
 	"github.com/pritunl/pritunl-cloud/state"
 	"github.com/pritunl/pritunl-cloud/utils"
@@ -155,7 +156,7 @@ func (d *Disks) restore(dsk *disk.Disk) {
 		defer db.Close()
 
 		inst := d.stat.GetInstace(dsk.Instance)
-		if inst != nil {
+		if inst != nil && !inst.Failover {
 			if inst.State != instance.Stop {
 				inst.State = instance.Stop
 
@@ -183,20 +184,28 @@ func (d *Disks) restore(dsk *disk.Disk) {
 			}
 		}
 
+		dsk.State = disk.Available
+
 		err := data.RestoreBackup(db, dsk)
 		if err != nil {
 			logrus.WithFields(logrus.Fields{
-				"instance_id": inst.Id.Hex(),
+				"instance_id": dsk.Instance.Hex(),
 				"disk_id":     dsk.Id.Hex(),
 				"error":       err,
 			}).Error("deploy: Failed to restore disk")
+
+			// Disks restored for failover do not exist on the node and
+			// must not be attached until a restore succeeds
+			exists, e := utils.Exists(paths.GetDiskPath(dsk.Id))
+			if e != nil || !exists {
+				dsk.State = disk.Failed
+			}
 		}
 
-		dsk.State = disk.Available
 		err = dsk.CommitFields(db, set.NewSet("state"))
 		if err != nil {
 			logrus.WithFields(logrus.Fields{
-				"instance_id": inst.Id.Hex(),
+				"instance_id": dsk.Instance.Hex(),
 				"disk_id":     dsk.Id.Hex(),
 				"error":       err,
 			}).Error("deploy: Failed update disk state")
@@ -254,10 +263,6 @@ func (d *Disks) destroy(dsk *disk.Disk) {
 }
 
 func (d *Disks) scheduleBackup(dsk *disk.Disk) {
-	if time.Since(dsk.LastBackup) < 12*time.Hour {
-		return
-	}
-
 	if !backupLimiter.Acquire() {
 		return
 	}
@@ -299,7 +304,7 @@ func (d *Disks) scheduleBackup(dsk *disk.Disk) {
 
 		event.PublishDispatch(db, "disk.change")
 
-		err = data.CreateBackup(db, dsk)
+		err = data.CreateIncrementalBackup(db, dsk)
 		if err != nil {
 			logrus.WithFields(logrus.Fields{
 				"error": err,
@@ -326,7 +331,8 @@ func (d *Disks) Deploy() (err error) {
 
 	backupHour := settings.System.DiskBackupTime
 	backupWindow := settings.System.DiskBackupWindow
-	utcHour := time.Now().UTC().Hour()
+	now := time.Now()
+	utcHour := now.UTC().Hour()
 	backupActive := false
 	if utcHour >= backupHour && utcHour <= (backupHour+backupWindow) {
 		backupActive = true
@@ -350,7 +356,14 @@ func (d *Disks) Deploy() (err error) {
 			d.destroy(dsk)
 			break
 		case disk.Available:
-			if backupActive && dsk.Backup {
+			if !dsk.BackupPolicy.IsZero() {
+				plcy := d.stat.BackupPolicy(dsk.BackupPolicy)
+				if plcy != nil && plcy.Due(dsk.LastBackup, now) {
+					d.scheduleBackup(dsk)
+				}
+			} else if backupActive && dsk.Backup &&
+				time.Since(dsk.LastBackup) >= 12*time.Hour {
+
 				d.scheduleBackup(dsk)
 			}
 			break
@@ -114,6 +114,10 @@ func (d *Domains) Deploy() (err error) {
 	instances := d.stat.Instances()
 
 	for _, inst := range instances {
+		if inst.Node != d.stat.Node().Id {
+			continue
+		}
+
 		recrds := d.stat.DomainRecords(inst.Id)
 		if recrds != nil {
 			var curRecrd *domain.Record
@@ -9,12 +9,14 @@ import (
 	"github.com/dropbox/godropbox/errors"
 	"github.com/pritunl/pritunl-cloud/database"
 	"github.com/pritunl/pritunl-cloud/disk"
+	"github.com/pritunl/pritunl-cloud/domain"
 	"github.com/pritunl/pritunl-cloud/errortypes"
 	"github.com/pritunl/pritunl-cloud/event"
 	"github.com/pritunl/pritunl-cloud/instance"
 	"github.com/pritunl/pritunl-cloud/node"
 	"github.com/pritunl/pritunl-cloud/qemu"
 	"github.com/pritunl/pritunl-cloud/qms"
+	"github.com/pritunl/pritunl-cloud/settings"
 	"github.com/pritunl/pritunl-cloud/state"
 	"github.com/pritunl/pritunl-cloud/store"
 	"github.com/pritunl/pritunl-cloud/utils"
@@ -43,6 +45,12 @@ func (s *Instances) create(inst *instance.Instance) {
 		return
 	}
 
+	if !s.pin(inst) {
+		instancesLock.Unlock(inst.Id.Hex(), lockId)
+		limiter.Release()
+		return
+	}
+
 	go func() {
 		defer func() {
 			time.Sleep(3 * time.Second)
@@ -88,6 +96,12 @@ func (s *Instances) start(inst *instance.Instance) {
 		return
 	}
 
+	if !s.pin(inst) {
+		instancesLock.Unlock(inst.Id.Hex(), lockId)
+		limiter.Release()
+		return
+	}
+
 	go func() {
 		defer func() {
 			time.Sleep(3 * time.Second)
@@ -214,6 +228,12 @@ func (s *Instances) restart(inst *instance.Instance) {
 		return
 	}
 
+	if !s.pin(inst) {
+		instancesLock.Unlock(inst.Id.Hex(), lockId)
+		limiter.Release()
+		return
+	}
+
 	go func() {
 		defer func() {
 			time.Sleep(3 * time.Second)
@@ -334,6 +354,387 @@ func (s *Instances) destroy(inst *instance.Instance) {
 	}()
 }
 
+func (s *Instances) migrate(inst *instance.Instance) {
+	if !limiter.Acquire() {
+		return
+	}
+
+	acquired, lockId := instancesLock.LockOpenTimeout(
+		inst.Id.Hex(), time.Duration(
+			settings.Hypervisor.MigrateTimeout+300)*time.Second)
+	if !acquired {
+		limiter.Release()
+		return
+	}
+
+	go func() {
+		defer func() {
+			time.Sleep(3 * time.Second)
+			instancesLock.Unlock(inst.Id.Hex(), lockId)
+			limiter.Release()
+		}()
+
+		db := database.GetDatabase()
+		defer db.Close()
+
+		err := qemu.Migrate(db, inst, inst.Virt)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"instance_id": inst.Id.Hex(),
+				"error":       err,
+			}).Error("deploy: Failed to migrate instance")
+
+			_, err = instance.MigrateAbort(db, inst.Id, inst.Node)
+			if err != nil {
+				logrus.WithFields(logrus.Fields{
+					"instance_id": inst.Id.Hex(),
+					"error":       err,
+				}).Error("deploy: Failed to abort instance migration")
+			}
+
+			event.PublishDispatch(db, "instance.change")
+
+			return
+		}
+
+		migrated := false
+		for i := 0; i < 60; i++ {
+			time.Sleep(1 * time.Second)
+
+			curInst, e := instance.Get(db, inst.Id)
+			if e != nil {
+				err = e
+				logrus.WithFields(logrus.Fields{
+					"instance_id": inst.Id.Hex(),
+					"error":       err,
+				}).Error("deploy: Failed to get migrated instance")
+				continue
+			}
+
+			if curInst.Node == inst.MigrateNode {
+				migrated = true
+				break
+			}
+
+			if curInst.State != instance.Migrate {
+				break
+			}
+		}
+
+		if !migrated {
+			logrus.WithFields(logrus.Fields{
+				"instance_id": inst.Id.Hex(),
+			}).Error("deploy: Migration target failed to start instance")
+
+			// Abort must match before resuming to prevent the target
+			// completing the migration while the source is running
+			aborted, e := instance.MigrateAbort(db, inst.Id, node.Self.Id)
+			if e != nil {
+				logrus.WithFields(logrus.Fields{
+					"instance_id": inst.Id.Hex(),
+					"error":       e,
+				}).Error("deploy: Failed to abort instance migration")
+				return
+			}
+
+			if !aborted {
+				curInst, e := instance.Get(db, inst.Id)
+				if e != nil {
+					logrus.WithFields(logrus.Fields{
+						"instance_id": inst.Id.Hex(),
+						"error":       e,
+					}).Error("deploy: Failed to get migrated instance")
+					return
+				}
+
+				if curInst.Node == inst.MigrateNode {
+					migrated = true
+				} else if curInst.Node != node.Self.Id ||
+					curInst.State == instance.Migrate {
+
+					logrus.WithFields(logrus.Fields{
+						"instance_id": inst.Id.Hex(),
+						"node_id":     curInst.Node.Hex(),
+						"state":       curInst.State,
+					}).Error("deploy: Migrated instance in unknown state")
+					return
+				}
+			}
+
+			if !migrated {
+				// Target clears the migrate node once the incoming
+				// instance has been stopped
+				stopped := false
+				for i := 0; i < 60; i++ {
+					curInst, e := instance.Get(db, inst.Id)
+					if e != nil {
+						logrus.WithFields(logrus.Fields{
+							"instance_id": inst.Id.Hex(),
+							"error":       e,
+						}).Error("deploy: Failed to get migrated instance")
+					} else if curInst.MigrateNode.IsZero() {
+						stopped = true
+						break
+					}
+
+					time.Sleep(1 * time.Second)
+				}
+
+				if !stopped {
+					logrus.WithFields(logrus.Fields{
+						"instance_id": inst.Id.Hex(),
+					}).Error("deploy: Migration target failed to stop instance")
+					return
+				}
+
+				err = qms.Continue(inst.Id)
+				if err != nil {
+					logrus.WithFields(logrus.Fields{
+						"instance_id": inst.Id.Hex(),
+						"error":       err,
+					}).Error("deploy: Failed to resume instance")
+				}
+
+				event.PublishDispatch(db, "instance.change")
+
+				return
+			}
+		}
+
+		err = qemu.MigrateCleanup(db, inst.Virt)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"instance_id": inst.Id.Hex(),
+				"error":       err,
+			}).Error("deploy: Failed to cleanup migrated instance")
+			return
+		}
+
+		event.PublishDispatch(db, "instance.change")
+		event.PublishDispatch(db, "disk.change")
+	}()
+}
+
+func (s *Instances) migrateIncoming(inst *instance.Instance) {
+	if !limiter.Acquire() {
+		return
+	}
+
+	acquired, lockId := instancesLock.LockOpenTimeout(
+		inst.Id.Hex(), 10*time.Minute)
+	if !acquired {
+		limiter.Release()
+		return
+	}
+
+	if !s.pin(inst) {
+		instancesLock.Unlock(inst.Id.Hex(), lockId)
+		limiter.Release()
+		return
+	}
+
+	go func() {
+		defer func() {
+			time.Sleep(3 * time.Second)
+			instancesLock.Unlock(inst.Id.Hex(), lockId)
+			limiter.Release()
+		}()
+
+		db := database.GetDatabase()
+		defer db.Close()
+
+		addr, port, nbdPort, err := qemu.MigrateIncoming(
+			db, inst, inst.Virt)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"instance_id": inst.Id.Hex(),
+				"error":       err,
+			}).Error("deploy: Failed to create incoming instance")
+
+			_, err = instance.MigrateAbort(db, inst.Id, inst.Node)
+			if err != nil {
+				logrus.WithFields(logrus.Fields{
+					"instance_id": inst.Id.Hex(),
+					"error":       err,
+				}).Error("deploy: Failed to abort instance migration")
+			}
+
+			event.PublishDispatch(db, "instance.change")
+
+			return
+		}
+
+		err = instance.SetMigrateReady(
+			db, inst.Id, node.Self.Id, addr, port, nbdPort)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"instance_id": inst.Id.Hex(),
+				"error":       err,
+			}).Error("deploy: Failed to update instance migration")
+			return
+		}
+
+		event.PublishDispatch(db, "instance.change")
+	}()
+}
+
+func (s *Instances) migrateComplete(inst *instance.Instance) {
+	acquired, lockId := instancesLock.LockOpen(inst.Id.Hex())
+	if !acquired {
+		return
+	}
+
+	go func() {
+		defer func() {
+			instancesLock.Unlock(inst.Id.Hex(), lockId)
+		}()
+
+		status, err := qms.GetStatus(inst.Id)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"instance_id": inst.Id.Hex(),
+				"error":       err,
+			}).Error("deploy: Failed to get incoming instance status")
+			return
+		}
+
+		// Incoming instance is paused once the migration has completed
+		if status != "paused" {
+			return
+		}
+
+		err = qms.StopMigrateExport(inst.Id)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"instance_id": inst.Id.Hex(),
+				"error":       err,
+			}).Error("deploy: Failed to stop incoming instance export")
+		}
+
+		db := database.GetDatabase()
+		defer db.Close()
+
+		updated, err := instance.MigrateComplete(
+			db, inst.Id, inst.Node, node.Self.Id)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"instance_id": inst.Id.Hex(),
+				"error":       err,
+			}).Error("deploy: Failed to complete instance migration")
+			return
+		}
+
+		if !updated {
+			return
+		}
+
+		err = disk.SetNode(db, inst.Id, node.Self.Id)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"instance_id": inst.Id.Hex(),
+				"error":       err,
+			}).Error("deploy: Failed to update migrated instance disks")
+		}
+
+		err = domain.SetRecordNode(db, inst.Id, node.Self.Id)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"instance_id": inst.Id.Hex(),
+				"error":       err,
+			}).Error("deploy: Failed to update migrated instance records")
+		}
+
+		err = qemu.NetworkConf(db, inst.Virt)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"instance_id": inst.Id.Hex(),
+				"error":       err,
+			}).Error("deploy: Failed to configure migrated instance network")
+		}
+
+		err = qms.Continue(inst.Id)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"instance_id": inst.Id.Hex(),
+				"error":       err,
+			}).Error("deploy: Failed to resume migrated instance")
+		}
+
+		logrus.WithFields(logrus.Fields{
+			"instance_id": inst.Id.Hex(),
+		}).Info("deploy: Instance migration complete")
+
+		event.PublishDispatch(db, "instance.change")
+		event.PublishDispatch(db, "disk.change")
+	}()
+}
+
+func (s *Instances) migrateAbort(inst *instance.Instance) {
+	acquired, lockId := instancesLock.LockOpen(inst.Id.Hex())
+	if !acquired {
+		return
+	}
+
+	go func() {
+		defer func() {
+			time.Sleep(3 * time.Second)
+			instancesLock.Unlock(inst.Id.Hex(), lockId)
+		}()
+
+		db := database.GetDatabase()
+		defer db.Close()
+
+		curVirt := s.stat.GetVirt(inst.Id)
+		if curVirt != nil {
+			err := qemu.MigrateCleanup(db, inst.Virt)
+			if err != nil {
+				logrus.WithFields(logrus.Fields{
+					"instance_id": inst.Id.Hex(),
+					"error":       err,
+				}).Error("deploy: Failed to cleanup incoming instance")
+				return
+			}
+		}
+
+		err := instance.MigrateClear(db, inst.Id, node.Self.Id)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"instance_id": inst.Id.Hex(),
+				"error":       err,
+			}).Error("deploy: Failed to clear instance migration")
+			return
+		}
+
+		event.PublishDispatch(db, "instance.change")
+	}()
+}
+
+func (s *Instances) failover(inst *instance.Instance) {
+	for _, dsk := range s.stat.GetInstaceDisks(inst.Id) {
+		if dsk.State != disk.Available {
+			return
+		}
+	}
+
+	db := database.GetDatabase()
+	defer db.Close()
+
+	err := instance.FailoverComplete(db, inst.Id)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"instance_id": inst.Id.Hex(),
+			"error":       err,
+		}).Error("deploy: Failed to complete instance failover")
+		return
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"instance_id": inst.Id.Hex(),
+	}).Info("deploy: Instance failover disks restored")
+
+	event.PublishDispatch(db, "instance.change")
+}
+
 func (s *Instances) diskRemove(inst *instance.Instance,
 	remDisks []*vm.Disk) {
 
@@ -366,6 +767,57 @@ func (s *Instances) diskRemove(inst *instance.Instance,
 	}()
 }
 
+func (s *Instances) diskLimits(inst *instance.Instance,
+	limitDisks []*vm.Disk) {
+
+	acquired, lockId := instancesLock.LockOpen(inst.Id.Hex())
+	if !acquired {
+		return
+	}
+
+	go func() {
+		defer func() {
+			instancesLock.Unlock(inst.Id.Hex(), lockId)
+		}()
+
+		for _, dsk := range limitDisks {
+			e := qms.SetDiskLimits(inst.Id, dsk)
+			if e != nil {
+				logrus.WithFields(logrus.Fields{
+					"instance_id": inst.Id.Hex(),
+					"disk_path":   dsk.Path,
+					"error":       e,
+				}).Error("deploy: Failed to update disk limits")
+			}
+		}
+
+		store.RemDisks(inst.Id)
+	}()
+}
+
+func (s *Instances) networkLimits(inst *instance.Instance,
+	curVirt *vm.VirtualMachine) {
+
+	acquired, lockId := instancesLock.LockOpen(inst.Id.Hex())
+	if !acquired {
+		return
+	}
+
+	go func() {
+		defer func() {
+			instancesLock.Unlock(inst.Id.Hex(), lockId)
+		}()
+
+		err := qemu.UpdateNetworkLimits(inst.Virt, curVirt)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"instance_id": inst.Id.Hex(),
+				"error":       err,
+			}).Error("deploy: Failed to update network limits")
+		}
+	}()
+}
+
 func (s *Instances) diff(db *database.Database,
 	inst *instance.Instance) (err error) {
 
@@ -376,6 +828,17 @@ func (s *Instances) diff(db *database.Database,
 		changed = true
 	}
 
+	resize := false
+	if !changed {
+		resize, changed = s.checkResize(inst, curVirt)
+	}
+
+	var addAdapters []int
+	var remAdapters []int
+	if !changed && !resize && curVirt.State == vm.Running {
+		addAdapters, remAdapters, changed = s.checkAdapters(inst, curVirt)
+	}
+
 	if instancesLock.Locked(inst.Id.Hex()) {
 		return
 	}
@@ -396,6 +859,17 @@ func (s *Instances) diff(db *database.Database,
 
 	if len(remDisks) > 0 {
 		s.diskRemove(inst, remDisks)
+	} else if resize {
+		s.resize(inst, curVirt)
+	} else if len(addAdapters) > 0 || len(remAdapters) > 0 {
+		s.adapters(inst, curVirt, addAdapters, remAdapters)
+	} else if curVirt.State == vm.Running {
+		limitDisks := inst.DiskLimitsChanged(curVirt)
+		if len(limitDisks) > 0 {
+			s.diskLimits(inst, limitDisks)
+		} else if inst.NetworkLimitsChanged(curVirt) {
+			s.networkLimits(inst, curVirt)
+		}
 	}
 
 	return
@@ -581,10 +1055,57 @@ func (s *Instances) Deploy() (err error) {
 
 	cpuUnits := 0
 	memoryUnits := 0.0
+	hugepagesUnits := 0
+	pinnedCpus := s.syncPins(instances)
+	s.syncAffinity(instances)
 
 	for _, inst := range instances {
 		curVirt := s.stat.GetVirt(inst.Id)
 
+		if inst.Node != s.stat.Node().Id {
+			if instancesLock.Locked(inst.Id.Hex()) {
+				continue
+			}
+
+			if inst.State != instance.Migrate {
+				s.migrateAbort(inst)
+				continue
+			}
+
+			cpuUnits += inst.Processors
+			memoryUnits += float64(inst.Memory) / float64(1024)
+			if inst.Hugepages {
+				hugepagesUnits += inst.Memory
+			}
+
+			if curVirt == nil && inst.MigratePort == 0 {
+				if (inst.Virt.IsUefi() && inst.MigrateNvram == nil) ||
+					inst.MigrateDiskSizes == nil {
+
+					continue
+				}
+
+				s.migrateIncoming(inst)
+			} else if curVirt == nil || curVirt.State == vm.Stopped ||
+				curVirt.State == vm.Failed {
+
+				logrus.WithFields(logrus.Fields{
+					"instance_id": inst.Id.Hex(),
+				}).Error("deploy: Incoming instance stopped")
+
+				_, err = instance.MigrateAbort(db, inst.Id, inst.Node)
+				if err != nil {
+					return
+				}
+
+				event.PublishDispatch(db, "instance.change")
+			} else if inst.MigratePort != 0 {
+				s.migrateComplete(inst)
+			}
+
+			continue
+		}
+
 		if inst.State == instance.Destroy {
 			if inst.DeleteProtection {
 				logrus.WithFields(logrus.Fields{
@@ -610,18 +1131,45 @@ func (s *Instances) Deploy() (err error) {
 
 		cpuUnits += inst.Processors
 		memoryUnits += float64(inst.Memory) / float64(1024)
+		if inst.Hugepages {
+			hugepagesUnits += inst.Memory
+		}
+
+		if inst.Failover {
+			if curVirt == nil {
+				s.failover(inst)
+			}
+			continue
+		}
 
 		if curVirt == nil {
 			if inst.State == instance.Start {
 				s.create(inst)
+			} else if inst.State == instance.Migrate &&
+				!instancesLock.Locked(inst.Id.Hex()) {
+
+				_, err = instance.MigrateAbort(db, inst.Id, inst.Node)
+				if err != nil {
+					return
+				}
+
+				event.PublishDispatch(db, "instance.change")
 			}
 
 			continue
 		}
 
+		if curVirt.State == vm.Running {
+			qms.Listen(inst.Id)
+		}
+
 		switch inst.State {
 		case instance.Start:
 			if curVirt.State == vm.Stopped || curVirt.State == vm.Failed {
+				if store.IsFenced(inst.Id) {
+					continue
+				}
+
 				dsks := s.stat.GetInstaceDisks(inst.Id)
 
 				for _, dsk := range dsks {
@@ -677,11 +1225,77 @@ func (s *Instances) Deploy() (err error) {
 				continue
 			}
 			break
+		case instance.Migrate:
+			if instancesLock.Locked(inst.Id.Hex()) {
+				continue
+			}
+
+			if curVirt.State != vm.Running {
+				_, err = instance.MigrateAbort(db, inst.Id, inst.Node)
+				if err != nil {
+					return
+				}
+
+				event.PublishDispatch(db, "instance.change")
+				continue
+			}
+
+			if inst.Virt.IsUefi() && inst.MigrateNvram == nil {
+				e := qemu.MigrateNvram(db, inst.Virt)
+				if e != nil {
+					logrus.WithFields(logrus.Fields{
+						"instance_id": inst.Id.Hex(),
+						"error":       e,
+					}).Error("deploy: Failed to send instance nvram")
+				}
+				continue
+			}
+
+			if inst.MigrateDiskSizes == nil {
+				e := qemu.MigrateSerialLog(db, inst.Virt)
+				if e != nil {
+					logrus.WithFields(logrus.Fields{
+						"instance_id": inst.Id.Hex(),
+						"error":       e,
+					}).Error("deploy: Failed to send instance serial log")
+					continue
+				}
+
+				e = qemu.MigrateDiskSizes(db, inst.Virt)
+				if e != nil {
+					logrus.WithFields(logrus.Fields{
+						"instance_id": inst.Id.Hex(),
+						"error":       e,
+					}).Error("deploy: Failed to send instance disk sizes")
+				}
+				continue
+			}
+
+			if inst.MigratePort != 0 {
+				s.migrate(inst)
+				continue
+			}
+			break
 		}
 	}
 
+	resChanged := node.Self.CpuUnitsRes != cpuUnits ||
+		node.Self.MemoryUnitsRes != memoryUnits
+
 	node.Self.CpuUnitsRes = cpuUnits
 	node.Self.MemoryUnitsRes = memoryUnits
+	node.Self.PinnedCpusRes = pinnedCpus
+	node.Self.HugepagesRes = hugepagesUnits
+
+	if resChanged {
+		err = node.Self.CommitFields(db, set.NewSet(
+			"cpu_units_res",
+			"memory_units_res",
+		))
+		if err != nil {
+			return
+		}
+	}
 
 	return
 }
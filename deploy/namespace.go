@@ -76,6 +76,11 @@ func (n *Namespace) Deploy() (err error) {
 		if externalNetwork6 {
 			curExternalIfaces.Add(vm.GetIfaceExternal(inst.Id, 1))
 		}
+
+		for i := range inst.Adapters {
+			curNamespaces.Add(vm.GetNamespace(inst.Id, i+1))
+			curVirtIfaces.Add(vm.GetIfaceVirtAdapter(inst.Id, i+1))
+		}
 	}
 
 	for _, iface := range ifaces {
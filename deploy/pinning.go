@@ -0,0 +1,216 @@
+package deploy
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/dropbox/godropbox/errors"
+	"github.com/pritunl/mongo-go-driver/bson/primitive"
+	"github.com/pritunl/pritunl-cloud/errortypes"
+	"github.com/pritunl/pritunl-cloud/instance"
+	"github.com/pritunl/pritunl-cloud/node"
+	"github.com/pritunl/pritunl-cloud/qemu"
+	"github.com/pritunl/pritunl-cloud/vm"
+)
+
+var (
+	pins       = map[primitive.ObjectID][]int{}
+	pinsLock   = sync.Mutex{}
+	affinities = map[primitive.ObjectID]string{}
+)
+
+func (s *Instances) syncPins(instances []*instance.Instance) (
+	pinnedCpus int) {
+
+	pinsLock.Lock()
+	defer pinsLock.Unlock()
+
+	newPins := map[primitive.ObjectID][]int{}
+
+	for _, inst := range instances {
+		curVirt := s.stat.GetVirt(inst.Id)
+
+		if curVirt != nil && len(curVirt.PinnedCpus) > 0 &&
+			(curVirt.State == vm.Running || curVirt.State == vm.Starting) {
+
+			newPins[inst.Id] = curVirt.PinnedCpus
+		} else if instancesLock.Locked(inst.Id.Hex()) {
+			if cpus, ok := pins[inst.Id]; ok {
+				newPins[inst.Id] = cpus
+			}
+		}
+	}
+
+	for _, cpus := range newPins {
+		pinnedCpus += len(cpus)
+	}
+	pins = newPins
+
+	return
+}
+
+func getNumaNodes() (numaNodes []*node.NumaNode) {
+	numaNodes = node.Self.Numa
+	if len(numaNodes) == 0 {
+		allCpus := []int{}
+		for i := 0; i < node.Self.CpuUnits; i++ {
+			allCpus = append(allCpus, i)
+		}
+
+		numaNodes = []*node.NumaNode{
+			&node.NumaNode{
+				Id:   0,
+				Cpus: allCpus,
+			},
+		}
+	}
+
+	return
+}
+
+func getSharedCpus() (cpus []int) {
+	used := map[int]bool{}
+	for _, instCpus := range pins {
+		for _, cpu := range instCpus {
+			used[cpu] = true
+		}
+	}
+
+	cpus = []int{}
+	for _, numa := range getNumaNodes() {
+		for _, cpu := range numa.Cpus {
+			if !used[cpu] {
+				cpus = append(cpus, cpu)
+			}
+		}
+	}
+	sort.Ints(cpus)
+
+	return
+}
+
+func (s *Instances) syncAffinity(instances []*instance.Instance) {
+	pinsLock.Lock()
+	sharedCpus := getSharedCpus()
+	pinsLock.Unlock()
+
+	if len(sharedCpus) == 0 {
+		return
+	}
+
+	sharedKey := fmt.Sprint(sharedCpus)
+	newAffinities := map[primitive.ObjectID]string{}
+
+	for _, inst := range instances {
+		curVirt := s.stat.GetVirt(inst.Id)
+		if curVirt == nil || curVirt.State != vm.Running ||
+			len(curVirt.PinnedCpus) > 0 {
+
+			continue
+		}
+
+		if affinities[inst.Id] == sharedKey {
+			newAffinities[inst.Id] = sharedKey
+			continue
+		}
+
+		err := qemu.SetAffinity(inst.Id, sharedCpus)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"instance_id": inst.Id.Hex(),
+				"error":       err,
+			}).Error("deploy: Failed to set instance cpu affinity")
+			continue
+		}
+
+		newAffinities[inst.Id] = sharedKey
+	}
+
+	affinities = newAffinities
+}
+
+func allocatePins(inst *instance.Instance) (
+	cpus []int, numaNode int, err error) {
+
+	pinsLock.Lock()
+	defer pinsLock.Unlock()
+
+	used := map[int]bool{}
+	for instId, instCpus := range pins {
+		if instId == inst.Id {
+			continue
+		}
+
+		for _, cpu := range instCpus {
+			used[cpu] = true
+		}
+	}
+
+	numaNodes := getNumaNodes()
+
+	allFree := []int{}
+	for _, numa := range numaNodes {
+		free := []int{}
+		for _, cpu := range numa.Cpus {
+			if !used[cpu] {
+				free = append(free, cpu)
+			}
+		}
+		allFree = append(allFree, free...)
+
+		if len(free) < inst.Processors {
+			continue
+		}
+
+		if inst.Hugepages && inst.NumaLocal &&
+			numa.HugepagesFree < inst.Memory {
+
+			continue
+		}
+
+		cpus = free[:inst.Processors]
+		numaNode = numa.Id
+		break
+	}
+
+	if cpus == nil {
+		if inst.NumaLocal || len(allFree) < inst.Processors {
+			err = &errortypes.NotFoundError{
+				errors.New("deploy: Not enough free cpus for instance"),
+			}
+			return
+		}
+
+		cpus = allFree[:inst.Processors]
+	}
+
+	pins[inst.Id] = cpus
+
+	return
+}
+
+func (s *Instances) pin(inst *instance.Instance) bool {
+	if !inst.DedicatedCpus {
+		pinsLock.Lock()
+		inst.Virt.SharedCpus = getSharedCpus()
+		pinsLock.Unlock()
+		return true
+	}
+
+	cpus, numaNode, err := allocatePins(inst)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"instance_id": inst.Id.Hex(),
+			"processors":  inst.Processors,
+			"error":       err,
+		}).Error("deploy: Failed to allocate instance cpus")
+		return false
+	}
+
+	inst.Virt.PinnedCpus = cpus
+	inst.Virt.NumaNode = numaNode
+
+	return true
+}
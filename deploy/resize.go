@@ -0,0 +1,89 @@
+package deploy
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/dropbox/godropbox/container/set"
+	"github.com/pritunl/mongo-go-driver/bson/primitive"
+	"github.com/pritunl/pritunl-cloud/database"
+	"github.com/pritunl/pritunl-cloud/event"
+	"github.com/pritunl/pritunl-cloud/instance"
+	"github.com/pritunl/pritunl-cloud/qemu"
+	"github.com/pritunl/pritunl-cloud/vm"
+)
+
+var (
+	resizeFailed     = map[primitive.ObjectID]string{}
+	resizeFailedLock = sync.Mutex{}
+)
+
+func getResizeKey(virt *vm.VirtualMachine) string {
+	return fmt.Sprintf("%d:%d", virt.Processors, virt.Memory)
+}
+
+func (s *Instances) checkResize(inst *instance.Instance,
+	curVirt *vm.VirtualMachine) (resize, restart bool) {
+
+	resizeFailedLock.Lock()
+	defer resizeFailedLock.Unlock()
+
+	if inst.Virt.Processors == curVirt.Processors &&
+		inst.Virt.Memory == curVirt.Memory {
+
+		delete(resizeFailed, inst.Id)
+		return
+	}
+
+	if resizeFailed[inst.Id] == getResizeKey(inst.Virt) {
+		restart = true
+		return
+	}
+
+	resize = true
+	return
+}
+
+func (s *Instances) resize(inst *instance.Instance,
+	curVirt *vm.VirtualMachine) {
+
+	acquired, lockId := instancesLock.LockOpen(inst.Id.Hex())
+	if !acquired {
+		return
+	}
+
+	go func() {
+		defer func() {
+			time.Sleep(3 * time.Second)
+			instancesLock.Unlock(inst.Id.Hex(), lockId)
+		}()
+
+		db := database.GetDatabase()
+		defer db.Close()
+
+		err := qemu.Resize(inst.Virt, curVirt)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"instance_id": inst.Id.Hex(),
+				"error":       err,
+			}).Error("deploy: Failed to resize instance, restart required")
+
+			resizeFailedLock.Lock()
+			resizeFailed[inst.Id] = getResizeKey(inst.Virt)
+			resizeFailedLock.Unlock()
+
+			inst.Restart = true
+			err = inst.CommitFields(db, set.NewSet("restart"))
+			if err != nil {
+				logrus.WithFields(logrus.Fields{
+					"instance_id": inst.Id.Hex(),
+					"error":       err,
+				}).Error("deploy: Failed to commit instance restart")
+			}
+		}
+
+		event.PublishDispatch(db, "instance.change")
+	}()
+}
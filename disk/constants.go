@@ -6,5 +6,6 @@ const (
 	Snapshot  = "snapshot"
 	Backup    = "backup"
 	Restore   = "restore"
+	Failed    = "failed"
 	Destroy   = "destroy"
 )
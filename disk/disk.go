@@ -9,9 +9,11 @@ import (
 	"github.com/Sirupsen/logrus"
 	"github.com/dropbox/godropbox/container/set"
 	"github.com/pritunl/mongo-go-driver/bson/primitive"
+	"github.com/pritunl/pritunl-cloud/backup"
 	"github.com/pritunl/pritunl-cloud/database"
 	"github.com/pritunl/pritunl-cloud/errortypes"
 	"github.com/pritunl/pritunl-cloud/event"
+	"github.com/pritunl/pritunl-cloud/organization"
 	"github.com/pritunl/pritunl-cloud/paths"
 	"github.com/pritunl/pritunl-cloud/utils"
 )
@@ -33,7 +35,11 @@ type Disk struct {
 	Index            string             `bson:"index" json:"index"`
 	Size             int                `bson:"size" json:"size"`
 	Backup           bool               `bson:"backup" json:"backup"`
+	BackupPolicy     primitive.ObjectID `bson:"backup_policy,omitempty" json:"backup_policy"`
 	LastBackup       time.Time          `bson:"last_backup" json:"last_backup"`
+	BackupImage      primitive.ObjectID `bson:"backup_image,omitempty" json:"backup_image"`
+	IopsLimit        int                `bson:"iops_limit" json:"iops_limit"`
+	BandwidthLimit   int                `bson:"bandwidth_limit" json:"bandwidth_limit"`
 }
 
 func (d *Disk) Validate(db *database.Database) (
@@ -60,7 +66,7 @@ func (d *Disk) Validate(db *database.Database) (
 		d.Index = strconv.Itoa(index)
 	}
 
-	if d.Backup && d.BackingImage != "" {
+	if (d.Backup || !d.BackupPolicy.IsZero()) && d.BackingImage != "" {
 		errData = &errortypes.ErrorData{
 			Error:   "backing_image_backup",
 			Message: "Cannot enable backups with backing image",
@@ -68,6 +74,25 @@ func (d *Disk) Validate(db *database.Database) (
 		return
 	}
 
+	if !d.BackupPolicy.IsZero() {
+		plcy, e := backup.Get(db, d.BackupPolicy)
+		if e != nil {
+			if _, ok := e.(*database.NotFoundError); !ok {
+				err = e
+				return
+			}
+			plcy = nil
+		}
+
+		if plcy == nil || plcy.Organization != d.Organization {
+			errData = &errortypes.ErrorData{
+				Error:   "backup_policy_invalid",
+				Message: "Backup policy not in organization",
+			}
+			return
+		}
+	}
+
 	if d.Instance.IsZero() && !strings.HasPrefix(d.Index, "hold") {
 		d.Index = fmt.Sprintf("hold_%s", primitive.NewObjectID().Hex())
 	}
@@ -80,6 +105,14 @@ func (d *Disk) Validate(db *database.Database) (
 		d.Size = 10
 	}
 
+	if d.IopsLimit < 0 || d.BandwidthLimit < 0 {
+		errData = &errortypes.ErrorData{
+			Error:   "invalid_limit",
+			Message: "Disk limits cannot be negative",
+		}
+		return
+	}
+
 	return
 }
 
@@ -110,6 +143,21 @@ func (d *Disk) CommitFields(db *database.Database, fields set.Set) (
 func (d *Disk) Insert(db *database.Database) (err error) {
 	coll := db.Disks()
 
+	if !d.Organization.IsZero() && d.IopsLimit == 0 &&
+		d.BandwidthLimit == 0 {
+
+		org, e := organization.Get(db, d.Organization)
+		if e != nil {
+			if _, ok := e.(*database.NotFoundError); !ok {
+				err = e
+				return
+			}
+		} else {
+			d.IopsLimit = org.DiskIopsLimit
+			d.BandwidthLimit = org.DiskBandwidthLimit
+		}
+	}
+
 	_, err = coll.InsertOne(db, d)
 	if err != nil {
 		err = database.ParseError(err)
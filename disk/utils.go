@@ -469,3 +469,49 @@ func SetDeleteProtection(db *database.Database, instId primitive.ObjectID,
 
 	return
 }
+
+func SetNode(db *database.Database, instId, ndeId primitive.ObjectID) (
+	err error) {
+
+	coll := db.Disks()
+
+	_, err = coll.UpdateMany(db, &bson.M{
+		"instance": instId,
+	}, &bson.M{
+		"$set": &bson.M{
+			"node":          ndeId,
+			"backing":       false,
+			"backing_image": "",
+		},
+	})
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
+	return
+}
+
+func SetRestore(db *database.Database, dskId, ndeId,
+	imgId primitive.ObjectID) (err error) {
+
+	coll := db.Disks()
+
+	_, err = coll.UpdateOne(db, &bson.M{
+		"_id": dskId,
+	}, &bson.M{
+		"$set": &bson.M{
+			"node":          ndeId,
+			"state":         Restore,
+			"restore_image": imgId,
+			"backing":       false,
+			"backing_image": "",
+		},
+	})
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
+	return
+}
@@ -313,3 +313,23 @@ func RemoveRecord(db *database.Database, recrdId primitive.ObjectID) (
 
 	return
 }
+
+func SetRecordNode(db *database.Database, instId, ndeId primitive.ObjectID) (
+	err error) {
+
+	coll := db.DomainsRecord()
+
+	_, err = coll.UpdateMany(db, &bson.M{
+		"instance": instId,
+	}, &bson.M{
+		"$set": &bson.M{
+			"node": ndeId,
+		},
+	})
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
+	return
+}
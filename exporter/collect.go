@@ -0,0 +1,274 @@
+package exporter
+
+import (
+	"time"
+
+	"github.com/pritunl/mongo-go-driver/bson"
+	"github.com/pritunl/pritunl-cloud/certificate"
+	"github.com/pritunl/pritunl-cloud/database"
+	"github.com/pritunl/pritunl-cloud/disk"
+	"github.com/pritunl/pritunl-cloud/node"
+)
+
+type stateCount struct {
+	State string `bson:"_id"`
+	Count int    `bson:"count"`
+}
+
+type jobCount struct {
+	Id struct {
+		Name  string `bson:"name"`
+		State string `bson:"state"`
+	} `bson:"_id"`
+	Count     int       `bson:"count"`
+	Timestamp time.Time `bson:"timestamp"`
+}
+
+func collectNode(w *writer) {
+	nde := node.Self
+	name := nde.Name
+
+	w.family("node_cpu_units", "gauge", "Processor units of the node")
+	w.sample("node_cpu_units", float64(nde.CpuUnits), "node", name)
+
+	w.family("node_cpu_units_reserved", "gauge",
+		"Processor units reserved by instances")
+	w.sample("node_cpu_units_reserved", float64(nde.CpuUnitsRes),
+		"node", name)
+
+	w.family("node_memory_units", "gauge", "Memory of the node in gigabytes")
+	w.sample("node_memory_units", nde.MemoryUnits, "node", name)
+
+	w.family("node_memory_units_reserved", "gauge",
+		"Memory reserved by instances in gigabytes")
+	w.sample("node_memory_units_reserved", nde.MemoryUnitsRes,
+		"node", name)
+
+	w.family("node_memory_usage", "gauge", "Memory usage percent")
+	w.sample("node_memory_usage", nde.Memory, "node", name)
+
+	w.family("node_load", "gauge", "Load average of the node")
+	w.sample("node_load", nde.Load1, "node", name, "period", "1")
+	w.sample("node_load", nde.Load5, "node", name, "period", "5")
+	w.sample("node_load", nde.Load15, "node", name, "period", "15")
+}
+
+func collectInstances(db *database.Database, w *writer) (err error) {
+	coll := db.Instances()
+
+	cursor, err := coll.Aggregate(db, []*bson.M{
+		&bson.M{
+			"$match": &bson.M{
+				"node": node.Self.Id,
+			},
+		},
+		&bson.M{
+			"$group": &bson.M{
+				"_id": "$vm_state",
+				"count": &bson.M{
+					"$sum": 1,
+				},
+			},
+		},
+	})
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+	defer cursor.Close(db)
+
+	w.family("instances", "gauge", "Instances on the node by state")
+
+	for cursor.Next(db) {
+		count := &stateCount{}
+		err = cursor.Decode(count)
+		if err != nil {
+			err = database.ParseError(err)
+			return
+		}
+
+		w.sample("instances", float64(count.Count),
+			"node", node.Self.Name, "state", count.State)
+	}
+
+	err = cursor.Err()
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
+	return
+}
+
+func collectBalancers(w *writer) {
+	if prxy == nil {
+		return
+	}
+
+	stats := prxy.Stats()
+
+	w.family("balancer_requests", "gauge",
+		"Balancer requests in the last 50 seconds")
+	for _, stat := range stats {
+		w.sample("balancer_requests", float64(stat.Requests),
+			"balancer", stat.Balancer, "domain", stat.Domain)
+	}
+
+	w.family("balancer_retries", "gauge",
+		"Balancer retries in the last 50 seconds")
+	for _, stat := range stats {
+		w.sample("balancer_retries", float64(stat.Retries),
+			"balancer", stat.Balancer, "domain", stat.Domain)
+	}
+
+	w.family("balancer_websockets", "gauge",
+		"Active balancer websocket connections")
+	for _, stat := range stats {
+		w.sample("balancer_websockets", float64(stat.WebSockets),
+			"balancer", stat.Balancer, "domain", stat.Domain)
+	}
+}
+
+func collectDisks(db *database.Database, w *writer) (err error) {
+	dsks, err := disk.GetNode(db, node.Self.Id)
+	if err != nil {
+		return
+	}
+
+	w.family("disk_backup_age_seconds", "gauge",
+		"Seconds since the last disk backup")
+
+	for _, dsk := range dsks {
+		if !dsk.Backup || dsk.LastBackup.IsZero() {
+			continue
+		}
+
+		w.sample("disk_backup_age_seconds",
+			time.Since(dsk.LastBackup).Seconds(),
+			"disk", dsk.Id.Hex(), "name", dsk.Name)
+	}
+
+	return
+}
+
+func collectTasks(db *database.Database, w *writer) (err error) {
+	coll := db.Tasks()
+
+	cursor, err := coll.Aggregate(db, []*bson.M{
+		&bson.M{
+			"$match": &bson.M{
+				"timestamp": &bson.M{
+					"$gte": time.Now().Add(-24 * time.Hour),
+				},
+			},
+		},
+		&bson.M{
+			"$group": &bson.M{
+				"_id": &bson.M{
+					"name":  "$name",
+					"state": "$state",
+				},
+				"count": &bson.M{
+					"$sum": 1,
+				},
+				"timestamp": &bson.M{
+					"$max": "$timestamp",
+				},
+			},
+		},
+	})
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+	defer cursor.Close(db)
+
+	counts := []*jobCount{}
+	for cursor.Next(db) {
+		count := &jobCount{}
+		err = cursor.Decode(count)
+		if err != nil {
+			err = database.ParseError(err)
+			return
+		}
+
+		counts = append(counts, count)
+	}
+
+	err = cursor.Err()
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
+	w.family("task_jobs", "gauge", "Task jobs in the last 24 hours by state")
+	for _, count := range counts {
+		w.sample("task_jobs", float64(count.Count),
+			"task", count.Id.Name, "state", count.Id.State)
+	}
+
+	w.family("task_last_timestamp_seconds", "gauge",
+		"Time of the last task job by state")
+	for _, count := range counts {
+		w.sample("task_last_timestamp_seconds",
+			float64(count.Timestamp.Unix()),
+			"task", count.Id.Name, "state", count.Id.State)
+	}
+
+	return
+}
+
+func collectCertificates(db *database.Database, w *writer) (err error) {
+	certs, err := certificate.GetAll(db)
+	if err != nil {
+		return
+	}
+
+	w.family("certificate_expiry_timestamp_seconds", "gauge",
+		"Expiration time of the certificate")
+
+	for _, cert := range certs {
+		if cert.Info == nil || cert.Info.ExpiresOn.IsZero() {
+			continue
+		}
+
+		w.sample("certificate_expiry_timestamp_seconds",
+			float64(cert.Info.ExpiresOn.Unix()),
+			"certificate", cert.Id.Hex(), "name", cert.Name,
+			"type", cert.Type)
+	}
+
+	return
+}
+
+func collect(db *database.Database) (data []byte, err error) {
+	w := newWriter()
+
+	collectNode(w)
+
+	err = collectInstances(db, w)
+	if err != nil {
+		return
+	}
+
+	collectBalancers(w)
+
+	err = collectDisks(db, w)
+	if err != nil {
+		return
+	}
+
+	err = collectTasks(db, w)
+	if err != nil {
+		return
+	}
+
+	err = collectCertificates(db, w)
+	if err != nil {
+		return
+	}
+
+	data = w.Bytes()
+
+	return
+}
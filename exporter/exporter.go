@@ -0,0 +1,174 @@
+package exporter
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/pritunl/pritunl-cloud/constants"
+	"github.com/pritunl/pritunl-cloud/database"
+	"github.com/pritunl/pritunl-cloud/proxy"
+	"github.com/pritunl/pritunl-cloud/settings"
+	"github.com/pritunl/pritunl-cloud/utils"
+)
+
+var (
+	prxy      *proxy.Proxy
+	server    *http.Server
+	serverKey = ""
+)
+
+func SetProxy(p *proxy.Proxy) {
+	prxy = p
+}
+
+func authorized(r *http.Request) bool {
+	token := settings.Telemetry.PrometheusToken
+	networks := settings.Telemetry.PrometheusNetworks
+
+	if token == "" && len(networks) == 0 {
+		return false
+	}
+
+	if token != "" {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") ||
+			subtle.ConstantTimeCompare(
+				[]byte(auth[7:]), []byte(token)) != 1 {
+
+			return false
+		}
+	}
+
+	if len(networks) > 0 {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			return false
+		}
+
+		remoteIp := net.ParseIP(host)
+		if remoteIp == nil {
+			return false
+		}
+
+		match := false
+		for _, network := range networks {
+			_, cidr, err := net.ParseCIDR(network)
+			if err != nil {
+				continue
+			}
+
+			if cidr.Contains(remoteIp) {
+				match = true
+				break
+			}
+		}
+
+		if !match {
+			return false
+		}
+	}
+
+	return true
+}
+
+func handler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/metrics" {
+		utils.WriteStatus(w, 404)
+		return
+	}
+
+	if !authorized(r) {
+		utils.WriteStatus(w, 401)
+		return
+	}
+
+	db := database.GetDatabase()
+	defer db.Close()
+
+	data, err := collect(db)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+		}).Error("exporter: Failed to collect metrics")
+		utils.WriteStatus(w, 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.WriteHeader(200)
+	_, _ = w.Write(data)
+}
+
+func update() {
+	port := settings.Telemetry.PrometheusPort
+	if settings.Telemetry.PrometheusToken == "" &&
+		len(settings.Telemetry.PrometheusNetworks) == 0 {
+
+		port = 0
+	}
+
+	key := fmt.Sprintf("%d", port)
+	if key == serverKey {
+		return
+	}
+	serverKey = key
+
+	if server != nil {
+		ctx, cancel := context.WithTimeout(
+			context.Background(), 3*time.Second)
+		_ = server.Shutdown(ctx)
+		cancel()
+		server = nil
+	}
+
+	if port == 0 {
+		return
+	}
+
+	srv := &http.Server{
+		Addr:              fmt.Sprintf(":%d", port),
+		Handler:           http.HandlerFunc(handler),
+		ReadTimeout:       30 * time.Second,
+		ReadHeaderTimeout: 10 * time.Second,
+		WriteTimeout:      30 * time.Second,
+		MaxHeaderBytes:    4096,
+	}
+	server = srv
+
+	logrus.WithFields(logrus.Fields{
+		"port": port,
+	}).Info("exporter: Starting metrics server")
+
+	go func() {
+		err := srv.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			logrus.WithFields(logrus.Fields{
+				"error": err,
+			}).Error("exporter: Metrics server error")
+
+			serverKey = ""
+		}
+	}()
+}
+
+func runner() {
+	for {
+		if constants.Interrupt {
+			return
+		}
+
+		update()
+
+		time.Sleep(5 * time.Second)
+	}
+}
+
+func Init() {
+	go runner()
+}
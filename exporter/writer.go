@@ -0,0 +1,56 @@
+package exporter
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var labelReplacer = strings.NewReplacer(
+	"\\", "\\\\",
+	"\"", "\\\"",
+	"\n", "\\n",
+)
+
+type writer struct {
+	buffer *bytes.Buffer
+}
+
+func (w *writer) family(name, typ, help string) {
+	fmt.Fprintf(w.buffer, "# HELP pritunl_cloud_%s %s\n", name, help)
+	fmt.Fprintf(w.buffer, "# TYPE pritunl_cloud_%s %s\n", name, typ)
+}
+
+func (w *writer) sample(name string, value float64, labels ...string) {
+	w.buffer.WriteString("pritunl_cloud_")
+	w.buffer.WriteString(name)
+
+	if len(labels) > 1 {
+		w.buffer.WriteString("{")
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i != 0 {
+				w.buffer.WriteString(",")
+			}
+			w.buffer.WriteString(labels[i])
+			w.buffer.WriteString("=\"")
+			w.buffer.WriteString(labelReplacer.Replace(labels[i+1]))
+			w.buffer.WriteString("\"")
+		}
+		w.buffer.WriteString("}")
+	}
+
+	w.buffer.WriteString(" ")
+	w.buffer.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+	w.buffer.WriteString("\n")
+}
+
+func (w *writer) Bytes() []byte {
+	return w.buffer.Bytes()
+}
+
+func newWriter() *writer {
+	return &writer{
+		buffer: &bytes.Buffer{},
+	}
+}
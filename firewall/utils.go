@@ -420,7 +420,7 @@ func GetAllIngress(db *database.Database, nodeSelf *node.Node,
 			namespace := vm.GetNamespace(inst.Id, i)
 
 			fires, e := GetOrgRoles(db,
-				inst.Organization, inst.NetworkRoles)
+				inst.Organization, inst.GetNetworkRoles(i))
 			if e != nil {
 				err = e
 				return
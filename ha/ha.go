@@ -0,0 +1,297 @@
+package ha
+
+import (
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/dropbox/godropbox/errors"
+	"github.com/pritunl/mongo-go-driver/bson"
+	"github.com/pritunl/mongo-go-driver/bson/primitive"
+	"github.com/pritunl/pritunl-cloud/audit"
+	"github.com/pritunl/pritunl-cloud/data"
+	"github.com/pritunl/pritunl-cloud/database"
+	"github.com/pritunl/pritunl-cloud/disk"
+	"github.com/pritunl/pritunl-cloud/domain"
+	"github.com/pritunl/pritunl-cloud/errortypes"
+	"github.com/pritunl/pritunl-cloud/event"
+	"github.com/pritunl/pritunl-cloud/image"
+	"github.com/pritunl/pritunl-cloud/instance"
+	"github.com/pritunl/pritunl-cloud/node"
+	"github.com/pritunl/pritunl-cloud/scheduler"
+	"github.com/pritunl/pritunl-cloud/settings"
+	"github.com/pritunl/pritunl-cloud/storage"
+)
+
+func getRestoreImages(db *database.Database, dsks []*disk.Disk) (
+	imgs map[primitive.ObjectID]primitive.ObjectID, err error) {
+
+	imgs = map[primitive.ObjectID]primitive.ObjectID{}
+
+	for _, dsk := range dsks {
+		img, e := image.GetDiskLatest(db, dsk.Id)
+		if e != nil {
+			err = e
+			if _, ok := err.(*database.NotFoundError); ok {
+				err = nil
+				imgs = nil
+			}
+			return
+		}
+
+		store, e := storage.Get(db, img.Storage)
+		if e != nil {
+			err = e
+			return
+		}
+
+		available, e := data.ImageAvailable(store, img)
+		if e != nil {
+			err = e
+			return
+		}
+
+		if !available {
+			imgs = nil
+			return
+		}
+
+		imgs[dsk.Id] = img.Id
+	}
+
+	return
+}
+
+func failoverInstance(db *database.Database, nde *node.Node,
+	inst *instance.Instance) (err error) {
+
+	dsks, err := disk.GetInstance(db, inst.Id)
+	if err != nil {
+		return
+	}
+
+	imgs, err := getRestoreImages(db, dsks)
+	if err != nil {
+		return
+	}
+
+	if imgs == nil {
+		err = &errortypes.NotFoundError{
+			errors.New("ha: Cannot failover instance without available " +
+				"disk backups"),
+		}
+		return
+	}
+
+	exclude := []primitive.ObjectID{
+		nde.Id,
+	}
+	if !inst.MigrateNode.IsZero() {
+		exclude = append(exclude, inst.MigrateNode)
+	}
+
+	dstNde, errData, err := scheduler.Schedule(db, &scheduler.Spec{
+		Organization:  inst.Organization,
+		Instance:      inst.Id,
+		Placement:     inst.Placement,
+		Zone:          inst.Zone,
+		Processors:    inst.Processors,
+		Memory:        inst.Memory,
+		UsbDevices:    inst.UsbDevices,
+		NetworkRoles:  inst.NetworkRoles,
+		DedicatedCpus: inst.DedicatedCpus,
+		Hugepages:     inst.Hugepages,
+		Exclude:       exclude,
+	})
+	if err != nil {
+		return
+	}
+
+	if errData != nil {
+		err = &errortypes.NotFoundError{
+			errors.Newf("ha: Failed to find node for instance "+
+				"failover, %s", errData.Message),
+		}
+		return
+	}
+
+	updated, err := instance.Failover(db, inst.Id, nde.Id, dstNde.Id)
+	if err != nil {
+		return
+	}
+
+	if !updated {
+		return
+	}
+
+	for _, dsk := range dsks {
+		err = disk.SetRestore(db, dsk.Id, dstNde.Id, imgs[dsk.Id])
+		if err != nil {
+			return
+		}
+	}
+
+	err = domain.SetRecordNode(db, inst.Id, dstNde.Id)
+	if err != nil {
+		return
+	}
+
+	backupImages := map[string]primitive.ObjectID{}
+	for dskId, imgId := range imgs {
+		backupImages[dskId.Hex()] = imgId
+	}
+
+	err = audit.NewSystem(db, audit.InstanceFailover, audit.Fields{
+		"instance_id":   inst.Id,
+		"organization":  inst.Organization,
+		"source_node":   nde.Id,
+		"target_node":   dstNde.Id,
+		"backup_images": backupImages,
+	})
+	if err != nil {
+		return
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"instance_id": inst.Id.Hex(),
+		"source_node": nde.Id.Hex(),
+		"target_node": dstNde.Id.Hex(),
+	}).Warning("ha: Instance failed over to new node")
+
+	return
+}
+
+func failoverBackoff(inst *instance.Instance) time.Duration {
+	backoff := time.Duration(settings.Hypervisor.FailoverBackoff) *
+		time.Second
+	backoffMax := time.Duration(settings.Hypervisor.FailoverBackoffMax) *
+		time.Second
+
+	for i := 1; i < inst.FailoverAttempts && backoff < backoffMax; i++ {
+		backoff *= 2
+	}
+
+	if backoff > backoffMax {
+		backoff = backoffMax
+	}
+
+	return backoff
+}
+
+func failoverNode(db *database.Database, nde *node.Node) (err error) {
+	insts, err := instance.GetAll(db, &bson.M{
+		"node":              nde.Id,
+		"high_availability": true,
+		"state": &bson.M{
+			"$in": []string{
+				instance.Start,
+				instance.Migrate,
+			},
+		},
+	})
+	if err != nil {
+		return
+	}
+
+	if len(insts) == 0 {
+		return
+	}
+
+	if !nde.Fenced {
+		cutoff := time.Now().Add(
+			-time.Duration(settings.Hypervisor.FenceTimeout) * time.Second)
+
+		fenced, e := node.Fence(db, nde.Id, cutoff)
+		if e != nil {
+			err = e
+			return
+		}
+
+		if !fenced {
+			return
+		}
+
+		logrus.WithFields(logrus.Fields{
+			"node_id":   nde.Id.Hex(),
+			"timestamp": nde.Timestamp,
+		}).Warning("ha: Fenced unresponsive node")
+
+		event.PublishDispatch(db, "node.change")
+	}
+
+	for _, inst := range insts {
+		if inst.FailoverError != "" &&
+			time.Since(inst.FailoverTimestamp) < failoverBackoff(inst) {
+
+			continue
+		}
+
+		e := failoverInstance(db, nde, inst)
+		if e != nil {
+			logrus.WithFields(logrus.Fields{
+				"instance_id": inst.Id.Hex(),
+				"node_id":     nde.Id.Hex(),
+				"attempts":    inst.FailoverAttempts + 1,
+				"error":       e,
+			}).Error("ha: Failed to failover instance")
+
+			e = instance.FailoverFailed(db, inst.Id, nde.Id,
+				errors.GetMessage(e))
+			if e != nil {
+				err = e
+				return
+			}
+		}
+	}
+
+	event.PublishDispatch(db, "instance.change")
+	event.PublishDispatch(db, "disk.change")
+
+	return
+}
+
+func Failover(db *database.Database) (err error) {
+	ndes, err := node.GetAll(db)
+	if err != nil {
+		return
+	}
+
+	timeout := time.Duration(settings.Hypervisor.FenceTimeout) * time.Second
+	total := 0
+	healthy := 0
+	failed := []*node.Node{}
+
+	for _, nde := range ndes {
+		if !nde.IsHypervisor() || nde.Zone.IsZero() {
+			continue
+		}
+		total += 1
+
+		since := time.Since(nde.Timestamp)
+		if since < 30*time.Second && !nde.Fenced {
+			healthy += 1
+		} else if since > timeout {
+			failed = append(failed, nde)
+		}
+	}
+
+	if len(failed) == 0 {
+		return
+	}
+
+	if healthy*2 < total {
+		logrus.WithFields(logrus.Fields{
+			"healthy": healthy,
+			"total":   total,
+		}).Error("ha: Too few responsive nodes, skipping failover")
+		return
+	}
+
+	for _, nde := range failed {
+		err = failoverNode(db, nde)
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
@@ -0,0 +1,7 @@
+package image
+
+const (
+	Offline     = "offline"
+	Crash       = "crash"
+	Application = "application"
+)
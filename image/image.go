@@ -11,6 +11,7 @@ import (
 	"github.com/pritunl/mongo-go-driver/mongo/options"
 	"github.com/pritunl/pritunl-cloud/database"
 	"github.com/pritunl/pritunl-cloud/errortypes"
+	"github.com/pritunl/pritunl-cloud/vm"
 )
 
 type Image struct {
@@ -23,14 +24,28 @@ type Image struct {
 	Type         string             `bson:"type" json:"type"`
 	Storage      primitive.ObjectID `bson:"storage" json:"storage"`
 	Key          string             `bson:"key" json:"key"`
+	NvramKey     string             `bson:"nvram_key,omitempty" json:"nvram_key"`
+	Firmware     string             `bson:"firmware" json:"firmware"`
 	LastModified time.Time          `bson:"last_modified" json:"last_modified"`
 	StorageClass string             `bson:"storage_class" json:"storage_class"`
 	Etag         string             `bson:"etag" json:"etag"`
+	Consistency  string             `bson:"consistency" json:"consistency"`
+	Parent       primitive.ObjectID `bson:"parent,omitempty" json:"parent"`
+	Chain        int                `bson:"chain" json:"chain"`
+	DataKey      primitive.ObjectID `bson:"data_key,omitempty" json:"data_key"`
 }
 
 func (i *Image) Validate(db *database.Database) (
 	errData *errortypes.ErrorData, err error) {
 
+	if i.Firmware != "" && !vm.ValidFirmwares.Contains(i.Firmware) {
+		errData = &errortypes.ErrorData{
+			Error:   "invalid_firmware",
+			Message: "Invalid image firmware",
+		}
+		return
+	}
+
 	return
 }
 
@@ -96,6 +111,7 @@ func (i *Image) Upsert(db *database.Database) (err error) {
 				"type":          i.Type,
 				"storage":       i.Storage,
 				"key":           i.Key,
+				"nvram_key":     i.NvramKey,
 				"last_modified": i.LastModified,
 				"storage_class": i.StorageClass,
 				"etag":          i.Etag,
@@ -44,6 +44,101 @@ func Get(db *database.Database, imgId primitive.ObjectID) (
 	return
 }
 
+func GetDiskLatest(db *database.Database, dskId primitive.ObjectID) (
+	img *Image, err error) {
+
+	coll := db.Images()
+	img = &Image{}
+
+	err = coll.FindOne(
+		db,
+		&bson.M{
+			"disk": dskId,
+		},
+		&options.FindOneOptions{
+			Sort: &bson.D{
+				{"_id", -1},
+			},
+		},
+	).Decode(img)
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
+	return
+}
+
+func GetDisk(db *database.Database, dskId primitive.ObjectID) (
+	imgs []*Image, err error) {
+
+	coll := db.Images()
+	imgs = []*Image{}
+
+	cursor, err := coll.Find(db, &bson.M{
+		"disk": dskId,
+	})
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+	defer cursor.Close(db)
+
+	for cursor.Next(db) {
+		img := &Image{}
+		err = cursor.Decode(img)
+		if err != nil {
+			err = database.ParseError(err)
+			return
+		}
+
+		imgs = append(imgs, img)
+	}
+
+	err = cursor.Err()
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
+	return
+}
+
+func GetChildren(db *database.Database, imgId primitive.ObjectID) (
+	imgs []*Image, err error) {
+
+	coll := db.Images()
+	imgs = []*Image{}
+
+	cursor, err := coll.Find(db, &bson.M{
+		"parent": imgId,
+	})
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+	defer cursor.Close(db)
+
+	for cursor.Next(db) {
+		img := &Image{}
+		err = cursor.Decode(img)
+		if err != nil {
+			err = database.ParseError(err)
+			return
+		}
+
+		imgs = append(imgs, img)
+	}
+
+	err = cursor.Err()
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
+	return
+}
+
 func GetOrg(db *database.Database, orgId, imgId primitive.ObjectID) (
 	img *Image, err error) {
 
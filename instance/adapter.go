@@ -0,0 +1,159 @@
+package instance
+
+import (
+	"github.com/dropbox/godropbox/container/set"
+	"github.com/pritunl/mongo-go-driver/bson/primitive"
+	"github.com/pritunl/pritunl-cloud/database"
+	"github.com/pritunl/pritunl-cloud/errortypes"
+	"github.com/pritunl/pritunl-cloud/vm"
+	"github.com/pritunl/pritunl-cloud/vpc"
+)
+
+type Adapter struct {
+	Vpc          primitive.ObjectID `bson:"vpc" json:"vpc"`
+	Subnet       primitive.ObjectID `bson:"subnet" json:"subnet"`
+	NetworkRoles []string           `bson:"network_roles" json:"network_roles"`
+}
+
+func (i *Instance) validateAdapters(db *database.Database) (
+	errData *errortypes.ErrorData, err error) {
+
+	if i.Adapters == nil {
+		i.Adapters = []*Adapter{}
+	}
+
+	if len(i.Adapters) > MaxAdapters {
+		errData = &errortypes.ErrorData{
+			Error:   "adapters_limit",
+			Message: "Too many network adapters",
+		}
+		return
+	}
+
+	vpcs := set.NewSet(i.Vpc)
+
+	for _, adapter := range i.Adapters {
+		if adapter.Vpc.IsZero() {
+			errData = &errortypes.ErrorData{
+				Error:   "adapter_vpc_required",
+				Message: "Missing required network adapter VPC",
+			}
+			return
+		}
+
+		if vpcs.Contains(adapter.Vpc) {
+			errData = &errortypes.ErrorData{
+				Error:   "adapter_vpc_duplicate",
+				Message: "Network adapters must use different VPCs",
+			}
+			return
+		}
+		vpcs.Add(adapter.Vpc)
+
+		vc, e := vpc.Get(db, adapter.Vpc)
+		if e != nil {
+			err = e
+			return
+		}
+
+		if vc.Organization != i.Organization {
+			errData = &errortypes.ErrorData{
+				Error:   "adapter_vpc_invalid",
+				Message: "Network adapter VPC not in organization",
+			}
+			return
+		}
+
+		if adapter.Subnet.IsZero() {
+			errData = &errortypes.ErrorData{
+				Error:   "adapter_subnet_required",
+				Message: "Missing required network adapter VPC subnet",
+			}
+			return
+		}
+
+		if vc.GetSubnet(adapter.Subnet) == nil {
+			errData = &errortypes.ErrorData{
+				Error:   "adapter_subnet_missing",
+				Message: "Network adapter VPC subnet does not exist",
+			}
+			return
+		}
+
+		if adapter.NetworkRoles == nil {
+			adapter.NetworkRoles = []string{}
+		}
+	}
+
+	return
+}
+
+func (i *Instance) releaseAdapters(db *database.Database) (err error) {
+	subnets := map[primitive.ObjectID]primitive.ObjectID{
+		i.Vpc: i.Subnet,
+	}
+	for _, adapter := range i.Adapters {
+		subnets[adapter.Vpc] = adapter.Subnet
+	}
+
+	for _, adapter := range i.curAdapters {
+		subnet, ok := subnets[adapter.Vpc]
+		if ok && subnet == adapter.Subnet {
+			continue
+		}
+
+		err = vpc.RemoveInstanceIp(db, i.Id, adapter.Vpc)
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func (i *Instance) GetNetworkRoles(index int) []string {
+	if index == 0 {
+		return i.NetworkRoles
+	}
+
+	if index > len(i.Adapters) {
+		return []string{}
+	}
+
+	return i.Adapters[index-1].NetworkRoles
+}
+
+func (i *Instance) AdapterChanged(curVirt *vm.VirtualMachine) (
+	addAdapters, remAdapters []int) {
+
+	addAdapters = []int{}
+	remAdapters = []int{}
+
+	for index := 1; index < len(curVirt.NetworkAdapters); index++ {
+		curAdapter := curVirt.NetworkAdapters[index]
+
+		if index >= len(i.Virt.NetworkAdapters) {
+			remAdapters = append(remAdapters, index)
+			continue
+		}
+
+		adapter := i.Virt.NetworkAdapters[index]
+		if adapter.Vpc != curAdapter.Vpc ||
+			adapter.Subnet != curAdapter.Subnet {
+
+			remAdapters = append(remAdapters, index)
+			addAdapters = append(addAdapters, index)
+		}
+	}
+
+	start := len(curVirt.NetworkAdapters)
+	if start < 1 {
+		start = 1
+	}
+
+	for index := start; index < len(i.Virt.NetworkAdapters); index++ {
+		addAdapters = append(addAdapters, index)
+	}
+
+	return
+}
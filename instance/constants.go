@@ -11,6 +11,12 @@ const (
 	Cleanup   = "cleanup"
 	Restart   = "restart"
 	Destroy   = "destroy"
+	Migrate   = "migrate"
+
+	DrainMigrate = "migrate"
+	DrainStop    = "stop"
+
+	MaxAdapters = 6
 )
 
 var (
@@ -21,5 +27,10 @@ var (
 		Cleanup,
 		Restart,
 		Destroy,
+		Migrate,
+	)
+	ValidDrainPolicies = set.NewSet(
+		DrainMigrate,
+		DrainStop,
 	)
 )
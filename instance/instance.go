@@ -2,7 +2,9 @@ package instance
 
 import (
 	"math/rand"
+	"path"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/dropbox/godropbox/container/set"
@@ -13,9 +15,12 @@ import (
 	"github.com/pritunl/pritunl-cloud/disk"
 	"github.com/pritunl/pritunl-cloud/errortypes"
 	"github.com/pritunl/pritunl-cloud/node"
+	"github.com/pritunl/pritunl-cloud/organization"
 	"github.com/pritunl/pritunl-cloud/paths"
+	"github.com/pritunl/pritunl-cloud/placement"
 	"github.com/pritunl/pritunl-cloud/systemd"
 	"github.com/pritunl/pritunl-cloud/usb"
+	"github.com/pritunl/pritunl-cloud/userdata"
 	"github.com/pritunl/pritunl-cloud/utils"
 	"github.com/pritunl/pritunl-cloud/vm"
 	"github.com/pritunl/pritunl-cloud/vpc"
@@ -46,24 +51,84 @@ type Instance struct {
 	NoPublicAddress     bool               `bson:"no_public_address" json:"no_public_address"`
 	NoHostAddress       bool               `bson:"no_host_address" json:"no_host_address"`
 	Node                primitive.ObjectID `bson:"node" json:"node"`
+	MigrateNode         primitive.ObjectID `bson:"migrate_node,omitempty" json:"migrate_node"`
+	MigrateAddr         string             `bson:"migrate_addr" json:"migrate_addr"`
+	MigratePort         int                `bson:"migrate_port" json:"migrate_port"`
+	MigrateNbdPort      int                `bson:"migrate_nbd_port" json:"migrate_nbd_port"`
+	MigrateNvram        []byte             `bson:"migrate_nvram,omitempty" json:"-"`
+	MigrateDiskSizes    map[string]int64   `bson:"migrate_disk_sizes,omitempty" json:"-"`
+	MigrateSerialLog    []byte             `bson:"migrate_serial_log,omitempty" json:"-"`
 	Domain              primitive.ObjectID `bson:"domain,omitempty" json:"domain"`
+	Placement           primitive.ObjectID `bson:"placement,omitempty" json:"placement"`
+	HighAvailability    bool               `bson:"high_availability" json:"high_availability"`
+	Failover            bool               `bson:"failover" json:"failover"`
+	FailoverError       string             `bson:"failover_error" json:"failover_error"`
+	FailoverAttempts    int                `bson:"failover_attempts" json:"failover_attempts"`
+	FailoverTimestamp   time.Time          `bson:"failover_timestamp" json:"failover_timestamp"`
+	DrainPolicy         string             `bson:"drain_policy" json:"drain_policy"`
 	Name                string             `bson:"name" json:"name"`
 	Comment             string             `bson:"comment" json:"comment"`
+	UserData            string             `bson:"user_data" json:"user_data"`
+	PreFreezeHook       string             `bson:"pre_freeze_hook" json:"pre_freeze_hook"`
+	PostThawHook        string             `bson:"post_thaw_hook" json:"post_thaw_hook"`
 	InitDiskSize        int                `bson:"init_disk_size" json:"init_disk_size"`
 	Memory              int                `bson:"memory" json:"memory"`
 	Processors          int                `bson:"processors" json:"processors"`
+	MaxMemory           int                `bson:"max_memory" json:"max_memory"`
+	MaxProcessors       int                `bson:"max_processors" json:"max_processors"`
 	NetworkRoles        []string           `bson:"network_roles" json:"network_roles"`
+	Adapters            []*Adapter         `bson:"adapters" json:"adapters"`
 	UsbDevices          []*usb.Device      `bson:"usb_devices" json:"usb_devices"`
 	Vnc                 bool               `bson:"vnc" json:"vnc"`
 	VncPassword         string             `bson:"vnc_password" json:"vnc_password"`
 	VncDisplay          int                `bson:"vnc_display,omitempty" json:"vnc_display"`
+	Firmware            string             `bson:"firmware" json:"firmware"`
+	Tpm                 bool               `bson:"tpm" json:"tpm"`
+	CpuModel            string             `bson:"cpu_model" json:"cpu_model"`
+	MachineType         string             `bson:"machine_type" json:"machine_type"`
+	Sockets             int                `bson:"sockets" json:"sockets"`
+	Cores               int                `bson:"cores" json:"cores"`
+	Threads             int                `bson:"threads" json:"threads"`
+	NestedVirt          bool               `bson:"nested_virt" json:"nested_virt"`
+	DedicatedCpus       bool               `bson:"dedicated_cpus" json:"dedicated_cpus"`
+	NumaLocal           bool               `bson:"numa_local" json:"numa_local"`
+	Hugepages           bool               `bson:"hugepages" json:"hugepages"`
+	NetworkRateIn       int                `bson:"network_rate_in" json:"network_rate_in"`
+	NetworkRateOut      int                `bson:"network_rate_out" json:"network_rate_out"`
 	Virt                *vm.VirtualMachine `bson:"-" json:"-"`
 	curVpc              primitive.ObjectID `bson:"-" json:"-"`
 	curSubnet           primitive.ObjectID `bson:"-" json:"-"`
+	curAdapters         []*Adapter         `bson:"-" json:"-"`
 	curDeleteProtection bool               `bson:"-" json:"-"`
 	curState            string             `bson:"-" json:"-"`
 	curNoPublicAddress  bool               `bson:"-" json:"-"`
 	curNoHostAddress    bool               `bson:"-" json:"-"`
+	curMigrating        bool               `bson:"-" json:"-"`
+	curHardware         hardware           `bson:"-" json:"-"`
+}
+
+type hardware struct {
+	Vpc            primitive.ObjectID
+	Subnet         primitive.ObjectID
+	Adapters       string
+	UsbDevices     string
+	Memory         int
+	Processors     int
+	MaxMemory      int
+	MaxProcessors  int
+	Firmware       string
+	Tpm            bool
+	CpuModel       string
+	MachineType    string
+	Sockets        int
+	Cores          int
+	Threads        int
+	NestedVirt     bool
+	DedicatedCpus  bool
+	NumaLocal      bool
+	Hugepages      bool
+	NetworkRateIn  int
+	NetworkRateOut int
 }
 
 func (i *Instance) Validate(db *database.Database) (
@@ -86,6 +151,36 @@ func (i *Instance) Validate(db *database.Database) (
 		return
 	}
 
+	if i.curMigrating && (i.State != i.curState ||
+		i.hardware() != i.curHardware) {
+
+		errData = &errortypes.ErrorData{
+			Error:   "instance_migrating",
+			Message: "Cannot modify instance while migrating",
+		}
+		return
+	}
+
+	if i.State == Migrate && i.MigrateNode.IsZero() {
+		errData = &errortypes.ErrorData{
+			Error:   "migrate_node_required",
+			Message: "Missing required migration node",
+		}
+		return
+	}
+
+	if i.DrainPolicy == "" {
+		i.DrainPolicy = DrainStop
+	}
+
+	if !ValidDrainPolicies.Contains(i.DrainPolicy) {
+		errData = &errortypes.ErrorData{
+			Error:   "invalid_drain_policy",
+			Message: "Invalid instance drain policy",
+		}
+		return
+	}
+
 	if i.Organization.IsZero() {
 		errData = &errortypes.ErrorData{
 			Error:   "organization_required",
@@ -148,6 +243,44 @@ func (i *Instance) Validate(db *database.Database) (
 		return
 	}
 
+	errData, err = i.validateAdapters(db)
+	if err != nil || errData != nil {
+		return
+	}
+
+	if len(i.UserData) > userdata.MaxSize {
+		errData = &errortypes.ErrorData{
+			Error:   "user_data_size_invalid",
+			Message: "User data exceeds maximum size",
+		}
+		return
+	}
+
+	_, e := userdata.Parse(i.UserData)
+	if e != nil {
+		errData = &errortypes.ErrorData{
+			Error:   "user_data_invalid",
+			Message: "User data must be a cloud-config, script or MIME message",
+		}
+		return
+	}
+
+	if i.PreFreezeHook != "" && !path.IsAbs(i.PreFreezeHook) {
+		errData = &errortypes.ErrorData{
+			Error:   "pre_freeze_hook_invalid",
+			Message: "Pre-freeze hook must be an absolute path",
+		}
+		return
+	}
+
+	if i.PostThawHook != "" && !path.IsAbs(i.PostThawHook) {
+		errData = &errortypes.ErrorData{
+			Error:   "post_thaw_hook_invalid",
+			Message: "Post-thaw hook must be an absolute path",
+		}
+		return
+	}
+
 	if i.InitDiskSize != 0 && i.InitDiskSize < 10 {
 		errData = &errortypes.ErrorData{
 			Error:   "init_disk_size_invalid",
@@ -202,6 +335,99 @@ func (i *Instance) Validate(db *database.Database) (
 		}
 	}
 
+	ndeId := i.Node
+	if i.State == Migrate {
+		ndeId = i.MigrateNode
+	}
+
+	errData, err = i.checkPlacement(db, ndeId)
+	if err != nil || errData != nil {
+		return
+	}
+
+	if i.Firmware == "" {
+		i.Firmware = vm.Bios
+	}
+
+	if !vm.ValidFirmwares.Contains(i.Firmware) {
+		errData = &errortypes.ErrorData{
+			Error:   "invalid_firmware",
+			Message: "Invalid instance firmware",
+		}
+		return
+	}
+
+	if i.CpuModel == "" {
+		i.CpuModel = vm.CpuHost
+	}
+
+	if i.Firmware == vm.UefiSecure && i.MachineType != "" &&
+		!strings.Contains(i.MachineType, "q35") {
+
+		errData = &errortypes.ErrorData{
+			Error:   "invalid_machine_type",
+			Message: "Secure boot requires q35 machine type",
+		}
+		return
+	}
+
+	if i.MaxProcessors != 0 && i.MaxProcessors < i.Processors {
+		errData = &errortypes.ErrorData{
+			Error:   "invalid_max_processors",
+			Message: "Maximum processors cannot be less than processors",
+		}
+		return
+	}
+
+	if i.MaxMemory != 0 && i.MaxMemory < i.Memory {
+		errData = &errortypes.ErrorData{
+			Error:   "invalid_max_memory",
+			Message: "Maximum memory cannot be less than memory",
+		}
+		return
+	}
+
+	if i.Sockets != 0 || i.Cores != 0 || i.Threads != 0 {
+		if i.Sockets < 1 {
+			i.Sockets = 1
+		}
+		if i.Cores < 1 {
+			i.Cores = 1
+		}
+		if i.Threads < 1 {
+			i.Threads = 1
+		}
+
+		if i.Sockets*i.Cores*i.Threads != i.GetMaxProcessors() {
+			errData = &errortypes.ErrorData{
+				Error:   "invalid_cpu_topology",
+				Message: "Sockets, cores and threads must equal max cpus",
+			}
+			return
+		}
+	}
+
+	if i.NumaLocal && !i.DedicatedCpus {
+		errData = &errortypes.ErrorData{
+			Error:   "numa_local_requires_dedicated_cpus",
+			Message: "NUMA local memory requires dedicated CPUs",
+		}
+		return
+	}
+
+	if i.NetworkRateIn < 0 || i.NetworkRateOut < 0 {
+		errData = &errortypes.ErrorData{
+			Error:   "invalid_network_rate",
+			Message: "Network rate limits cannot be negative",
+		}
+		return
+	}
+
+	errData, err = i.checkCapabilities(db, ndeId)
+	if err != nil || errData != nil {
+		return
+	}
+
 	if i.Vnc {
 		if i.VncDisplay == 0 {
 			i.VncDisplay = rand.Intn(9998) + 4101
@@ -228,6 +454,10 @@ func (i *Instance) Json() {
 	case Start:
 		if i.Restart || i.RestartBlockIp {
 			i.Status = "Restart Required"
+		} else if i.Failover {
+			i.Status = "Recovering"
+		} else if i.FailoverError != "" {
+			i.Status = "Failover Failed"
 		} else {
 			switch i.VmState {
 			case vm.Starting:
@@ -310,6 +540,9 @@ func (i *Instance) Json() {
 	case Destroy:
 		i.Status = "Destroying"
 		break
+	case Migrate:
+		i.Status = "Migrating"
+		break
 	}
 
 	i.PublicMac = vm.GetMacAddrExternal(i.Id, i.Vpc)
@@ -320,6 +553,258 @@ func (i *Instance) Json() {
 	}
 }
 
+func (i *Instance) Migrate(db *database.Database,
+	ndeId primitive.ObjectID) (errData *errortypes.ErrorData, err error) {
+
+	if i.State != Start || i.VmState != vm.Running {
+		errData = &errortypes.ErrorData{
+			Error:   "instance_not_running",
+			Message: "Instance must be running to migrate",
+		}
+		return
+	}
+
+	if ndeId.IsZero() || ndeId == i.Node {
+		errData = &errortypes.ErrorData{
+			Error:   "migrate_node_invalid",
+			Message: "Invalid migration node",
+		}
+		return
+	}
+
+	if len(i.UsbDevices) > 0 {
+		errData = &errortypes.ErrorData{
+			Error:   "migrate_usb_devices",
+			Message: "Cannot migrate instance with USB devices",
+		}
+		return
+	}
+
+	curNde, err := node.Get(db, i.Node)
+	if err != nil {
+		return
+	}
+
+	nde, err := node.Get(db, ndeId)
+	if err != nil {
+		if _, ok := err.(*database.NotFoundError); ok {
+			errData = &errortypes.ErrorData{
+				Error:   "migrate_node_invalid",
+				Message: "Migration node does not exist",
+			}
+			err = nil
+		}
+		return
+	}
+
+	if !nde.IsHypervisor() || nde.Hypervisor != curNde.Hypervisor {
+		errData = &errortypes.ErrorData{
+			Error:   "migrate_node_hypervisor",
+			Message: "Migration node hypervisor does not match",
+		}
+		return
+	}
+
+	if nde.Vga != curNde.Vga {
+		errData = &errortypes.ErrorData{
+			Error:   "migrate_node_vga",
+			Message: "Migration node VGA type does not match",
+		}
+		return
+	}
+
+	if nde.Zone != i.Zone {
+		errData = &errortypes.ErrorData{
+			Error:   "migrate_node_zone",
+			Message: "Migration node must be in the same zone",
+		}
+		return
+	}
+
+	if time.Since(nde.Timestamp) > 30*time.Second || nde.Fenced {
+		errData = &errortypes.ErrorData{
+			Error:   "migrate_node_offline",
+			Message: "Migration node is offline",
+		}
+		return
+	}
+
+	if nde.Maintenance {
+		errData = &errortypes.ErrorData{
+			Error:   "migrate_node_maintenance",
+			Message: "Migration node is in maintenance",
+		}
+		return
+	}
+
+	if len(nde.PrivateIps) == 0 {
+		errData = &errortypes.ErrorData{
+			Error:   "migrate_node_internal",
+			Message: "Migration node missing internal address",
+		}
+		return
+	}
+
+	dsks, err := disk.GetInstance(db, i.Id)
+	if err != nil {
+		return
+	}
+
+	for _, dsk := range dsks {
+		if dsk.State != disk.Available {
+			errData = &errortypes.ErrorData{
+				Error:   "migrate_disk_busy",
+				Message: "Instance disks must be available to migrate",
+			}
+			return
+		}
+	}
+
+	errData, err = i.checkPlacement(db, nde.Id)
+	if err != nil || errData != nil {
+		return
+	}
+
+	i.State = Migrate
+	i.MigrateNode = nde.Id
+	i.MigrateAddr = ""
+	i.MigratePort = 0
+	i.MigrateNbdPort = 0
+
+	return
+}
+
+func (i *Instance) checkPlacement(db *database.Database,
+	ndeId primitive.ObjectID) (errData *errortypes.ErrorData, err error) {
+
+	if i.Placement.IsZero() {
+		return
+	}
+
+	plc, err := placement.GetOrg(db, i.Organization, i.Placement)
+	if err != nil {
+		if _, ok := err.(*database.NotFoundError); ok {
+			err = nil
+			errData = &errortypes.ErrorData{
+				Error:   "placement_not_found",
+				Message: "Placement group not found",
+			}
+		}
+		return
+	}
+
+	errData, err = plc.Check(db, i.Id, ndeId, i.Zone)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func (i *Instance) checkCapabilities(db *database.Database,
+	ndeId primitive.ObjectID) (errData *errortypes.ErrorData, err error) {
+
+	if ndeId.IsZero() {
+		return
+	}
+
+	nde, err := node.Get(db, ndeId)
+	if err != nil {
+		if _, ok := err.(*database.NotFoundError); ok {
+			err = nil
+		}
+		return
+	}
+
+	if i.CpuModel != vm.CpuHost {
+		found := false
+		for _, model := range nde.CpuModels {
+			if model == i.CpuModel {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			errData = &errortypes.ErrorData{
+				Error:   "cpu_model_unsupported",
+				Message: "CPU model not supported by node",
+			}
+			return
+		}
+	}
+
+	if i.MachineType != "" && len(nde.MachineTypes) > 0 {
+		found := false
+		for _, typ := range nde.MachineTypes {
+			if typ == i.MachineType {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			errData = &errortypes.ErrorData{
+				Error:   "machine_type_unsupported",
+				Message: "Machine type not supported by node",
+			}
+			return
+		}
+	}
+
+	if i.NestedVirt && !nde.NestedVirt {
+		errData = &errortypes.ErrorData{
+			Error:   "nested_virt_unsupported",
+			Message: "Nested virtualization not enabled on node",
+		}
+		return
+	}
+
+	if !i.Hugepages && !i.DedicatedCpus {
+		return
+	}
+
+	hugepagesFree := nde.GetHugepagesFree()
+	cpusFree := nde.GetCpusFree()
+
+	if !i.Id.IsZero() {
+		curInst, e := Get(db, i.Id)
+		if e != nil {
+			if _, ok := e.(*database.NotFoundError); !ok {
+				err = e
+				return
+			}
+		} else if curInst.Node == ndeId {
+			if curInst.Hugepages {
+				hugepagesFree += curInst.Memory
+			}
+			if curInst.DedicatedCpus && (curInst.VmState == vm.Running ||
+				curInst.VmState == vm.Starting) {
+
+				cpusFree += curInst.Processors
+			}
+		}
+	}
+
+	if i.Hugepages && hugepagesFree < i.Memory {
+		errData = &errortypes.ErrorData{
+			Error:   "hugepages_unavailable",
+			Message: "Node free hugepages less than instance memory",
+		}
+		return
+	}
+
+	if i.DedicatedCpus && nde.CpuUnits > 0 && cpusFree < i.Processors {
+		errData = &errortypes.ErrorData{
+			Error:   "dedicated_cpus_unavailable",
+			Message: "Node free CPUs less than instance processors",
+		}
+		return
+	}
+
+	return
+}
+
 func (i *Instance) IsActive() bool {
 	return i.State == Start || i.VmState == vm.Running ||
 		i.VmState == vm.Starting || i.VmState == vm.Provisioning
@@ -328,10 +813,50 @@ func (i *Instance) IsActive() bool {
 func (i *Instance) PreCommit() {
 	i.curVpc = i.Vpc
 	i.curSubnet = i.Subnet
+	i.curAdapters = i.Adapters
 	i.curDeleteProtection = i.DeleteProtection
 	i.curState = i.State
 	i.curNoPublicAddress = i.NoPublicAddress
 	i.curNoHostAddress = i.NoHostAddress
+	i.curMigrating = i.State == Migrate || !i.MigrateNode.IsZero()
+	i.curHardware = i.hardware()
+}
+
+func (i *Instance) hardware() hardware {
+	adapters := []string{}
+	for _, adapter := range i.Adapters {
+		adapters = append(adapters,
+			adapter.Vpc.Hex()+"/"+adapter.Subnet.Hex())
+	}
+
+	usbDevices := []string{}
+	for _, device := range i.UsbDevices {
+		usbDevices = append(usbDevices, device.Vendor+":"+device.Product)
+	}
+
+	return hardware{
+		Vpc:            i.Vpc,
+		Subnet:         i.Subnet,
+		Adapters:       strings.Join(adapters, ","),
+		UsbDevices:     strings.Join(usbDevices, ","),
+		Memory:         i.Memory,
+		Processors:     i.Processors,
+		MaxMemory:      i.MaxMemory,
+		MaxProcessors:  i.MaxProcessors,
+		Firmware:       i.Firmware,
+		Tpm:            i.Tpm,
+		CpuModel:       i.CpuModel,
+		MachineType:    i.MachineType,
+		Sockets:        i.Sockets,
+		Cores:          i.Cores,
+		Threads:        i.Threads,
+		NestedVirt:     i.NestedVirt,
+		DedicatedCpus:  i.DedicatedCpus,
+		NumaLocal:      i.NumaLocal,
+		Hugepages:      i.Hugepages,
+		NetworkRateIn:  i.NetworkRateIn,
+		NetworkRateOut: i.NetworkRateOut,
+	}
 }
 
 func (i *Instance) PostCommit(db *database.Database) (
@@ -346,6 +871,11 @@ func (i *Instance) PostCommit(db *database.Database) (
 		}
 	}
 
+	err = i.releaseAdapters(db)
+	if err != nil {
+		return
+	}
+
 	if i.curDeleteProtection != i.DeleteProtection {
 		dskChange = true
 
@@ -403,6 +933,13 @@ func (i *Instance) CommitFields(db *database.Database, fields set.Set) (
 	return
 }
 
+func (i *Instance) GetMaxProcessors() int {
+	if i.MaxProcessors > i.Processors {
+		return i.MaxProcessors
+	}
+	return i.Processors
+}
+
 func (i *Instance) Insert(db *database.Database) (err error) {
 	coll := db.Instances()
 
@@ -413,6 +950,19 @@ func (i *Instance) Insert(db *database.Database) (err error) {
 		return
 	}
 
+	if i.NetworkRateIn == 0 && i.NetworkRateOut == 0 {
+		org, e := organization.Get(db, i.Organization)
+		if e != nil {
+			if _, ok := e.(*database.NotFoundError); !ok {
+				err = e
+				return
+			}
+		} else {
+			i.NetworkRateIn = org.NetworkRateIn
+			i.NetworkRateOut = org.NetworkRateOut
+		}
+	}
+
 	_, err = coll.InsertOne(db, i)
 	if err != nil {
 		err = database.ParseError(err)
@@ -424,13 +974,28 @@ func (i *Instance) Insert(db *database.Database) (err error) {
 
 func (i *Instance) LoadVirt(disks []*disk.Disk) {
 	i.Virt = &vm.VirtualMachine{
-		Id:         i.Id,
-		Image:      i.Image,
-		Processors: i.Processors,
-		Memory:     i.Memory,
-		Vnc:        i.Vnc,
-		VncDisplay: i.VncDisplay,
-		Disks:      []*vm.Disk{},
+		Id:             i.Id,
+		Image:          i.Image,
+		Processors:     i.Processors,
+		Memory:         i.Memory,
+		MaxProcessors:  i.MaxProcessors,
+		MaxMemory:      i.MaxMemory,
+		Vnc:            i.Vnc,
+		VncDisplay:     i.VncDisplay,
+		Firmware:       i.Firmware,
+		Tpm:            i.Tpm,
+		CpuModel:       i.CpuModel,
+		MachineType:    i.MachineType,
+		Sockets:        i.Sockets,
+		Cores:          i.Cores,
+		Threads:        i.Threads,
+		NestedVirt:     i.NestedVirt,
+		DedicatedCpus:  i.DedicatedCpus,
+		NumaLocal:      i.NumaLocal,
+		Hugepages:      i.Hugepages,
+		NetworkRateIn:  i.NetworkRateIn,
+		NetworkRateOut: i.NetworkRateOut,
+		Disks:          []*vm.Disk{},
 		NetworkAdapters: []*vm.NetworkAdapter{
 			&vm.NetworkAdapter{
 				Type:       vm.Bridge,
@@ -444,6 +1009,16 @@ func (i *Instance) LoadVirt(disks []*disk.Disk) {
 		UsbDevices:      []*vm.UsbDevice{},
 	}
 
+	for _, adapter := range i.Adapters {
+		i.Virt.NetworkAdapters = append(i.Virt.NetworkAdapters,
+			&vm.NetworkAdapter{
+				Type:       vm.Bridge,
+				MacAddress: vm.GetMacAddr(i.Id, adapter.Vpc),
+				Vpc:        adapter.Vpc,
+				Subnet:     adapter.Subnet,
+			})
+	}
+
 	if disks != nil {
 		for _, dsk := range disks {
 			index, err := strconv.Atoi(dsk.Index)
@@ -452,8 +1027,10 @@ func (i *Instance) LoadVirt(disks []*disk.Disk) {
 			}
 
 			i.Virt.Disks = append(i.Virt.Disks, &vm.Disk{
-				Index: index,
-				Path:  paths.GetDiskPath(dsk.Id),
+				Index:          index,
+				Path:           paths.GetDiskPath(dsk.Id),
+				IopsLimit:      dsk.IopsLimit,
+				BandwidthLimit: dsk.BandwidthLimit,
 			})
 		}
 	}
@@ -470,27 +1047,71 @@ func (i *Instance) LoadVirt(disks []*disk.Disk) {
 	return
 }
 
+func (i *Instance) Resizable(curVirt *vm.VirtualMachine) bool {
+	if curVirt.State != vm.Running || i.Virt.DedicatedCpus ||
+		i.Virt.MaxProcessors != curVirt.MaxProcessors ||
+		i.Virt.MaxMemory != curVirt.MaxMemory {
+
+		return false
+	}
+
+	if i.Virt.Processors < curVirt.Processors ||
+		i.Virt.Memory < curVirt.Memory {
+
+		return false
+	}
+
+	if i.Virt.Processors > curVirt.Processors &&
+		i.Virt.Processors > curVirt.MaxProcessors {
+
+		return false
+	}
+
+	if i.Virt.Memory > curVirt.Memory &&
+		i.Virt.Memory > curVirt.MaxMemory {
+
+		return false
+	}
+
+	return true
+}
+
 func (i *Instance) Changed(curVirt *vm.VirtualMachine) bool {
-	if i.Virt.Memory != curVirt.Memory ||
-		i.Virt.Processors != curVirt.Processors ||
+	if ((i.Virt.Memory != curVirt.Memory ||
+		i.Virt.Processors != curVirt.Processors) &&
+		!i.Resizable(curVirt)) ||
+		i.Virt.MaxProcessors != curVirt.MaxProcessors ||
+		i.Virt.MaxMemory != curVirt.MaxMemory ||
 		i.Virt.Vnc != curVirt.Vnc ||
 		i.Virt.VncDisplay != curVirt.VncDisplay ||
+		i.Virt.GetFirmware() != curVirt.GetFirmware() ||
+		i.Virt.Tpm != curVirt.Tpm ||
+		i.Virt.GetCpuModel() != curVirt.GetCpuModel() ||
+		i.Virt.MachineType != curVirt.MachineType ||
+		i.Virt.Sockets != curVirt.Sockets ||
+		i.Virt.Cores != curVirt.Cores ||
+		i.Virt.Threads != curVirt.Threads ||
+		i.Virt.NestedVirt != curVirt.NestedVirt ||
+		i.Virt.DedicatedCpus != curVirt.DedicatedCpus ||
+		i.Virt.NumaLocal != curVirt.NumaLocal ||
+		i.Virt.Hugepages != curVirt.Hugepages ||
 		i.Virt.NoPublicAddress != curVirt.NoPublicAddress ||
 		i.Virt.NoHostAddress != curVirt.NoHostAddress {
 
 		return true
 	}
 
-	for i, adapter := range i.Virt.NetworkAdapters {
-		if len(curVirt.NetworkAdapters) <= i {
-			return true
-		}
+	if len(curVirt.NetworkAdapters) == 0 ||
+		i.Virt.NetworkAdapters[0].Vpc != curVirt.NetworkAdapters[0].Vpc ||
+		i.Virt.NetworkAdapters[0].Subnet !=
+			curVirt.NetworkAdapters[0].Subnet {
 
-		if adapter.Vpc != curVirt.NetworkAdapters[i].Vpc {
-			return true
-		}
+		return true
+	}
 
-		if adapter.Subnet != curVirt.NetworkAdapters[i].Subnet {
+	if curVirt.State != vm.Running {
+		addAdapters, remAdapters := i.AdapterChanged(curVirt)
+		if len(addAdapters) > 0 || len(remAdapters) > 0 {
 			return true
 		}
 	}
@@ -544,3 +1165,34 @@ func (i *Instance) DiskChanged(curVirt *vm.VirtualMachine) (
 
 	return
 }
+
+func (i *Instance) DiskLimitsChanged(curVirt *vm.VirtualMachine) (
+	limitDisks []*vm.Disk) {
+
+	limitDisks = []*vm.Disk{}
+	curDisks := map[int]*vm.Disk{}
+
+	for _, dsk := range curVirt.Disks {
+		curDisks[dsk.Index] = dsk
+	}
+
+	for _, dsk := range i.Virt.Disks {
+		curDsk := curDisks[dsk.Index]
+		if curDsk == nil || dsk.Path != curDsk.Path {
+			continue
+		}
+
+		if dsk.IopsLimit != curDsk.IopsLimit ||
+			dsk.BandwidthLimit != curDsk.BandwidthLimit {
+
+			limitDisks = append(limitDisks, dsk)
+		}
+	}
+
+	return
+}
+
+func (i *Instance) NetworkLimitsChanged(curVirt *vm.VirtualMachine) bool {
+	return i.Virt.NetworkRateIn != curVirt.NetworkRateIn ||
+		i.Virt.NetworkRateOut != curVirt.NetworkRateOut
+}
@@ -1,6 +1,8 @@
 package instance
 
 import (
+	"time"
+
 	"github.com/Sirupsen/logrus"
 	"github.com/pritunl/mongo-go-driver/bson"
 	"github.com/pritunl/mongo-go-driver/bson/primitive"
@@ -8,7 +10,9 @@ import (
 	"github.com/pritunl/pritunl-cloud/block"
 	"github.com/pritunl/pritunl-cloud/database"
 	"github.com/pritunl/pritunl-cloud/disk"
+	"github.com/pritunl/pritunl-cloud/metric"
 	"github.com/pritunl/pritunl-cloud/utils"
+	"github.com/pritunl/pritunl-cloud/vm"
 	"github.com/pritunl/pritunl-cloud/vpc"
 )
 
@@ -323,6 +327,11 @@ func Remove(db *database.Database, instId primitive.ObjectID) (err error) {
 		return
 	}
 
+	err = metric.RemoveInstance(db, instId)
+	if err != nil {
+		return
+	}
+
 	_, err = coll.DeleteOne(db, &bson.M{
 		"_id": instId,
 	})
@@ -501,3 +510,300 @@ func SetState(db *database.Database, instId primitive.ObjectID,
 
 	return
 }
+
+func SetMigrateReady(db *database.Database, instId, ndeId primitive.ObjectID,
+	addr string, port, nbdPort int) (err error) {
+
+	coll := db.Instances()
+
+	_, err = coll.UpdateOne(db, &bson.M{
+		"_id":          instId,
+		"state":        Migrate,
+		"migrate_node": ndeId,
+	}, &bson.M{
+		"$set": &bson.M{
+			"migrate_addr":     addr,
+			"migrate_port":     port,
+			"migrate_nbd_port": nbdPort,
+		},
+	})
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
+	return
+}
+
+func SetMigrateNvram(db *database.Database,
+	instId, srcNdeId primitive.ObjectID, nvram []byte) (err error) {
+
+	coll := db.Instances()
+
+	_, err = coll.UpdateOne(db, &bson.M{
+		"_id":   instId,
+		"state": Migrate,
+		"node":  srcNdeId,
+	}, &bson.M{
+		"$set": &bson.M{
+			"migrate_nvram": nvram,
+		},
+	})
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
+	return
+}
+
+func SetMigrateSerialLog(db *database.Database,
+	instId, srcNdeId primitive.ObjectID, data []byte) (err error) {
+
+	coll := db.Instances()
+
+	_, err = coll.UpdateOne(db, &bson.M{
+		"_id":   instId,
+		"state": Migrate,
+		"node":  srcNdeId,
+	}, &bson.M{
+		"$set": &bson.M{
+			"migrate_serial_log": data,
+		},
+	})
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
+	return
+}
+
+func SetMigrateDiskSizes(db *database.Database,
+	instId, srcNdeId primitive.ObjectID, sizes map[string]int64) (
+	err error) {
+
+	coll := db.Instances()
+
+	_, err = coll.UpdateOne(db, &bson.M{
+		"_id":   instId,
+		"state": Migrate,
+		"node":  srcNdeId,
+	}, &bson.M{
+		"$set": &bson.M{
+			"migrate_disk_sizes": sizes,
+		},
+	})
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
+	return
+}
+
+func MigrateComplete(db *database.Database, instId, srcNdeId,
+	ndeId primitive.ObjectID) (updated bool, err error) {
+
+	coll := db.Instances()
+
+	resp, err := coll.UpdateOne(db, &bson.M{
+		"_id":          instId,
+		"state":        Migrate,
+		"node":         srcNdeId,
+		"migrate_node": ndeId,
+	}, &bson.M{
+		"$set": &bson.M{
+			"node":             ndeId,
+			"state":            Start,
+			"migrate_addr":     "",
+			"migrate_port":     0,
+			"migrate_nbd_port": 0,
+		},
+		"$unset": &bson.M{
+			"migrate_node":       "",
+			"migrate_nvram":      "",
+			"migrate_disk_sizes": "",
+			"migrate_serial_log": "",
+		},
+	})
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
+	if resp.MatchedCount > 0 {
+		updated = true
+	}
+
+	return
+}
+
+func MigrateAbort(db *database.Database, instId,
+	srcNdeId primitive.ObjectID) (aborted bool, err error) {
+
+	coll := db.Instances()
+
+	resp, err := coll.UpdateOne(db, &bson.M{
+		"_id":   instId,
+		"state": Migrate,
+		"node":  srcNdeId,
+	}, &bson.M{
+		"$set": &bson.M{
+			"state":            Start,
+			"migrate_addr":     "",
+			"migrate_port":     0,
+			"migrate_nbd_port": 0,
+		},
+		"$unset": &bson.M{
+			"migrate_nvram":      "",
+			"migrate_disk_sizes": "",
+			"migrate_serial_log": "",
+		},
+	})
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
+	if resp.MatchedCount > 0 {
+		aborted = true
+	}
+
+	return
+}
+
+func MigrateClear(db *database.Database, instId,
+	ndeId primitive.ObjectID) (err error) {
+
+	coll := db.Instances()
+
+	_, err = coll.UpdateOne(db, &bson.M{
+		"_id":          instId,
+		"state":        &bson.M{"$ne": Migrate},
+		"migrate_node": ndeId,
+	}, &bson.M{
+		"$unset": &bson.M{
+			"migrate_node": "",
+		},
+	})
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
+	return
+}
+
+func Failover(db *database.Database, instId, srcNdeId,
+	dstNdeId primitive.ObjectID) (updated bool, err error) {
+
+	coll := db.Instances()
+
+	resp, err := coll.UpdateOne(db, &bson.M{
+		"_id":  instId,
+		"node": srcNdeId,
+		"state": &bson.M{
+			"$in": []string{Start, Migrate},
+		},
+	}, &bson.M{
+		"$set": &bson.M{
+			"node":              dstNdeId,
+			"state":             Start,
+			"vm_state":          vm.Stopped,
+			"restart":           false,
+			"restart_block_ip":  false,
+			"migrate_addr":      "",
+			"migrate_port":      0,
+			"migrate_nbd_port":  0,
+			"failover":          true,
+			"failover_error":    "",
+			"failover_attempts": 0,
+		},
+		"$unset": &bson.M{
+			"migrate_node":       "",
+			"migrate_nvram":      "",
+			"migrate_disk_sizes": "",
+			"migrate_serial_log": "",
+		},
+	})
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
+	if resp.MatchedCount > 0 {
+		updated = true
+	}
+
+	return
+}
+
+func FailoverFailed(db *database.Database, instId, srcNdeId primitive.ObjectID,
+	message string) (err error) {
+
+	coll := db.Instances()
+
+	_, err = coll.UpdateOne(db, &bson.M{
+		"_id":  instId,
+		"node": srcNdeId,
+	}, &bson.M{
+		"$set": &bson.M{
+			"failover_error":     message,
+			"failover_timestamp": time.Now(),
+		},
+		"$inc": &bson.M{
+			"failover_attempts": 1,
+		},
+	})
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
+	return
+}
+
+func FailoverClear(db *database.Database, ndeId primitive.ObjectID) (
+	err error) {
+
+	coll := db.Instances()
+
+	_, err = coll.UpdateMany(db, &bson.M{
+		"node": ndeId,
+		"failover_error": &bson.M{
+			"$nin": []interface{}{"", nil},
+		},
+	}, &bson.M{
+		"$set": &bson.M{
+			"failover_error":    "",
+			"failover_attempts": 0,
+		},
+	})
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
+	return
+}
+
+func FailoverComplete(db *database.Database, instId primitive.ObjectID) (
+	err error) {
+
+	coll := db.Instances()
+
+	_, err = coll.UpdateOne(db, &bson.M{
+		"_id": instId,
+	}, &bson.M{
+		"$set": &bson.M{
+			"failover": false,
+		},
+	})
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
+	return
+}
@@ -736,6 +736,24 @@ func UpdateState(nodeSelf *node.Node, instances []*instance.Instance,
 
 		rules := generateVirt(namespace, iface, ingress)
 		newState.Interfaces[namespace+"-"+iface] = rules
+
+		for i := 1; i < len(inst.Virt.NetworkAdapters); i++ {
+			adapterNamespace := vm.GetNamespace(inst.Id, i)
+			adapterIface := vm.GetIface(inst.Id, i)
+
+			adapterIngress := firewalls[adapterNamespace]
+			if adapterIngress == nil {
+				logrus.WithFields(logrus.Fields{
+					"instance_id": inst.Id.Hex(),
+					"namespace":   adapterNamespace,
+				}).Warn("iptables: Failed to load adapter firewall rules")
+				continue
+			}
+
+			rules = generateVirt(adapterNamespace, adapterIface,
+				adapterIngress)
+			newState.Interfaces[adapterNamespace+"-"+adapterIface] = rules
+		}
 	}
 
 	err = applyState(curState, newState, namespaces)
@@ -0,0 +1,6 @@
+package metadata
+
+const (
+	Address = "169.254.169.254"
+	Port    = 80
+)
@@ -0,0 +1,357 @@
+package metadata
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/pritunl/mongo-go-driver/bson/primitive"
+	"github.com/pritunl/pritunl-cloud/cloudinit"
+	"github.com/pritunl/pritunl-cloud/database"
+	"github.com/pritunl/pritunl-cloud/disk"
+	"github.com/pritunl/pritunl-cloud/instance"
+	"github.com/pritunl/pritunl-cloud/utils"
+)
+
+const metaDataIndex = `instance-id
+hostname
+local-hostname
+local-ipv4
+public-ipv4
+public-keys/`
+
+type openstackMetaData struct {
+	Uuid       string            `json:"uuid"`
+	Name       string            `json:"name"`
+	Hostname   string            `json:"hostname"`
+	PublicKeys map[string]string `json:"public_keys"`
+}
+
+type openstackLink struct {
+	Id         string `json:"id"`
+	Type       string `json:"type"`
+	MacAddress string `json:"ethernet_mac_address"`
+	Mtu        int    `json:"mtu,omitempty"`
+}
+
+type openstackRoute struct {
+	Network string `json:"network"`
+	Netmask string `json:"netmask"`
+	Gateway string `json:"gateway"`
+}
+
+type openstackNetwork struct {
+	Id        string            `json:"id"`
+	Link      string            `json:"link"`
+	Type      string            `json:"type"`
+	IpAddress string            `json:"ip_address"`
+	Netmask   string            `json:"netmask"`
+	Routes    []*openstackRoute `json:"routes"`
+}
+
+type openstackService struct {
+	Type    string `json:"type"`
+	Address string `json:"address"`
+}
+
+type openstackNetworkData struct {
+	Links    []*openstackLink    `json:"links"`
+	Networks []*openstackNetwork `json:"networks"`
+	Services []*openstackService `json:"services"`
+}
+
+type handler struct {
+	instId primitive.ObjectID
+}
+
+func (h *handler) authorized(inst *instance.Instance,
+	r *http.Request) bool {
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+
+	remoteIp := net.ParseIP(host)
+	if remoteIp == nil {
+		return false
+	}
+
+	addrs := append([]string{}, inst.PrivateIps...)
+	addrs = append(addrs, inst.PrivateIps6...)
+
+	for _, addr := range addrs {
+		if remoteIp.Equal(net.ParseIP(addr)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (h *handler) getNetworkData(db *database.Database,
+	inst *instance.Instance) (data *openstackNetworkData, err error) {
+
+	adapters, mtu, err := cloudinit.GetNetAdapters(db, inst, inst.Virt)
+	if err != nil {
+		return
+	}
+
+	data = &openstackNetworkData{
+		Links:    []*openstackLink{},
+		Networks: []*openstackNetwork{},
+		Services: []*openstackService{},
+	}
+
+	for i, adapter := range adapters {
+		data.Links = append(data.Links, &openstackLink{
+			Id:         adapter.Name,
+			Type:       "phy",
+			MacAddress: adapter.MacAddress,
+			Mtu:        mtu,
+		})
+
+		routes := []*openstackRoute{}
+		if adapter.Gateway != "" {
+			routes = append(routes, &openstackRoute{
+				Network: "0.0.0.0",
+				Netmask: "0.0.0.0",
+				Gateway: adapter.Gateway,
+			})
+		}
+
+		data.Networks = append(data.Networks, &openstackNetwork{
+			Id:        fmt.Sprintf("network%d", i*2),
+			Link:      adapter.Name,
+			Type:      "ipv4",
+			IpAddress: adapter.Address,
+			Netmask:   adapter.Netmask,
+			Routes:    routes,
+		})
+
+		routes6 := []*openstackRoute{}
+		if adapter.Gateway6 != "" {
+			routes6 = append(routes6, &openstackRoute{
+				Network: "::",
+				Netmask: "::",
+				Gateway: adapter.Gateway6,
+			})
+		}
+
+		data.Networks = append(data.Networks, &openstackNetwork{
+			Id:        fmt.Sprintf("network%d", i*2+1),
+			Link:      adapter.Name,
+			Type:      "ipv6",
+			IpAddress: adapter.Address6,
+			Netmask:   "ffff:ffff:ffff:ffff::",
+			Routes:    routes6,
+		})
+
+		for _, nameserver := range adapter.Nameservers {
+			data.Services = append(data.Services, &openstackService{
+				Type:    "dns",
+				Address: nameserver,
+			})
+		}
+	}
+
+	return
+}
+
+func (h *handler) serve(db *database.Database, inst *instance.Instance,
+	w http.ResponseWriter, pth string) (err error) {
+
+	switch pth {
+	case "/latest", "/latest/":
+		utils.WriteText(w, 200,
+			"meta-data/\nuser-data\nvendor-data\nnetwork-config")
+	case "/latest/meta-data", "/latest/meta-data/":
+		utils.WriteText(w, 200, metaDataIndex)
+	case "/latest/meta-data/instance-id":
+		utils.WriteText(w, 200, inst.Id.Hex())
+	case "/latest/meta-data/hostname", "/latest/meta-data/local-hostname":
+		utils.WriteText(w, 200, cloudinit.GetHostname(inst))
+	case "/latest/meta-data/local-ipv4":
+		if len(inst.PrivateIps) == 0 {
+			utils.WriteStatus(w, 404)
+			return
+		}
+		utils.WriteText(w, 200, inst.PrivateIps[0])
+	case "/latest/meta-data/public-ipv4":
+		if len(inst.PublicIps) == 0 {
+			utils.WriteStatus(w, 404)
+			return
+		}
+		utils.WriteText(w, 200, inst.PublicIps[0])
+	case "/latest/meta-data/public-keys", "/latest/meta-data/public-keys/":
+		utils.WriteText(w, 200, "0=cloud")
+	case "/latest/meta-data/public-keys/0",
+		"/latest/meta-data/public-keys/0/":
+
+		utils.WriteText(w, 200, "openssh-key")
+	case "/latest/meta-data/public-keys/0/openssh-key":
+		keys, e := cloudinit.GetAuthorizedKeys(db, inst)
+		if e != nil {
+			err = e
+			return
+		}
+
+		utils.WriteText(w, 200, strings.Join(keys, "\n"))
+	case "/latest/user-data", "/openstack/latest/user_data":
+		usrData, e := cloudinit.GetUserData(db, inst, inst.Virt, false)
+		if e != nil {
+			err = e
+			return
+		}
+
+		if usrData == "" {
+			utils.WriteStatus(w, 404)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(200)
+		_, _ = w.Write([]byte(usrData))
+	case "/latest/vendor-data":
+		vendorData, e := cloudinit.GetVendorData(db, inst)
+		if e != nil {
+			err = e
+			return
+		}
+
+		if vendorData == "" {
+			utils.WriteStatus(w, 404)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(200)
+		_, _ = w.Write([]byte(vendorData))
+	case "/openstack/latest/vendor_data.json":
+		vendorData, e := cloudinit.GetVendorData(db, inst)
+		if e != nil {
+			err = e
+			return
+		}
+
+		data := map[string]string{}
+		if vendorData != "" {
+			data["cloud-init"] = vendorData
+		}
+
+		writeJson(w, data)
+	case "/latest/network-config":
+		netData, e := cloudinit.GetNetData(db, inst, inst.Virt)
+		if e != nil {
+			err = e
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/yaml; charset=utf-8")
+		w.WriteHeader(200)
+		_, _ = w.Write([]byte(netData))
+	case "/openstack", "/openstack/":
+		utils.WriteText(w, 200, "latest")
+	case "/openstack/latest", "/openstack/latest/":
+		utils.WriteText(w, 200,
+			"meta_data.json\nuser_data\nvendor_data.json\nnetwork_data.json")
+	case "/openstack/latest/meta_data.json":
+		keys, e := cloudinit.GetAuthorizedKeys(db, inst)
+		if e != nil {
+			err = e
+			return
+		}
+
+		data := &openstackMetaData{
+			Uuid:       inst.Id.Hex(),
+			Name:       inst.Name,
+			Hostname:   cloudinit.GetHostname(inst),
+			PublicKeys: map[string]string{},
+		}
+		for i, key := range keys {
+			data.PublicKeys[fmt.Sprintf("key%d", i)] = key
+		}
+
+		writeJson(w, data)
+	case "/openstack/latest/network_data.json":
+		data, e := h.getNetworkData(db, inst)
+		if e != nil {
+			err = e
+			return
+		}
+
+		writeJson(w, data)
+	default:
+		utils.WriteStatus(w, 404)
+	}
+
+	return
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		utils.WriteStatus(w, 405)
+		return
+	}
+
+	db := database.GetDatabase()
+	defer db.Close()
+
+	inst, err := instance.Get(db, h.instId)
+	if err != nil {
+		if _, ok := err.(*database.NotFoundError); ok {
+			utils.WriteStatus(w, 404)
+			return
+		}
+
+		logrus.WithFields(logrus.Fields{
+			"instance_id": h.instId.Hex(),
+			"error":       err,
+		}).Error("metadata: Failed to get instance")
+		utils.WriteStatus(w, 500)
+		return
+	}
+
+	if !h.authorized(inst, r) {
+		utils.WriteStatus(w, 401)
+		return
+	}
+
+	disks, err := disk.GetInstance(db, inst.Id)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"instance_id": h.instId.Hex(),
+			"error":       err,
+		}).Error("metadata: Failed to get instance disks")
+		utils.WriteStatus(w, 500)
+		return
+	}
+
+	inst.LoadVirt(disks)
+
+	err = h.serve(db, inst, w, r.URL.Path)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"instance_id": h.instId.Hex(),
+			"path":        r.URL.Path,
+			"error":       err,
+		}).Error("metadata: Failed to serve instance metadata")
+		utils.WriteStatus(w, 500)
+		return
+	}
+}
+
+func writeJson(w http.ResponseWriter, data interface{}) {
+	output, err := json.Marshal(data)
+	if err != nil {
+		utils.WriteStatus(w, 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	_, _ = w.Write(output)
+}
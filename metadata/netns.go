@@ -0,0 +1,119 @@
+package metadata
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"runtime"
+	"syscall"
+
+	"github.com/dropbox/godropbox/errors"
+	"github.com/pritunl/pritunl-cloud/errortypes"
+	"github.com/pritunl/pritunl-cloud/utils"
+	"golang.org/x/sys/unix"
+)
+
+func setNamespace(file *os.File) (err error) {
+	err = unix.Setns(int(file.Fd()), unix.CLONE_NEWNET)
+	if err != nil {
+		err = &errortypes.ExecError{
+			errors.Wrap(err, "metadata: Failed to set network namespace"),
+		}
+		return
+	}
+
+	return
+}
+
+func getNamespaceIno(namespace string) (ino uint64, err error) {
+	stat := &syscall.Stat_t{}
+	err = syscall.Stat(path.Join("/var/run/netns", namespace), stat)
+	if err != nil {
+		err = &errortypes.ReadError{
+			errors.Wrap(err, "metadata: Failed to stat network namespace"),
+		}
+		return
+	}
+
+	ino = stat.Ino
+
+	return
+}
+
+func listenNamespace(namespace string) (lstn net.Listener, err error) {
+	_, err = utils.ExecCombinedOutputLogged(
+		[]string{"File exists"},
+		"ip", "netns", "exec", namespace,
+		"ip", "addr",
+		"add", Address+"/32",
+		"dev", "lo",
+	)
+	if err != nil {
+		return
+	}
+
+	runtime.LockOSThread()
+
+	hostNs, err := os.Open(fmt.Sprintf(
+		"/proc/self/task/%d/ns/net", syscall.Gettid()))
+	if err != nil {
+		runtime.UnlockOSThread()
+		err = &errortypes.ReadError{
+			errors.Wrap(err, "metadata: Failed to open host namespace"),
+		}
+		return
+	}
+	defer hostNs.Close()
+
+	targetNs, err := os.Open(path.Join("/var/run/netns", namespace))
+	if err != nil {
+		runtime.UnlockOSThread()
+		err = &errortypes.ReadError{
+			errors.Wrap(err, "metadata: Failed to open network namespace"),
+		}
+		return
+	}
+	defer targetNs.Close()
+
+	err = setNamespace(targetNs)
+	if err != nil {
+		runtime.UnlockOSThread()
+		return
+	}
+
+	lstn, e := net.Listen("tcp", fmt.Sprintf("%s:%d", Address, Port))
+
+	err = setNamespace(hostNs)
+	if err != nil {
+		// Thread remains locked and exits with the listen goroutine
+		if lstn != nil {
+			_ = lstn.Close()
+			lstn = nil
+		}
+		return
+	}
+	runtime.UnlockOSThread()
+
+	if e != nil {
+		err = &errortypes.NetworkError{
+			errors.Wrap(e, "metadata: Failed to listen in namespace"),
+		}
+		return
+	}
+
+	return
+}
+
+func listen(namespace string) (lstn net.Listener, err error) {
+	done := make(chan bool)
+
+	go func() {
+		lstn, err = listenNamespace(namespace)
+		close(done)
+	}()
+
+	<-done
+
+	return
+}
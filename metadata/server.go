@@ -0,0 +1,161 @@
+package metadata
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/dropbox/godropbox/container/set"
+	"github.com/pritunl/mongo-go-driver/bson"
+	"github.com/pritunl/mongo-go-driver/bson/primitive"
+	"github.com/pritunl/pritunl-cloud/constants"
+	"github.com/pritunl/pritunl-cloud/database"
+	"github.com/pritunl/pritunl-cloud/instance"
+	"github.com/pritunl/pritunl-cloud/node"
+	"github.com/pritunl/pritunl-cloud/utils"
+	"github.com/pritunl/pritunl-cloud/vm"
+)
+
+type server struct {
+	instId primitive.ObjectID
+	ino    uint64
+	srv    *http.Server
+}
+
+var (
+	servers = map[string]*server{}
+)
+
+func (s *server) close() {
+	_ = s.srv.Close()
+}
+
+func start(instId primitive.ObjectID, namespace string, ino uint64) {
+	lstn, err := listen(namespace)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"instance_id": instId.Hex(),
+			"namespace":   namespace,
+			"error":       err,
+		}).Error("metadata: Failed to start metadata server")
+		return
+	}
+
+	srv := &http.Server{
+		Handler: &handler{
+			instId: instId,
+		},
+		ReadTimeout:       30 * time.Second,
+		ReadHeaderTimeout: 10 * time.Second,
+		WriteTimeout:      30 * time.Second,
+		MaxHeaderBytes:    4096,
+	}
+
+	servers[namespace] = &server{
+		instId: instId,
+		ino:    ino,
+		srv:    srv,
+	}
+
+	go func() {
+		err := srv.Serve(lstn)
+		if err != nil && err != http.ErrServerClosed {
+			logrus.WithFields(logrus.Fields{
+				"instance_id": instId.Hex(),
+				"error":       err,
+			}).Error("metadata: Metadata server error")
+		}
+	}()
+}
+
+func update() (err error) {
+	if !node.Self.IsHypervisor() {
+		for namespace, srv := range servers {
+			srv.close()
+			delete(servers, namespace)
+		}
+		return
+	}
+
+	db := database.GetDatabase()
+	defer db.Close()
+
+	namespaces, err := utils.GetNamespaces()
+	if err != nil {
+		return
+	}
+
+	namespacesSet := set.NewSet()
+	for _, namespace := range namespaces {
+		namespacesSet.Add(namespace)
+	}
+
+	instances, err := instance.GetAll(db, &bson.M{
+		"node": node.Self.Id,
+	})
+	if err != nil {
+		return
+	}
+
+	curNamespaces := set.NewSet()
+	for _, inst := range instances {
+		if !inst.IsActive() {
+			continue
+		}
+
+		for i := 0; i <= len(inst.Adapters); i++ {
+			namespace := vm.GetNamespace(inst.Id, i)
+			if !namespacesSet.Contains(namespace) {
+				continue
+			}
+
+			ino, e := getNamespaceIno(namespace)
+			if e != nil {
+				continue
+			}
+			curNamespaces.Add(namespace)
+
+			srv := servers[namespace]
+			if srv != nil {
+				if srv.ino == ino {
+					continue
+				}
+
+				srv.close()
+				delete(servers, namespace)
+			}
+
+			start(inst.Id, namespace, ino)
+		}
+	}
+
+	for namespace, srv := range servers {
+		if !curNamespaces.Contains(namespace) {
+			srv.close()
+			delete(servers, namespace)
+		}
+	}
+
+	return
+}
+
+func runner() {
+	for {
+		if constants.Interrupt {
+			return
+		}
+
+		err := update()
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err,
+			}).Error("metadata: Failed to update metadata servers")
+		}
+
+		time.Sleep(5 * time.Second)
+	}
+}
+
+func Init() {
+	go runner()
+}
@@ -0,0 +1,34 @@
+package metric
+
+import (
+	"time"
+)
+
+const (
+	Hour  = "1h"
+	Day   = "24h"
+	Week  = "7d"
+	Month = "30d"
+)
+
+type period struct {
+	Duration time.Duration
+	Hourly   bool
+}
+
+var periods = map[string]*period{
+	Hour: &period{
+		Duration: 1 * time.Hour,
+	},
+	Day: &period{
+		Duration: 24 * time.Hour,
+	},
+	Week: &period{
+		Duration: 168 * time.Hour,
+		Hourly:   true,
+	},
+	Month: &period{
+		Duration: 720 * time.Hour,
+		Hourly:   true,
+	},
+}
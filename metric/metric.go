@@ -0,0 +1,47 @@
+package metric
+
+import (
+	"time"
+
+	"github.com/dropbox/godropbox/errors"
+	"github.com/pritunl/mongo-go-driver/bson/primitive"
+	"github.com/pritunl/pritunl-cloud/database"
+	"github.com/pritunl/pritunl-cloud/errortypes"
+)
+
+type Metric struct {
+	Id           primitive.ObjectID `bson:"_id,omitempty" json:"-"`
+	Instance     primitive.ObjectID `bson:"i" json:"instance"`
+	Node         primitive.ObjectID `bson:"n" json:"node"`
+	Timestamp    time.Time          `bson:"t" json:"timestamp"`
+	CpuTime      float64            `bson:"ct" json:"cpu_time"`
+	CpuUsage     float64            `bson:"cu" json:"cpu_usage"`
+	Memory       float64            `bson:"m" json:"memory"`
+	DiskRead     float64            `bson:"dr" json:"disk_read"`
+	DiskWrite    float64            `bson:"dw" json:"disk_write"`
+	DiskReadOps  float64            `bson:"dro" json:"disk_read_ops"`
+	DiskWriteOps float64            `bson:"dwo" json:"disk_write_ops"`
+	NetRx        float64            `bson:"nr" json:"net_rx"`
+	NetTx        float64            `bson:"nt" json:"net_tx"`
+	NetRxPackets float64            `bson:"nrp" json:"net_rx_packets"`
+	NetTxPackets float64            `bson:"ntp" json:"net_tx_packets"`
+}
+
+func (m *Metric) Insert(db *database.Database) (err error) {
+	coll := db.Metrics()
+
+	if !m.Id.IsZero() {
+		err = &errortypes.DatabaseError{
+			errors.New("metric: Metric already exists"),
+		}
+		return
+	}
+
+	_, err = coll.InsertOne(db, m)
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
+	return
+}
@@ -0,0 +1,196 @@
+package metric
+
+import (
+	"time"
+
+	"github.com/pritunl/mongo-go-driver/bson"
+	"github.com/pritunl/mongo-go-driver/bson/primitive"
+	"github.com/pritunl/mongo-go-driver/mongo/options"
+	"github.com/pritunl/pritunl-cloud/database"
+)
+
+func GetInstance(db *database.Database, instId primitive.ObjectID,
+	prd string) (metrics []*Metric, err error) {
+
+	metrics = []*Metric{}
+
+	per := periods[prd]
+	if per == nil {
+		per = periods[Day]
+	}
+
+	coll := db.Metrics()
+	if per.Hourly {
+		coll = db.MetricsHourly()
+	}
+
+	cursor, err := coll.Find(
+		db,
+		&bson.M{
+			"i": instId,
+			"t": &bson.M{
+				"$gte": time.Now().Add(-per.Duration),
+			},
+		},
+		&options.FindOptions{
+			Sort: &bson.D{
+				{"t", 1},
+			},
+		},
+	)
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+	defer cursor.Close(db)
+
+	for cursor.Next(db) {
+		mtrc := &Metric{}
+		err = cursor.Decode(mtrc)
+		if err != nil {
+			err = database.ParseError(err)
+			return
+		}
+
+		metrics = append(metrics, mtrc)
+	}
+
+	err = cursor.Err()
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
+	return
+}
+
+func Downsample(db *database.Database, start time.Time) (err error) {
+	coll := db.Metrics()
+	hourlyColl := db.MetricsHourly()
+
+	start = start.Truncate(time.Hour)
+
+	cursor, err := coll.Aggregate(db, []*bson.M{
+		&bson.M{
+			"$match": &bson.M{
+				"t": &bson.M{
+					"$gte": start,
+					"$lt":  start.Add(time.Hour),
+				},
+			},
+		},
+		&bson.M{
+			"$sort": &bson.M{
+				"t": 1,
+			},
+		},
+		&bson.M{
+			"$group": &bson.M{
+				"_id": "$i",
+				"n": &bson.M{
+					"$last": "$n",
+				},
+				"ct": &bson.M{
+					"$sum": "$ct",
+				},
+				"cu": &bson.M{
+					"$avg": "$cu",
+				},
+				"m": &bson.M{
+					"$avg": "$m",
+				},
+				"dr": &bson.M{
+					"$avg": "$dr",
+				},
+				"dw": &bson.M{
+					"$avg": "$dw",
+				},
+				"dro": &bson.M{
+					"$avg": "$dro",
+				},
+				"dwo": &bson.M{
+					"$avg": "$dwo",
+				},
+				"nr": &bson.M{
+					"$avg": "$nr",
+				},
+				"nt": &bson.M{
+					"$avg": "$nt",
+				},
+				"nrp": &bson.M{
+					"$avg": "$nrp",
+				},
+				"ntp": &bson.M{
+					"$avg": "$ntp",
+				},
+			},
+		},
+	})
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+	defer cursor.Close(db)
+
+	for cursor.Next(db) {
+		mtrc := &Metric{}
+		err = cursor.Decode(mtrc)
+		if err != nil {
+			err = database.ParseError(err)
+			return
+		}
+
+		mtrc.Instance = mtrc.Id
+		mtrc.Id = primitive.NilObjectID
+		mtrc.Timestamp = start
+
+		opts := &options.UpdateOptions{}
+		opts.SetUpsert(true)
+
+		_, err = hourlyColl.UpdateOne(
+			db,
+			&bson.M{
+				"i": mtrc.Instance,
+				"t": start,
+			},
+			&bson.M{
+				"$set": mtrc,
+			},
+			opts,
+		)
+		if err != nil {
+			err = database.ParseError(err)
+			return
+		}
+	}
+
+	err = cursor.Err()
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
+	return
+}
+
+func RemoveInstance(db *database.Database, instId primitive.ObjectID) (
+	err error) {
+
+	_, err = db.Metrics().DeleteMany(db, &bson.M{
+		"i": instId,
+	})
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
+	_, err = db.MetricsHourly().DeleteMany(db, &bson.M{
+		"i": instId,
+	})
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
+	return
+}
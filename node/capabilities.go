@@ -0,0 +1,118 @@
+package node
+
+import (
+	"io/ioutil"
+	"strings"
+
+	"github.com/pritunl/pritunl-cloud/utils"
+)
+
+const qemuPath = "/usr/bin/qemu-system-x86_64"
+
+func getCpuModels() (models []string, err error) {
+	models = []string{}
+
+	output, err := utils.ExecOutput("", qemuPath, "-cpu", "help")
+	if err != nil {
+		return
+	}
+
+	// Older versions prefix each model with x86, newer versions list the
+	// indented models under a header
+	available := false
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 1 {
+			continue
+		}
+
+		if strings.HasPrefix(line, "x86 ") {
+			if len(fields) > 1 {
+				models = append(models, strings.Trim(fields[1], "[]"))
+			}
+			continue
+		}
+
+		if line == "Available CPUs:" {
+			available = true
+			continue
+		}
+
+		if !strings.HasPrefix(line, " ") {
+			available = false
+			continue
+		}
+
+		if available {
+			models = append(models, fields[0])
+		}
+	}
+
+	return
+}
+
+func getMachineTypes() (types []string, err error) {
+	types = []string{}
+
+	output, err := utils.ExecOutput("", qemuPath, "-machine", "help")
+	if err != nil {
+		return
+	}
+
+	for i, line := range strings.Split(output, "\n") {
+		if i == 0 {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 1 {
+			continue
+		}
+
+		types = append(types, fields[0])
+	}
+
+	return
+}
+
+func getVirtExtension() (extension string, nested bool) {
+	cpuinfo, err := ioutil.ReadFile("/proc/cpuinfo")
+	if err != nil {
+		return
+	}
+
+	module := ""
+	for _, line := range strings.Split(string(cpuinfo), "\n") {
+		if !strings.HasPrefix(line, "flags") {
+			continue
+		}
+
+		for _, flag := range strings.Fields(line) {
+			if flag == "vmx" {
+				extension = "vmx"
+				module = "kvm_intel"
+				break
+			} else if flag == "svm" {
+				extension = "svm"
+				module = "kvm_amd"
+				break
+			}
+		}
+		break
+	}
+
+	if module == "" {
+		return
+	}
+
+	nestedData, err := ioutil.ReadFile(
+		"/sys/module/" + module + "/parameters/nested")
+	if err != nil {
+		return
+	}
+
+	val := strings.TrimSpace(string(nestedData))
+	nested = val == "Y" || val == "1"
+
+	return
+}
@@ -18,5 +18,8 @@ const (
 	Static   = "static"
 	Internal = "internal"
 
-	Restart = "restart"
+	Restart     = "restart"
+	Maintenance = "maintenance"
+	Drain       = "drain"
+	Active      = "active"
 )
@@ -44,6 +44,10 @@ type Node struct {
 	Protocol             string               `bson:"protocol" json:"protocol"`
 	Hypervisor           string               `bson:"hypervisor" json:"hypervisor"`
 	Vga                  string               `bson:"vga" json:"vga"`
+	CpuModels            []string             `bson:"cpu_models" json:"cpu_models"`
+	MachineTypes         []string             `bson:"machine_types" json:"machine_types"`
+	VirtExtension        string               `bson:"virt_extension" json:"virt_extension"`
+	NestedVirt           bool                 `bson:"nested_virt" json:"nested_virt"`
 	Certificate          primitive.ObjectID   `bson:"certificate" json:"certificate"`
 	Certificates         []primitive.ObjectID `bson:"certificates" json:"certificates"`
 	SelfCertificate      string               `bson:"self_certificate_key" json:"-"`
@@ -81,6 +85,10 @@ type Node struct {
 	MemoryUnits          float64              `bson:"memory_units" json:"memory_units"`
 	CpuUnitsRes          int                  `bson:"cpu_units_res" json:"cpu_units_res"`
 	MemoryUnitsRes       float64              `bson:"memory_units_res" json:"memory_units_res"`
+	Numa                 []*NumaNode          `bson:"numa" json:"numa"`
+	HugepageSize         int                  `bson:"hugepage_size" json:"hugepage_size"`
+	PinnedCpusRes        int                  `bson:"pinned_cpus_res" json:"pinned_cpus_res"`
+	HugepagesRes         int                  `bson:"hugepages_res" json:"hugepages_res"`
 	PublicIps            []string             `bson:"public_ips" json:"public_ips"`
 	PublicIps6           []string             `bson:"public_ips6" json:"public_ips6"`
 	PrivateIps           map[string]string    `bson:"private_ips" json:"private_ips"`
@@ -94,13 +102,21 @@ type Node struct {
 	OraclePublicKey      string               `bson:"oracle_public_key" json:"oracle_public_key"`
 	OracleHostRoute      bool                 `bson:"oracle_host_route" json:"oracle_host_route"`
 	Operation            string               `bson:"operation" json:"operation"`
+	Fenced               bool                 `bson:"fenced" json:"fenced"`
+	Maintenance          bool                 `bson:"maintenance" json:"maintenance"`
+	Draining             bool                 `bson:"draining" json:"draining"`
+	DrainRemaining       int                  `bson:"drain_remaining" json:"drain_remaining"`
+	heartbeat            time.Time            `bson:"-" json:"-"`
 	reqLock              sync.Mutex           `bson:"-" json:"-"`
+	drainLock            sync.Mutex           `bson:"-" json:"-"`
 	reqCount             *list.List           `bson:"-" json:"-"`
 	dcId                 primitive.ObjectID   `bson:"-" json:"-"`
 	dcZoneId             primitive.ObjectID   `bson:"-" json:"-"`
 }
 
 func (n *Node) Copy() *Node {
+	draining, drainRemaining := n.GetDrain()
+
 	nde := &Node{
 		Id:                   n.Id,
 		Zone:                 n.Zone,
@@ -113,6 +129,10 @@ func (n *Node) Copy() *Node {
 		Protocol:             n.Protocol,
 		Hypervisor:           n.Hypervisor,
 		Vga:                  n.Vga,
+		CpuModels:            n.CpuModels,
+		MachineTypes:         n.MachineTypes,
+		VirtExtension:        n.VirtExtension,
+		NestedVirt:           n.NestedVirt,
 		Certificate:          n.Certificate,
 		Certificates:         n.Certificates,
 		SelfCertificate:      n.SelfCertificate,
@@ -148,6 +168,10 @@ func (n *Node) Copy() *Node {
 		MemoryUnits:          n.MemoryUnits,
 		CpuUnitsRes:          n.CpuUnitsRes,
 		MemoryUnitsRes:       n.MemoryUnitsRes,
+		Numa:                 n.Numa,
+		HugepageSize:         n.HugepageSize,
+		PinnedCpusRes:        n.PinnedCpusRes,
+		HugepagesRes:         n.HugepagesRes,
 		PublicIps:            n.PublicIps,
 		PublicIps6:           n.PublicIps6,
 		PrivateIps:           n.PrivateIps,
@@ -160,6 +184,10 @@ func (n *Node) Copy() *Node {
 		OraclePrivateKey:     n.OraclePrivateKey,
 		OraclePublicKey:      n.OraclePublicKey,
 		OracleHostRoute:      n.OracleHostRoute,
+		Fenced:               n.Fenced,
+		Maintenance:          n.Maintenance,
+		Draining:             draining,
+		DrainRemaining:       drainRemaining,
 		reqLock:              n.reqLock,
 		reqCount:             n.reqCount,
 	}
@@ -167,6 +195,10 @@ func (n *Node) Copy() *Node {
 	return nde
 }
 
+func (n *Node) LastHeartbeat() time.Time {
+	return n.heartbeat
+}
+
 func (n *Node) AddRequest() {
 	n.reqLock.Lock()
 	back := n.reqCount.Back()
@@ -174,6 +206,31 @@ func (n *Node) AddRequest() {
 	n.reqLock.Unlock()
 }
 
+func (n *Node) GetDrain() (draining bool, remaining int) {
+	n.drainLock.Lock()
+	draining = n.Draining
+	remaining = n.DrainRemaining
+	n.drainLock.Unlock()
+	return
+}
+
+func (n *Node) SetDrain(db *database.Database, draining bool,
+	remaining int) (err error) {
+
+	n.drainLock.Lock()
+	defer n.drainLock.Unlock()
+
+	err = SetDrain(db, n.Id, draining, remaining)
+	if err != nil {
+		return
+	}
+
+	n.Draining = draining
+	n.DrainRemaining = remaining
+
+	return
+}
+
 func (n *Node) GetVirtPath() string {
 	if n.VirtPath == "" {
 		return constants.DefaultRoot
@@ -244,6 +301,19 @@ func (n *Node) IsHypervisor() bool {
 	return false
 }
 
+func (n *Node) GetHugepagesFree() int {
+	hugepages := 0
+	for _, numaNode := range n.Numa {
+		hugepages += numaNode.Hugepages
+	}
+
+	return hugepages - n.HugepagesRes
+}
+
+func (n *Node) GetCpusFree() int {
+	return n.CpuUnits - n.PinnedCpusRes
+}
+
 func (n *Node) IsIpsec() bool {
 	for _, typ := range n.Types {
 		if typ == Ipsec {
@@ -531,6 +601,8 @@ func (n *Node) SetActive() {
 		n.CpuUnitsRes = 0
 		n.MemoryUnits = 0
 		n.MemoryUnitsRes = 0
+		n.PinnedCpusRes = 0
+		n.HugepagesRes = 0
 	}
 }
 
@@ -729,6 +801,10 @@ func (n *Node) update(db *database.Database) (err error) {
 				"memory_units":         n.MemoryUnits,
 				"cpu_units_res":        n.CpuUnitsRes,
 				"memory_units_res":     n.MemoryUnitsRes,
+				"numa":                 n.Numa,
+				"hugepage_size":        n.HugepageSize,
+				"pinned_cpus_res":      n.PinnedCpusRes,
+				"hugepages_res":        n.HugepagesRes,
 				"public_ips":           n.PublicIps,
 				"public_ips6":          n.PublicIps6,
 				"private_ips":          n.PrivateIps,
@@ -786,6 +862,13 @@ func (n *Node) update(db *database.Database) (err error) {
 	n.OraclePublicKey = nde.OraclePublicKey
 	n.OracleHostRoute = nde.OracleHostRoute
 	n.Operation = nde.Operation
+	n.Fenced = nde.Fenced
+	n.Maintenance = nde.Maintenance
+	n.drainLock.Lock()
+	n.Draining = nde.Draining
+	n.DrainRemaining = nde.DrainRemaining
+	n.drainLock.Unlock()
+	n.heartbeat = n.Timestamp
 
 	return
 }
@@ -826,6 +909,16 @@ func (n *Node) sync() {
 		n.Load15 = load.Load15
 	}
 
+	numa, hugepageSize, err := getNuma()
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+		}).Error("node: Failed to get numa topology")
+	} else {
+		n.Numa = numa
+		n.HugepageSize = hugepageSize
+	}
+
 	defaultIface, err := getDefaultIface()
 	if err != nil {
 		logrus.WithFields(logrus.Fields{
@@ -1027,6 +1120,26 @@ func (n *Node) Init() (err error) {
 		n.Hypervisor = Kvm
 	}
 
+	cpuModels, err := getCpuModels()
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+		}).Warning("node: Failed to get qemu cpu models")
+		err = nil
+	}
+	n.CpuModels = cpuModels
+
+	machineTypes, err := getMachineTypes()
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+		}).Warning("node: Failed to get qemu machine types")
+		err = nil
+	}
+	n.MachineTypes = machineTypes
+
+	n.VirtExtension, n.NestedVirt = getVirtExtension()
+
 	bsonSet := bson.M{
 		"_id":              n.Id,
 		"name":             n.Name,
@@ -1036,6 +1149,10 @@ func (n *Node) Init() (err error) {
 		"port":             n.Port,
 		"hypervisor":       n.Hypervisor,
 		"vga":              n.Vga,
+		"cpu_models":       n.CpuModels,
+		"machine_types":    n.MachineTypes,
+		"virt_extension":   n.VirtExtension,
+		"nested_virt":      n.NestedVirt,
 		"software_version": n.SoftwareVersion,
 	}
 
@@ -0,0 +1,161 @@
+package node
+
+import (
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dropbox/godropbox/errors"
+	"github.com/pritunl/pritunl-cloud/errortypes"
+)
+
+const numaPath = "/sys/devices/system/node"
+
+type NumaNode struct {
+	Id            int   `bson:"id" json:"id"`
+	Cpus          []int `bson:"cpus" json:"cpus"`
+	Memory        int   `bson:"memory" json:"memory"`
+	Hugepages     int   `bson:"hugepages" json:"hugepages"`
+	HugepagesFree int   `bson:"hugepages_free" json:"hugepages_free"`
+}
+
+func ParseCpuList(cpuList string) (cpus []int, err error) {
+	cpus = []int{}
+
+	cpuList = strings.TrimSpace(cpuList)
+	if cpuList == "" {
+		return
+	}
+
+	for _, part := range strings.Split(cpuList, ",") {
+		bounds := strings.SplitN(part, "-", 2)
+
+		start, e := strconv.Atoi(bounds[0])
+		if e != nil {
+			err = &errortypes.ParseError{
+				errors.Wrap(e, "node: Failed to parse cpu list"),
+			}
+			return
+		}
+
+		end := start
+		if len(bounds) == 2 {
+			end, e = strconv.Atoi(bounds[1])
+			if e != nil {
+				err = &errortypes.ParseError{
+					errors.Wrap(e, "node: Failed to parse cpu list"),
+				}
+				return
+			}
+		}
+
+		for i := start; i <= end; i++ {
+			cpus = append(cpus, i)
+		}
+	}
+
+	return
+}
+
+func readInt(pth string) int {
+	data, err := ioutil.ReadFile(pth)
+	if err != nil {
+		return 0
+	}
+
+	val, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+	return val
+}
+
+func getHugepageSize() (size int) {
+	data, err := ioutil.ReadFile("/proc/meminfo")
+	if err != nil {
+		return
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		if !strings.HasPrefix(line, "Hugepagesize:") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) > 1 {
+			size, _ = strconv.Atoi(fields[1])
+		}
+		break
+	}
+
+	return
+}
+
+func getNuma() (nodes []*NumaNode, hugepageSize int, err error) {
+	nodes = []*NumaNode{}
+	hugepageSize = getHugepageSize()
+
+	nodePaths, err := filepath.Glob(path.Join(numaPath, "node[0-9]*"))
+	if err != nil {
+		err = &errortypes.ReadError{
+			errors.Wrap(err, "node: Failed to list numa nodes"),
+		}
+		return
+	}
+
+	for _, nodePath := range nodePaths {
+		id, e := strconv.Atoi(strings.TrimPrefix(path.Base(nodePath), "node"))
+		if e != nil {
+			continue
+		}
+
+		cpuList, e := ioutil.ReadFile(path.Join(nodePath, "cpulist"))
+		if e != nil {
+			err = &errortypes.ReadError{
+				errors.Wrap(e, "node: Failed to read numa cpu list"),
+			}
+			return
+		}
+
+		cpus, e := ParseCpuList(string(cpuList))
+		if e != nil {
+			err = e
+			return
+		}
+
+		numaNode := &NumaNode{
+			Id:   id,
+			Cpus: cpus,
+		}
+
+		memInfo, e := ioutil.ReadFile(path.Join(nodePath, "meminfo"))
+		if e == nil {
+			for _, line := range strings.Split(string(memInfo), "\n") {
+				fields := strings.Fields(line)
+				if len(fields) > 3 && fields[2] == "MemTotal:" {
+					memory, _ := strconv.Atoi(fields[3])
+					numaNode.Memory = memory / 1024
+					break
+				}
+			}
+		}
+
+		if hugepageSize > 0 {
+			hugepagesPath := path.Join(nodePath, "hugepages",
+				"hugepages-"+strconv.Itoa(hugepageSize)+"kB")
+
+			numaNode.Hugepages = readInt(path.Join(
+				hugepagesPath, "nr_hugepages")) * hugepageSize / 1024
+			numaNode.HugepagesFree = readInt(path.Join(
+				hugepagesPath, "free_hugepages")) * hugepageSize / 1024
+		}
+
+		nodes = append(nodes, numaNode)
+	}
+
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].Id < nodes[j].Id
+	})
+
+	return
+}
@@ -1,6 +1,8 @@
 package node
 
 import (
+	"time"
+
 	"github.com/pritunl/mongo-go-driver/bson"
 	"github.com/pritunl/mongo-go-driver/bson/primitive"
 	"github.com/pritunl/mongo-go-driver/mongo/options"
@@ -157,6 +159,44 @@ func GetAllPaged(db *database.Database, query *bson.M,
 	return
 }
 
+func GetAllZones(db *database.Database, zoneIds []primitive.ObjectID) (
+	nodes []*Node, err error) {
+
+	coll := db.Nodes()
+	nodes = []*Node{}
+
+	cursor, err := coll.Find(db, &bson.M{
+		"zone": &bson.M{
+			"$in": zoneIds,
+		},
+	})
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+	defer cursor.Close(db)
+
+	for cursor.Next(db) {
+		nde := &Node{}
+		err = cursor.Decode(nde)
+		if err != nil {
+			err = database.ParseError(err)
+			return
+		}
+
+		nde.SetActive()
+		nodes = append(nodes, nde)
+	}
+
+	err = cursor.Err()
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
+	return
+}
+
 func GetAllNet(db *database.Database) (nodes []*Node, err error) {
 	coll := db.Nodes()
 	nodes = []*Node{}
@@ -195,6 +235,75 @@ func GetAllNet(db *database.Database) (nodes []*Node, err error) {
 	return
 }
 
+func Fence(db *database.Database, nodeId primitive.ObjectID,
+	cutoff time.Time) (fenced bool, err error) {
+
+	coll := db.Nodes()
+
+	resp, err := coll.UpdateOne(db, &bson.M{
+		"_id": nodeId,
+		"timestamp": &bson.M{
+			"$lt": cutoff,
+		},
+	}, &bson.M{
+		"$set": &bson.M{
+			"fenced": true,
+		},
+	})
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
+	if resp.MatchedCount > 0 {
+		fenced = true
+	}
+
+	return
+}
+
+func ClearFence(db *database.Database, nodeId primitive.ObjectID) (
+	err error) {
+
+	coll := db.Nodes()
+
+	_, err = coll.UpdateOne(db, &bson.M{
+		"_id": nodeId,
+	}, &bson.M{
+		"$set": &bson.M{
+			"fenced": false,
+		},
+	})
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
+	return
+}
+
+func SetDrain(db *database.Database, nodeId primitive.ObjectID,
+	draining bool, remaining int) (err error) {
+
+	coll := db.Nodes()
+
+	_, err = coll.UpdateOne(db, &bson.M{
+		"_id":      nodeId,
+		"draining": true,
+	}, &bson.M{
+		"$set": &bson.M{
+			"draining":        draining,
+			"drain_remaining": remaining,
+		},
+	})
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
+	return
+}
+
 func Remove(db *database.Database, nodeId primitive.ObjectID) (err error) {
 	coll := db.Nodes()
 
@@ -6,13 +6,19 @@ import (
 	"github.com/pritunl/mongo-go-driver/bson/primitive"
 	"github.com/pritunl/pritunl-cloud/database"
 	"github.com/pritunl/pritunl-cloud/errortypes"
+	"github.com/pritunl/pritunl-cloud/userdata"
 )
 
 type Organization struct {
-	Id      primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	Roles   []string           `bson:"roles" json:"roles"`
-	Name    string             `bson:"name" json:"name"`
-	Comment string             `bson:"comment" json:"comment"`
+	Id                 primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Roles              []string           `bson:"roles" json:"roles"`
+	Name               string             `bson:"name" json:"name"`
+	Comment            string             `bson:"comment" json:"comment"`
+	DiskIopsLimit      int                `bson:"disk_iops_limit" json:"disk_iops_limit"`
+	DiskBandwidthLimit int                `bson:"disk_bandwidth_limit" json:"disk_bandwidth_limit"`
+	NetworkRateIn      int                `bson:"network_rate_in" json:"network_rate_in"`
+	NetworkRateOut     int                `bson:"network_rate_out" json:"network_rate_out"`
+	VendorData         string             `bson:"vendor_data" json:"vendor_data"`
 }
 
 func (d *Organization) Validate(db *database.Database) (
@@ -22,6 +28,42 @@ func (d *Organization) Validate(db *database.Database) (
 		d.Roles = []string{}
 	}
 
+	if d.DiskIopsLimit < 0 || d.DiskBandwidthLimit < 0 ||
+		d.NetworkRateIn < 0 || d.NetworkRateOut < 0 {
+
+		errData = &errortypes.ErrorData{
+			Error:   "invalid_limit",
+			Message: "Resource limits cannot be negative",
+		}
+		return
+	}
+
+	if len(d.VendorData) > userdata.MaxSize {
+		errData = &errortypes.ErrorData{
+			Error:   "vendor_data_size_invalid",
+			Message: "Vendor data exceeds maximum size",
+		}
+		return
+	}
+
+	vendorData, e := userdata.Render(d.VendorData, &userdata.TemplateData{})
+	if e != nil {
+		errData = &errortypes.ErrorData{
+			Error:   "vendor_data_template_invalid",
+			Message: "Vendor data template is invalid",
+		}
+		return
+	}
+
+	_, e = userdata.Parse(vendorData)
+	if e != nil {
+		errData = &errortypes.ErrorData{
+			Error:   "vendor_data_invalid",
+			Message: "Vendor data must be a cloud-config, script or MIME message",
+		}
+		return
+	}
+
 	return
 }
 
@@ -35,6 +35,21 @@ func GetDiskPath(diskId primitive.ObjectID) string {
 		fmt.Sprintf("%s.qcow2", diskId.Hex()))
 }
 
+func GetNvramPath(instId primitive.ObjectID) string {
+	return path.Join(GetDisksPath(),
+		fmt.Sprintf("%s_vars.fd", instId.Hex()))
+}
+
+func GetNvramFirmwarePath(instId primitive.ObjectID) string {
+	return path.Join(GetDisksPath(),
+		fmt.Sprintf("%s_vars.firmware", instId.Hex()))
+}
+
+func GetTpmPath(instId primitive.ObjectID) string {
+	return path.Join(GetDisksPath(),
+		fmt.Sprintf("%s_tpm", instId.Hex()))
+}
+
 func GetDiskTempPath() string {
 	return path.Join(GetTempPath(),
 		fmt.Sprintf("disk-%s", primitive.NewObjectID().Hex()))
@@ -89,6 +104,38 @@ func GetSockPath(virtId primitive.ObjectID) string {
 		fmt.Sprintf("%s.sock", virtId.Hex()))
 }
 
+func GetEventsSockPath(virtId primitive.ObjectID) string {
+	return path.Join(settings.Hypervisor.LibPath,
+		fmt.Sprintf("%s.events", virtId.Hex()))
+}
+
+func GetSerialPath(virtId primitive.ObjectID) string {
+	return path.Join(settings.Hypervisor.LibPath,
+		fmt.Sprintf("%s.serial", virtId.Hex()))
+}
+
+func GetSerialLogPath(virtId primitive.ObjectID) string {
+	return path.Join(GetVmPath(virtId), "serial.log")
+}
+
+func GetTpmUnitName(virtId primitive.ObjectID) string {
+	return fmt.Sprintf("pritunl_cloud_%s_tpm.service", virtId.Hex())
+}
+
+func GetTpmUnitPath(virtId primitive.ObjectID) string {
+	return path.Join(settings.Hypervisor.SystemdPath, GetTpmUnitName(virtId))
+}
+
+func GetTpmPidPath(virtId primitive.ObjectID) string {
+	return path.Join(settings.Hypervisor.LibPath,
+		fmt.Sprintf("%s.tpm.pid", virtId.Hex()))
+}
+
+func GetTpmSockPath(virtId primitive.ObjectID) string {
+	return path.Join(settings.Hypervisor.LibPath,
+		fmt.Sprintf("%s.tpm", virtId.Hex()))
+}
+
 func GetGuestPath(virtId primitive.ObjectID) string {
 	return path.Join(settings.Hypervisor.LibPath,
 		fmt.Sprintf("%s.guest", virtId.Hex()))
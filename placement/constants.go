@@ -0,0 +1,31 @@
+package placement
+
+import (
+	"github.com/dropbox/godropbox/container/set"
+)
+
+const (
+	Affinity     = "affinity"
+	AntiAffinity = "anti_affinity"
+
+	Node = "node"
+	Zone = "zone"
+
+	Hard = "hard"
+	Soft = "soft"
+)
+
+var (
+	ValidTypes = set.NewSet(
+		Affinity,
+		AntiAffinity,
+	)
+	ValidScopes = set.NewSet(
+		Node,
+		Zone,
+	)
+	ValidEnforcements = set.NewSet(
+		Hard,
+		Soft,
+	)
+)
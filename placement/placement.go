@@ -0,0 +1,214 @@
+package placement
+
+import (
+	"github.com/dropbox/godropbox/container/set"
+	"github.com/dropbox/godropbox/errors"
+	"github.com/pritunl/mongo-go-driver/bson"
+	"github.com/pritunl/mongo-go-driver/bson/primitive"
+	"github.com/pritunl/pritunl-cloud/database"
+	"github.com/pritunl/pritunl-cloud/errortypes"
+)
+
+type Placement struct {
+	Id           primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name         string             `bson:"name" json:"name"`
+	Comment      string             `bson:"comment" json:"comment"`
+	Organization primitive.ObjectID `bson:"organization" json:"organization"`
+	Type         string             `bson:"type" json:"type"`
+	Scope        string             `bson:"scope" json:"scope"`
+	Enforcement  string             `bson:"enforcement" json:"enforcement"`
+}
+
+type Member struct {
+	Id          primitive.ObjectID `bson:"_id"`
+	Node        primitive.ObjectID `bson:"node"`
+	MigrateNode primitive.ObjectID `bson:"migrate_node,omitempty"`
+	Zone        primitive.ObjectID `bson:"zone"`
+}
+
+func (p *Placement) Validate(db *database.Database) (
+	errData *errortypes.ErrorData, err error) {
+
+	if p.Organization.IsZero() {
+		errData = &errortypes.ErrorData{
+			Error:   "organization_required",
+			Message: "Missing required organization",
+		}
+		return
+	}
+
+	if p.Type == "" {
+		p.Type = AntiAffinity
+	}
+
+	if !ValidTypes.Contains(p.Type) {
+		errData = &errortypes.ErrorData{
+			Error:   "placement_type_invalid",
+			Message: "Invalid placement type",
+		}
+		return
+	}
+
+	if p.Scope == "" {
+		p.Scope = Node
+	}
+
+	if !ValidScopes.Contains(p.Scope) {
+		errData = &errortypes.ErrorData{
+			Error:   "placement_scope_invalid",
+			Message: "Invalid placement scope",
+		}
+		return
+	}
+
+	if p.Enforcement == "" {
+		p.Enforcement = Hard
+	}
+
+	if !ValidEnforcements.Contains(p.Enforcement) {
+		errData = &errortypes.ErrorData{
+			Error:   "placement_enforcement_invalid",
+			Message: "Invalid placement enforcement",
+		}
+		return
+	}
+
+	return
+}
+
+func (p *Placement) GetMembers(db *database.Database,
+	instId primitive.ObjectID) (members []*Member, err error) {
+
+	coll := db.Instances()
+	members = []*Member{}
+
+	cursor, err := coll.Find(db, &bson.M{
+		"_id": &bson.M{
+			"$ne": instId,
+		},
+		"placement": p.Id,
+	})
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+	defer cursor.Close(db)
+
+	for cursor.Next(db) {
+		member := &Member{}
+		err = cursor.Decode(member)
+		if err != nil {
+			err = database.ParseError(err)
+			return
+		}
+
+		members = append(members, member)
+	}
+
+	err = cursor.Err()
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
+	return
+}
+
+func (p *Placement) match(member *Member, ndeId,
+	zneId primitive.ObjectID) bool {
+
+	if p.Scope == Zone {
+		return member.Zone == zneId
+	}
+
+	return member.Node == ndeId || member.MigrateNode == ndeId
+}
+
+func (p *Placement) Satisfied(members []*Member, ndeId,
+	zneId primitive.ObjectID) bool {
+
+	for _, member := range members {
+		matched := p.match(member, ndeId, zneId)
+
+		if p.Type == Affinity && !matched {
+			return false
+		} else if p.Type == AntiAffinity && matched {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (p *Placement) Check(db *database.Database, instId, ndeId,
+	zneId primitive.ObjectID) (errData *errortypes.ErrorData, err error) {
+
+	if p.Enforcement != Hard {
+		return
+	}
+
+	members, err := p.GetMembers(db, instId)
+	if err != nil {
+		return
+	}
+
+	if !p.Satisfied(members, ndeId, zneId) {
+		if p.Type == Affinity {
+			errData = &errortypes.ErrorData{
+				Error:   "placement_unsatisfied",
+				Message: "Placement group requires instances be together",
+			}
+		} else {
+			errData = &errortypes.ErrorData{
+				Error:   "placement_unsatisfied",
+				Message: "Placement group requires instances be separate",
+			}
+		}
+		return
+	}
+
+	return
+}
+
+func (p *Placement) Commit(db *database.Database) (err error) {
+	coll := db.Placements()
+
+	err = coll.Commit(p.Id, p)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func (p *Placement) CommitFields(db *database.Database, fields set.Set) (
+	err error) {
+
+	coll := db.Placements()
+
+	err = coll.CommitFields(p.Id, p, fields)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func (p *Placement) Insert(db *database.Database) (err error) {
+	coll := db.Placements()
+
+	if !p.Id.IsZero() {
+		err = &errortypes.DatabaseError{
+			errors.New("placement: Placement already exists"),
+		}
+		return
+	}
+
+	_, err = coll.InsertOne(db, p)
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
+	return
+}
@@ -0,0 +1,280 @@
+package placement
+
+import (
+	"github.com/pritunl/mongo-go-driver/bson"
+	"github.com/pritunl/mongo-go-driver/bson/primitive"
+	"github.com/pritunl/mongo-go-driver/mongo/options"
+	"github.com/pritunl/pritunl-cloud/database"
+	"github.com/pritunl/pritunl-cloud/utils"
+)
+
+func Get(db *database.Database, plcId primitive.ObjectID) (
+	plc *Placement, err error) {
+
+	coll := db.Placements()
+	plc = &Placement{}
+
+	err = coll.FindOneId(plcId, plc)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func GetOrg(db *database.Database, orgId, plcId primitive.ObjectID) (
+	plc *Placement, err error) {
+
+	coll := db.Placements()
+	plc = &Placement{}
+
+	err = coll.FindOne(db, &bson.M{
+		"_id":          plcId,
+		"organization": orgId,
+	}).Decode(plc)
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
+	return
+}
+
+func ExistsOrg(db *database.Database, orgId, plcId primitive.ObjectID) (
+	exists bool, err error) {
+
+	coll := db.Placements()
+
+	count, err := coll.CountDocuments(db, &bson.M{
+		"_id":          plcId,
+		"organization": orgId,
+	})
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
+	if count > 0 {
+		exists = true
+	}
+
+	return
+}
+
+func GetAll(db *database.Database, query *bson.M) (
+	plcs []*Placement, err error) {
+
+	coll := db.Placements()
+	plcs = []*Placement{}
+
+	cursor, err := coll.Find(db, query)
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+	defer cursor.Close(db)
+
+	for cursor.Next(db) {
+		plc := &Placement{}
+		err = cursor.Decode(plc)
+		if err != nil {
+			err = database.ParseError(err)
+			return
+		}
+
+		plcs = append(plcs, plc)
+	}
+
+	err = cursor.Err()
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
+	return
+}
+
+func GetAllPaged(db *database.Database, query *bson.M,
+	page, pageCount int64) (plcs []*Placement, count int64, err error) {
+
+	coll := db.Placements()
+	plcs = []*Placement{}
+
+	count, err = coll.CountDocuments(db, query)
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
+	page = utils.Min64(page, count/pageCount)
+	skip := utils.Min64(page*pageCount, count)
+
+	cursor, err := coll.Find(
+		db,
+		query,
+		&options.FindOptions{
+			Sort: &bson.D{
+				{"name", 1},
+			},
+			Skip:  &skip,
+			Limit: &pageCount,
+		},
+	)
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+	defer cursor.Close(db)
+
+	for cursor.Next(db) {
+		plc := &Placement{}
+		err = cursor.Decode(plc)
+		if err != nil {
+			err = database.ParseError(err)
+			return
+		}
+
+		plcs = append(plcs, plc)
+	}
+
+	err = cursor.Err()
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
+	return
+}
+
+func clearInstances(db *database.Database, query *bson.M) (err error) {
+	coll := db.Instances()
+
+	_, err = coll.UpdateMany(db, query, &bson.M{
+		"$unset": &bson.M{
+			"placement": 1,
+		},
+	})
+	if err != nil {
+		err = database.ParseError(err)
+		switch err.(type) {
+		case *database.NotFoundError:
+			err = nil
+		default:
+			return
+		}
+	}
+
+	return
+}
+
+func Remove(db *database.Database, plcId primitive.ObjectID) (err error) {
+	coll := db.Placements()
+
+	_, err = coll.DeleteOne(db, &bson.M{
+		"_id": plcId,
+	})
+	if err != nil {
+		err = database.ParseError(err)
+		switch err.(type) {
+		case *database.NotFoundError:
+			err = nil
+		default:
+			return
+		}
+	}
+
+	err = clearInstances(db, &bson.M{
+		"placement": plcId,
+	})
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func RemoveOrg(db *database.Database, orgId, plcId primitive.ObjectID) (
+	err error) {
+
+	coll := db.Placements()
+
+	_, err = coll.DeleteOne(db, &bson.M{
+		"_id":          plcId,
+		"organization": orgId,
+	})
+	if err != nil {
+		err = database.ParseError(err)
+		switch err.(type) {
+		case *database.NotFoundError:
+			err = nil
+		default:
+			return
+		}
+	}
+
+	err = clearInstances(db, &bson.M{
+		"placement":    plcId,
+		"organization": orgId,
+	})
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func RemoveMulti(db *database.Database, plcIds []primitive.ObjectID) (
+	err error) {
+
+	coll := db.Placements()
+
+	_, err = coll.DeleteMany(db, &bson.M{
+		"_id": &bson.M{
+			"$in": plcIds,
+		},
+	})
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
+	err = clearInstances(db, &bson.M{
+		"placement": &bson.M{
+			"$in": plcIds,
+		},
+	})
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func RemoveMultiOrg(db *database.Database, orgId primitive.ObjectID,
+	plcIds []primitive.ObjectID) (err error) {
+
+	coll := db.Placements()
+
+	_, err = coll.DeleteMany(db, &bson.M{
+		"_id": &bson.M{
+			"$in": plcIds,
+		},
+		"organization": orgId,
+	})
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
+	err = clearInstances(db, &bson.M{
+		"placement": &bson.M{
+			"$in": plcIds,
+		},
+		"organization": orgId,
+	})
+	if err != nil {
+		return
+	}
+
+	return
+}
@@ -20,6 +20,14 @@ type Proxy struct {
 	lock    sync.Mutex
 }
 
+type DomainStats struct {
+	Balancer   string
+	Domain     string
+	Requests   int
+	Retries    int
+	WebSockets int
+}
+
 type balancerState struct {
 	Balancer *balancer.Balancer
 	State    *balancer.State
@@ -220,6 +228,28 @@ func (p *Proxy) Update(db *database.Database, balncs []*balancer.Balancer) (
 	return
 }
 
+func (p *Proxy) Stats() (stats []*DomainStats) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	stats = []*DomainStats{}
+	for name, dom := range p.Domains {
+		dom.WebSocketConnsLock.Lock()
+		webSockets := dom.WebSocketConns.Len()
+		dom.WebSocketConnsLock.Unlock()
+
+		stats = append(stats, &DomainStats{
+			Balancer:   dom.Balancer.Name,
+			Domain:     name,
+			Requests:   dom.RequestsTotal,
+			Retries:    dom.RetriesTotal,
+			WebSockets: webSockets,
+		})
+	}
+
+	return
+}
+
 func (p *Proxy) syncCount() {
 	p.lock.Lock()
 	defer p.lock.Unlock()
@@ -0,0 +1,466 @@
+package qemu
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/dropbox/godropbox/errors"
+	"github.com/pritunl/mongo-go-driver/bson"
+	"github.com/pritunl/pritunl-cloud/database"
+	"github.com/pritunl/pritunl-cloud/errortypes"
+	"github.com/pritunl/pritunl-cloud/interfaces"
+	"github.com/pritunl/pritunl-cloud/iproute"
+	"github.com/pritunl/pritunl-cloud/node"
+	"github.com/pritunl/pritunl-cloud/qms"
+	"github.com/pritunl/pritunl-cloud/settings"
+	"github.com/pritunl/pritunl-cloud/store"
+	"github.com/pritunl/pritunl-cloud/utils"
+	"github.com/pritunl/pritunl-cloud/vm"
+	"github.com/pritunl/pritunl-cloud/vpc"
+	"github.com/pritunl/pritunl-cloud/zone"
+)
+
+func getInternalMtu(db *database.Database) (
+	vxlan bool, mtuInternal, mtuInstance string, err error) {
+
+	zne, err := zone.Get(db, node.Self.Zone)
+	if err != nil {
+		return
+	}
+
+	if zne.NetworkMode == zone.VxlanVlan {
+		vxlan = true
+	}
+
+	if !node.Self.JumboFrames && !vxlan {
+		return
+	}
+
+	mtuSize := 0
+	if node.Self.JumboFrames {
+		mtuSize = settings.Hypervisor.JumboMtu
+	} else {
+		mtuSize = settings.Hypervisor.NormalMtu
+	}
+
+	if vxlan {
+		mtuSize -= 50
+	}
+	mtuInternal = strconv.Itoa(mtuSize)
+
+	if vxlan {
+		mtuSize -= 4
+	}
+	mtuInstance = strconv.Itoa(mtuSize)
+
+	return
+}
+
+func networkConfAdapter(db *database.Database, virt *vm.VirtualMachine,
+	n int) (err error) {
+
+	iface := vm.GetIface(virt.Id, n)
+	ifaceInternalVirt := vm.GetIfaceVirtAdapter(virt.Id, n)
+	ifaceInternal := vm.GetIfaceInternal(virt.Id, n)
+	ifaceVlan := vm.GetIfaceVlan(virt.Id, n)
+	namespace := vm.GetNamespace(virt.Id, n)
+	adapter := virt.NetworkAdapters[n]
+
+	vxlan, mtuInternal, mtuInstance, err := getInternalMtu(db)
+	if err != nil {
+		return
+	}
+
+	vc, err := vpc.Get(db, adapter.Vpc)
+	if err != nil {
+		return
+	}
+
+	vcNet, err := vc.GetNetwork()
+	if err != nil {
+		return
+	}
+
+	_, gatewayAddr, err := vc.GetIp(db, adapter.Subnet, virt.Id)
+	if err != nil {
+		return
+	}
+
+	gatewayAddr6 := vc.GetIp6(gatewayAddr)
+
+	cidr, _ := vcNet.Mask.Size()
+	gatewayCidr := fmt.Sprintf("%s/%d", gatewayAddr.String(), cidr)
+
+	_, err = utils.ExecCombinedOutputLogged(
+		[]string{"File exists"},
+		"ip", "netns",
+		"add", namespace,
+	)
+	if err != nil {
+		return
+	}
+
+	_, _ = utils.ExecCombinedOutput(
+		"", "ip", "link", "set", ifaceInternalVirt, "down")
+	_, _ = utils.ExecCombinedOutput(
+		"", "ip", "link", "del", ifaceInternalVirt)
+
+	interfaces.RemoveVirtIface(ifaceInternalVirt)
+
+	_, err = utils.ExecCombinedOutputLogged(
+		nil,
+		"ip", "link",
+		"add", ifaceInternalVirt,
+		"type", "veth",
+		"peer", "name", ifaceInternal,
+		"addr", vm.GetMacAddrInternal(virt.Id, vc.Id),
+	)
+	if err != nil {
+		return
+	}
+
+	if mtuInternal != "" {
+		_, err = utils.ExecCombinedOutputLogged(
+			nil,
+			"ip", "link",
+			"set", "dev", ifaceInternalVirt,
+			"mtu", mtuInternal,
+		)
+		if err != nil {
+			return
+		}
+
+		_, err = utils.ExecCombinedOutputLogged(
+			nil,
+			"ip", "link",
+			"set", "dev", ifaceInternal,
+			"mtu", mtuInternal,
+		)
+		if err != nil {
+			return
+		}
+	}
+
+	_, err = utils.ExecCombinedOutputLogged(
+		nil,
+		"ip", "link",
+		"set", "dev", ifaceInternalVirt, "up",
+	)
+	if err != nil {
+		return
+	}
+
+	internalIface := interfaces.GetInternal(ifaceInternalVirt, vxlan)
+	if internalIface == "" {
+		err = &errortypes.NotFoundError{
+			errors.New("qemu: Failed to get internal interface"),
+		}
+		return
+	}
+
+	_, err = utils.ExecCombinedOutputLogged(
+		nil,
+		"ip", "link", "set",
+		ifaceInternalVirt, "master", internalIface,
+	)
+	if err != nil {
+		return
+	}
+
+	_, err = utils.ExecCombinedOutputLogged(
+		[]string{"File exists"},
+		"ip", "link",
+		"set", "dev", ifaceInternal,
+		"netns", namespace,
+	)
+	if err != nil {
+		return
+	}
+
+	_, err = utils.ExecCombinedOutputLogged(
+		[]string{"File exists"},
+		"ip", "link",
+		"set", "dev", iface,
+		"netns", namespace,
+	)
+	if err != nil {
+		return
+	}
+
+	_, err = utils.ExecCombinedOutputLogged(
+		nil,
+		"ip", "netns", "exec", namespace,
+		"sysctl", "-w", "net.ipv6.conf.all.accept_ra=0",
+	)
+	if err != nil {
+		return
+	}
+
+	_, err = utils.ExecCombinedOutputLogged(
+		nil,
+		"ip", "netns", "exec", namespace,
+		"sysctl", "-w", "net.ipv6.conf.default.accept_ra=0",
+	)
+	if err != nil {
+		return
+	}
+
+	_, err = utils.ExecCombinedOutputLogged(
+		nil,
+		"ip", "netns", "exec", namespace,
+		"ip", "link",
+		"set", "dev", "lo", "up",
+	)
+	if err != nil {
+		return
+	}
+
+	_, err = utils.ExecCombinedOutputLogged(
+		nil,
+		"ip", "netns", "exec", namespace,
+		"ip", "link",
+		"set", "dev", ifaceInternal, "up",
+	)
+	if err != nil {
+		return
+	}
+
+	if mtuInstance != "" {
+		_, err = utils.ExecCombinedOutputLogged(
+			nil,
+			"ip", "netns", "exec", namespace,
+			"ip", "link",
+			"set", "dev", iface,
+			"mtu", mtuInstance,
+		)
+		if err != nil {
+			return
+		}
+	}
+
+	_, err = utils.ExecCombinedOutputLogged(
+		nil,
+		"ip", "netns", "exec", namespace,
+		"ip", "link",
+		"set", "dev", iface, "up",
+	)
+	if err != nil {
+		return
+	}
+
+	err = networkLimits(namespace, iface,
+		virt.NetworkRateIn, virt.NetworkRateOut)
+	if err != nil {
+		return
+	}
+
+	_, err = utils.ExecCombinedOutputLogged(
+		[]string{"File exists"},
+		"ip", "netns", "exec", namespace,
+		"ip", "link",
+		"add", "link", ifaceInternal,
+		"name", ifaceVlan,
+		"type", "vlan",
+		"id", strconv.Itoa(vc.VpcId),
+	)
+	if err != nil {
+		return
+	}
+
+	if mtuInternal != "" {
+		_, err = utils.ExecCombinedOutputLogged(
+			nil,
+			"ip", "netns", "exec", namespace,
+			"ip", "link",
+			"set", "dev", ifaceVlan,
+			"mtu", mtuInternal,
+		)
+		if err != nil {
+			return
+		}
+	}
+
+	_, err = utils.ExecCombinedOutputLogged(
+		nil,
+		"ip", "netns", "exec", namespace,
+		"ip", "link",
+		"set", "dev", ifaceVlan, "up",
+	)
+	if err != nil {
+		return
+	}
+
+	err = iproute.BridgeAdd(namespace, "br0")
+	if err != nil {
+		return
+	}
+
+	_, err = utils.ExecCombinedOutputLogged(
+		nil,
+		"ip", "netns", "exec", namespace,
+		"ip", "link", "set",
+		ifaceVlan, "master", "br0",
+	)
+	if err != nil {
+		return
+	}
+
+	_, err = utils.ExecCombinedOutputLogged(
+		nil,
+		"ip", "netns", "exec", namespace,
+		"ip", "link", "set",
+		iface, "master", "br0",
+	)
+	if err != nil {
+		return
+	}
+
+	_, err = utils.ExecCombinedOutputLogged(
+		[]string{"File exists"},
+		"ip", "netns", "exec", namespace,
+		"ip", "addr",
+		"add", gatewayCidr,
+		"dev", "br0",
+	)
+	if err != nil {
+		return
+	}
+
+	_, err = utils.ExecCombinedOutputLogged(
+		[]string{"File exists"},
+		"ip", "netns", "exec", namespace,
+		"ip", "-6", "addr",
+		"add", gatewayAddr6.String()+"/64",
+		"dev", "br0",
+	)
+	if err != nil {
+		return
+	}
+
+	_, err = utils.ExecCombinedOutputLogged(
+		nil,
+		"ip", "netns", "exec", namespace,
+		"ip", "link",
+		"set", "dev", "br0", "up",
+	)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func networkConfAdapterClear(virt *vm.VirtualMachine, n int) {
+	ifaceInternalVirt := vm.GetIfaceVirtAdapter(virt.Id, n)
+
+	_, _ = utils.ExecCombinedOutput(
+		"", "ip", "link", "set", ifaceInternalVirt, "down")
+	_, _ = utils.ExecCombinedOutput(
+		"", "ip", "link", "del", ifaceInternalVirt)
+
+	interfaces.RemoveVirtIface(ifaceInternalVirt)
+
+	_, _ = utils.ExecCombinedOutput(
+		"", "ip", "netns", "del", vm.GetNamespace(virt.Id, n))
+}
+
+func commitPrivateIps(db *database.Database, virt *vm.VirtualMachine) (
+	err error) {
+
+	addrs := []string{}
+	addrs6 := []string{}
+
+	for _, adapter := range virt.NetworkAdapters {
+		vc, e := vpc.Get(db, adapter.Vpc)
+		if e != nil {
+			err = e
+			return
+		}
+
+		addr, _, e := vc.GetIp(db, adapter.Subnet, virt.Id)
+		if e != nil {
+			err = e
+			return
+		}
+
+		addrs = append(addrs, addr.String())
+		addrs6 = append(addrs6, vc.GetIp6(addr).String())
+	}
+
+	coll := db.Instances()
+	err = coll.UpdateId(virt.Id, &bson.M{
+		"$set": &bson.M{
+			"private_ips":  addrs,
+			"private_ips6": addrs6,
+		},
+	})
+	if err != nil {
+		err = database.ParseError(err)
+		if _, ok := err.(*database.NotFoundError); ok {
+			err = nil
+		} else {
+			return
+		}
+	}
+
+	return
+}
+
+func UpdateAdapters(db *database.Database, virt, curVirt *vm.VirtualMachine,
+	addAdapters, remAdapters []int) (err error) {
+
+	for _, n := range remAdapters {
+		logrus.WithFields(logrus.Fields{
+			"id":    virt.Id.Hex(),
+			"index": n,
+		}).Info("qemu: Removing virtual machine network adapter")
+
+		err = qms.RemoveNetwork(virt.Id, n)
+		if err != nil {
+			return
+		}
+
+		networkConfAdapterClear(curVirt, n)
+	}
+
+	for _, n := range addAdapters {
+		logrus.WithFields(logrus.Fields{
+			"id":    virt.Id.Hex(),
+			"index": n,
+		}).Info("qemu: Adding virtual machine network adapter")
+
+		err = qms.AddNetwork(virt.Id, n, vm.GetIface(virt.Id, n),
+			virt.NetworkAdapters[n].MacAddress)
+		if err != nil {
+			return
+		}
+
+		err = networkConfAdapter(db, virt, n)
+		if err != nil {
+			return
+		}
+	}
+
+	err = commitPrivateIps(db, virt)
+	if err != nil {
+		return
+	}
+
+	virt.Processors = curVirt.Processors
+	virt.Memory = curVirt.Memory
+	virt.PinnedCpus = curVirt.PinnedCpus
+	virt.SharedCpus = curVirt.SharedCpus
+	virt.NumaNode = curVirt.NumaNode
+	virt.Hotplugged = true
+
+	err = writeService(virt)
+	if err != nil {
+		return
+	}
+
+	store.RemVirt(virt.Id)
+	store.RemAddress(virt.Id)
+
+	return
+}
@@ -1,13 +1,27 @@
 package qemu
 
+const memorySlots = 16
+
 const systemdTemplate = `# PritunlData=%s
 
 [Unit]
 Description=Pritunl Cloud Virtual Machine
 After=network.target
-
+%s
 [Service]
 Type=simple
 User=root
-ExecStart=%s
+%sExecStart=%s
+`
+
+const tpmSystemdTemplate = `[Unit]
+Description=Pritunl Cloud Virtual Machine TPM
+After=network.target
+PartOf=%s
+
+[Service]
+Type=forking
+User=root
+PIDFile=%s
+ExecStart=/usr/bin/swtpm socket --tpm2 --tpmstate dir=%s,mode=0600 --ctrl type=unixio,path=%s --pid file=%s --terminate --daemon
 `
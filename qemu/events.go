@@ -0,0 +1,125 @@
+package qemu
+
+import (
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/pritunl/mongo-go-driver/bson/primitive"
+	"github.com/pritunl/pritunl-cloud/database"
+	"github.com/pritunl/pritunl-cloud/event"
+	"github.com/pritunl/pritunl-cloud/instance"
+	"github.com/pritunl/pritunl-cloud/node"
+	"github.com/pritunl/pritunl-cloud/qms"
+	"github.com/pritunl/pritunl-cloud/settings"
+	"github.com/pritunl/pritunl-cloud/store"
+	"github.com/pritunl/pritunl-cloud/vm"
+)
+
+func syncState(vmId primitive.ObjectID, stopped bool) (err error) {
+	store.RemVirt(vmId)
+
+	virt, err := GetVmInfo(vmId, false, true)
+	if err != nil || virt == nil {
+		return
+	}
+
+	if stopped && virt.State != vm.Stopped && virt.State != vm.Failed {
+		return
+	}
+
+	db := database.GetDatabase()
+	defer db.Close()
+
+	inst, err := instance.Get(db, vmId)
+	if err != nil {
+		if _, ok := err.(*database.NotFoundError); ok {
+			err = nil
+		}
+		return
+	}
+
+	if inst.Node != node.Self.Id {
+		return
+	}
+
+	if inst.State == instance.Start &&
+		(virt.State == vm.Stopped || virt.State == vm.Failed) {
+
+		err = virt.CommitState(db, instance.Cleanup)
+	} else {
+		err = virt.Commit(db)
+	}
+	if err != nil {
+		return
+	}
+
+	event.PublishDispatch(db, "instance.change")
+
+	return
+}
+
+func handleShutdown(vmId primitive.ObjectID, evt *qms.Event) {
+	guest, _ := evt.Data["guest"].(bool)
+
+	logrus.WithFields(logrus.Fields{
+		"instance_id": vmId.Hex(),
+		"guest":       guest,
+		"reason":      evt.GetString("reason"),
+	}).Info("qemu: Virtual machine shutdown")
+
+	if !guest {
+		return
+	}
+
+	go func() {
+		for i := 0; i < settings.Hypervisor.StopTimeout; i++ {
+			time.Sleep(1 * time.Second)
+
+			virt, err := GetVmInfo(vmId, false, true)
+			if err != nil || virt == nil {
+				return
+			}
+
+			if virt.State != vm.Stopped && virt.State != vm.Failed {
+				continue
+			}
+
+			err = syncState(vmId, true)
+			if err != nil {
+				logrus.WithFields(logrus.Fields{
+					"instance_id": vmId.Hex(),
+					"error":       err,
+				}).Error("qemu: Failed to update instance after shutdown")
+			}
+
+			return
+		}
+	}()
+}
+
+func handleReset(vmId primitive.ObjectID, evt *qms.Event) {
+	guest, _ := evt.Data["guest"].(bool)
+	if !guest {
+		return
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"instance_id": vmId.Hex(),
+		"reason":      evt.GetString("reason"),
+	}).Info("qemu: Virtual machine reboot")
+
+	go func() {
+		err := syncState(vmId, false)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"instance_id": vmId.Hex(),
+				"error":       err,
+			}).Error("qemu: Failed to update instance after reboot")
+		}
+	}()
+}
+
+func init() {
+	qms.RegisterHandler("SHUTDOWN", handleShutdown)
+	qms.RegisterHandler("RESET", handleReset)
+}
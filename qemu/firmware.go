@@ -0,0 +1,149 @@
+package qemu
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/dropbox/godropbox/errors"
+	"github.com/pritunl/pritunl-cloud/database"
+	"github.com/pritunl/pritunl-cloud/errortypes"
+	"github.com/pritunl/pritunl-cloud/instance"
+	"github.com/pritunl/pritunl-cloud/node"
+	"github.com/pritunl/pritunl-cloud/paths"
+	"github.com/pritunl/pritunl-cloud/settings"
+	"github.com/pritunl/pritunl-cloud/utils"
+	"github.com/pritunl/pritunl-cloud/vm"
+)
+
+func writeNvram(virt *vm.VirtualMachine) (err error) {
+	nvramPath := paths.GetNvramPath(virt.Id)
+	firmwarePath := paths.GetNvramFirmwarePath(virt.Id)
+
+	if !virt.IsUefi() {
+		err = utils.RemoveAll(nvramPath)
+		if err != nil {
+			return
+		}
+
+		err = utils.RemoveAll(firmwarePath)
+		if err != nil {
+			return
+		}
+
+		return
+	}
+
+	firmware := virt.GetFirmware()
+
+	exists, err := utils.Exists(nvramPath)
+	if err != nil {
+		return
+	}
+
+	if exists {
+		curFirmware, e := ioutil.ReadFile(firmwarePath)
+		if e != nil {
+			if !os.IsNotExist(e) {
+				err = &errortypes.ReadError{
+					errors.Wrap(e, "qemu: Failed to read nvram firmware"),
+				}
+				return
+			}
+
+			err = writeNvramFirmware(virt)
+			if err != nil {
+				return
+			}
+
+			return
+		}
+
+		if string(curFirmware) == firmware {
+			return
+		}
+	}
+
+	varsPath := settings.Hypervisor.OvmfVarsPath
+	if firmware == vm.UefiSecure {
+		varsPath = settings.Hypervisor.OvmfSecureVarsPath
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"id":         virt.Id.Hex(),
+		"nvram_path": nvramPath,
+		"vars_path":  varsPath,
+	}).Info("qemu: Creating virtual machine nvram")
+
+	err = utils.ExistsMkdir(paths.GetDisksPath(), 0755)
+	if err != nil {
+		return
+	}
+
+	err = utils.Exec("", "cp", "-f", varsPath, nvramPath)
+	if err != nil {
+		return
+	}
+
+	err = utils.Chmod(nvramPath, 0600)
+	if err != nil {
+		return
+	}
+
+	err = writeNvramFirmware(virt)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func writeNvramFirmware(virt *vm.VirtualMachine) (err error) {
+	err = utils.CreateWrite(paths.GetNvramFirmwarePath(virt.Id),
+		virt.GetFirmware(), 0600)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func writeMigrateNvram(virt *vm.VirtualMachine, nvram []byte) (err error) {
+	nvramPath := paths.GetNvramPath(virt.Id)
+
+	err = utils.ExistsMkdir(paths.GetDisksPath(), 0755)
+	if err != nil {
+		return
+	}
+
+	err = utils.CreateWrite(nvramPath, string(nvram), 0600)
+	if err != nil {
+		return
+	}
+
+	err = writeNvramFirmware(virt)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func MigrateNvram(db *database.Database, virt *vm.VirtualMachine) (
+	err error) {
+
+	nvram, err := ioutil.ReadFile(paths.GetNvramPath(virt.Id))
+	if err != nil {
+		err = &errortypes.ReadError{
+			errors.Wrap(err, "qemu: Failed to read nvram"),
+		}
+		return
+	}
+
+	err = instance.SetMigrateNvram(db, virt.Id, node.Self.Id, nvram)
+	if err != nil {
+		return
+	}
+
+	return
+}
@@ -0,0 +1,47 @@
+package qemu
+
+import (
+	"github.com/Sirupsen/logrus"
+	"github.com/pritunl/pritunl-cloud/qms"
+	"github.com/pritunl/pritunl-cloud/store"
+	"github.com/pritunl/pritunl-cloud/vm"
+)
+
+func Resize(virt, curVirt *vm.VirtualMachine) (err error) {
+
+	logrus.WithFields(logrus.Fields{
+		"id":         virt.Id.Hex(),
+		"processors": virt.Processors,
+		"memory":     virt.Memory,
+	}).Info("qemu: Resizing virtual machine")
+
+	if virt.Processors > curVirt.Processors {
+		err = qms.AddCpus(virt.Id, virt.Processors)
+		if err != nil {
+			return
+		}
+	}
+
+	if virt.Memory > curVirt.Memory {
+		err = qms.AddMemory(virt.Id, virt.Memory-curVirt.Memory,
+			curVirt.Hugepages, curVirt.NumaLocal, curVirt.NumaNode)
+		if err != nil {
+			return
+		}
+	}
+
+	virt.NetworkAdapters = curVirt.NetworkAdapters
+	virt.PinnedCpus = curVirt.PinnedCpus
+	virt.SharedCpus = curVirt.SharedCpus
+	virt.NumaNode = curVirt.NumaNode
+	virt.Hotplugged = true
+
+	err = writeService(virt)
+	if err != nil {
+		return
+	}
+
+	store.RemVirt(virt.Id)
+
+	return
+}
@@ -0,0 +1,108 @@
+package qemu
+
+import (
+	"fmt"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/pritunl/pritunl-cloud/store"
+	"github.com/pritunl/pritunl-cloud/utils"
+	"github.com/pritunl/pritunl-cloud/vm"
+)
+
+func networkBurst(rate int) string {
+	burst := rate * 1000000 / 8 / 100 / 1024
+	if burst < 32 {
+		burst = 32
+	}
+	return fmt.Sprintf("%dk", burst)
+}
+
+func networkLimits(namespace, iface string, rateIn, rateOut int) (
+	err error) {
+
+	_, _ = utils.ExecCombinedOutput("",
+		"ip", "netns", "exec", namespace,
+		"tc", "qdisc", "del", "dev", iface, "root",
+	)
+	_, _ = utils.ExecCombinedOutput("",
+		"ip", "netns", "exec", namespace,
+		"tc", "qdisc", "del", "dev", iface, "ingress",
+	)
+
+	if rateIn > 0 {
+		_, err = utils.ExecCombinedOutputLogged(
+			nil,
+			"ip", "netns", "exec", namespace,
+			"tc", "qdisc", "add", "dev", iface,
+			"root", "tbf",
+			"rate", fmt.Sprintf("%dmbit", rateIn),
+			"burst", networkBurst(rateIn),
+			"latency", "50ms",
+		)
+		if err != nil {
+			return
+		}
+	}
+
+	if rateOut > 0 {
+		_, err = utils.ExecCombinedOutputLogged(
+			nil,
+			"ip", "netns", "exec", namespace,
+			"tc", "qdisc", "add", "dev", iface,
+			"handle", "ffff:", "ingress",
+		)
+		if err != nil {
+			return
+		}
+
+		_, err = utils.ExecCombinedOutputLogged(
+			nil,
+			"ip", "netns", "exec", namespace,
+			"tc", "filter", "add", "dev", iface,
+			"parent", "ffff:", "protocol", "all",
+			"u32", "match", "u32", "0", "0",
+			"police",
+			"rate", fmt.Sprintf("%dmbit", rateOut),
+			"burst", networkBurst(rateOut),
+			"drop", "flowid", ":1",
+		)
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func UpdateNetworkLimits(virt, curVirt *vm.VirtualMachine) (err error) {
+	logrus.WithFields(logrus.Fields{
+		"id":               virt.Id.Hex(),
+		"network_rate_in":  virt.NetworkRateIn,
+		"network_rate_out": virt.NetworkRateOut,
+	}).Info("qemu: Updating virtual machine network limits")
+
+	for n := range curVirt.NetworkAdapters {
+		err = networkLimits(vm.GetNamespace(virt.Id, n),
+			vm.GetIface(virt.Id, n), virt.NetworkRateIn, virt.NetworkRateOut)
+		if err != nil {
+			return
+		}
+	}
+
+	virt.Processors = curVirt.Processors
+	virt.Memory = curVirt.Memory
+	virt.NetworkAdapters = curVirt.NetworkAdapters
+	virt.PinnedCpus = curVirt.PinnedCpus
+	virt.SharedCpus = curVirt.SharedCpus
+	virt.NumaNode = curVirt.NumaNode
+	virt.Hotplugged = curVirt.Hotplugged
+
+	err = writeService(virt)
+	if err != nil {
+		return
+	}
+
+	store.RemVirt(virt.Id)
+
+	return
+}
@@ -297,8 +297,16 @@ func GetVms(db *database.Database,
 
 			if virt != nil {
 				inst := instMap[vmId]
-				if inst != nil && inst.VmState == vm.Running &&
-					(virt.State == vm.Stopped || virt.State == vm.Failed) {
+				if inst == nil || inst.Node != node.Self.Id {
+					virtsLock.Lock()
+					virts = append(virts, virt)
+					virtsLock.Unlock()
+					return
+				}
+
+				if inst.VmState == vm.Running &&
+					(virt.State == vm.Stopped || virt.State == vm.Failed) &&
+					!store.IsFenced(vmId) {
 
 					inst.State = instance.Cleanup
 					e = virt.CommitState(db, instance.Cleanup)
@@ -1097,6 +1105,12 @@ func NetworkConf(db *database.Database,
 		return
 	}
 
+	err = networkLimits(namespace, iface,
+		virt.NetworkRateIn, virt.NetworkRateOut)
+	if err != nil {
+		return
+	}
+
 	_, err = utils.ExecCombinedOutputLogged(
 		[]string{"File exists"},
 		"ip", "netns", "exec", namespace,
@@ -1558,6 +1572,13 @@ func NetworkConf(db *database.Database,
 		}
 	}
 
+	for n := 1; n < len(virt.NetworkAdapters); n++ {
+		err = networkConfAdapter(db, virt, n)
+		if err != nil {
+			return
+		}
+	}
+
 	store.RemAddress(virt.Id)
 	store.RemRoutes(virt.Id)
 
@@ -1569,9 +1590,7 @@ func NetworkConf(db *database.Database,
 	coll := db.Instances()
 	err = coll.UpdateId(virt.Id, &bson.M{
 		"$set": &bson.M{
-			"private_ips":  []string{addr.String()},
-			"private_ips6": []string{addr6.String()},
-			"host_ips":     hostIps,
+			"host_ips": hostIps,
 		},
 	})
 	if err != nil {
@@ -1583,6 +1602,11 @@ func NetworkConf(db *database.Database,
 		}
 	}
 
+	err = commitPrivateIps(db, virt)
+	if err != nil {
+		return
+	}
+
 	return
 }
 
@@ -1655,6 +1679,10 @@ func NetworkConfClear(db *database.Database,
 	interfaces.RemoveVirtIface(ifaceExternalVirt6)
 	interfaces.RemoveVirtIface(ifaceInternalVirt)
 
+	for n := 1; n < len(virt.NetworkAdapters); n++ {
+		networkConfAdapterClear(virt, n)
+	}
+
 	store.RemAddress(virt.Id)
 	store.RemRoutes(virt.Id)
 
@@ -1674,6 +1702,11 @@ func writeService(virt *vm.VirtualMachine) (err error) {
 		return
 	}
 
+	err = writeTpmService(virt)
+	if err != nil {
+		return
+	}
+
 	err = utils.CreateWrite(unitPath, output, 0644)
 	if err != nil {
 		return
@@ -1766,6 +1799,16 @@ func Create(db *database.Database, inst *instance.Instance,
 		return
 	}
 
+	err = writeNvram(virt)
+	if err != nil {
+		return
+	}
+
+	err = writeTpm(virt)
+	if err != nil {
+		return
+	}
+
 	err = writeService(virt)
 	if err != nil {
 		return
@@ -1787,6 +1830,11 @@ func Create(db *database.Database, inst *instance.Instance,
 		return
 	}
 
+	err = pinCpus(virt)
+	if err != nil {
+		return
+	}
+
 	if virt.Vnc {
 		err = qms.VncPassword(virt.Id, inst.VncPassword)
 		if err != nil {
@@ -1810,6 +1858,7 @@ func Destroy(db *database.Database, virt *vm.VirtualMachine) (err error) {
 	unitName := paths.GetUnitName(virt.Id)
 	unitPath := paths.GetUnitPath(virt.Id)
 	sockPath := paths.GetSockPath(virt.Id)
+	eventsSockPath := paths.GetEventsSockPath(virt.Id)
 	guestPath := paths.GetGuestPath(virt.Id)
 	pidPath := paths.GetPidPath(virt.Id)
 
@@ -1944,16 +1993,51 @@ func Destroy(db *database.Database, virt *vm.VirtualMachine) (err error) {
 		return
 	}
 
+	err = removeTpmService(virt)
+	if err != nil {
+		return
+	}
+
 	err = utils.RemoveAll(sockPath)
 	if err != nil {
 		return
 	}
 
+	err = utils.RemoveAll(eventsSockPath)
+	if err != nil {
+		return
+	}
+
 	err = utils.RemoveAll(guestPath)
 	if err != nil {
 		return
 	}
 
+	err = utils.RemoveAll(paths.GetSerialPath(virt.Id))
+	if err != nil {
+		return
+	}
+
+	err = utils.RemoveAll(paths.GetNvramPath(virt.Id))
+	if err != nil {
+		return
+	}
+
+	err = utils.RemoveAll(paths.GetNvramFirmwarePath(virt.Id))
+	if err != nil {
+		return
+	}
+
+	err = utils.RemoveAll(paths.GetTpmPath(virt.Id))
+	if err != nil {
+		return
+	}
+
+	err = utils.RemoveAll(paths.GetTpmSockPath(virt.Id))
+	if err != nil {
+		return
+	}
+
 	err = utils.RemoveAll(pidPath)
 	if err != nil {
 		return
@@ -1995,6 +2079,21 @@ func PowerOn(db *database.Database, inst *instance.Instance,
 		return
 	}
 
+	err = writeNvram(virt)
+	if err != nil {
+		return
+	}
+
+	err = writeTpm(virt)
+	if err != nil {
+		return
+	}
+
+	err = rotateSerialLog(virt)
+	if err != nil {
+		return
+	}
+
 	err = writeService(virt)
 	if err != nil {
 		return
@@ -2010,6 +2109,11 @@ func PowerOn(db *database.Database, inst *instance.Instance,
 		return
 	}
 
+	err = pinCpus(virt)
+	if err != nil {
+		return
+	}
+
 	if virt.Vnc {
 		err = qms.VncPassword(virt.Id, inst.VncPassword)
 		if err != nil {
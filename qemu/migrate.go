@@ -0,0 +1,472 @@
+package qemu
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/dropbox/godropbox/errors"
+	"github.com/pritunl/pritunl-cloud/cloudinit"
+	"github.com/pritunl/pritunl-cloud/database"
+	"github.com/pritunl/pritunl-cloud/errortypes"
+	"github.com/pritunl/pritunl-cloud/instance"
+	"github.com/pritunl/pritunl-cloud/node"
+	"github.com/pritunl/pritunl-cloud/paths"
+	"github.com/pritunl/pritunl-cloud/qms"
+	"github.com/pritunl/pritunl-cloud/settings"
+	"github.com/pritunl/pritunl-cloud/store"
+	"github.com/pritunl/pritunl-cloud/systemd"
+	"github.com/pritunl/pritunl-cloud/utils"
+	"github.com/pritunl/pritunl-cloud/vm"
+)
+
+func getMigrateAddr() (addr string, err error) {
+	if node.Self.PrivateIps != nil {
+		for _, iface := range node.Self.InternalInterfaces {
+			addr = node.Self.PrivateIps[iface]
+			if addr != "" {
+				return
+			}
+		}
+	}
+
+	err = &errortypes.NotFoundError{
+		errors.New("qemu: Missing private IP for internal interface"),
+	}
+	return
+}
+
+func getMigratePort(addr string) (port int, err error) {
+	for i := settings.Hypervisor.MigratePortMin; i <=
+		settings.Hypervisor.MigratePortMax; i++ {
+
+		listener, e := net.Listen("tcp", fmt.Sprintf("%s:%d", addr, i))
+		if e != nil {
+			continue
+		}
+		listener.Close()
+
+		port = i
+		return
+	}
+
+	err = &errortypes.NotFoundError{
+		errors.New("qemu: No available migration port"),
+	}
+	return
+}
+
+type imageInfo struct {
+	VirtualSize int64 `json:"virtual-size"`
+}
+
+func getImageSize(pth string) (size int64, err error) {
+	output, err := utils.ExecOutput("", "qemu-img", "info",
+		"--force-share", "--output=json", pth)
+	if err != nil {
+		return
+	}
+
+	info := &imageInfo{}
+	err = json.Unmarshal([]byte(output), info)
+	if err != nil {
+		err = &errortypes.ParseError{
+			errors.Wrap(err, "qemu: Failed to parse image info"),
+		}
+		return
+	}
+
+	if info.VirtualSize == 0 {
+		err = &errortypes.ParseError{
+			errors.New("qemu: Missing image virtual size"),
+		}
+		return
+	}
+
+	size = info.VirtualSize
+
+	return
+}
+
+func MigrateDiskSizes(db *database.Database, virt *vm.VirtualMachine) (
+	err error) {
+
+	sizes := map[string]int64{}
+
+	for _, virtDsk := range virt.Disks {
+		size, e := getImageSize(virtDsk.Path)
+		if e != nil {
+			err = e
+			return
+		}
+
+		sizes[virtDsk.GetId().Hex()] = size
+	}
+
+	err = instance.SetMigrateDiskSizes(db, virt.Id, node.Self.Id, sizes)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func MigrateIncoming(db *database.Database, inst *instance.Instance,
+	virt *vm.VirtualMachine) (addr string, port, nbdPort int, err error) {
+
+	vmPath := paths.GetVmPath(virt.Id)
+	unitName := paths.GetUnitName(virt.Id)
+	unitPath := paths.GetUnitPath(virt.Id)
+
+	logrus.WithFields(logrus.Fields{
+		"id": virt.Id.Hex(),
+	}).Info("qemu: Creating incoming migration virtual machine")
+
+	addr, err = getMigrateAddr()
+	if err != nil {
+		return
+	}
+
+	port, err = getMigratePort(addr)
+	if err != nil {
+		return
+	}
+
+	err = utils.ExistsMkdir(settings.Hypervisor.LibPath, 0755)
+	if err != nil {
+		return
+	}
+
+	err = utils.ExistsMkdir(vmPath, 0755)
+	if err != nil {
+		return
+	}
+
+	err = utils.ExistsMkdir(paths.GetDisksPath(), 0755)
+	if err != nil {
+		return
+	}
+
+	indexes := []int{}
+	for _, virtDsk := range virt.Disks {
+		size := inst.MigrateDiskSizes[virtDsk.GetId().Hex()]
+		if size == 0 {
+			err = &errortypes.NotFoundError{
+				errors.New("qemu: Missing migration disk size"),
+			}
+			return
+		}
+
+		err = utils.Exec("", "qemu-img", "create",
+			"-f", "qcow2", virtDsk.Path, strconv.FormatInt(size, 10))
+		if err != nil {
+			return
+		}
+
+		err = utils.Chmod(virtDsk.Path, 0600)
+		if err != nil {
+			return
+		}
+
+		indexes = append(indexes, virtDsk.Index)
+	}
+
+	err = cloudinit.Write(db, inst, virt, false)
+	if err != nil {
+		return
+	}
+
+	if virt.IsUefi() && inst.MigrateNvram != nil {
+		err = writeMigrateNvram(virt, inst.MigrateNvram)
+	} else {
+		err = writeNvram(virt)
+	}
+	if err != nil {
+		return
+	}
+
+	err = writeTpm(virt)
+	if err != nil {
+		return
+	}
+
+	err = writeMigrateSerialLog(virt, inst.MigrateSerialLog)
+	if err != nil {
+		return
+	}
+
+	qm, err := NewQemu(virt)
+	if err != nil {
+		return
+	}
+	qm.Incoming = fmt.Sprintf("tcp:%s:%d", addr, port)
+
+	output, err := qm.Marshal()
+	if err != nil {
+		return
+	}
+
+	err = writeTpmService(virt)
+	if err != nil {
+		return
+	}
+
+	err = utils.CreateWrite(unitPath, output, 0644)
+	if err != nil {
+		return
+	}
+
+	err = systemd.Reload()
+	if err != nil {
+		return
+	}
+
+	err = systemd.Start(unitName)
+	if err != nil {
+		return
+	}
+
+	err = Wait(db, virt)
+	if err != nil {
+		return
+	}
+
+	err = pinCpus(virt)
+	if err != nil {
+		return
+	}
+
+	nbdPort, err = getMigratePort(addr)
+	if err != nil {
+		return
+	}
+
+	err = qms.StartMigrateExport(virt.Id, addr, nbdPort, indexes)
+	if err != nil {
+		return
+	}
+
+	if virt.Vnc {
+		err = qms.VncPassword(virt.Id, inst.VncPassword)
+		if err != nil {
+			return
+		}
+	}
+
+	store.RemVirt(virt.Id)
+	store.RemDisks(virt.Id)
+
+	return
+}
+
+func Migrate(db *database.Database, inst *instance.Instance,
+	virt *vm.VirtualMachine) (err error) {
+
+	logrus.WithFields(logrus.Fields{
+		"id":           virt.Id.Hex(),
+		"migrate_node": inst.MigrateNode.Hex(),
+	}).Info("qemu: Migrating virtual machine")
+
+	curVirt, err := GetVmInfo(virt.Id, false, true)
+	if err != nil {
+		return
+	}
+
+	// Hot plugged devices are not in the command line of the destination
+	if curVirt != nil && curVirt.Hotplugged {
+		err = &errortypes.ExecError{
+			errors.New("qemu: Virtual machine with hot plugged " +
+				"resources must be restarted before migration"),
+		}
+		return
+	}
+
+	indexes := []int{}
+	for _, virtDsk := range virt.Disks {
+		indexes = append(indexes, virtDsk.Index)
+	}
+
+	start := time.Now()
+	timeout := time.Duration(
+		settings.Hypervisor.MigrateTimeout) * time.Second
+
+	err = qms.StartMirror(virt.Id, inst.MigrateAddr,
+		inst.MigrateNbdPort, indexes)
+	defer func() {
+		e := qms.StopMirror(virt.Id, indexes)
+		if e != nil {
+			logrus.WithFields(logrus.Fields{
+				"instance_id": virt.Id.Hex(),
+				"error":       e,
+			}).Error("qemu: Failed to stop storage migration")
+		}
+	}()
+	if err != nil {
+		return
+	}
+
+	err = qms.WaitMirror(virt.Id, indexes, timeout)
+	if err != nil {
+		return
+	}
+
+	err = qms.Migrate(virt.Id, inst.MigrateAddr, inst.MigratePort)
+	if err != nil {
+		return
+	}
+
+	status := ""
+
+	for {
+		time.Sleep(1 * time.Second)
+
+		status, err = qms.GetMigrateStatus(virt.Id)
+		if err != nil {
+			return
+		}
+
+		if status == "completed" {
+			break
+		}
+
+		if status == "failed" || status == "cancelled" {
+			err = &errortypes.ExecError{
+				errors.Newf("qemu: Migration %s", status),
+			}
+			return
+		}
+
+		if time.Since(start) > timeout {
+			_ = qms.MigrateCancel(virt.Id)
+
+			err = &errortypes.TimeoutError{
+				errors.New("qemu: Migration timeout"),
+			}
+			return
+		}
+	}
+
+	return
+}
+
+func MigrateCleanup(db *database.Database, virt *vm.VirtualMachine) (
+	err error) {
+
+	unitName := paths.GetUnitName(virt.Id)
+	unitPath := paths.GetUnitPath(virt.Id)
+
+	logrus.WithFields(logrus.Fields{
+		"id": virt.Id.Hex(),
+	}).Info("qemu: Removing migrated virtual machine")
+
+	exists, err := utils.Exists(unitPath)
+	if err != nil {
+		return
+	}
+
+	if exists {
+		err = systemd.Stop(unitName)
+		if err != nil {
+			return
+		}
+	}
+
+	err = utils.RemoveAll(unitPath)
+	if err != nil {
+		return
+	}
+
+	err = removeTpmService(virt)
+	if err != nil {
+		return
+	}
+
+	time.Sleep(3 * time.Second)
+
+	err = NetworkConfClear(db, virt)
+	if err != nil {
+		return
+	}
+
+	for _, dsk := range virt.Disks {
+		err = utils.RemoveAll(dsk.Path)
+		if err != nil {
+			return
+		}
+	}
+
+	err = utils.RemoveAll(paths.GetNvramPath(virt.Id))
+	if err != nil {
+		return
+	}
+
+	err = utils.RemoveAll(paths.GetNvramFirmwarePath(virt.Id))
+	if err != nil {
+		return
+	}
+
+	err = utils.RemoveAll(paths.GetTpmPath(virt.Id))
+	if err != nil {
+		return
+	}
+
+	err = utils.RemoveAll(paths.GetTpmSockPath(virt.Id))
+	if err != nil {
+		return
+	}
+
+	err = utils.RemoveAll(paths.GetVmPath(virt.Id))
+	if err != nil {
+		return
+	}
+
+	err = utils.RemoveAll(paths.GetSockPath(virt.Id))
+	if err != nil {
+		return
+	}
+
+	err = utils.RemoveAll(paths.GetEventsSockPath(virt.Id))
+	if err != nil {
+		return
+	}
+
+	err = utils.RemoveAll(paths.GetGuestPath(virt.Id))
+	if err != nil {
+		return
+	}
+
+	err = utils.RemoveAll(paths.GetSerialPath(virt.Id))
+	if err != nil {
+		return
+	}
+
+	err = utils.RemoveAll(paths.GetPidPath(virt.Id))
+	if err != nil {
+		return
+	}
+
+	err = utils.RemoveAll(paths.GetInitPath(virt.Id))
+	if err != nil {
+		return
+	}
+
+	err = utils.RemoveAll(paths.GetLeasePath(virt.Id))
+	if err != nil {
+		return
+	}
+
+	err = systemd.Reload()
+	if err != nil {
+		return
+	}
+
+	store.RemVirt(virt.Id)
+	store.RemDisks(virt.Id)
+	store.RemAddress(virt.Id)
+	store.RemRoutes(virt.Id)
+
+	return
+}
@@ -0,0 +1,68 @@
+package qemu
+
+import (
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/dropbox/godropbox/errors"
+	"github.com/pritunl/mongo-go-driver/bson/primitive"
+	"github.com/pritunl/pritunl-cloud/errortypes"
+	"github.com/pritunl/pritunl-cloud/paths"
+	"github.com/pritunl/pritunl-cloud/qms"
+	"github.com/pritunl/pritunl-cloud/utils"
+	"github.com/pritunl/pritunl-cloud/vm"
+)
+
+func pinCpus(virt *vm.VirtualMachine) (err error) {
+	if len(virt.PinnedCpus) == 0 {
+		return
+	}
+
+	threads, err := qms.GetCpuThreads(virt.Id)
+	if err != nil {
+		return
+	}
+
+	for i, thread := range threads {
+		cpu := virt.PinnedCpus[i%len(virt.PinnedCpus)]
+
+		err = utils.Exec("", "taskset", "-pc",
+			strconv.Itoa(cpu), strconv.Itoa(thread))
+		if err != nil {
+			return
+		}
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"id":          virt.Id.Hex(),
+		"pinned_cpus": virt.PinnedCpus,
+	}).Info("qemu: Pinned virtual machine cpus")
+
+	return
+}
+
+func SetAffinity(virtId primitive.ObjectID, cpus []int) (err error) {
+	pidData, err := ioutil.ReadFile(paths.GetPidPath(virtId))
+	if err != nil {
+		err = &errortypes.ReadError{
+			errors.Wrap(err, "qemu: Failed to read pid file"),
+		}
+		return
+	}
+	pid := strings.TrimSpace(string(pidData))
+
+	cpuList := []string{}
+	for _, cpu := range cpus {
+		cpuList = append(cpuList, strconv.Itoa(cpu))
+	}
+
+	err = utils.Exec("", "taskset", "-a", "-pc",
+		strings.Join(cpuList, ","), pid)
+	if err != nil {
+		return
+	}
+
+	return
+}
@@ -2,20 +2,25 @@ package qemu
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 
 	"github.com/pritunl/mongo-go-driver/bson/primitive"
 	"github.com/pritunl/pritunl-cloud/node"
 	"github.com/pritunl/pritunl-cloud/paths"
+	"github.com/pritunl/pritunl-cloud/settings"
 	"github.com/pritunl/pritunl-cloud/usb"
+	"github.com/pritunl/pritunl-cloud/vm"
 )
 
 type Disk struct {
-	Media   string
-	Index   int
-	File    string
-	Format  string
-	Discard bool
+	Media          string
+	Index          int
+	File           string
+	Format         string
+	Discard        bool
+	IopsLimit      int
+	BandwidthLimit int
 }
 
 type Network struct {
@@ -33,14 +38,26 @@ type Qemu struct {
 	Data       string
 	Kvm        bool
 	Machine    string
+	Firmware   string
+	Tpm        bool
 	Cpu        string
+	NestedVirt bool
 	Cpus       int
+	MaxCpus    int
+	Sockets    int
 	Cores      int
 	Threads    int
 	Boot       string
 	Memory     int
+	MaxMemory  int
+	Hugepages  bool
+	NumaLocal  bool
+	NumaNode   int
+	PinnedCpus []int
+	SharedCpus []int
 	Vnc        bool
 	VncDisplay int
+	Incoming   string
 	Disks      []*Disk
 	Networks   []*Network
 	UsbDevices []*UsbDevice
@@ -80,26 +97,93 @@ func (q *Qemu) Marshal() (output string, err error) {
 	if nodeVga == node.Virtio {
 		options += ",gfx_passthru=on"
 	}
+	if q.Firmware == vm.UefiSecure {
+		options += ",smm=on"
+	}
 	cmd = append(cmd, fmt.Sprintf("type=%s%s", q.Machine, options))
 
+	if q.Firmware == vm.Uefi || q.Firmware == vm.UefiSecure {
+		codePath := settings.Hypervisor.OvmfCodePath
+		if q.Firmware == vm.UefiSecure {
+			codePath = settings.Hypervisor.OvmfSecureCodePath
+
+			cmd = append(cmd, "-global")
+			cmd = append(cmd, "driver=cfi.pflash01,property=secure,value=on")
+		}
+
+		cmd = append(cmd, "-drive")
+		cmd = append(cmd, fmt.Sprintf(
+			"if=pflash,format=raw,unit=0,file=%s,readonly=on",
+			codePath,
+		))
+
+		cmd = append(cmd, "-drive")
+		cmd = append(cmd, fmt.Sprintf(
+			"if=pflash,format=raw,unit=1,file=%s",
+			paths.GetNvramPath(q.Id),
+		))
+	}
+
 	if q.Kvm {
+		cpu := q.Cpu
+		virtExt := node.Self.VirtExtension
+		if virtExt != "" && q.NestedVirt {
+			cpu += ",+" + virtExt
+		}
+
 		cmd = append(cmd, "-cpu")
-		cmd = append(cmd, q.Cpu)
+		cmd = append(cmd, cpu)
 	}
 
+	smp := fmt.Sprintf("cpus=%d", q.Cpus)
+	if q.MaxCpus > q.Cpus {
+		smp += fmt.Sprintf(",maxcpus=%d", q.MaxCpus)
+	}
+	if q.Sockets > 0 {
+		smp += fmt.Sprintf(",sockets=%d", q.Sockets)
+	}
+	smp += fmt.Sprintf(",cores=%d,threads=%d", q.Cores, q.Threads)
+
 	cmd = append(cmd, "-smp")
-	cmd = append(cmd, fmt.Sprintf(
-		"cpus=%d,cores=%d,threads=%d",
-		q.Cpus,
-		q.Cores,
-		q.Threads,
-	))
+	cmd = append(cmd, smp)
 
 	cmd = append(cmd, "-boot")
 	cmd = append(cmd, q.Boot)
 
 	cmd = append(cmd, "-m")
-	cmd = append(cmd, fmt.Sprintf("%dM", q.Memory))
+	if q.MaxMemory > q.Memory {
+		cmd = append(cmd, fmt.Sprintf(
+			"size=%dM,slots=%d,maxmem=%dM",
+			q.Memory,
+			memorySlots,
+			q.MaxMemory,
+		))
+	} else {
+		cmd = append(cmd, fmt.Sprintf("%dM", q.Memory))
+	}
+
+	if q.Hugepages || q.NumaLocal {
+		backend := ""
+		if q.Hugepages {
+			backend = fmt.Sprintf(
+				"memory-backend-file,id=mem0,size=%dM,"+
+					"mem-path=/dev/hugepages,share=on,prealloc=on",
+				q.Memory,
+			)
+		} else {
+			backend = fmt.Sprintf(
+				"memory-backend-ram,id=mem0,size=%dM", q.Memory)
+		}
+
+		if q.NumaLocal {
+			backend += fmt.Sprintf(",host-nodes=%d,policy=bind", q.NumaNode)
+		}
+
+		cmd = append(cmd, "-object")
+		cmd = append(cmd, backend)
+		cmd = append(cmd, "-numa")
+		cmd = append(cmd, "node,memdev=mem0")
+	}
 
 	for _, disk := range q.Disks {
 		additional := ""
@@ -111,6 +195,14 @@ func (q *Qemu) Marshal() (output string, err error) {
 		if disk.Media == "disk" {
 			additional += ",if=virtio"
 		}
+		if disk.IopsLimit > 0 {
+			additional += fmt.Sprintf(",throttling.iops-total=%d",
+				disk.IopsLimit)
+		}
+		if disk.BandwidthLimit > 0 {
+			additional += fmt.Sprintf(",throttling.bps-total=%d",
+				disk.BandwidthLimit*1048576)
+		}
 
 		cmd = append(cmd, "-drive")
 		cmd = append(cmd, fmt.Sprintf(
@@ -127,7 +219,8 @@ func (q *Qemu) Marshal() (output string, err error) {
 	for _, network := range q.Networks {
 		cmd = append(cmd, "-device")
 		cmd = append(cmd, fmt.Sprintf(
-			"virtio-net-pci,netdev=net%d,mac=%s",
+			"virtio-net-pci,id=nic%d,netdev=net%d,mac=%s",
+			count,
 			count,
 			network.MacAddress,
 		))
@@ -138,20 +231,73 @@ func (q *Qemu) Marshal() (output string, err error) {
 			count,
 			network.Iface,
 		))
+
+		count += 1
 	}
 
 	cmd = append(cmd, "-cdrom")
 	cmd = append(cmd, paths.GetInitPath(q.Id))
 
-	cmd = append(cmd, "-monitor")
+	cmd = append(cmd, "-qmp")
 	cmd = append(cmd, fmt.Sprintf(
 		"unix:%s,server,nowait",
 		paths.GetSockPath(q.Id),
 	))
 
+	cmd = append(cmd, "-qmp")
+	cmd = append(cmd, fmt.Sprintf(
+		"unix:%s,server,nowait",
+		paths.GetEventsSockPath(q.Id),
+	))
+
 	cmd = append(cmd, "-pidfile")
 	cmd = append(cmd, paths.GetPidPath(q.Id))
 
+	cmd = append(cmd, "-chardev")
+	cmd = append(cmd, fmt.Sprintf(
+		"socket,path=%s,server,nowait,id=serial,logfile=%s,logappend=on",
+		paths.GetSerialPath(q.Id),
+		paths.GetSerialLogPath(q.Id),
+	))
+	cmd = append(cmd, "-serial")
+	cmd = append(cmd, "chardev:serial")
+
+	unitOpts := ""
+	if len(q.PinnedCpus) > 0 {
+		cpus := []string{}
+		for _, cpu := range q.PinnedCpus {
+			cpus = append(cpus, strconv.Itoa(cpu))
+		}
+
+		unitOpts += fmt.Sprintf("CPUAffinity=%s\n", strings.Join(cpus, " "))
+	} else if len(q.SharedCpus) > 0 {
+		cpus := []string{}
+		for _, cpu := range q.SharedCpus {
+			cpus = append(cpus, strconv.Itoa(cpu))
+		}
+
+		unitOpts += fmt.Sprintf("CPUAffinity=%s\n", strings.Join(cpus, " "))
+	}
+
+	unitDeps := ""
+	if q.Tpm {
+		tpmSockPath := paths.GetTpmSockPath(q.Id)
+		tpmUnitName := paths.GetTpmUnitName(q.Id)
+
+		// Processes forked from ExecStartPre are killed before the main
+		// process starts, swtpm must run in a separate unit
+		unitDeps += fmt.Sprintf("Requires=%s\nAfter=%s\n",
+			tpmUnitName, tpmUnitName)
+
+		cmd = append(cmd, "-chardev")
+		cmd = append(cmd, fmt.Sprintf(
+			"socket,id=tpm,path=%s", tpmSockPath))
+		cmd = append(cmd, "-tpmdev")
+		cmd = append(cmd, "emulator,id=tpm0,chardev=tpm")
+		cmd = append(cmd, "-device")
+		cmd = append(cmd, "tpm-crb,tpmdev=tpm0")
+	}
+
 	guestPath := paths.GetGuestPath(q.Id)
 	cmd = append(cmd, "-chardev")
 	cmd = append(cmd, fmt.Sprintf(
@@ -185,9 +331,17 @@ func (q *Qemu) Marshal() (output string, err error) {
 		}
 	}
 
+	if q.Incoming != "" {
+		cmd = append(cmd, "-S")
+		cmd = append(cmd, "-incoming")
+		cmd = append(cmd, q.Incoming)
+	}
+
 	output = fmt.Sprintf(
 		systemdTemplate,
 		q.Data,
+		unitDeps,
+		unitOpts,
 		strings.Join(cmd, " "),
 	)
 	return
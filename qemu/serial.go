@@ -0,0 +1,117 @@
+package qemu
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/dropbox/godropbox/errors"
+	"github.com/pritunl/pritunl-cloud/database"
+	"github.com/pritunl/pritunl-cloud/errortypes"
+	"github.com/pritunl/pritunl-cloud/instance"
+	"github.com/pritunl/pritunl-cloud/node"
+	"github.com/pritunl/pritunl-cloud/paths"
+	"github.com/pritunl/pritunl-cloud/settings"
+	"github.com/pritunl/pritunl-cloud/utils"
+	"github.com/pritunl/pritunl-cloud/vm"
+)
+
+const serialLogMigrateMax = 1048576
+
+func rotateSerialLog(virt *vm.VirtualMachine) (err error) {
+	logPath := paths.GetSerialLogPath(virt.Id)
+
+	stat, err := os.Stat(logPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+			return
+		}
+
+		err = &errortypes.ReadError{
+			errors.Wrap(err, "qemu: Failed to stat serial log"),
+		}
+		return
+	}
+
+	maxSize := int64(settings.Hypervisor.SerialLogSize) * 1048576
+	if maxSize <= 0 || stat.Size() <= maxSize {
+		return
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"id":   virt.Id.Hex(),
+		"size": stat.Size(),
+	}).Info("qemu: Rotating virtual machine serial log")
+
+	err = utils.Exec("", "mv", "-f", logPath, logPath+".1")
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func writeMigrateSerialLog(virt *vm.VirtualMachine, data []byte) (
+	err error) {
+
+	err = utils.CreateWrite(paths.GetSerialLogPath(virt.Id),
+		string(data), 0600)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func MigrateSerialLog(db *database.Database, virt *vm.VirtualMachine) (
+	err error) {
+
+	file, err := os.Open(paths.GetSerialLogPath(virt.Id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+			return
+		}
+
+		err = &errortypes.ReadError{
+			errors.Wrap(err, "qemu: Failed to open serial log"),
+		}
+		return
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		err = &errortypes.ReadError{
+			errors.Wrap(err, "qemu: Failed to stat serial log"),
+		}
+		return
+	}
+
+	if stat.Size() > serialLogMigrateMax {
+		_, err = file.Seek(-serialLogMigrateMax, io.SeekEnd)
+		if err != nil {
+			err = &errortypes.ReadError{
+				errors.Wrap(err, "qemu: Failed to seek serial log"),
+			}
+			return
+		}
+	}
+
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		err = &errortypes.ReadError{
+			errors.Wrap(err, "qemu: Failed to read serial log"),
+		}
+		return
+	}
+
+	err = instance.SetMigrateSerialLog(db, virt.Id, node.Self.Id, data)
+	if err != nil {
+		return
+	}
+
+	return
+}
@@ -0,0 +1,91 @@
+package qemu
+
+import (
+	"fmt"
+
+	"github.com/pritunl/pritunl-cloud/paths"
+	"github.com/pritunl/pritunl-cloud/systemd"
+	"github.com/pritunl/pritunl-cloud/utils"
+	"github.com/pritunl/pritunl-cloud/vm"
+)
+
+func writeTpm(virt *vm.VirtualMachine) (err error) {
+	if !virt.Tpm {
+		return
+	}
+
+	err = utils.ExistsMkdir(paths.GetDisksPath(), 0755)
+	if err != nil {
+		return
+	}
+
+	err = utils.ExistsMkdir(paths.GetTpmPath(virt.Id), 0700)
+	if err != nil {
+		return
+	}
+
+	err = utils.RemoveAll(paths.GetTpmSockPath(virt.Id))
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func writeTpmService(virt *vm.VirtualMachine) (err error) {
+	unitPath := paths.GetTpmUnitPath(virt.Id)
+
+	if !virt.Tpm {
+		err = utils.RemoveAll(unitPath)
+		if err != nil {
+			return
+		}
+		return
+	}
+
+	output := fmt.Sprintf(
+		tpmSystemdTemplate,
+		paths.GetUnitName(virt.Id),
+		paths.GetTpmPidPath(virt.Id),
+		paths.GetTpmPath(virt.Id),
+		paths.GetTpmSockPath(virt.Id),
+		paths.GetTpmPidPath(virt.Id),
+	)
+
+	err = utils.CreateWrite(unitPath, output, 0644)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func removeTpmService(virt *vm.VirtualMachine) (err error) {
+	unitPath := paths.GetTpmUnitPath(virt.Id)
+
+	exists, err := utils.Exists(unitPath)
+	if err != nil {
+		return
+	}
+
+	if !exists {
+		return
+	}
+
+	err = systemd.Stop(paths.GetTpmUnitName(virt.Id))
+	if err != nil {
+		return
+	}
+
+	err = utils.RemoveAll(unitPath)
+	if err != nil {
+		return
+	}
+
+	err = utils.RemoveAll(paths.GetTpmPidPath(virt.Id))
+	if err != nil {
+		return
+	}
+
+	return
+}
@@ -23,12 +23,22 @@ func NewQemu(virt *vm.VirtualMachine) (qm *Qemu, err error) {
 		Data:       string(data),
 		Kvm:        node.Self.Hypervisor == node.Kvm,
 		Machine:    "pc",
-		Cpu:        "host",
+		Firmware:   virt.GetFirmware(),
+		Tpm:        virt.Tpm,
+		Cpu:        virt.GetCpuModel(),
+		NestedVirt: virt.NestedVirt,
 		Cpus:       virt.Processors,
+		MaxCpus:    virt.MaxProcessors,
 		Cores:      1,
 		Threads:    1,
 		Boot:       "c",
 		Memory:     virt.Memory,
+		MaxMemory:  virt.MaxMemory,
+		Hugepages:  virt.Hugepages,
+		NumaLocal:  virt.NumaLocal,
+		NumaNode:   virt.NumaNode,
+		PinnedCpus: virt.PinnedCpus,
+		SharedCpus: virt.SharedCpus,
 		Vnc:        virt.Vnc,
 		VncDisplay: virt.VncDisplay,
 		Disks:      []*Disk{},
@@ -36,13 +46,28 @@ func NewQemu(virt *vm.VirtualMachine) (qm *Qemu, err error) {
 		UsbDevices: []*UsbDevice{},
 	}
 
+	if virt.MachineType != "" {
+		qm.Machine = virt.MachineType
+	} else if qm.Firmware == vm.UefiSecure {
+		// Secure boot requires SMM which is only supported by q35
+		qm.Machine = "q35"
+	}
+
+	if virt.Sockets > 0 {
+		qm.Sockets = virt.Sockets
+		qm.Cores = virt.Cores
+		qm.Threads = virt.Threads
+	}
+
 	for _, disk := range virt.Disks {
 		qm.Disks = append(qm.Disks, &Disk{
-			Media:   "disk",
-			Index:   disk.Index,
-			File:    disk.Path,
-			Format:  "qcow2",
-			Discard: false,
+			Media:          "disk",
+			Index:          disk.Index,
+			File:           disk.Path,
+			Format:         "qcow2",
+			Discard:        false,
+			IopsLimit:      disk.IopsLimit,
+			BandwidthLimit: disk.BandwidthLimit,
 		})
 	}
 
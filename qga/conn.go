@@ -0,0 +1,247 @@
+package qga
+
+import (
+	"bufio"
+	"encoding/json"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/dropbox/godropbox/errors"
+	"github.com/pritunl/mongo-go-driver/bson/primitive"
+	"github.com/pritunl/pritunl-cloud/errortypes"
+	"github.com/pritunl/pritunl-cloud/paths"
+	"github.com/pritunl/pritunl-cloud/utils"
+)
+
+var (
+	socketsLock    = utils.NewMultiTimeoutLock(2 * time.Minute)
+	commandTimeout = 10 * time.Second
+)
+
+type request struct {
+	Execute   string      `json:"execute"`
+	Arguments interface{} `json:"arguments,omitempty"`
+}
+
+type agentError struct {
+	Class       string `json:"class"`
+	Description string `json:"desc"`
+}
+
+type response struct {
+	Return json.RawMessage `json:"return"`
+	Error  *agentError     `json:"error"`
+}
+
+type CommandError struct {
+	errors.DropboxError
+	Command     string
+	Class       string
+	Description string
+}
+
+type Connection struct {
+	vmId    primitive.ObjectID
+	lockId  primitive.ObjectID
+	conn    net.Conn
+	reader  *bufio.Reader
+	timeout time.Duration
+}
+
+func (c *Connection) setDeadline(timeout time.Duration) (err error) {
+	err = c.conn.SetDeadline(time.Now().Add(timeout))
+	if err != nil {
+		err = &errortypes.ReadError{
+			errors.Wrap(err, "qga: Failed set deadline"),
+		}
+		return
+	}
+
+	return
+}
+
+func (c *Connection) write(req *request) (err error) {
+	reqByte, err := json.Marshal(req)
+	if err != nil {
+		err = &errortypes.ParseError{
+			errors.Wrap(err, "qga: Failed to parse guest agent command"),
+		}
+		return
+	}
+
+	_, err = c.conn.Write(append(reqByte, '\n'))
+	if err != nil {
+		err = &errortypes.WriteError{
+			errors.Wrap(err, "qga: Failed to write to guest agent"),
+		}
+		return
+	}
+
+	return
+}
+
+func (c *Connection) read() (resp *response, err error) {
+	line, err := c.reader.ReadBytes('\n')
+	if err != nil {
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			err = &errortypes.TimeoutError{
+				errors.Wrap(err, "qga: Guest agent read timeout"),
+			}
+			return
+		}
+
+		err = &errortypes.ReadError{
+			errors.Wrap(err, "qga: Failed to read from guest agent"),
+		}
+		return
+	}
+
+	resp = &response{}
+	err = json.Unmarshal(line, resp)
+	if err != nil {
+		err = &errortypes.ParseError{
+			errors.Wrap(err, "qga: Failed to parse guest agent response"),
+		}
+		return
+	}
+
+	return
+}
+
+func (c *Connection) sync() (err error) {
+	id := rand.Int63n(1000000000)
+
+	err = c.write(&request{
+		Execute: "guest-sync-delimited",
+		Arguments: map[string]interface{}{
+			"id": id,
+		},
+	})
+	if err != nil {
+		return
+	}
+
+	_, err = c.reader.ReadBytes(0xff)
+	if err != nil {
+		err = &errortypes.ReadError{
+			errors.Wrap(err, "qga: Failed to sync guest agent"),
+		}
+		return
+	}
+
+	for {
+		resp, e := c.read()
+		if e != nil {
+			err = e
+			return
+		}
+
+		var respId int64
+		if resp.Return != nil && json.Unmarshal(
+			resp.Return, &respId) == nil && respId == id {
+
+			break
+		}
+	}
+
+	return
+}
+
+func (c *Connection) SetTimeout(timeout time.Duration) {
+	c.timeout = timeout
+}
+
+func (c *Connection) Command(execute string, args interface{},
+	resp interface{}) (err error) {
+
+	err = c.setDeadline(c.timeout)
+	if err != nil {
+		return
+	}
+
+	err = c.write(&request{
+		Execute:   execute,
+		Arguments: args,
+	})
+	if err != nil {
+		return
+	}
+
+	msg, err := c.read()
+	if err != nil {
+		return
+	}
+
+	if msg.Error != nil {
+		err = &CommandError{
+			DropboxError: errors.Newf(
+				"qga: Command '%s' failed with %s '%s'",
+				execute, msg.Error.Class, msg.Error.Description,
+			),
+			Command:     execute,
+			Class:       msg.Error.Class,
+			Description: msg.Error.Description,
+		}
+		return
+	}
+
+	if resp != nil && msg.Return != nil {
+		err = json.Unmarshal(msg.Return, resp)
+		if err != nil {
+			err = &errortypes.ParseError{
+				errors.Wrapf(err,
+					"qga: Failed to parse '%s' response", execute),
+			}
+			return
+		}
+	}
+
+	return
+}
+
+func (c *Connection) Close() {
+	_ = c.conn.Close()
+	socketsLock.Unlock(c.vmId.Hex(), c.lockId)
+}
+
+func Connect(vmId primitive.ObjectID) (c *Connection, err error) {
+	lockId := socketsLock.Lock(vmId.Hex())
+
+	conn, err := net.DialTimeout(
+		"unix",
+		paths.GetGuestPath(vmId),
+		1*time.Second,
+	)
+	if err != nil {
+		socketsLock.Unlock(vmId.Hex(), lockId)
+		err = &errortypes.ConnectionError{
+			errors.Wrap(err, "qga: Failed to connect to guest agent"),
+		}
+		return
+	}
+
+	c = &Connection{
+		vmId:    vmId,
+		lockId:  lockId,
+		conn:    conn,
+		reader:  bufio.NewReader(conn),
+		timeout: commandTimeout,
+	}
+
+	err = c.setDeadline(3 * time.Second)
+	if err != nil {
+		c.Close()
+		c = nil
+		return
+	}
+
+	err = c.sync()
+	if err != nil {
+		c.Close()
+		c = nil
+		return
+	}
+
+	return
+}
@@ -0,0 +1,25 @@
+package qga
+
+import (
+	"time"
+)
+
+const (
+	Exec       = "exec"
+	FileRead   = "file_read"
+	FileWrite  = "file_write"
+	Password   = "password"
+	OsInfo     = "os_info"
+	FsInfo     = "fs_info"
+	Users      = "users"
+	Freeze     = "freeze"
+	Thaw       = "thaw"
+	FreezeStat = "freeze_status"
+
+	MaxOutput   = 65536
+	MaxFileSize = 1048576
+
+	execTimeout    = 10 * time.Second
+	execTimeoutMax = 60 * time.Second
+	fileChunkSize  = 49152
+)
@@ -0,0 +1,495 @@
+package qga
+
+import (
+	"encoding/base64"
+	"path"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/dropbox/godropbox/errors"
+	"github.com/pritunl/mongo-go-driver/bson/primitive"
+	"github.com/pritunl/pritunl-cloud/errortypes"
+)
+
+type ExecResult struct {
+	Exited    bool   `json:"exited"`
+	ExitCode  int    `json:"exit_code"`
+	Output    string `json:"output"`
+	Error     string `json:"error"`
+	Truncated bool   `json:"truncated"`
+}
+
+type OsInformation struct {
+	Id            string `json:"id"`
+	Name          string `json:"name"`
+	PrettyName    string `json:"pretty_name"`
+	Version       string `json:"version"`
+	VersionId     string `json:"version_id"`
+	KernelRelease string `json:"kernel_release"`
+	KernelVersion string `json:"kernel_version"`
+	Machine       string `json:"machine"`
+}
+
+type FsInformation struct {
+	Name       string `json:"name"`
+	Mountpoint string `json:"mountpoint"`
+	Type       string `json:"type"`
+	TotalBytes int64  `json:"total_bytes"`
+	UsedBytes  int64  `json:"used_bytes"`
+}
+
+type User struct {
+	User      string    `json:"user"`
+	Domain    string    `json:"domain"`
+	LoginTime time.Time `json:"login_time"`
+}
+
+type osInfo struct {
+	Id            string `json:"id"`
+	Name          string `json:"name"`
+	PrettyName    string `json:"pretty-name"`
+	Version       string `json:"version"`
+	VersionId     string `json:"version-id"`
+	KernelRelease string `json:"kernel-release"`
+	KernelVersion string `json:"kernel-version"`
+	Machine       string `json:"machine"`
+}
+
+type fsInfo struct {
+	Name       string `json:"name"`
+	Mountpoint string `json:"mountpoint"`
+	Type       string `json:"type"`
+	TotalBytes int64  `json:"total-bytes"`
+	UsedBytes  int64  `json:"used-bytes"`
+}
+
+type userInfo struct {
+	User      string  `json:"user"`
+	Domain    string  `json:"domain"`
+	LoginTime float64 `json:"login-time"`
+}
+
+type execPid struct {
+	Pid int `json:"pid"`
+}
+
+type execStatus struct {
+	Exited       bool   `json:"exited"`
+	ExitCode     int    `json:"exitcode"`
+	Signal       int    `json:"signal"`
+	OutData      string `json:"out-data"`
+	ErrData      string `json:"err-data"`
+	OutTruncated bool   `json:"out-truncated"`
+	ErrTruncated bool   `json:"err-truncated"`
+}
+
+type fileRead struct {
+	Count int    `json:"count"`
+	Data  string `json:"buf-b64"`
+	Eof   bool   `json:"eof"`
+}
+
+func decodeOutput(data string) (output string, truncated bool, err error) {
+	outputByt, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		err = &errortypes.ParseError{
+			errors.Wrap(err, "qga: Failed to decode exec output"),
+		}
+		return
+	}
+
+	if len(outputByt) > MaxOutput {
+		outputByt = outputByt[:MaxOutput]
+		truncated = true
+	}
+	output = string(outputByt)
+
+	return
+}
+
+func ExecCommand(vmId primitive.ObjectID, cmdPath string, args []string,
+	input string, timeout time.Duration) (result *ExecResult, err error) {
+
+	if !path.IsAbs(cmdPath) {
+		err = &errortypes.ParseError{
+			errors.New("qga: Command path must be absolute"),
+		}
+		return
+	}
+
+	if timeout <= 0 {
+		timeout = execTimeout
+	} else if timeout > execTimeoutMax {
+		timeout = execTimeoutMax
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"instance_id": vmId.Hex(),
+		"path":        cmdPath,
+	}).Info("qga: Running guest agent command")
+
+	conn, err := Connect(vmId)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	if args == nil {
+		args = []string{}
+	}
+
+	execArgs := map[string]interface{}{
+		"path":           cmdPath,
+		"arg":            args,
+		"capture-output": true,
+	}
+	if input != "" {
+		execArgs["input-data"] = base64.StdEncoding.EncodeToString(
+			[]byte(input))
+	}
+
+	pid := &execPid{}
+	err = conn.Command("guest-exec", execArgs, pid)
+	if err != nil {
+		return
+	}
+
+	start := time.Now()
+	status := &execStatus{}
+	for {
+		err = conn.Command("guest-exec-status", map[string]interface{}{
+			"pid": pid.Pid,
+		}, status)
+		if err != nil {
+			return
+		}
+
+		if status.Exited || time.Since(start) > timeout {
+			break
+		}
+
+		time.Sleep(200 * time.Millisecond)
+	}
+
+	result = &ExecResult{
+		Exited:    status.Exited,
+		ExitCode:  status.ExitCode,
+		Truncated: status.OutTruncated || status.ErrTruncated,
+	}
+
+	if status.Signal != 0 {
+		result.ExitCode = 128 + status.Signal
+	}
+
+	output, truncated, err := decodeOutput(status.OutData)
+	if err != nil {
+		return
+	}
+	result.Output = output
+	result.Truncated = result.Truncated || truncated
+
+	output, truncated, err = decodeOutput(status.ErrData)
+	if err != nil {
+		return
+	}
+	result.Error = output
+	result.Truncated = result.Truncated || truncated
+
+	return
+}
+
+func ReadFile(vmId primitive.ObjectID, filePath string) (
+	data []byte, err error) {
+
+	if !path.IsAbs(filePath) {
+		err = &errortypes.ParseError{
+			errors.New("qga: File path must be absolute"),
+		}
+		return
+	}
+
+	conn, err := Connect(vmId)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	var handle int
+	err = conn.Command("guest-file-open", map[string]interface{}{
+		"path": filePath,
+		"mode": "r",
+	}, &handle)
+	if err != nil {
+		return
+	}
+	defer func() {
+		_ = conn.Command("guest-file-close", map[string]interface{}{
+			"handle": handle,
+		}, nil)
+	}()
+
+	data = []byte{}
+	for {
+		chunk := &fileRead{}
+		err = conn.Command("guest-file-read", map[string]interface{}{
+			"handle": handle,
+			"count":  fileChunkSize,
+		}, chunk)
+		if err != nil {
+			return
+		}
+
+		chunkData, e := base64.StdEncoding.DecodeString(chunk.Data)
+		if e != nil {
+			err = &errortypes.ParseError{
+				errors.Wrap(e, "qga: Failed to decode file data"),
+			}
+			return
+		}
+		data = append(data, chunkData...)
+
+		if len(data) > MaxFileSize {
+			err = &errortypes.ReadError{
+				errors.New("qga: File exceeds maximum size"),
+			}
+			return
+		}
+
+		if chunk.Eof || chunk.Count == 0 {
+			break
+		}
+	}
+
+	return
+}
+
+func WriteFile(vmId primitive.ObjectID, filePath string,
+	data []byte) (err error) {
+
+	if !path.IsAbs(filePath) {
+		err = &errortypes.ParseError{
+			errors.New("qga: File path must be absolute"),
+		}
+		return
+	}
+
+	if len(data) > MaxFileSize {
+		err = &errortypes.WriteError{
+			errors.New("qga: File exceeds maximum size"),
+		}
+		return
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"instance_id": vmId.Hex(),
+		"path":        filePath,
+	}).Info("qga: Writing guest file")
+
+	conn, err := Connect(vmId)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	var handle int
+	err = conn.Command("guest-file-open", map[string]interface{}{
+		"path": filePath,
+		"mode": "w",
+	}, &handle)
+	if err != nil {
+		return
+	}
+	defer func() {
+		_ = conn.Command("guest-file-close", map[string]interface{}{
+			"handle": handle,
+		}, nil)
+	}()
+
+	for start := 0; start < len(data); start += fileChunkSize {
+		end := start + fileChunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+
+		err = conn.Command("guest-file-write", map[string]interface{}{
+			"handle":  handle,
+			"buf-b64": base64.StdEncoding.EncodeToString(data[start:end]),
+		}, nil)
+		if err != nil {
+			return
+		}
+	}
+
+	err = conn.Command("guest-file-flush", map[string]interface{}{
+		"handle": handle,
+	}, nil)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func SetUserPassword(vmId primitive.ObjectID, username,
+	password string) (err error) {
+
+	logrus.WithFields(logrus.Fields{
+		"instance_id": vmId.Hex(),
+		"username":    username,
+	}).Info("qga: Setting guest user password")
+
+	conn, err := Connect(vmId)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	err = conn.Command("guest-set-user-password", map[string]interface{}{
+		"username": username,
+		"password": base64.StdEncoding.EncodeToString([]byte(password)),
+		"crypted":  false,
+	}, nil)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func GetOsInfo(vmId primitive.ObjectID) (info *OsInformation, err error) {
+	conn, err := Connect(vmId)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	data := &osInfo{}
+	err = conn.Command("guest-get-osinfo", nil, data)
+	if err != nil {
+		return
+	}
+
+	info = &OsInformation{
+		Id:            data.Id,
+		Name:          data.Name,
+		PrettyName:    data.PrettyName,
+		Version:       data.Version,
+		VersionId:     data.VersionId,
+		KernelRelease: data.KernelRelease,
+		KernelVersion: data.KernelVersion,
+		Machine:       data.Machine,
+	}
+
+	return
+}
+
+func GetFsInfo(vmId primitive.ObjectID) (infos []*FsInformation, err error) {
+	conn, err := Connect(vmId)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	data := []*fsInfo{}
+	err = conn.Command("guest-get-fsinfo", nil, &data)
+	if err != nil {
+		return
+	}
+
+	infos = []*FsInformation{}
+	for _, info := range data {
+		infos = append(infos, &FsInformation{
+			Name:       info.Name,
+			Mountpoint: info.Mountpoint,
+			Type:       info.Type,
+			TotalBytes: info.TotalBytes,
+			UsedBytes:  info.UsedBytes,
+		})
+	}
+
+	return
+}
+
+func GetUsers(vmId primitive.ObjectID) (users []*User, err error) {
+	conn, err := Connect(vmId)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	data := []*userInfo{}
+	err = conn.Command("guest-get-users", nil, &data)
+	if err != nil {
+		return
+	}
+
+	users = []*User{}
+	for _, usr := range data {
+		loginTime := time.Unix(0, int64(usr.LoginTime*float64(time.Second)))
+
+		users = append(users, &User{
+			User:      usr.User,
+			Domain:    usr.Domain,
+			LoginTime: loginTime,
+		})
+	}
+
+	return
+}
+
+func FreezeFs(vmId primitive.ObjectID) (count int, err error) {
+	logrus.WithFields(logrus.Fields{
+		"instance_id": vmId.Hex(),
+	}).Info("qga: Freezing guest filesystems")
+
+	conn, err := Connect(vmId)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	conn.SetTimeout(60 * time.Second)
+
+	err = conn.Command("guest-fsfreeze-freeze", nil, &count)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func ThawFs(vmId primitive.ObjectID) (count int, err error) {
+	logrus.WithFields(logrus.Fields{
+		"instance_id": vmId.Hex(),
+	}).Info("qga: Thawing guest filesystems")
+
+	conn, err := Connect(vmId)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	conn.SetTimeout(60 * time.Second)
+
+	err = conn.Command("guest-fsfreeze-thaw", nil, &count)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func GetFreezeStatus(vmId primitive.ObjectID) (status string, err error) {
+	conn, err := Connect(vmId)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	err = conn.Command("guest-fsfreeze-status", nil, &status)
+	if err != nil {
+		return
+	}
+
+	return
+}
@@ -0,0 +1,306 @@
+package qms
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/dropbox/godropbox/errors"
+	"github.com/pritunl/mongo-go-driver/bson/primitive"
+	"github.com/pritunl/pritunl-cloud/errortypes"
+)
+
+const (
+	BackupFull        = "full"
+	BackupReset       = "reset"
+	BackupIncremental = "incremental"
+
+	Bitmap = "backup"
+)
+
+type jobInfo struct {
+	Id     string `json:"id"`
+	Type   string `json:"type"`
+	Status string `json:"status"`
+	Error  string `json:"error"`
+}
+
+func getBackupIds(backupId primitive.ObjectID) (jobId, nodeName string) {
+	jobId = fmt.Sprintf("backup_%s", backupId.Hex())
+	nodeName = fmt.Sprintf("target_%s", backupId.Hex())
+	return
+}
+
+func getDiskBlock(conn *Connection, index int) (blk *blockInfo, err error) {
+	blks, err := queryBlock(conn)
+	if err != nil {
+		return
+	}
+
+	for _, b := range blks {
+		if b.Inserted == nil {
+			continue
+		}
+
+		i, ok := getDiskIndex(b)
+		if ok && i == index {
+			blk = b
+			return
+		}
+	}
+
+	err = &errortypes.NotFoundError{
+		errors.Newf("qms: Failed to find virtual machine disk %d", index),
+	}
+	return
+}
+
+func getJob(conn *Connection, jobId string) (job *jobInfo, err error) {
+	jobs := []*jobInfo{}
+	err = conn.Command("query-jobs", nil, &jobs)
+	if err != nil {
+		return
+	}
+
+	for _, j := range jobs {
+		if j.Id == jobId {
+			job = j
+			return
+		}
+	}
+
+	return
+}
+
+func GetDiskSize(vmId primitive.ObjectID, index int) (size int64, err error) {
+	conn, err := Connect(vmId)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	blk, err := getDiskBlock(conn, index)
+	if err != nil {
+		return
+	}
+
+	if blk.Inserted.Image == nil || blk.Inserted.Image.VirtualSize == 0 {
+		err = &errortypes.ParseError{
+			errors.New("qms: Missing virtual machine disk size"),
+		}
+		return
+	}
+
+	size = blk.Inserted.Image.VirtualSize
+
+	return
+}
+
+func StartBackup(vmId primitive.ObjectID, index int,
+	backupId primitive.ObjectID, targetPath, mode string) (err error) {
+
+	jobId, nodeName := getBackupIds(backupId)
+
+	logrus.WithFields(logrus.Fields{
+		"instance_id": vmId.Hex(),
+		"disk_index":  index,
+		"job_id":      jobId,
+		"target_path": targetPath,
+		"mode":        mode,
+	}).Info("qms: Starting virtual machine disk backup")
+
+	conn, err := Connect(vmId)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	blk, err := getDiskBlock(conn, index)
+	if err != nil {
+		return
+	}
+
+	device := blk.Device
+	if device == "" {
+		device = blk.Inserted.NodeName
+	}
+
+	err = conn.Command("blockdev-add", map[string]interface{}{
+		"driver":    "qcow2",
+		"node-name": nodeName,
+		"file": map[string]interface{}{
+			"driver":   "file",
+			"filename": targetPath,
+		},
+	}, nil)
+	if err != nil {
+		return
+	}
+
+	backupArgs := map[string]interface{}{
+		"job-id":       jobId,
+		"device":       device,
+		"target":       nodeName,
+		"sync":         "full",
+		"compress":     true,
+		"auto-dismiss": false,
+	}
+
+	switch mode {
+	case BackupFull:
+		err = conn.Command("blockdev-backup", backupArgs, nil)
+		break
+	case BackupReset:
+		if blk.Inserted.GetBitmap(Bitmap) != nil {
+			err = conn.Command("block-dirty-bitmap-remove",
+				map[string]interface{}{
+					"node": device,
+					"name": Bitmap,
+				}, nil)
+			if err != nil {
+				break
+			}
+		}
+
+		// Bitmap must be created atomically with the start of the job
+		err = conn.Command("transaction", map[string]interface{}{
+			"actions": []interface{}{
+				map[string]interface{}{
+					"type": "block-dirty-bitmap-add",
+					"data": map[string]interface{}{
+						"node":       device,
+						"name":       Bitmap,
+						"persistent": true,
+					},
+				},
+				map[string]interface{}{
+					"type": "blockdev-backup",
+					"data": backupArgs,
+				},
+			},
+		}, nil)
+		break
+	case BackupIncremental:
+		backupArgs["sync"] = "incremental"
+		backupArgs["bitmap"] = Bitmap
+		err = conn.Command("blockdev-backup", backupArgs, nil)
+		break
+	default:
+		err = &errortypes.ParseError{
+			errors.Newf("qms: Unknown backup mode '%s'", mode),
+		}
+	}
+	if err != nil {
+		_ = conn.Command("blockdev-del", map[string]interface{}{
+			"node-name": nodeName,
+		}, nil)
+		return
+	}
+
+	return
+}
+
+func HasBitmap(vmId primitive.ObjectID, index int) (exists bool, err error) {
+	conn, err := Connect(vmId)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	blk, err := getDiskBlock(conn, index)
+	if err != nil {
+		return
+	}
+
+	bitmap := blk.Inserted.GetBitmap(Bitmap)
+	if bitmap != nil && bitmap.Recording && bitmap.Persistent &&
+		!bitmap.Inconsistent {
+
+		exists = true
+	}
+
+	return
+}
+
+func WaitBackup(vmId, backupId primitive.ObjectID) (err error) {
+	jobId, _ := getBackupIds(backupId)
+
+	for {
+		conn, e := Connect(vmId)
+		if e != nil {
+			err = e
+			return
+		}
+
+		job, e := getJob(conn, jobId)
+		conn.Close()
+		if e != nil {
+			err = e
+			return
+		}
+
+		if job == nil {
+			err = &errortypes.NotFoundError{
+				errors.Newf("qms: Backup job '%s' not found", jobId),
+			}
+			return
+		}
+
+		if job.Status == "concluded" {
+			if job.Error != "" {
+				err = &errortypes.WriteError{
+					errors.Newf("qms: Backup job failed '%s'", job.Error),
+				}
+				return
+			}
+			return
+		}
+
+		time.Sleep(2 * time.Second)
+	}
+}
+
+func StopBackup(vmId, backupId primitive.ObjectID) (err error) {
+	jobId, nodeName := getBackupIds(backupId)
+
+	conn, err := Connect(vmId)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	job, err := getJob(conn, jobId)
+	if err != nil {
+		return
+	}
+
+	if job != nil {
+		if job.Status != "concluded" {
+			_ = conn.Command("job-cancel", map[string]interface{}{
+				"id": jobId,
+			}, nil)
+
+			_, _ = conn.WaitEvent("JOB_STATUS_CHANGE", 30*time.Second,
+				func(evt *Event) bool {
+					return evt.GetString("id") == jobId &&
+						evt.GetString("status") == "concluded"
+				})
+		}
+
+		err = conn.Command("job-dismiss", map[string]interface{}{
+			"id": jobId,
+		}, nil)
+		if err != nil {
+			return
+		}
+	}
+
+	err = conn.Command("blockdev-del", map[string]interface{}{
+		"node-name": nodeName,
+	}, nil)
+	if err != nil {
+		return
+	}
+
+	return
+}
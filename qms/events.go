@@ -0,0 +1,126 @@
+package qms
+
+import (
+	"bufio"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/dropbox/godropbox/container/set"
+	"github.com/dropbox/godropbox/errors"
+	"github.com/pritunl/mongo-go-driver/bson/primitive"
+	"github.com/pritunl/pritunl-cloud/errortypes"
+	"github.com/pritunl/pritunl-cloud/utils"
+)
+
+var (
+	listeners     = set.NewSet()
+	listenersLock = sync.Mutex{}
+)
+
+func Listen(vmId primitive.ObjectID) {
+	listenersLock.Lock()
+	if listeners.Contains(vmId) {
+		listenersLock.Unlock()
+		return
+	}
+	listeners.Add(vmId)
+	listenersLock.Unlock()
+
+	go func() {
+		defer func() {
+			listenersLock.Lock()
+			listeners.Remove(vmId)
+			listenersLock.Unlock()
+		}()
+
+		err := listen(vmId)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"instance_id": vmId.Hex(),
+				"error":       err,
+			}).Warn("qms: Virtual machine event listener failed")
+		}
+	}()
+}
+
+func listen(vmId primitive.ObjectID) (err error) {
+	sockPath := GetEventsSockPath(vmId)
+
+	exists, err := utils.Exists(sockPath)
+	if err != nil || !exists {
+		return
+	}
+
+	conn, err := net.DialTimeout(
+		"unix",
+		sockPath,
+		1*time.Second,
+	)
+	if err != nil {
+		err = &errortypes.ConnectionError{
+			errors.Wrap(err, "qms: Failed to open event socket"),
+		}
+		return
+	}
+	defer conn.Close()
+
+	c := &Connection{
+		vmId:   vmId,
+		conn:   conn,
+		reader: bufio.NewReader(conn),
+		events: []*Event{},
+	}
+
+	err = c.setDeadline(commandTimeout)
+	if err != nil {
+		return
+	}
+
+	msg, err := c.read()
+	if err != nil {
+		return
+	}
+
+	if msg.Greeting == nil {
+		err = &errortypes.ParseError{
+			errors.New("qms: Event socket did not send QMP greeting"),
+		}
+		return
+	}
+
+	err = c.Command("qmp_capabilities", nil, nil)
+	if err != nil {
+		return
+	}
+
+	err = conn.SetDeadline(time.Time{})
+	if err != nil {
+		err = &errortypes.ReadError{
+			errors.Wrap(err, "qms: Failed set deadline"),
+		}
+		return
+	}
+
+	for {
+		_, err = c.read()
+		if err != nil {
+			if _, ok := err.(*errortypes.ReadError); ok {
+				err = nil
+			}
+			return
+		}
+
+		for _, evt := range c.events {
+			handlersLock.Lock()
+			evtHandlers := handlers[evt.Event]
+			handlersLock.Unlock()
+
+			for _, handler := range evtHandlers {
+				handler(vmId, evt)
+			}
+		}
+		c.events = c.events[:0]
+	}
+}
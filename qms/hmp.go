@@ -0,0 +1,200 @@
+package qms
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/dropbox/godropbox/errors"
+	"github.com/pritunl/pritunl-cloud/errortypes"
+)
+
+const hmpPrompt = "(qemu)"
+
+func (c *Connection) hmpRead() (output string, err error) {
+	buffer := []byte{}
+	buf := make([]byte, 10000)
+
+	for {
+		n, e := c.reader.Read(buf)
+		if e != nil {
+			err = &errortypes.ReadError{
+				errors.Wrap(e, "qms: Failed to read socket"),
+			}
+			return
+		}
+		buffer = append(buffer, buf[:n]...)
+
+		if bytes.HasSuffix(bytes.TrimSpace(buffer), []byte(hmpPrompt)) {
+			break
+		}
+	}
+
+	output = strings.Replace(string(buffer), "\r", "", -1)
+	output = strings.TrimSuffix(strings.TrimSpace(output), hmpPrompt)
+
+	return
+}
+
+func (c *Connection) hmp(line string) (output string, err error) {
+	err = c.setDeadline(c.timeout)
+	if err != nil {
+		return
+	}
+
+	_, err = c.conn.Write([]byte(line + "\n"))
+	if err != nil {
+		err = &errortypes.WriteError{
+			errors.Wrap(err, "qms: Failed to write socket"),
+		}
+		return
+	}
+
+	output, err = c.hmpRead()
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func hmpStatus(output string) (info *statusInfo) {
+	for _, line := range strings.Split(output, "\n") {
+		index := strings.Index(line, "VM status:")
+		if index == -1 {
+			continue
+		}
+
+		status := strings.TrimSpace(line[index+10:])
+		if start := strings.Index(status, "("); start != -1 {
+			status = strings.TrimSuffix(status[start+1:], ")")
+		}
+
+		info = &statusInfo{
+			Status:  status,
+			Running: status == "running",
+		}
+		return
+	}
+
+	return
+}
+
+func hmpBlock(output string) (blks []*blockInfo) {
+	blks = []*blockInfo{}
+	var cur *blockInfo
+
+	for _, line := range strings.Split(output, "\n") {
+		if strings.HasPrefix(line, "virtio") {
+			cur = nil
+
+			lineSpl := strings.SplitN(line, ":", 2)
+			if len(lineSpl) != 2 {
+				continue
+			}
+
+			device := strings.Fields(lineSpl[0])
+			file := strings.Fields(lineSpl[1])
+			if len(device) == 0 || len(file) == 0 {
+				continue
+			}
+
+			cur = &blockInfo{
+				Device: device[0],
+				Inserted: &blockFile{
+					File: file[0],
+				},
+			}
+			blks = append(blks, cur)
+			continue
+		}
+
+		if cur == nil || !strings.Contains(line, "I/O throttling:") {
+			continue
+		}
+
+		for _, field := range strings.Fields(line) {
+			fieldSpl := strings.SplitN(field, "=", 2)
+			if len(fieldSpl) != 2 {
+				continue
+			}
+
+			val, e := strconv.Atoi(fieldSpl[1])
+			if e != nil {
+				continue
+			}
+
+			switch fieldSpl[0] {
+			case "bps":
+				cur.Inserted.Bps = val
+			case "iops":
+				cur.Inserted.Iops = val
+			}
+		}
+	}
+
+	return
+}
+
+func (c *Connection) hmpCommand(execute string, args interface{},
+	resp interface{}) (err error) {
+
+	argsMap, _ := args.(map[string]interface{})
+
+	switch execute {
+	case "system_powerdown", "cont":
+		_, err = c.hmp(execute)
+		if err != nil {
+			return
+		}
+	case "set_password":
+		passwd, _ := argsMap["password"].(string)
+
+		_, err = c.hmp(fmt.Sprintf("change vnc password %s", passwd))
+		if err != nil {
+			return
+		}
+	case "query-status":
+		output, e := c.hmp("info status")
+		if e != nil {
+			err = e
+			return
+		}
+
+		info := hmpStatus(output)
+		if info == nil {
+			err = &errortypes.ParseError{
+				errors.New("qms: Missing virtual machine status"),
+			}
+			return
+		}
+
+		if respInfo, ok := resp.(*statusInfo); ok {
+			*respInfo = *info
+		}
+	case "query-block":
+		output, e := c.hmp("info block")
+		if e != nil {
+			err = e
+			return
+		}
+
+		if respBlks, ok := resp.(*[]*blockInfo); ok {
+			*respBlks = hmpBlock(output)
+		}
+	default:
+		err = &CommandError{
+			DropboxError: errors.Newf(
+				"qms: Command '%s' not supported on human monitor, "+
+					"virtual machine must be restarted", execute,
+			),
+			Command:     execute,
+			Class:       "CommandNotFound",
+			Description: "Command not supported on human monitor",
+		}
+		return
+	}
+
+	return
+}
@@ -0,0 +1,215 @@
+package qms
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/dropbox/godropbox/errors"
+	"github.com/pritunl/mongo-go-driver/bson/primitive"
+	"github.com/pritunl/pritunl-cloud/errortypes"
+)
+
+type hotpluggableCpu struct {
+	Type       string                 `json:"type"`
+	VcpusCount int                    `json:"vcpus-count"`
+	Props      map[string]interface{} `json:"props"`
+	QomPath    string                 `json:"qom-path"`
+}
+
+type memoryDevice struct {
+	Type string `json:"type"`
+}
+
+func AddCpus(vmId primitive.ObjectID, count int) (err error) {
+	logrus.WithFields(logrus.Fields{
+		"instance_id": vmId.Hex(),
+		"processors":  count,
+	}).Info("qms: Adding virtual machine processors")
+
+	conn, err := Connect(vmId)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	cpus := []*hotpluggableCpu{}
+	err = conn.Command("query-hotpluggable-cpus", nil, &cpus)
+	if err != nil {
+		return
+	}
+
+	plugged := 0
+	for _, cpu := range cpus {
+		if cpu.QomPath != "" {
+			plugged += cpu.VcpusCount
+		}
+	}
+
+	// Cpus are listed from the highest index, add from the end to keep
+	// the cpu indexes contiguous
+	for i := len(cpus) - 1; i >= 0 && plugged < count; i-- {
+		cpu := cpus[i]
+		if cpu.QomPath != "" {
+			continue
+		}
+
+		args := map[string]interface{}{
+			"driver": cpu.Type,
+			"id":     fmt.Sprintf("cpu%d", plugged),
+		}
+		for key, val := range cpu.Props {
+			args[key] = val
+		}
+
+		err = conn.Command("device_add", args, nil)
+		if err != nil {
+			return
+		}
+
+		plugged += cpu.VcpusCount
+	}
+
+	if plugged < count {
+		err = &errortypes.ExecError{
+			errors.Newf("qms: Not enough cpu slots to add %d cpus", count),
+		}
+		return
+	}
+
+	return
+}
+
+func AddMemory(vmId primitive.ObjectID, size int, hugepages,
+	numaLocal bool, numaNode int) (err error) {
+
+	logrus.WithFields(logrus.Fields{
+		"instance_id": vmId.Hex(),
+		"memory":      size,
+	}).Info("qms: Adding virtual machine memory")
+
+	conn, err := Connect(vmId)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	devices := []*memoryDevice{}
+	err = conn.Command("query-memory-devices", nil, &devices)
+	if err != nil {
+		return
+	}
+
+	memId := fmt.Sprintf("memdimm%d", len(devices))
+	dimmId := fmt.Sprintf("dimm%d", len(devices))
+
+	args := map[string]interface{}{
+		"qom-type": "memory-backend-ram",
+		"id":       memId,
+		"size":     size * 1048576,
+	}
+	if hugepages {
+		args["qom-type"] = "memory-backend-file"
+		args["mem-path"] = "/dev/hugepages"
+		args["share"] = true
+		args["prealloc"] = true
+	}
+	if numaLocal {
+		args["host-nodes"] = []int{numaNode}
+		args["policy"] = "bind"
+	}
+
+	// Allocating the memory can exceed the default timeout
+	conn.SetTimeout(60 * time.Second)
+
+	err = conn.Command("object-add", args, nil)
+	if err != nil {
+		return
+	}
+
+	err = conn.Command("device_add", map[string]interface{}{
+		"driver": "pc-dimm",
+		"id":     dimmId,
+		"memdev": memId,
+	}, nil)
+	if err != nil {
+		_ = conn.Command("object-del", map[string]interface{}{
+			"id": memId,
+		}, nil)
+		return
+	}
+
+	return
+}
+
+func AddNetwork(vmId primitive.ObjectID, index int, iface, macAddr string) (
+	err error) {
+
+	conn, err := Connect(vmId)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	netId := fmt.Sprintf("net%d", index)
+
+	err = conn.Command("netdev_add", map[string]interface{}{
+		"type":   "tap",
+		"id":     netId,
+		"ifname": iface,
+		"script": "no",
+		"vhost":  true,
+	}, nil)
+	if err != nil {
+		return
+	}
+
+	err = conn.Command("device_add", map[string]interface{}{
+		"driver": "virtio-net-pci",
+		"id":     fmt.Sprintf("nic%d", index),
+		"netdev": netId,
+		"mac":    macAddr,
+	}, nil)
+	if err != nil {
+		_ = conn.Command("netdev_del", map[string]interface{}{
+			"id": netId,
+		}, nil)
+		return
+	}
+
+	return
+}
+
+func RemoveNetwork(vmId primitive.ObjectID, index int) (err error) {
+	conn, err := Connect(vmId)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	devId := fmt.Sprintf("nic%d", index)
+
+	err = conn.Command("device_del", map[string]interface{}{
+		"id": devId,
+	}, nil)
+	if err != nil {
+		return
+	}
+
+	_, err = conn.WaitEvent("DEVICE_DELETED", 10*time.Second,
+		func(evt *Event) bool {
+			return evt.GetString("device") == devId
+		})
+	if err != nil {
+		return
+	}
+
+	err = conn.Command("netdev_del", map[string]interface{}{
+		"id": fmt.Sprintf("net%d", index),
+	}, nil)
+	if err != nil {
+		return
+	}
+
+	return
+}
@@ -0,0 +1,237 @@
+package qms
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/dropbox/godropbox/errors"
+	"github.com/pritunl/mongo-go-driver/bson/primitive"
+	"github.com/pritunl/pritunl-cloud/errortypes"
+)
+
+func getMirrorIds(index int) (jobId, exportName string) {
+	jobId = fmt.Sprintf("mirror_virtio%d", index)
+	exportName = fmt.Sprintf("virtio%d", index)
+	return
+}
+
+func StartMigrateExport(vmId primitive.ObjectID, addr string, port int,
+	indexes []int) (err error) {
+
+	logrus.WithFields(logrus.Fields{
+		"instance_id": vmId.Hex(),
+		"address":     addr,
+		"port":        port,
+	}).Info("qms: Starting virtual machine migration export")
+
+	conn, err := Connect(vmId)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	err = conn.Command("nbd-server-start", map[string]interface{}{
+		"addr": map[string]interface{}{
+			"type": "inet",
+			"data": map[string]interface{}{
+				"host": addr,
+				"port": strconv.Itoa(port),
+			},
+		},
+	}, nil)
+	if err != nil {
+		return
+	}
+
+	for _, index := range indexes {
+		blk, e := getDiskBlock(conn, index)
+		if e != nil {
+			err = e
+			break
+		}
+
+		_, exportName := getMirrorIds(index)
+
+		err = conn.Command("block-export-add", map[string]interface{}{
+			"type":      "nbd",
+			"id":        exportName,
+			"node-name": blk.Inserted.NodeName,
+			"name":      exportName,
+			"writable":  true,
+		}, nil)
+		if err != nil {
+			break
+		}
+	}
+	if err != nil {
+		_ = conn.Command("nbd-server-stop", nil, nil)
+		return
+	}
+
+	return
+}
+
+func StopMigrateExport(vmId primitive.ObjectID) (err error) {
+	conn, err := Connect(vmId)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	err = conn.Command("nbd-server-stop", nil, nil)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func StartMirror(vmId primitive.ObjectID, addr string, port int,
+	indexes []int) (err error) {
+
+	logrus.WithFields(logrus.Fields{
+		"instance_id": vmId.Hex(),
+		"address":     addr,
+		"port":        port,
+	}).Info("qms: Starting virtual machine storage migration")
+
+	conn, err := Connect(vmId)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	for _, index := range indexes {
+		blk, e := getDiskBlock(conn, index)
+		if e != nil {
+			err = e
+			return
+		}
+
+		device := blk.Device
+		if device == "" {
+			device = blk.Inserted.NodeName
+		}
+
+		jobId, exportName := getMirrorIds(index)
+
+		err = conn.Command("drive-mirror", map[string]interface{}{
+			"job-id": jobId,
+			"device": device,
+			"target": fmt.Sprintf("nbd:%s:%d:exportname=%s",
+				addr, port, exportName),
+			"format":       "raw",
+			"sync":         "full",
+			"mode":         "existing",
+			"copy-mode":    "write-blocking",
+			"auto-dismiss": false,
+		}, nil)
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func WaitMirror(vmId primitive.ObjectID, indexes []int,
+	timeout time.Duration) (err error) {
+
+	start := time.Now()
+
+	for {
+		conn, e := Connect(vmId)
+		if e != nil {
+			err = e
+			return
+		}
+
+		ready := true
+		for _, index := range indexes {
+			jobId, _ := getMirrorIds(index)
+
+			job, e := getJob(conn, jobId)
+			if e != nil {
+				err = e
+				break
+			}
+
+			if job == nil {
+				err = &errortypes.NotFoundError{
+					errors.Newf("qms: Mirror job '%s' not found", jobId),
+				}
+				break
+			}
+
+			if job.Status == "concluded" || job.Status == "aborting" {
+				err = &errortypes.WriteError{
+					errors.Newf("qms: Mirror job failed '%s'", job.Error),
+				}
+				break
+			}
+
+			if job.Status != "ready" {
+				ready = false
+			}
+		}
+		conn.Close()
+
+		if err != nil || ready {
+			return
+		}
+
+		if time.Since(start) > timeout {
+			err = &errortypes.TimeoutError{
+				errors.New("qms: Mirror timeout"),
+			}
+			return
+		}
+
+		time.Sleep(1 * time.Second)
+	}
+}
+
+func StopMirror(vmId primitive.ObjectID, indexes []int) (err error) {
+	conn, err := Connect(vmId)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	for _, index := range indexes {
+		jobId, _ := getMirrorIds(index)
+
+		job, e := getJob(conn, jobId)
+		if e != nil {
+			err = e
+			return
+		}
+
+		if job == nil {
+			continue
+		}
+
+		if job.Status != "concluded" {
+			_ = conn.Command("block-job-cancel", map[string]interface{}{
+				"device": jobId,
+			}, nil)
+
+			_, _ = conn.WaitEvent("JOB_STATUS_CHANGE", 30*time.Second,
+				func(evt *Event) bool {
+					return evt.GetString("id") == jobId &&
+						evt.GetString("status") == "concluded"
+				})
+		}
+
+		err = conn.Command("job-dismiss", map[string]interface{}{
+			"id": jobId,
+		}, nil)
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
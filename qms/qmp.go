@@ -0,0 +1,356 @@
+package qms
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/dropbox/godropbox/errors"
+	"github.com/pritunl/mongo-go-driver/bson/primitive"
+	"github.com/pritunl/pritunl-cloud/errortypes"
+	"github.com/pritunl/pritunl-cloud/utils"
+)
+
+var (
+	socketsLock    = utils.NewMultiTimeoutLock(1 * time.Minute)
+	handlers       = map[string][]EventHandler{}
+	handlersLock   = sync.Mutex{}
+	commandTimeout = 3 * time.Second
+)
+
+type EventHandler func(vmId primitive.ObjectID, evt *Event)
+
+type Command struct {
+	Execute   string      `json:"execute"`
+	Arguments interface{} `json:"arguments,omitempty"`
+}
+
+type Timestamp struct {
+	Seconds      int64 `json:"seconds"`
+	Microseconds int64 `json:"microseconds"`
+}
+
+type Event struct {
+	Event     string                 `json:"event"`
+	Data      map[string]interface{} `json:"data"`
+	Timestamp Timestamp              `json:"timestamp"`
+}
+
+func (e *Event) GetString(key string) string {
+	if e.Data == nil {
+		return ""
+	}
+
+	val, _ := e.Data[key].(string)
+	return val
+}
+
+type qmpError struct {
+	Class       string `json:"class"`
+	Description string `json:"desc"`
+}
+
+type message struct {
+	Greeting  json.RawMessage        `json:"QMP"`
+	Return    json.RawMessage        `json:"return"`
+	Error     *qmpError              `json:"error"`
+	Event     string                 `json:"event"`
+	Data      map[string]interface{} `json:"data"`
+	Timestamp Timestamp              `json:"timestamp"`
+}
+
+type CommandError struct {
+	errors.DropboxError
+	Command     string
+	Class       string
+	Description string
+}
+
+func RegisterHandler(evtType string, handler EventHandler) {
+	handlersLock.Lock()
+	handlers[evtType] = append(handlers[evtType], handler)
+	handlersLock.Unlock()
+}
+
+type Connection struct {
+	vmId    primitive.ObjectID
+	lockId  primitive.ObjectID
+	conn    net.Conn
+	reader  *bufio.Reader
+	events  []*Event
+	legacy  bool
+	timeout time.Duration
+}
+
+func (c *Connection) read() (msg *message, err error) {
+	line, err := c.reader.ReadBytes('\n')
+	if err != nil {
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			err = &errortypes.TimeoutError{
+				errors.Wrap(err, "qms: Socket read timeout"),
+			}
+			return
+		}
+
+		err = &errortypes.ReadError{
+			errors.Wrap(err, "qms: Failed to read socket"),
+		}
+		return
+	}
+
+	msg = &message{}
+	err = json.Unmarshal(line, msg)
+	if err != nil {
+		err = &errortypes.ParseError{
+			errors.Wrap(err, "qms: Failed to parse monitor message"),
+		}
+		return
+	}
+
+	if msg.Event != "" {
+		c.events = append(c.events, &Event{
+			Event:     msg.Event,
+			Data:      msg.Data,
+			Timestamp: msg.Timestamp,
+		})
+	}
+
+	return
+}
+
+func (c *Connection) write(cmd *Command) (err error) {
+	cmdByte, err := json.Marshal(cmd)
+	if err != nil {
+		err = &errortypes.ParseError{
+			errors.Wrap(err, "qms: Failed to parse monitor command"),
+		}
+		return
+	}
+
+	_, err = c.conn.Write(append(cmdByte, '\n'))
+	if err != nil {
+		err = &errortypes.WriteError{
+			errors.Wrap(err, "qms: Failed to write socket"),
+		}
+		return
+	}
+
+	return
+}
+
+func (c *Connection) setDeadline(timeout time.Duration) (err error) {
+	err = c.conn.SetDeadline(time.Now().Add(timeout))
+	if err != nil {
+		err = &errortypes.ReadError{
+			errors.Wrap(err, "qms: Failed set deadline"),
+		}
+		return
+	}
+
+	return
+}
+
+func (c *Connection) SetTimeout(timeout time.Duration) {
+	c.timeout = timeout
+}
+
+func (c *Connection) Command(execute string, args interface{},
+	resp interface{}) (err error) {
+
+	if c.legacy {
+		err = c.hmpCommand(execute, args, resp)
+		return
+	}
+
+	err = c.setDeadline(c.timeout)
+	if err != nil {
+		return
+	}
+
+	err = c.write(&Command{
+		Execute:   execute,
+		Arguments: args,
+	})
+	if err != nil {
+		return
+	}
+
+	for {
+		msg, e := c.read()
+		if e != nil {
+			err = e
+			return
+		}
+
+		if msg.Event != "" {
+			continue
+		}
+
+		if msg.Error != nil {
+			err = &CommandError{
+				DropboxError: errors.Newf(
+					"qms: Command '%s' failed with %s '%s'",
+					execute, msg.Error.Class, msg.Error.Description,
+				),
+				Command:     execute,
+				Class:       msg.Error.Class,
+				Description: msg.Error.Description,
+			}
+			return
+		}
+
+		if msg.Return == nil {
+			continue
+		}
+
+		if resp != nil {
+			err = json.Unmarshal(msg.Return, resp)
+			if err != nil {
+				err = &errortypes.ParseError{
+					errors.Wrapf(err,
+						"qms: Failed to parse '%s' response", execute),
+				}
+				return
+			}
+		}
+
+		break
+	}
+
+	return
+}
+
+func (c *Connection) WaitEvent(evtType string, timeout time.Duration,
+	match func(evt *Event) bool) (evt *Event, err error) {
+
+	if c.legacy {
+		err = &errortypes.UnknownError{
+			errors.New("qms: Events not available on human monitor"),
+		}
+		return
+	}
+
+	for i, queued := range c.events {
+		if queued.Event == evtType && (match == nil || match(queued)) {
+			evt = queued
+			c.events = append(c.events[:i], c.events[i+1:]...)
+			return
+		}
+	}
+
+	err = c.setDeadline(timeout)
+	if err != nil {
+		return
+	}
+
+	for {
+		msg, e := c.read()
+		if e != nil {
+			err = e
+			return
+		}
+
+		if msg.Event != evtType {
+			continue
+		}
+
+		queued := c.events[len(c.events)-1]
+		if match == nil || match(queued) {
+			evt = queued
+			c.events = c.events[:len(c.events)-1]
+			return
+		}
+	}
+}
+
+func (c *Connection) Close() {
+	_ = c.conn.Close()
+	socketsLock.Unlock(c.vmId.Hex(), c.lockId)
+}
+
+func Connect(vmId primitive.ObjectID) (c *Connection, err error) {
+	sockPath := GetSockPath(vmId)
+
+	lockId := socketsLock.Lock(vmId.Hex())
+
+	conn, err := net.DialTimeout(
+		"unix",
+		sockPath,
+		1*time.Second,
+	)
+	if err != nil {
+		socketsLock.Unlock(vmId.Hex(), lockId)
+		err = &errortypes.ConnectionError{
+			errors.Wrap(err, "qms: Failed to open socket"),
+		}
+		return
+	}
+
+	c = &Connection{
+		vmId:    vmId,
+		lockId:  lockId,
+		conn:    conn,
+		reader:  bufio.NewReader(conn),
+		events:  []*Event{},
+		timeout: commandTimeout,
+	}
+
+	err = c.setDeadline(commandTimeout)
+	if err != nil {
+		c.Close()
+		c = nil
+		return
+	}
+
+	// Virtual machines started before the switch to QMP have a human
+	// monitor on the socket until restarted
+	start, err := c.reader.Peek(1)
+	if err != nil {
+		c.Close()
+		c = nil
+		err = &errortypes.ReadError{
+			errors.Wrap(err, "qms: Failed to read socket"),
+		}
+		return
+	}
+
+	if start[0] != '{' {
+		c.legacy = true
+
+		_, err = c.hmpRead()
+		if err != nil {
+			c.Close()
+			c = nil
+			return
+		}
+
+		return
+	}
+
+	msg, err := c.read()
+	if err != nil {
+		c.Close()
+		c = nil
+		return
+	}
+
+	if msg.Greeting == nil {
+		c.Close()
+		c = nil
+		err = &errortypes.ParseError{
+			errors.New("qms: Monitor socket did not send QMP greeting"),
+		}
+		return
+	}
+
+	err = c.Command("qmp_capabilities", nil, nil)
+	if err != nil {
+		c.Close()
+		c = nil
+		return
+	}
+
+	return
+}
@@ -1,9 +1,8 @@
 package qms
 
 import (
-	"bytes"
 	"fmt"
-	"net"
+	"path"
 	"strconv"
 	"strings"
 	"time"
@@ -12,295 +11,550 @@ import (
 	"github.com/dropbox/godropbox/errors"
 	"github.com/pritunl/mongo-go-driver/bson/primitive"
 	"github.com/pritunl/pritunl-cloud/errortypes"
-	"github.com/pritunl/pritunl-cloud/utils"
 	"github.com/pritunl/pritunl-cloud/vm"
 )
 
-var (
-	socketsLock = utils.NewMultiTimeoutLock(1 * time.Minute)
-)
+type blockImage struct {
+	VirtualSize int64 `json:"virtual-size"`
+}
 
-func GetDisks(vmId primitive.ObjectID) (disks []*vm.Disk, err error) {
-	sockPath := GetSockPath(vmId)
-	disks = []*vm.Disk{}
+type blockBitmap struct {
+	Name         string `json:"name"`
+	Recording    bool   `json:"recording"`
+	Persistent   bool   `json:"persistent"`
+	Inconsistent bool   `json:"inconsistent"`
+}
 
-	lockId := socketsLock.Lock(vmId.Hex())
-	defer socketsLock.Unlock(vmId.Hex(), lockId)
+type blockFile struct {
+	File     string         `json:"file"`
+	NodeName string         `json:"node-name"`
+	Iops     int            `json:"iops"`
+	Bps      int            `json:"bps"`
+	Image    *blockImage    `json:"image"`
+	Bitmaps  []*blockBitmap `json:"dirty-bitmaps"`
+}
 
-	conn, err := net.DialTimeout(
-		"unix",
-		sockPath,
-		1*time.Second,
-	)
-	if err != nil {
-		err = &errortypes.ReadError{
-			errors.Wrap(err, "qemu: Failed to open socket"),
+func (b *blockFile) GetBitmap(name string) *blockBitmap {
+	for _, bitmap := range b.Bitmaps {
+		if bitmap.Name == name {
+			return bitmap
 		}
+	}
+	return nil
+}
+
+type blockInfo struct {
+	Device   string     `json:"device"`
+	Qdev     string     `json:"qdev"`
+	Inserted *blockFile `json:"inserted"`
+}
+
+type blockStats struct {
+	ReadBytes  int64 `json:"rd_bytes"`
+	WriteBytes int64 `json:"wr_bytes"`
+	ReadOps    int64 `json:"rd_operations"`
+	WriteOps   int64 `json:"wr_operations"`
+}
+
+type blockStatsInfo struct {
+	Device string      `json:"device"`
+	Stats  *blockStats `json:"stats"`
+}
+
+type BlockStats struct {
+	ReadBytes  int64
+	WriteBytes int64
+	ReadOps    int64
+	WriteOps   int64
+}
+
+type cpuInfo struct {
+	CpuIndex int `json:"cpu-index"`
+	ThreadId int `json:"thread-id"`
+}
+
+type statusInfo struct {
+	Status  string `json:"status"`
+	Running bool   `json:"running"`
+}
+
+type migrateInfo struct {
+	Status string `json:"status"`
+}
+
+func getDevicePath(blk *blockInfo) string {
+	return strings.TrimSuffix(blk.Qdev, "/virtio-backend")
+}
+
+func getDiskIndex(blk *blockInfo) (index int, ok bool) {
+	name := blk.Device
+	if name == "" {
+		name = path.Base(getDevicePath(blk))
+	}
+
+	if !strings.HasPrefix(name, "virtio") {
 		return
 	}
-	defer conn.Close()
 
-	err = conn.SetDeadline(time.Now().Add(3 * time.Second))
-	if err != nil {
-		err = &errortypes.ReadError{
-			errors.Wrap(err, "qemu: Failed set deadline"),
-		}
+	index, e := strconv.Atoi(name[6:])
+	if e != nil {
 		return
 	}
+	ok = true
 
-	buffer := make([]byte, 100000)
-	for {
-		buf := make([]byte, 10000)
-		n, e := conn.Read(buf)
-		if e != nil {
-			err = &errortypes.ReadError{
-				errors.Wrap(e, "qemu: Failed to read socket"),
-			}
-			return
-		}
-		buffer = append(buffer, buf[:n]...)
+	return
+}
 
-		if bytes.Contains(bytes.TrimSpace(buffer), []byte("(qemu)")) {
-			break
-		}
+func queryBlock(conn *Connection) (blks []*blockInfo, err error) {
+	blks = []*blockInfo{}
+
+	err = conn.Command("query-block", nil, &blks)
+	if err != nil {
+		return
 	}
 
-	_, err = conn.Write([]byte("info block\n"))
+	return
+}
+
+func getDisk(conn *Connection, index int) (blk *blockInfo, err error) {
+	blks, err := queryBlock(conn)
 	if err != nil {
-		err = &errortypes.ReadError{
-			errors.Wrap(err, "qemu: Failed to write socket"),
-		}
 		return
 	}
 
-	buffer = make([]byte, 100000)
-	for {
-		buf := make([]byte, 10000)
-		n, e := conn.Read(buf)
-		if e != nil {
-			err = &errortypes.ReadError{
-				errors.Wrap(e, "qemu: Failed to read socket"),
-			}
+	for _, b := range blks {
+		i, ok := getDiskIndex(b)
+		if ok && i == index {
+			blk = b
 			return
 		}
-		buffer = append(buffer, buf[:n]...)
+	}
 
-		if bytes.Contains(bytes.TrimSpace(buffer), []byte("(qemu)")) {
-			break
-		}
+	err = &errortypes.NotFoundError{
+		errors.Newf("qms: Failed to find virtual machine disk %d", index),
+	}
+	return
+}
+
+func GetDisks(vmId primitive.ObjectID) (disks []*vm.Disk, err error) {
+	disks = []*vm.Disk{}
+
+	conn, err := Connect(vmId)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	blks, err := queryBlock(conn)
+	if err != nil {
+		return
 	}
 
-	for _, line := range strings.Split(string(buffer), "\n") {
-		if !strings.HasPrefix(line, "virtio") || len(line) < 10 {
+	for _, blk := range blks {
+		if blk.Inserted == nil {
 			continue
 		}
-		line = strings.Replace(line, "\r", "", -1)
-
-		lineSpl := strings.SplitN(line[6:], ":", 2)
-		if len(lineSpl) != 2 {
-			logrus.WithFields(logrus.Fields{
-				"instance_id": vmId.Hex(),
-				"line":        line,
-			}).Error("qemu: Unexpected qemu disk path")
+
+		index, ok := getDiskIndex(blk)
+		if !ok {
 			continue
 		}
 
-		indexStr := strings.Fields(strings.TrimSpace(lineSpl[0]))[0]
-		index, e := strconv.Atoi(indexStr)
-		if e != nil {
-			logrus.WithFields(logrus.Fields{
-				"instance_id": vmId.Hex(),
-				"line":        line,
-			}).Error("qemu: Unexpected qemu disk path index")
+		dsk := &vm.Disk{
+			Index:          index,
+			Path:           blk.Inserted.File,
+			IopsLimit:      blk.Inserted.Iops,
+			BandwidthLimit: blk.Inserted.Bps / 1048576,
+		}
+		disks = append(disks, dsk)
+	}
+
+	return
+}
+
+func GetBlockStats(vmId primitive.ObjectID) (stats *BlockStats, err error) {
+	conn, err := Connect(vmId)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	infos := []*blockStatsInfo{}
+	err = conn.Command("query-blockstats", nil, &infos)
+	if err != nil {
+		return
+	}
+
+	stats = &BlockStats{}
+	for _, info := range infos {
+		if info.Stats == nil {
 			continue
 		}
 
-		diskPath := strings.Fields(strings.TrimSpace(lineSpl[1]))[0]
+		stats.ReadBytes += info.Stats.ReadBytes
+		stats.WriteBytes += info.Stats.WriteBytes
+		stats.ReadOps += info.Stats.ReadOps
+		stats.WriteOps += info.Stats.WriteOps
+	}
+
+	return
+}
 
-		dsk := &vm.Disk{
-			Index: index,
-			Path:  diskPath,
+func GetCpuThreads(vmId primitive.ObjectID) (threads []int, err error) {
+	conn, err := Connect(vmId)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	infos := []*cpuInfo{}
+	err = conn.Command("query-cpus-fast", nil, &infos)
+	if err != nil {
+		return
+	}
+
+	threads = make([]int, len(infos))
+	for _, info := range infos {
+		if info.CpuIndex < 0 || info.CpuIndex >= len(threads) {
+			err = &errortypes.ParseError{
+				errors.New("qms: Invalid virtual cpu index"),
+			}
+			return
 		}
-		disks = append(disks, dsk)
+		threads[info.CpuIndex] = info.ThreadId
 	}
 
 	return
 }
 
 func AddDisk(vmId primitive.ObjectID, dsk *vm.Disk) (err error) {
-	sockPath := GetSockPath(vmId)
-
 	logrus.WithFields(logrus.Fields{
 		"instance_id": vmId.Hex(),
 		"disk_path":   dsk.Path,
-	}).Info("qemu: Connecting virtual machine disk")
+	}).Info("qms: Connecting virtual machine disk")
 
-	lockId := socketsLock.Lock(vmId.Hex())
-	defer socketsLock.Unlock(vmId.Hex(), lockId)
-
-	conn, err := net.DialTimeout(
-		"unix",
-		sockPath,
-		1*time.Second,
-	)
+	conn, err := Connect(vmId)
 	if err != nil {
-		err = &errortypes.ReadError{
-			errors.Wrap(err, "qemu: Failed to open socket"),
-		}
 		return
 	}
 	defer conn.Close()
 
-	err = conn.SetDeadline(time.Now().Add(3 * time.Second))
-	if err != nil {
-		err = &errortypes.ReadError{
-			errors.Wrap(err, "qemu: Failed set deadline"),
+	if conn.legacy {
+		_, err = conn.hmp(fmt.Sprintf("drive_add virtio "+
+			"file=%s,index=%d,media=disk,format=qcow2,discard=on,if=virtio",
+			dsk.Path, dsk.Index))
+		if err != nil {
+			return
 		}
+
+		if dsk.IopsLimit > 0 || dsk.BandwidthLimit > 0 {
+			err = setThrottle(conn, "", dsk)
+			if err != nil {
+				return
+			}
+		}
+
+		return
+	}
+
+	nodeName := fmt.Sprintf("drive_virtio%d", dsk.Index)
+
+	err = conn.Command("blockdev-add", map[string]interface{}{
+		"driver":    "qcow2",
+		"node-name": nodeName,
+		"discard":   "unmap",
+		"file": map[string]interface{}{
+			"driver":   "file",
+			"filename": dsk.Path,
+		},
+	}, nil)
+	if err != nil {
 		return
 	}
 
-	drive := fmt.Sprintf(
-		"file=%s,index=%d,media=disk,format=qcow2,discard=on,if=virtio\n",
-		dsk.Path,
-		dsk.Index,
-	)
+	devId := fmt.Sprintf("virtio%d", dsk.Index)
 
-	_, err = conn.Write([]byte("drive_add virtio " + drive))
+	err = conn.Command("device_add", map[string]interface{}{
+		"driver": "virtio-blk-pci",
+		"id":     devId,
+		"drive":  nodeName,
+	}, nil)
 	if err != nil {
-		err = &errortypes.ReadError{
-			errors.Wrap(err, "qemu: Failed to write socket"),
+		_ = conn.Command("blockdev-del", map[string]interface{}{
+			"node-name": nodeName,
+		}, nil)
+		return
+	}
+
+	if dsk.IopsLimit > 0 || dsk.BandwidthLimit > 0 {
+		err = setThrottle(conn, devId, dsk)
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func setThrottle(conn *Connection, devPath string, dsk *vm.Disk) (
+	err error) {
+
+	if conn.legacy {
+		_, err = conn.hmp(fmt.Sprintf(
+			"block_set_io_throttle virtio%d %d 0 0 %d 0 0",
+			dsk.Index, dsk.BandwidthLimit*1048576, dsk.IopsLimit))
+		if err != nil {
+			return
 		}
+
 		return
 	}
 
-	time.Sleep(1 * time.Second)
+	err = conn.Command("block_set_io_throttle", map[string]interface{}{
+		"id":      devPath + "/virtio-backend",
+		"bps":     dsk.BandwidthLimit * 1048576,
+		"bps_rd":  0,
+		"bps_wr":  0,
+		"iops":    dsk.IopsLimit,
+		"iops_rd": 0,
+		"iops_wr": 0,
+	}, nil)
+	if err != nil {
+		return
+	}
 
 	return
 }
 
-func RemoveDisk(vmId primitive.ObjectID, dsk *vm.Disk) (err error) {
-	sockPath := GetSockPath(vmId)
+func SetDiskLimits(vmId primitive.ObjectID, dsk *vm.Disk) (err error) {
+	logrus.WithFields(logrus.Fields{
+		"instance_id":     vmId.Hex(),
+		"disk_path":       dsk.Path,
+		"iops_limit":      dsk.IopsLimit,
+		"bandwidth_limit": dsk.BandwidthLimit,
+	}).Info("qms: Updating virtual machine disk limits")
 
+	conn, err := Connect(vmId)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	blk, err := getDisk(conn, dsk.Index)
+	if err != nil {
+		return
+	}
+
+	err = setThrottle(conn, getDevicePath(blk), dsk)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func RemoveDisk(vmId primitive.ObjectID, dsk *vm.Disk) (err error) {
 	logrus.WithFields(logrus.Fields{
 		"instance_id": vmId.Hex(),
 		"disk_path":   dsk.Path,
-	}).Info("qemu: Disconnecting virtual machine disk")
-
-	lockId := socketsLock.Lock(vmId.Hex())
-	defer socketsLock.Unlock(vmId.Hex(), lockId)
+	}).Info("qms: Disconnecting virtual machine disk")
 
-	conn, err := net.DialTimeout(
-		"unix",
-		sockPath,
-		1*time.Second,
-	)
+	conn, err := Connect(vmId)
 	if err != nil {
-		err = &errortypes.ReadError{
-			errors.Wrap(err, "qemu: Failed to open socket"),
-		}
 		return
 	}
 	defer conn.Close()
 
-	err = conn.SetDeadline(time.Now().Add(3 * time.Second))
+	blk, err := getDisk(conn, dsk.Index)
 	if err != nil {
-		err = &errortypes.ReadError{
-			errors.Wrap(err, "qemu: Failed set deadline"),
+		return
+	}
+
+	if conn.legacy {
+		_, err = conn.hmp(fmt.Sprintf("drive_del virtio%d", dsk.Index))
+		if err != nil {
+			return
 		}
+
 		return
 	}
 
-	_, err = conn.Write([]byte(
-		fmt.Sprintf("drive_del virtio%d\n", dsk.Index)))
+	devPath := getDevicePath(blk)
+
+	err = conn.Command("device_del", map[string]interface{}{
+		"id": devPath,
+	}, nil)
 	if err != nil {
-		err = &errortypes.ReadError{
-			errors.Wrap(err, "qemu: Failed to write socket"),
-		}
 		return
 	}
 
-	time.Sleep(1 * time.Second)
+	_, err = conn.WaitEvent("DEVICE_DELETED", 10*time.Second,
+		func(evt *Event) bool {
+			return evt.GetString("path") == devPath ||
+				evt.GetString("device") == path.Base(devPath)
+		})
+	if err != nil {
+		return
+	}
+
+	// Drives from the command line are removed with the device, hot
+	// plugged disks have a separate block node
+	nodeName := fmt.Sprintf("drive_virtio%d", dsk.Index)
+	if blk.Inserted != nil && blk.Inserted.NodeName == nodeName {
+		err = conn.Command("blockdev-del", map[string]interface{}{
+			"node-name": nodeName,
+		}, nil)
+		if err != nil {
+			return
+		}
+	}
 
 	return
 }
 
 func Shutdown(vmId primitive.ObjectID) (err error) {
-	sockPath := GetSockPath(vmId)
+	conn, err := Connect(vmId)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	err = conn.Command("system_powerdown", nil, nil)
+	if err != nil {
+		return
+	}
 
-	lockId := socketsLock.Lock(vmId.Hex())
-	defer socketsLock.Unlock(vmId.Hex(), lockId)
+	return
+}
 
-	conn, err := net.DialTimeout(
-		"unix",
-		sockPath,
-		1*time.Second,
-	)
+func VncPassword(vmId primitive.ObjectID, passwd string) (err error) {
+	conn, err := Connect(vmId)
 	if err != nil {
-		err = &errortypes.ReadError{
-			errors.Wrap(err, "qemu: Failed to open socket"),
-		}
 		return
 	}
 	defer conn.Close()
 
-	err = conn.SetDeadline(time.Now().Add(3 * time.Second))
+	err = conn.Command("set_password", map[string]interface{}{
+		"protocol": "vnc",
+		"password": passwd,
+	}, nil)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func IsLegacy(vmId primitive.ObjectID) (legacy bool, err error) {
+	conn, err := Connect(vmId)
 	if err != nil {
-		err = &errortypes.ReadError{
-			errors.Wrap(err, "qemu: Failed set deadline"),
-		}
 		return
 	}
+	defer conn.Close()
+
+	legacy = conn.legacy
 
-	_, err = conn.Write([]byte("system_powerdown\n"))
+	return
+}
+
+func GetStatus(vmId primitive.ObjectID) (status string, err error) {
+	conn, err := Connect(vmId)
 	if err != nil {
-		err = &errortypes.ReadError{
-			errors.Wrap(err, "qemu: Failed to write socket"),
-		}
 		return
 	}
+	defer conn.Close()
 
-	time.Sleep(800 * time.Millisecond)
+	info := &statusInfo{}
+	err = conn.Command("query-status", nil, info)
+	if err != nil {
+		return
+	}
+
+	status = info.Status
+	if status == "" {
+		err = &errortypes.ParseError{
+			errors.New("qms: Missing virtual machine status"),
+		}
+		return
+	}
 
 	return
 }
 
-func VncPassword(vmId primitive.ObjectID, passwd string) (err error) {
-	sockPath := GetSockPath(vmId)
+func Migrate(vmId primitive.ObjectID, addr string, port int) (err error) {
+	logrus.WithFields(logrus.Fields{
+		"instance_id": vmId.Hex(),
+		"address":     addr,
+		"port":        port,
+	}).Info("qms: Starting virtual machine migration")
 
-	lockId := socketsLock.Lock(vmId.Hex())
-	defer socketsLock.Unlock(vmId.Hex(), lockId)
+	conn, err := Connect(vmId)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
 
-	conn, err := net.DialTimeout(
-		"unix",
-		sockPath,
-		1*time.Second,
-	)
+	// Storage is copied separately with mirror jobs
+	err = conn.Command("migrate", map[string]interface{}{
+		"uri": fmt.Sprintf("tcp:%s:%d", addr, port),
+	}, nil)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func GetMigrateStatus(vmId primitive.ObjectID) (status string, err error) {
+	conn, err := Connect(vmId)
 	if err != nil {
-		err = &errortypes.ReadError{
-			errors.Wrap(err, "qemu: Failed to open socket"),
-		}
 		return
 	}
 	defer conn.Close()
 
-	err = conn.SetDeadline(time.Now().Add(3 * time.Second))
+	info := &migrateInfo{}
+	err = conn.Command("query-migrate", nil, info)
 	if err != nil {
-		err = &errortypes.ReadError{
-			errors.Wrap(err, "qemu: Failed set deadline"),
+		return
+	}
+
+	status = info.Status
+	if status == "" {
+		err = &errortypes.ParseError{
+			errors.New("qms: Missing migration status"),
 		}
 		return
 	}
 
-	_, err = conn.Write(
-		[]byte(fmt.Sprintf("change vnc password\n%s\n", passwd)))
+	return
+}
+
+func MigrateCancel(vmId primitive.ObjectID) (err error) {
+	conn, err := Connect(vmId)
 	if err != nil {
-		err = &errortypes.ReadError{
-			errors.Wrap(err, "qemu: Failed to write socket"),
-		}
 		return
 	}
+	defer conn.Close()
 
-	time.Sleep(800 * time.Millisecond)
+	err = conn.Command("migrate_cancel", nil, nil)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func Continue(vmId primitive.ObjectID) (err error) {
+	conn, err := Connect(vmId)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	err = conn.Command("cont", nil, nil)
+	if err != nil {
+		return
+	}
 
 	return
 }
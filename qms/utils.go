@@ -12,3 +12,8 @@ func GetSockPath(virtId primitive.ObjectID) string {
 	return path.Join(settings.Hypervisor.LibPath,
 		fmt.Sprintf("%s.sock", virtId.Hex()))
 }
+
+func GetEventsSockPath(virtId primitive.ObjectID) string {
+	return path.Join(settings.Hypervisor.LibPath,
+		fmt.Sprintf("%s.events", virtId.Hex()))
+}
@@ -25,6 +25,7 @@ import (
 	"github.com/pritunl/pritunl-cloud/database"
 	"github.com/pritunl/pritunl-cloud/errortypes"
 	"github.com/pritunl/pritunl-cloud/event"
+	"github.com/pritunl/pritunl-cloud/exporter"
 	"github.com/pritunl/pritunl-cloud/node"
 	"github.com/pritunl/pritunl-cloud/proxy"
 	"github.com/pritunl/pritunl-cloud/settings"
@@ -534,6 +535,7 @@ func (r *Router) Init() {
 
 	r.proxy = &proxy.Proxy{}
 	r.proxy.Init()
+	exporter.SetProxy(r.proxy)
 
 	r.certificates = &Certificates{}
 }
@@ -0,0 +1,7 @@
+package scheduler
+
+const (
+	BinPack     = "bin_pack"
+	Spread      = "spread"
+	LeastLoaded = "least_loaded"
+)
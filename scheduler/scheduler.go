@@ -0,0 +1,395 @@
+package scheduler
+
+import (
+	"sort"
+	"time"
+
+	"github.com/pritunl/mongo-go-driver/bson"
+	"github.com/pritunl/mongo-go-driver/bson/primitive"
+	"github.com/pritunl/pritunl-cloud/database"
+	"github.com/pritunl/pritunl-cloud/errortypes"
+	"github.com/pritunl/pritunl-cloud/instance"
+	"github.com/pritunl/pritunl-cloud/node"
+	"github.com/pritunl/pritunl-cloud/placement"
+	"github.com/pritunl/pritunl-cloud/settings"
+	"github.com/pritunl/pritunl-cloud/usb"
+	"github.com/pritunl/pritunl-cloud/zone"
+)
+
+type Spec struct {
+	Organization  primitive.ObjectID
+	Instance      primitive.ObjectID
+	Placement     primitive.ObjectID
+	Datacenter    primitive.ObjectID
+	Zone          primitive.ObjectID
+	Strategy      string
+	Hypervisor    string
+	Processors    int
+	Memory        int
+	DedicatedCpus bool
+	Hugepages     bool
+	UsbDevices    []*usb.Device
+	NetworkRoles  []string
+	Exclude       []primitive.ObjectID
+}
+
+type Candidate struct {
+	Node        *node.Node
+	CpuTotal    float64
+	MemoryTotal float64
+	CpuUsed     float64
+	MemoryUsed  float64
+	Instances   int
+	Violation   bool
+	RoleMatch   bool
+	Score       float64
+}
+
+func (c *Candidate) FreeRatio(spec *Spec) float64 {
+	cpuFree := (c.CpuTotal - c.CpuUsed - float64(spec.Processors)) /
+		c.CpuTotal
+	memFree := (c.MemoryTotal - c.MemoryUsed -
+		float64(spec.Memory)/float64(1024)) / c.MemoryTotal
+
+	return (cpuFree + memFree) / 2
+}
+
+type nodeUsage struct {
+	Id         primitive.ObjectID `bson:"_id"`
+	Processors int                `bson:"processors"`
+	Memory     int                `bson:"memory"`
+	Count      int                `bson:"count"`
+}
+
+func getUsage(db *database.Database, ndeIds []primitive.ObjectID) (
+	usage map[primitive.ObjectID]*nodeUsage, err error) {
+
+	usage = map[primitive.ObjectID]*nodeUsage{}
+
+	for _, field := range []string{"node", "migrate_node"} {
+		err = addUsage(db, usage, field, ndeIds)
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func addUsage(db *database.Database,
+	usage map[primitive.ObjectID]*nodeUsage, field string,
+	ndeIds []primitive.ObjectID) (err error) {
+
+	coll := db.Instances()
+
+	cursor, err := coll.Aggregate(db, []*bson.M{
+		&bson.M{
+			"$match": &bson.M{
+				field: &bson.M{
+					"$in": ndeIds,
+				},
+				"state": &bson.M{
+					"$ne": instance.Destroy,
+				},
+			},
+		},
+		&bson.M{
+			"$group": &bson.M{
+				"_id": "$" + field,
+				"processors": &bson.M{
+					"$sum": "$processors",
+				},
+				"memory": &bson.M{
+					"$sum": "$memory",
+				},
+				"count": &bson.M{
+					"$sum": 1,
+				},
+			},
+		},
+	})
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+	defer cursor.Close(db)
+
+	for cursor.Next(db) {
+		ndeUsage := &nodeUsage{}
+		err = cursor.Decode(ndeUsage)
+		if err != nil {
+			err = database.ParseError(err)
+			return
+		}
+
+		curUsage := usage[ndeUsage.Id]
+		if curUsage != nil {
+			curUsage.Processors += ndeUsage.Processors
+			curUsage.Memory += ndeUsage.Memory
+			curUsage.Count += ndeUsage.Count
+		} else {
+			usage[ndeUsage.Id] = ndeUsage
+		}
+	}
+
+	err = cursor.Err()
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
+	return
+}
+
+func hasUsbDevices(nde *node.Node, devices []*usb.Device) bool {
+	if len(devices) == 0 {
+		return true
+	}
+
+	if !nde.UsbPassthrough || nde.UsbDevices == nil {
+		return false
+	}
+
+	for _, device := range devices {
+		found := false
+		for _, ndeDevice := range nde.UsbDevices {
+			if ndeDevice.Vendor == device.Vendor &&
+				ndeDevice.Product == device.Product {
+
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+func hasNetworkRole(nde *node.Node, roles []string) bool {
+	for _, role := range roles {
+		for _, ndeRole := range nde.NetworkRoles {
+			if role == ndeRole {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func GetCandidates(db *database.Database, spec *Spec,
+	plc *placement.Placement) (cands []*Candidate, err error) {
+
+	cands = []*Candidate{}
+
+	zoneIds := []primitive.ObjectID{}
+	if !spec.Zone.IsZero() {
+		zoneIds = append(zoneIds, spec.Zone)
+	} else {
+		znes, e := zone.GetAllDatacenter(db, spec.Datacenter)
+		if e != nil {
+			err = e
+			return
+		}
+
+		for _, zne := range znes {
+			zoneIds = append(zoneIds, zne.Id)
+		}
+	}
+
+	if len(zoneIds) == 0 {
+		return
+	}
+
+	ndes, err := node.GetAllZones(db, zoneIds)
+	if err != nil {
+		return
+	}
+
+	exclude := map[primitive.ObjectID]bool{}
+	for _, ndeId := range spec.Exclude {
+		exclude[ndeId] = true
+	}
+
+	ndeIds := []primitive.ObjectID{}
+	for _, nde := range ndes {
+		ndeIds = append(ndeIds, nde.Id)
+	}
+
+	usage, err := getUsage(db, ndeIds)
+	if err != nil {
+		return
+	}
+
+	var members []*placement.Member
+	if plc != nil {
+		members, err = plc.GetMembers(db, spec.Instance)
+		if err != nil {
+			return
+		}
+	}
+
+	cpuOvercommit := float64(settings.Hypervisor.CpuOvercommit) / 100
+	memOvercommit := float64(settings.Hypervisor.MemOvercommit) / 100
+
+	for _, nde := range ndes {
+		if exclude[nde.Id] || !nde.IsHypervisor() ||
+			nde.Fenced || nde.Maintenance ||
+			time.Since(nde.Timestamp) > 30*time.Second ||
+			nde.CpuUnits == 0 || nde.MemoryUnits == 0 {
+
+			continue
+		}
+
+		if spec.Hypervisor != "" && nde.Hypervisor != spec.Hypervisor {
+			continue
+		}
+
+		if !hasUsbDevices(nde, spec.UsbDevices) {
+			continue
+		}
+
+		if spec.DedicatedCpus && nde.GetCpusFree() < spec.Processors {
+			continue
+		}
+
+		if spec.Hugepages && nde.GetHugepagesFree() < spec.Memory {
+			continue
+		}
+
+		violation := false
+		if plc != nil && !plc.Satisfied(members, nde.Id, nde.Zone) {
+			if plc.Enforcement == placement.Hard {
+				continue
+			}
+			violation = true
+		}
+
+		cand := &Candidate{
+			Node:        nde,
+			CpuTotal:    float64(nde.CpuUnits) * cpuOvercommit,
+			MemoryTotal: nde.MemoryUnits * memOvercommit,
+			Violation:   violation,
+			RoleMatch:   hasNetworkRole(nde, spec.NetworkRoles),
+		}
+
+		ndeUsage := usage[nde.Id]
+		if ndeUsage != nil {
+			cand.CpuUsed = float64(ndeUsage.Processors)
+			cand.MemoryUsed = float64(ndeUsage.Memory) / float64(1024)
+			cand.Instances = ndeUsage.Count
+		}
+
+		if cand.CpuUsed+float64(spec.Processors) > cand.CpuTotal ||
+			cand.MemoryUsed+float64(spec.Memory)/float64(1024) >
+				cand.MemoryTotal {
+
+			continue
+		}
+
+		cands = append(cands, cand)
+	}
+
+	return
+}
+
+func Schedule(db *database.Database, spec *Spec) (nde *node.Node,
+	errData *errortypes.ErrorData, err error) {
+
+	if spec.Zone.IsZero() && spec.Datacenter.IsZero() {
+		errData = &errortypes.ErrorData{
+			Error:   "zone_required",
+			Message: "Missing required zone or datacenter",
+		}
+		return
+	}
+
+	if spec.Processors < 1 {
+		spec.Processors = 1
+	}
+	if spec.Memory < 256 {
+		spec.Memory = 256
+	}
+
+	if spec.Strategy == "" {
+		spec.Strategy = settings.Hypervisor.Placement
+	}
+
+	strategy := strategies[spec.Strategy]
+	if strategy == nil {
+		errData = &errortypes.ErrorData{
+			Error:   "placement_strategy_invalid",
+			Message: "Invalid placement strategy",
+		}
+		return
+	}
+
+	switch spec.Hypervisor {
+	case "", node.Kvm, node.Qemu:
+		break
+	default:
+		errData = &errortypes.ErrorData{
+			Error:   "hypervisor_invalid",
+			Message: "Invalid hypervisor type",
+		}
+		return
+	}
+
+	var plc *placement.Placement
+	if !spec.Placement.IsZero() {
+		plc, err = placement.GetOrg(db, spec.Organization, spec.Placement)
+		if err != nil {
+			if _, ok := err.(*database.NotFoundError); ok {
+				err = nil
+				errData = &errortypes.ErrorData{
+					Error:   "placement_not_found",
+					Message: "Placement group not found",
+				}
+			}
+			return
+		}
+	}
+
+	cands, err := GetCandidates(db, spec, plc)
+	if err != nil {
+		return
+	}
+
+	if len(cands) == 0 {
+		errData = &errortypes.ErrorData{
+			Error:   "node_unavailable",
+			Message: "No node available with required resources",
+		}
+		return
+	}
+
+	for _, cand := range cands {
+		cand.Score = strategy.Score(cand, spec)
+	}
+
+	sort.SliceStable(cands, func(i, j int) bool {
+		if cands[i].Violation != cands[j].Violation {
+			return !cands[i].Violation
+		}
+
+		iKvm := cands[i].Node.Hypervisor == node.Kvm
+		jKvm := cands[j].Node.Hypervisor == node.Kvm
+		if iKvm != jKvm {
+			return iKvm
+		}
+
+		if cands[i].RoleMatch != cands[j].RoleMatch {
+			return cands[i].RoleMatch
+		}
+
+		return cands[i].Score > cands[j].Score
+	})
+
+	nde = cands[0].Node
+
+	return
+}
@@ -0,0 +1,40 @@
+package scheduler
+
+var strategies = map[string]Strategy{}
+
+type Strategy interface {
+	Score(cand *Candidate, spec *Spec) float64
+}
+
+type binPack struct{}
+
+func (s *binPack) Score(cand *Candidate, spec *Spec) float64 {
+	return 1 - cand.FreeRatio(spec)
+}
+
+type spread struct{}
+
+func (s *spread) Score(cand *Candidate, spec *Spec) float64 {
+	return cand.FreeRatio(spec) - float64(cand.Instances)/1000
+}
+
+type leastLoaded struct{}
+
+func (s *leastLoaded) Score(cand *Candidate, spec *Spec) float64 {
+	load := cand.Node.Load5
+	if cand.Node.CpuUnits > 0 {
+		load = load / float64(cand.Node.CpuUnits)
+	}
+
+	return cand.FreeRatio(spec) - load
+}
+
+func register(name string, strategy Strategy) {
+	strategies[name] = strategy
+}
+
+func init() {
+	register(BinPack, &binPack{})
+	register(Spread, &spread{})
+	register(LeastLoaded, &leastLoaded{})
+}
@@ -3,17 +3,35 @@ package settings
 var Hypervisor *hypervisor
 
 type hypervisor struct {
-	Id              string `bson:"_id"`
-	SystemdPath     string `bson:"systemd_path" default:"/etc/systemd/system"`
-	LibPath         string `bson:"systemd_path" default:"/var/lib/pritunl-cloud"`
-	NormalMtu       int    `bson:"normal_mtu" default:"1500"`
-	JumboMtu        int    `bson:"jumbo_mtu" default:"9000"`
-	VxlanId         int    `bson:"vxlan_id" default:"9417"`
-	VxlanDestPort   int    `bson:"vxlan_dest_port" default:"4789"`
-	HostNetworkName string `bson:"host_network_name" default:"pritunlhost0"`
-	StartTimeout    int    `bson:"start_timeout" default:"45"`
-	StopTimeout     int    `bson:"stop_timeout" default:"90"`
-	RefreshRate     int    `bson:"refresh_rate" default:"90"`
+	Id                 string `bson:"_id"`
+	SystemdPath        string `bson:"systemd_path" default:"/etc/systemd/system"`
+	LibPath            string `bson:"systemd_path" default:"/var/lib/pritunl-cloud"`
+	NormalMtu          int    `bson:"normal_mtu" default:"1500"`
+	JumboMtu           int    `bson:"jumbo_mtu" default:"9000"`
+	VxlanId            int    `bson:"vxlan_id" default:"9417"`
+	VxlanDestPort      int    `bson:"vxlan_dest_port" default:"4789"`
+	HostNetworkName    string `bson:"host_network_name" default:"pritunlhost0"`
+	StartTimeout       int    `bson:"start_timeout" default:"45"`
+	StopTimeout        int    `bson:"stop_timeout" default:"90"`
+	RefreshRate        int    `bson:"refresh_rate" default:"90"`
+	MigratePortMin     int    `bson:"migrate_port_min" default:"34000"`
+	MigratePortMax     int    `bson:"migrate_port_max" default:"34999"`
+	MigrateTimeout     int    `bson:"migrate_timeout" default:"3600"`
+	Placement          string `bson:"placement" default:"least_loaded"`
+	CpuOvercommit      int    `bson:"cpu_overcommit" default:"400"`
+	MemOvercommit      int    `bson:"mem_overcommit" default:"100"`
+	FenceTimeout       int    `bson:"fence_timeout" default:"120"`
+	FailoverBackoff    int    `bson:"failover_backoff" default:"60"`
+	FailoverBackoffMax int    `bson:"failover_backoff_max" default:"3600"`
+	DrainMigrations    int    `bson:"drain_migrations" default:"2"`
+	ConsolePort        int    `bson:"console_port" default:"9790"`
+	FreezeTimeout      int    `bson:"freeze_timeout" default:"30"`
+	SerialLogSize      int    `bson:"serial_log_size" default:"10"`
+	BackupKeyPath      string `bson:"backup_key_path"`
+	OvmfCodePath       string `bson:"ovmf_code_path" default:"/usr/share/edk2/ovmf/OVMF_CODE.fd"`
+	OvmfVarsPath       string `bson:"ovmf_vars_path" default:"/usr/share/edk2/ovmf/OVMF_VARS.fd"`
+	OvmfSecureCodePath string `bson:"ovmf_secure_code_path" default:"/usr/share/edk2/ovmf/OVMF_CODE.secboot.fd"`
+	OvmfSecureVarsPath string `bson:"ovmf_secure_vars_path" default:"/usr/share/edk2/ovmf/OVMF_VARS.secboot.fd"`
 }
 
 func newHypervisor() interface{} {
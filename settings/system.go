@@ -9,18 +9,21 @@ const (
 var System *system
 
 type system struct {
-	Id                   string `bson:"_id"`
-	Name                 string `bson:"name"`
-	DatabaseVersion      int    `bson:"database_version"`
-	Demo                 bool   `bson:"demo"`
-	License              string `bson:"license"`
-	AdminCookieAuthKey   []byte `bson:"admin_cookie_auth_key"`
-	AdminCookieCryptoKey []byte `bson:"admin_cookie_crypto_key"`
-	UserCookieAuthKey    []byte `bson:"user_cookie_auth_key"`
-	UserCookieCryptoKey  []byte `bson:"user_cookie_crypto_key"`
-	AcmeKeyAlgorithm     string `bson:"acme_key_algorithm" default:"rsa"`
-	DiskBackupWindow     int    `bson:"disk_backup_window" default:"6"`
-	DiskBackupTime       int    `bson:"disk_backup_time" default:"10"`
+	Id                   string   `bson:"_id"`
+	Name                 string   `bson:"name"`
+	DatabaseVersion      int      `bson:"database_version"`
+	Demo                 bool     `bson:"demo"`
+	License              string   `bson:"license"`
+	AdminCookieAuthKey   []byte   `bson:"admin_cookie_auth_key"`
+	AdminCookieCryptoKey []byte   `bson:"admin_cookie_crypto_key"`
+	UserCookieAuthKey    []byte   `bson:"user_cookie_auth_key"`
+	UserCookieCryptoKey  []byte   `bson:"user_cookie_crypto_key"`
+	AcmeKeyAlgorithm     string   `bson:"acme_key_algorithm" default:"rsa"`
+	DiskBackupWindow     int      `bson:"disk_backup_window" default:"6"`
+	DiskBackupTime       int      `bson:"disk_backup_time" default:"10"`
+	DiskBackupChain      int      `bson:"disk_backup_chain" default:"6"`
+	BackupEncryption     bool     `bson:"backup_encryption"`
+	BackupMasterKeys     [][]byte `bson:"backup_master_keys"`
 }
 
 func newSystem() interface{} {
@@ -0,0 +1,24 @@
+package settings
+
+var Telemetry *telemetry
+
+type telemetry struct {
+	Id                 string   `bson:"_id"`
+	PrometheusPort     int      `bson:"prometheus_port" default:"9180"`
+	PrometheusToken    string   `bson:"prometheus_token"`
+	PrometheusNetworks []string `bson:"prometheus_networks"`
+}
+
+func newTelemetry() interface{} {
+	return &telemetry{
+		Id: "telemetry",
+	}
+}
+
+func updateTelemetry(data interface{}) {
+	Telemetry = data.(*telemetry)
+}
+
+func init() {
+	register("telemetry", newTelemetry, updateTelemetry)
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/dropbox/godropbox/errors"
 	"github.com/pritunl/mongo-go-driver/bson"
 	"github.com/pritunl/mongo-go-driver/bson/primitive"
+	"github.com/pritunl/pritunl-cloud/backup"
 	"github.com/pritunl/pritunl-cloud/block"
 	"github.com/pritunl/pritunl-cloud/database"
 	"github.com/pritunl/pritunl-cloud/disk"
@@ -37,6 +38,7 @@ type State struct {
 	nodeFirewall     []*firewall.Rule
 	firewalls        map[string][]*firewall.Rule
 	disks            []*disk.Disk
+	backupPolicies   map[primitive.ObjectID]*backup.Policy
 	virtsMap         map[primitive.ObjectID]*vm.VirtualMachine
 	instances        []*instance.Instance
 	instancesMap     map[primitive.ObjectID]*instance.Instance
@@ -109,6 +111,10 @@ func (s *State) Disks() []*disk.Disk {
 	return s.disks
 }
 
+func (s *State) BackupPolicy(plcyId primitive.ObjectID) *backup.Policy {
+	return s.backupPolicies[plcyId]
+}
+
 func (s *State) GetInstaceDisks(instId primitive.ObjectID) []*disk.Disk {
 	return s.instanceDisks[instId]
 }
@@ -222,6 +228,31 @@ func (s *State) init() (err error) {
 	}
 	s.disks = disks
 
+	plcyIds := []primitive.ObjectID{}
+	for _, dsk := range disks {
+		if !dsk.BackupPolicy.IsZero() {
+			plcyIds = append(plcyIds, dsk.BackupPolicy)
+		}
+	}
+
+	backupPolicies := map[primitive.ObjectID]*backup.Policy{}
+	if len(plcyIds) > 0 {
+		plcys, e := backup.GetAll(db, &bson.M{
+			"_id": &bson.M{
+				"$in": plcyIds,
+			},
+		})
+		if e != nil {
+			err = e
+			return
+		}
+
+		for _, plcy := range plcys {
+			backupPolicies[plcy.Id] = plcy
+		}
+	}
+	s.backupPolicies = backupPolicies
+
 	instanceDisks := map[primitive.ObjectID][]*disk.Disk{}
 	for _, dsk := range disks {
 		dsks := instanceDisks[dsk.Instance]
@@ -230,10 +261,33 @@ func (s *State) init() (err error) {
 		}
 		instanceDisks[dsk.Instance] = append(dsks, dsk)
 	}
+
+	migrateInsts, err := instance.GetAll(db, &bson.M{
+		"migrate_node": s.nodeSelf.Id,
+	})
+	if err != nil {
+		return
+	}
+
+	for _, inst := range migrateInsts {
+		dsks, e := disk.GetInstance(db, inst.Id)
+		if e != nil {
+			err = e
+			return
+		}
+		instanceDisks[inst.Id] = dsks
+	}
 	s.instanceDisks = instanceDisks
 
 	instances, err := instance.GetAllVirtMapped(db, &bson.M{
-		"node": s.nodeSelf.Id,
+		"$or": []*bson.M{
+			&bson.M{
+				"node": s.nodeSelf.Id,
+			},
+			&bson.M{
+				"migrate_node": s.nodeSelf.Id,
+			},
+		},
 	}, instanceDisks)
 	s.instances = instances
 
@@ -0,0 +1,44 @@
+package store
+
+import (
+	"sync"
+
+	"github.com/pritunl/mongo-go-driver/bson/primitive"
+)
+
+var (
+	fenceStores     = map[primitive.ObjectID]bool{}
+	fenceStoresLock = sync.Mutex{}
+)
+
+func GetFenced() (virtIds []primitive.ObjectID) {
+	virtIds = []primitive.ObjectID{}
+
+	fenceStoresLock.Lock()
+	for virtId := range fenceStores {
+		virtIds = append(virtIds, virtId)
+	}
+	fenceStoresLock.Unlock()
+
+	return
+}
+
+func IsFenced(virtId primitive.ObjectID) (fenced bool) {
+	fenceStoresLock.Lock()
+	fenced = fenceStores[virtId]
+	fenceStoresLock.Unlock()
+
+	return
+}
+
+func SetFenced(virtId primitive.ObjectID) {
+	fenceStoresLock.Lock()
+	fenceStores[virtId] = true
+	fenceStoresLock.Unlock()
+}
+
+func RemFenced(virtId primitive.ObjectID) {
+	fenceStoresLock.Lock()
+	delete(fenceStores, virtId)
+	fenceStoresLock.Unlock()
+}
@@ -0,0 +1,216 @@
+package sync
+
+import (
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/dropbox/godropbox/container/set"
+	"github.com/pritunl/mongo-go-driver/bson"
+	"github.com/pritunl/mongo-go-driver/bson/primitive"
+	"github.com/pritunl/pritunl-cloud/database"
+	"github.com/pritunl/pritunl-cloud/event"
+	"github.com/pritunl/pritunl-cloud/instance"
+	"github.com/pritunl/pritunl-cloud/node"
+	"github.com/pritunl/pritunl-cloud/scheduler"
+	"github.com/pritunl/pritunl-cloud/settings"
+)
+
+var (
+	drainAttempted = set.NewSet()
+)
+
+func drainMigrate(db *database.Database, inst *instance.Instance) (
+	migrating bool, err error) {
+
+	nde, errData, err := scheduler.Schedule(db, &scheduler.Spec{
+		Organization:  inst.Organization,
+		Instance:      inst.Id,
+		Placement:     inst.Placement,
+		Hypervisor:    node.Self.Hypervisor,
+		Zone:          inst.Zone,
+		Processors:    inst.Processors,
+		Memory:        inst.Memory,
+		UsbDevices:    inst.UsbDevices,
+		NetworkRoles:  inst.NetworkRoles,
+		DedicatedCpus: inst.DedicatedCpus,
+		Hugepages:     inst.Hugepages,
+		Exclude: []primitive.ObjectID{
+			node.Self.Id,
+		},
+	})
+	if err != nil {
+		return
+	}
+
+	if errData == nil {
+		errData, err = inst.Migrate(db, nde.Id)
+		if err != nil {
+			return
+		}
+	}
+
+	if errData != nil {
+		logrus.WithFields(logrus.Fields{
+			"instance_id": inst.Id.Hex(),
+			"reason":      errData.Message,
+		}).Warning("sync: Unable to migrate instance for node drain")
+		return
+	}
+
+	err = inst.CommitFields(db, set.NewSet(
+		"state",
+		"migrate_node",
+		"migrate_addr",
+		"migrate_port",
+		"migrate_nbd_port",
+	))
+	if err != nil {
+		return
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"instance_id":  inst.Id.Hex(),
+		"migrate_node": nde.Id.Hex(),
+	}).Info("sync: Migrating instance for node drain")
+
+	migrating = true
+
+	return
+}
+
+func drainStop(db *database.Database, inst *instance.Instance) (
+	err error) {
+
+	inst.State = instance.Stop
+	inst.Restart = false
+	inst.RestartBlockIp = false
+
+	err = inst.CommitFields(db, set.NewSet(
+		"state",
+		"restart",
+		"restart_block_ip",
+	))
+	if err != nil {
+		return
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"instance_id": inst.Id.Hex(),
+	}).Info("sync: Stopping instance for node drain")
+
+	return
+}
+
+func drainSync() (err error) {
+	draining, drainRemaining := node.Self.GetDrain()
+	if !draining {
+		if drainAttempted.Len() > 0 {
+			drainAttempted = set.NewSet()
+		}
+		return
+	}
+
+	db := database.GetDatabase()
+	defer db.Close()
+
+	insts, err := instance.GetAll(db, &bson.M{
+		"node": node.Self.Id,
+	})
+	if err != nil {
+		return
+	}
+
+	remaining := 0
+	migrations := 0
+	pending := []*instance.Instance{}
+
+	for _, inst := range insts {
+		if inst.State == instance.Migrate {
+			remaining += 1
+			migrations += 1
+			continue
+		}
+
+		if inst.IsActive() {
+			remaining += 1
+		}
+
+		if inst.State == instance.Start || inst.State == instance.Restart {
+			pending = append(pending, inst)
+		}
+	}
+
+	changed := false
+	for _, inst := range pending {
+		if inst.DrainPolicy == instance.DrainMigrate &&
+			!drainAttempted.Contains(inst.Id) {
+
+			if migrations >= settings.Hypervisor.DrainMigrations {
+				continue
+			}
+			drainAttempted.Add(inst.Id)
+
+			migrating, e := drainMigrate(db, inst)
+			if e != nil {
+				err = e
+				return
+			}
+
+			if migrating {
+				migrations += 1
+				changed = true
+				continue
+			}
+		}
+
+		err = drainStop(db, inst)
+		if err != nil {
+			return
+		}
+		changed = true
+	}
+
+	if changed {
+		event.PublishDispatch(db, "instance.change")
+	}
+
+	if remaining == drainRemaining && remaining != 0 {
+		return
+	}
+
+	err = node.Self.SetDrain(db, remaining != 0, remaining)
+	if err != nil {
+		return
+	}
+
+	if remaining == 0 {
+		logrus.Info("sync: Node drain complete")
+	}
+
+	event.PublishDispatch(db, "node.change")
+
+	return
+}
+
+func drainRunner() {
+	time.Sleep(1 * time.Second)
+
+	for {
+		time.Sleep(3 * time.Second)
+
+		if !node.Self.IsHypervisor() {
+			continue
+		}
+
+		err := drainSync()
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err,
+			}).Error("sync: Failed to drain node")
+		}
+	}
+}
+
+func initDrain() {
+	go drainRunner()
+}
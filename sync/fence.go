@@ -0,0 +1,243 @@
+package sync
+
+import (
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/dropbox/godropbox/container/set"
+	"github.com/pritunl/mongo-go-driver/bson"
+	"github.com/pritunl/mongo-go-driver/bson/primitive"
+	"github.com/pritunl/pritunl-cloud/database"
+	"github.com/pritunl/pritunl-cloud/disk"
+	"github.com/pritunl/pritunl-cloud/event"
+	"github.com/pritunl/pritunl-cloud/instance"
+	"github.com/pritunl/pritunl-cloud/node"
+	"github.com/pritunl/pritunl-cloud/paths"
+	"github.com/pritunl/pritunl-cloud/qemu"
+	"github.com/pritunl/pritunl-cloud/settings"
+	"github.com/pritunl/pritunl-cloud/store"
+	"github.com/pritunl/pritunl-cloud/systemd"
+)
+
+var (
+	fenceInstances = set.NewSet()
+	fenceActive    = false
+)
+
+func fenceSelf() {
+	if fenceActive {
+		return
+	}
+	fenceActive = true
+
+	logrus.Error("sync: Node heartbeat lost, fencing high " +
+		"availability instances")
+
+	for instIdInf := range fenceInstances.Iter() {
+		instId := instIdInf.(primitive.ObjectID)
+
+		store.SetFenced(instId)
+
+		err := systemd.Stop(paths.GetUnitName(instId))
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"instance_id": instId.Hex(),
+				"error":       err,
+			}).Error("sync: Failed to fence instance")
+		}
+	}
+}
+
+func fenceRecover(db *database.Database) (err error) {
+	virts, err := qemu.GetVms(db, nil)
+	if err != nil {
+		return
+	}
+
+	virtIds := []primitive.ObjectID{}
+	for _, virt := range virts {
+		virtIds = append(virtIds, virt.Id)
+	}
+
+	insts, err := instance.GetAll(db, &bson.M{
+		"_id": &bson.M{
+			"$in": virtIds,
+		},
+	})
+	if err != nil {
+		return
+	}
+
+	instsMap := map[primitive.ObjectID]*instance.Instance{}
+	for _, inst := range insts {
+		instsMap[inst.Id] = inst
+	}
+
+	for _, virt := range virts {
+		inst := instsMap[virt.Id]
+		if inst == nil || inst.Node == node.Self.Id ||
+			inst.MigrateNode == node.Self.Id {
+
+			continue
+		}
+
+		logrus.WithFields(logrus.Fields{
+			"instance_id": inst.Id.Hex(),
+			"node_id":     inst.Node.Hex(),
+		}).Warning("sync: Removing instance failed over from fenced node")
+
+		err = qemu.MigrateCleanup(db, virt)
+		if err != nil {
+			return
+		}
+	}
+
+	err = node.ClearFence(db, node.Self.Id)
+	if err != nil {
+		return
+	}
+
+	err = instance.FailoverClear(db, node.Self.Id)
+	if err != nil {
+		return
+	}
+
+	logrus.Warning("sync: Cleared node fence")
+
+	event.PublishDispatch(db, "node.change")
+
+	return
+}
+
+func fenceRestart(db *database.Database) (err error) {
+	instIds := store.GetFenced()
+	if len(instIds) == 0 {
+		return
+	}
+
+	disks, err := disk.GetNode(db, node.Self.Id)
+	if err != nil {
+		return
+	}
+
+	insts, err := instance.GetAllVirt(db, &bson.M{
+		"_id": &bson.M{
+			"$in": instIds,
+		},
+	}, disks)
+	if err != nil {
+		return
+	}
+
+	instsMap := map[primitive.ObjectID]*instance.Instance{}
+	for _, inst := range insts {
+		instsMap[inst.Id] = inst
+	}
+
+	for _, instId := range instIds {
+		inst := instsMap[instId]
+		if inst == nil || inst.Node != node.Self.Id ||
+			inst.State != instance.Start {
+
+			store.RemFenced(instId)
+			continue
+		}
+
+		logrus.WithFields(logrus.Fields{
+			"instance_id": inst.Id.Hex(),
+		}).Warning("sync: Restarting fenced instance")
+
+		e := qemu.PowerOn(db, inst, inst.Virt)
+		if e != nil {
+			logrus.WithFields(logrus.Fields{
+				"instance_id": inst.Id.Hex(),
+				"error":       e,
+			}).Error("sync: Failed to restart fenced instance")
+
+			e = instance.SetState(db, inst.Id, instance.Stop)
+			if e != nil {
+				logrus.WithFields(logrus.Fields{
+					"instance_id": inst.Id.Hex(),
+					"error":       e,
+				}).Error("sync: Failed to set instance state")
+				continue
+			}
+		}
+
+		store.RemFenced(instId)
+	}
+
+	event.PublishDispatch(db, "instance.change")
+
+	return
+}
+
+func fenceSync() (err error) {
+	heartbeat := node.Self.LastHeartbeat()
+	if heartbeat.IsZero() {
+		return
+	}
+
+	timeout := time.Duration(
+		settings.Hypervisor.FenceTimeout) * time.Second / 2
+
+	if time.Since(heartbeat) > timeout {
+		fenceSelf()
+		return
+	}
+	fenceActive = false
+
+	db := database.GetDatabase()
+	defer db.Close()
+
+	if node.Self.Fenced {
+		err = fenceRecover(db)
+		if err != nil {
+			return
+		}
+	}
+
+	err = fenceRestart(db)
+	if err != nil {
+		return
+	}
+
+	insts, err := instance.GetAll(db, &bson.M{
+		"node":              node.Self.Id,
+		"high_availability": true,
+	})
+	if err != nil {
+		return
+	}
+
+	instIds := set.NewSet()
+	for _, inst := range insts {
+		instIds.Add(inst.Id)
+	}
+	fenceInstances = instIds
+
+	return
+}
+
+func fenceRunner() {
+	time.Sleep(1 * time.Second)
+
+	for {
+		time.Sleep(2 * time.Second)
+
+		if !node.Self.IsHypervisor() {
+			continue
+		}
+
+		err := fenceSync()
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err,
+			}).Error("sync: Failed to sync node fence")
+		}
+	}
+}
+
+func initFence() {
+	go fenceRunner()
+}
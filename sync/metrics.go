@@ -0,0 +1,267 @@
+package sync
+
+import (
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/dropbox/godropbox/errors"
+	"github.com/pritunl/mongo-go-driver/bson/primitive"
+	"github.com/pritunl/pritunl-cloud/database"
+	"github.com/pritunl/pritunl-cloud/errortypes"
+	"github.com/pritunl/pritunl-cloud/metric"
+	"github.com/pritunl/pritunl-cloud/node"
+	"github.com/pritunl/pritunl-cloud/paths"
+	"github.com/pritunl/pritunl-cloud/qemu"
+	"github.com/pritunl/pritunl-cloud/qms"
+	"github.com/pritunl/pritunl-cloud/utils"
+	"github.com/pritunl/pritunl-cloud/vm"
+)
+
+const clockTicks = 100
+
+var (
+	metricsCounters = map[primitive.ObjectID]*counters{}
+)
+
+type counters struct {
+	Timestamp    time.Time
+	CpuTicks     int64
+	Memory       int64
+	DiskRead     int64
+	DiskWrite    int64
+	DiskReadOps  int64
+	DiskWriteOps int64
+	NetRx        int64
+	NetTx        int64
+	NetRxPackets int64
+	NetTxPackets int64
+}
+
+func readProcess(virtId primitive.ObjectID, cnts *counters) (err error) {
+	pidData, err := ioutil.ReadFile(paths.GetPidPath(virtId))
+	if err != nil {
+		err = &errortypes.ReadError{
+			errors.Wrap(err, "sync: Failed to read pid file"),
+		}
+		return
+	}
+	pid := strings.TrimSpace(string(pidData))
+
+	statData, err := ioutil.ReadFile("/proc/" + pid + "/stat")
+	if err != nil {
+		err = &errortypes.ReadError{
+			errors.Wrap(err, "sync: Failed to read process stat"),
+		}
+		return
+	}
+
+	// Skip process name which may contain spaces
+	stat := string(statData)
+	fields := strings.Fields(stat[strings.LastIndex(stat, ")")+1:])
+	if len(fields) < 13 {
+		err = &errortypes.ParseError{
+			errors.New("sync: Invalid process stat"),
+		}
+		return
+	}
+
+	utime, _ := strconv.ParseInt(fields[11], 10, 64)
+	stime, _ := strconv.ParseInt(fields[12], 10, 64)
+	cnts.CpuTicks = utime + stime
+
+	statusData, err := ioutil.ReadFile("/proc/" + pid + "/status")
+	if err != nil {
+		err = &errortypes.ReadError{
+			errors.Wrap(err, "sync: Failed to read process status"),
+		}
+		return
+	}
+
+	for _, line := range strings.Split(string(statusData), "\n") {
+		if !strings.HasPrefix(line, "VmRSS:") {
+			continue
+		}
+
+		lineSpl := strings.Fields(line)
+		if len(lineSpl) > 1 {
+			cnts.Memory, _ = strconv.ParseInt(lineSpl[1], 10, 64)
+		}
+		break
+	}
+
+	return
+}
+
+func readNetwork(virt *vm.VirtualMachine, cnts *counters) (err error) {
+	for i := range virt.NetworkAdapters {
+		namespace := vm.GetNamespace(virt.Id, i)
+		statsPath := "/sys/class/net/" + vm.GetIface(virt.Id, i) +
+			"/statistics/"
+
+		output, e := utils.ExecOutput("",
+			"ip", "netns", "exec", namespace,
+			"cat",
+			statsPath+"rx_bytes",
+			statsPath+"tx_bytes",
+			statsPath+"rx_packets",
+			statsPath+"tx_packets",
+		)
+		if e != nil {
+			err = e
+			return
+		}
+
+		vals := strings.Fields(output)
+		if len(vals) != 4 {
+			err = &errortypes.ParseError{
+				errors.New("sync: Invalid interface statistics"),
+			}
+			return
+		}
+
+		// Tap counters are from the host side, received by the tap
+		// interface is transmitted by the instance
+		tx, _ := strconv.ParseInt(vals[0], 10, 64)
+		rx, _ := strconv.ParseInt(vals[1], 10, 64)
+		txPackets, _ := strconv.ParseInt(vals[2], 10, 64)
+		rxPackets, _ := strconv.ParseInt(vals[3], 10, 64)
+
+		cnts.NetRx += rx
+		cnts.NetTx += tx
+		cnts.NetRxPackets += rxPackets
+		cnts.NetTxPackets += txPackets
+	}
+
+	return
+}
+
+func getCounters(virt *vm.VirtualMachine) (cnts *counters, err error) {
+	cnts = &counters{
+		Timestamp: time.Now(),
+	}
+
+	err = readProcess(virt.Id, cnts)
+	if err != nil {
+		return
+	}
+
+	blkStats, err := qms.GetBlockStats(virt.Id)
+	if err != nil {
+		return
+	}
+
+	cnts.DiskRead = blkStats.ReadBytes
+	cnts.DiskWrite = blkStats.WriteBytes
+	cnts.DiskReadOps = blkStats.ReadOps
+	cnts.DiskWriteOps = blkStats.WriteOps
+
+	err = readNetwork(virt, cnts)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func rate(cur, prev int64, secs float64) float64 {
+	if cur < prev {
+		return 0
+	}
+	return float64(cur-prev) / secs
+}
+
+func metricsSync() (err error) {
+	db := database.GetDatabase()
+	defer db.Close()
+
+	virts, err := qemu.GetVms(db, nil)
+	if err != nil {
+		return
+	}
+
+	newCounters := map[primitive.ObjectID]*counters{}
+
+	for _, virt := range virts {
+		if virt.State != vm.Running {
+			continue
+		}
+
+		cnts, e := getCounters(virt)
+		if e != nil {
+			logrus.WithFields(logrus.Fields{
+				"instance_id": virt.Id.Hex(),
+				"error":       e,
+			}).Warning("sync: Failed to collect instance metrics")
+			continue
+		}
+		newCounters[virt.Id] = cnts
+
+		prev := metricsCounters[virt.Id]
+		if prev == nil || cnts.CpuTicks < prev.CpuTicks {
+			continue
+		}
+
+		secs := cnts.Timestamp.Sub(prev.Timestamp).Seconds()
+		if secs <= 0 {
+			continue
+		}
+
+		cpuTime := float64(cnts.CpuTicks-prev.CpuTicks) / clockTicks
+		cpuUsage := 0.0
+		if virt.Processors > 0 {
+			cpuUsage = cpuTime / secs / float64(virt.Processors) * 100
+		}
+
+		mtrc := &metric.Metric{
+			Instance:     virt.Id,
+			Node:         node.Self.Id,
+			Timestamp:    cnts.Timestamp,
+			CpuTime:      cpuTime,
+			CpuUsage:     cpuUsage,
+			Memory:       float64(cnts.Memory) / 1024,
+			DiskRead:     rate(cnts.DiskRead, prev.DiskRead, secs),
+			DiskWrite:    rate(cnts.DiskWrite, prev.DiskWrite, secs),
+			DiskReadOps:  rate(cnts.DiskReadOps, prev.DiskReadOps, secs),
+			DiskWriteOps: rate(cnts.DiskWriteOps, prev.DiskWriteOps, secs),
+			NetRx:        rate(cnts.NetRx, prev.NetRx, secs),
+			NetTx:        rate(cnts.NetTx, prev.NetTx, secs),
+			NetRxPackets: rate(cnts.NetRxPackets, prev.NetRxPackets, secs),
+			NetTxPackets: rate(cnts.NetTxPackets, prev.NetTxPackets, secs),
+		}
+
+		err = mtrc.Insert(db)
+		if err != nil {
+			return
+		}
+	}
+
+	metricsCounters = newCounters
+
+	return
+}
+
+func metricsRunner() {
+	time.Sleep(1 * time.Second)
+
+	for {
+		time.Sleep(60 * time.Second)
+
+		if !node.Self.IsHypervisor() {
+			continue
+		}
+
+		err := metricsSync()
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err,
+			}).Error("sync: Failed to sync instance metrics")
+		}
+	}
+}
+
+func initMetrics() {
+	go metricsRunner()
+}
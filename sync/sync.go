@@ -4,5 +4,8 @@ func Init() {
 	initAuth()
 	initNode()
 	initVm()
+	initFence()
+	initDrain()
+	initMetrics()
 	initLink()
 }
@@ -0,0 +1,80 @@
+package task
+
+import (
+	"github.com/Sirupsen/logrus"
+	"github.com/pritunl/mongo-go-driver/bson"
+	"github.com/pritunl/pritunl-cloud/backup"
+	"github.com/pritunl/pritunl-cloud/data"
+	"github.com/pritunl/pritunl-cloud/database"
+	"github.com/pritunl/pritunl-cloud/disk"
+	"github.com/pritunl/pritunl-cloud/event"
+	"github.com/pritunl/pritunl-cloud/image"
+)
+
+var backupPrune = &Task{
+	Name: "backup_prune",
+	Hours: []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11,
+		12, 13, 14, 15, 16, 17, 18, 19, 20, 21, 22, 23},
+	Mins:    []int{40},
+	Handler: backupPruneHandler,
+}
+
+func backupPruneHandler(db *database.Database) (err error) {
+	plcys, err := backup.GetAll(db, &bson.M{})
+	if err != nil {
+		return
+	}
+
+	pruned := false
+	for _, plcy := range plcys {
+		dsks, e := disk.GetAll(db, &bson.M{
+			"backup_policy": plcy.Id,
+		})
+		if e != nil {
+			err = e
+			return
+		}
+
+		for _, dsk := range dsks {
+			imgs, e := image.GetDisk(db, dsk.Id)
+			if e != nil {
+				err = e
+				return
+			}
+
+			for _, img := range plcy.Expired(imgs) {
+				logrus.WithFields(logrus.Fields{
+					"disk_id":          dsk.Id.Hex(),
+					"image_id":         img.Id.Hex(),
+					"backup_policy_id": plcy.Id.Hex(),
+				}).Info("task: Removing expired backup")
+
+				_, e = data.DeleteImage(db, img.Id, true)
+				if e != nil {
+					if _, ok := e.(*database.NotFoundError); ok {
+						continue
+					}
+
+					logrus.WithFields(logrus.Fields{
+						"disk_id":  dsk.Id.Hex(),
+						"image_id": img.Id.Hex(),
+						"error":    e,
+					}).Error("task: Failed to remove expired backup")
+					continue
+				}
+
+				pruned = true
+			}
+		}
+	}
+
+	if pruned {
+		event.PublishDispatch(db, "image.change")
+	}
+
+	return
+}
+
+func init() {
+	register(backupPrune)
+}
@@ -0,0 +1,26 @@
+package task
+
+import (
+	"github.com/pritunl/pritunl-cloud/database"
+	"github.com/pritunl/pritunl-cloud/ha"
+)
+
+var haFailover = &Task{
+	Name:    "ha_failover",
+	Hours:   AllHours,
+	Mins:    AllMins,
+	Handler: haFailoverHandler,
+}
+
+func haFailoverHandler(db *database.Database) (err error) {
+	err = ha.Failover(db)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func init() {
+	register(haFailover)
+}
@@ -0,0 +1,28 @@
+package task
+
+import (
+	"time"
+
+	"github.com/pritunl/pritunl-cloud/database"
+	"github.com/pritunl/pritunl-cloud/metric"
+)
+
+var metricDownsample = &Task{
+	Name:    "metric_downsample",
+	Hours:   AllHours,
+	Mins:    []int{5},
+	Handler: metricDownsampleHandler,
+}
+
+func metricDownsampleHandler(db *database.Database) (err error) {
+	err = metric.Downsample(db, time.Now().Add(-1*time.Hour))
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func init() {
+	register(metricDownsample)
+}
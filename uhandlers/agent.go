@@ -0,0 +1,106 @@
+package uhandlers
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/pritunl/mongo-go-driver/bson/primitive"
+	"github.com/pritunl/pritunl-cloud/audit"
+	"github.com/pritunl/pritunl-cloud/authorizer"
+	"github.com/pritunl/pritunl-cloud/console"
+	"github.com/pritunl/pritunl-cloud/database"
+	"github.com/pritunl/pritunl-cloud/demo"
+	"github.com/pritunl/pritunl-cloud/errortypes"
+	"github.com/pritunl/pritunl-cloud/instance"
+	"github.com/pritunl/pritunl-cloud/qga"
+	"github.com/pritunl/pritunl-cloud/utils"
+	"github.com/pritunl/pritunl-cloud/vm"
+)
+
+func instanceAgentPost(c *gin.Context) {
+	if demo.Blocked(c) {
+		return
+	}
+
+	db := c.MustGet("db").(*database.Database)
+	userOrg := c.MustGet("organization").(primitive.ObjectID)
+	authr := c.MustGet("authorizer").(*authorizer.Authorizer)
+	data := &console.AgentRequest{}
+
+	instanceId, ok := utils.ParseObjectId(c.Param("instance_id"))
+	if !ok {
+		utils.AbortWithStatus(c, 400)
+		return
+	}
+
+	err := c.Bind(data)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	errData := data.Validate()
+	if errData != nil {
+		c.JSON(400, errData)
+		return
+	}
+
+	if data.Operation == qga.Freeze || data.Operation == qga.Thaw {
+		errData = &errortypes.ErrorData{
+			Error:   "agent_operation_invalid",
+			Message: "Agent operation not permitted",
+		}
+		c.JSON(400, errData)
+		return
+	}
+
+	usr, err := authr.GetUser(db)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	inst, err := instance.GetOrg(db, userOrg, instanceId)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	if inst.VmState != vm.Running {
+		errData = &errortypes.ErrorData{
+			Error:   "instance_not_running",
+			Message: "Instance must be running",
+		}
+		c.JSON(400, errData)
+		return
+	}
+
+	result, errData, err := console.RunAgent(db, inst, data)
+
+	fields := audit.Fields{
+		"instance_id":     inst.Id,
+		"organization_id": userOrg,
+	}
+	data.AuditFields(fields, result, errData, err)
+
+	e := audit.New(
+		db,
+		c.Request,
+		usr.Id,
+		audit.UserAgent,
+		fields,
+	)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+	if e != nil {
+		utils.AbortWithError(c, 500, e)
+		return
+	}
+
+	if errData != nil {
+		c.JSON(400, errData)
+		return
+	}
+
+	c.Data(200, "application/json; charset=utf-8", result)
+}
@@ -0,0 +1,286 @@
+package uhandlers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/dropbox/godropbox/container/set"
+	"github.com/dropbox/godropbox/errors"
+	"github.com/gin-gonic/gin"
+	"github.com/pritunl/mongo-go-driver/bson"
+	"github.com/pritunl/mongo-go-driver/bson/primitive"
+	"github.com/pritunl/pritunl-cloud/backup"
+	"github.com/pritunl/pritunl-cloud/database"
+	"github.com/pritunl/pritunl-cloud/demo"
+	"github.com/pritunl/pritunl-cloud/errortypes"
+	"github.com/pritunl/pritunl-cloud/event"
+	"github.com/pritunl/pritunl-cloud/utils"
+)
+
+type backupPolicyData struct {
+	Id            primitive.ObjectID `json:"id"`
+	Name          string             `json:"name"`
+	Comment       string             `json:"comment"`
+	Frequency     string             `json:"frequency"`
+	StartHour     int                `json:"start_hour"`
+	Window        int                `json:"window"`
+	Weekday       int                `json:"weekday"`
+	MonthDay      int                `json:"month_day"`
+	RetainHourly  int                `json:"retain_hourly"`
+	RetainDaily   int                `json:"retain_daily"`
+	RetainWeekly  int                `json:"retain_weekly"`
+	RetainMonthly int                `json:"retain_monthly"`
+}
+
+type backupPoliciesData struct {
+	Policies []*backup.Policy `json:"backup_policies"`
+	Count    int64            `json:"count"`
+}
+
+func backupPolicyPut(c *gin.Context) {
+	if demo.Blocked(c) {
+		return
+	}
+
+	db := c.MustGet("db").(*database.Database)
+	userOrg := c.MustGet("organization").(primitive.ObjectID)
+	data := &backupPolicyData{}
+
+	plcyId, ok := utils.ParseObjectId(c.Param("backup_policy_id"))
+	if !ok {
+		utils.AbortWithStatus(c, 400)
+		return
+	}
+
+	err := c.Bind(data)
+	if err != nil {
+		err = &errortypes.ParseError{
+			errors.Wrap(err, "handler: Bind error"),
+		}
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	plcy, err := backup.GetOrg(db, userOrg, plcyId)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	plcy.Name = data.Name
+	plcy.Comment = data.Comment
+	plcy.Frequency = data.Frequency
+	plcy.StartHour = data.StartHour
+	plcy.Window = data.Window
+	plcy.Weekday = data.Weekday
+	plcy.MonthDay = data.MonthDay
+	plcy.RetainHourly = data.RetainHourly
+	plcy.RetainDaily = data.RetainDaily
+	plcy.RetainWeekly = data.RetainWeekly
+	plcy.RetainMonthly = data.RetainMonthly
+
+	fields := set.NewSet(
+		"name",
+		"comment",
+		"frequency",
+		"start_hour",
+		"window",
+		"weekday",
+		"month_day",
+		"retain_hourly",
+		"retain_daily",
+		"retain_weekly",
+		"retain_monthly",
+	)
+
+	errData, err := plcy.Validate(db)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	if errData != nil {
+		c.JSON(400, errData)
+		return
+	}
+
+	err = plcy.CommitFields(db, fields)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	event.PublishDispatch(db, "backup_policy.change")
+
+	c.JSON(200, plcy)
+}
+
+func backupPolicyPost(c *gin.Context) {
+	if demo.Blocked(c) {
+		return
+	}
+
+	db := c.MustGet("db").(*database.Database)
+	userOrg := c.MustGet("organization").(primitive.ObjectID)
+	data := &backupPolicyData{
+		Name: "New Backup Policy",
+	}
+
+	err := c.Bind(data)
+	if err != nil {
+		err = &errortypes.ParseError{
+			errors.Wrap(err, "handler: Bind error"),
+		}
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	plcy := &backup.Policy{
+		Name:          data.Name,
+		Comment:       data.Comment,
+		Organization:  userOrg,
+		Frequency:     data.Frequency,
+		StartHour:     data.StartHour,
+		Window:        data.Window,
+		Weekday:       data.Weekday,
+		MonthDay:      data.MonthDay,
+		RetainHourly:  data.RetainHourly,
+		RetainDaily:   data.RetainDaily,
+		RetainWeekly:  data.RetainWeekly,
+		RetainMonthly: data.RetainMonthly,
+	}
+
+	errData, err := plcy.Validate(db)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	if errData != nil {
+		c.JSON(400, errData)
+		return
+	}
+
+	err = plcy.Insert(db)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	event.PublishDispatch(db, "backup_policy.change")
+
+	c.JSON(200, plcy)
+}
+
+func backupPolicyDelete(c *gin.Context) {
+	if demo.Blocked(c) {
+		return
+	}
+
+	db := c.MustGet("db").(*database.Database)
+	userOrg := c.MustGet("organization").(primitive.ObjectID)
+
+	plcyId, ok := utils.ParseObjectId(c.Param("backup_policy_id"))
+	if !ok {
+		utils.AbortWithStatus(c, 400)
+		return
+	}
+
+	err := backup.RemoveOrg(db, userOrg, plcyId)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	event.PublishDispatch(db, "backup_policy.change")
+
+	c.JSON(200, nil)
+}
+
+func backupPoliciesDelete(c *gin.Context) {
+	if demo.Blocked(c) {
+		return
+	}
+
+	db := c.MustGet("db").(*database.Database)
+	userOrg := c.MustGet("organization").(primitive.ObjectID)
+	data := []primitive.ObjectID{}
+
+	err := c.Bind(&data)
+	if err != nil {
+		err = &errortypes.ParseError{
+			errors.Wrap(err, "handler: Bind error"),
+		}
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	err = backup.RemoveMultiOrg(db, userOrg, data)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	event.PublishDispatch(db, "backup_policy.change")
+
+	c.JSON(200, nil)
+}
+
+func backupPolicyGet(c *gin.Context) {
+	db := c.MustGet("db").(*database.Database)
+	userOrg := c.MustGet("organization").(primitive.ObjectID)
+
+	plcyId, ok := utils.ParseObjectId(c.Param("backup_policy_id"))
+	if !ok {
+		utils.AbortWithStatus(c, 400)
+		return
+	}
+
+	plcy, err := backup.GetOrg(db, userOrg, plcyId)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	c.JSON(200, plcy)
+}
+
+func backupPoliciesGet(c *gin.Context) {
+	db := c.MustGet("db").(*database.Database)
+	userOrg := c.MustGet("organization").(primitive.ObjectID)
+
+	page, _ := strconv.ParseInt(c.Query("page"), 10, 0)
+	pageCount, _ := strconv.ParseInt(c.Query("page_count"), 10, 0)
+
+	query := bson.M{
+		"organization": userOrg,
+	}
+
+	plcyId, ok := utils.ParseObjectId(c.Query("id"))
+	if ok {
+		query["_id"] = plcyId
+	}
+
+	name := strings.TrimSpace(c.Query("name"))
+	if name != "" {
+		query["name"] = &bson.M{
+			"$regex":   fmt.Sprintf(".*%s.*", name),
+			"$options": "i",
+		}
+	}
+
+	plcys, count, err := backup.GetAllPaged(db, &query, page,
+		pageCount)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	data := &backupPoliciesData{
+		Policies: plcys,
+		Count:    count,
+	}
+
+	c.JSON(200, data)
+}
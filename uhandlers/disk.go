@@ -11,6 +11,7 @@ import (
 	"github.com/pritunl/mongo-go-driver/bson"
 	"github.com/pritunl/mongo-go-driver/bson/primitive"
 	"github.com/pritunl/pritunl-cloud/aggregate"
+	"github.com/pritunl/pritunl-cloud/backup"
 	"github.com/pritunl/pritunl-cloud/data"
 	"github.com/pritunl/pritunl-cloud/database"
 	"github.com/pritunl/pritunl-cloud/datacenter"
@@ -40,6 +41,7 @@ type diskData struct {
 	State            string             `json:"state"`
 	Size             int                `json:"size"`
 	Backup           bool               `json:"backup"`
+	BackupPolicy     primitive.ObjectID `json:"backup_policy"`
 }
 
 type disksMultiData struct {
@@ -89,6 +91,7 @@ func diskPut(c *gin.Context) {
 		"delete_protection",
 		"index",
 		"backup",
+		"backup_policy",
 	)
 
 	if !dta.Instance.IsZero() {
@@ -102,12 +105,25 @@ func diskPut(c *gin.Context) {
 		}
 	}
 
+	if !dta.BackupPolicy.IsZero() {
+		exists, err := backup.ExistsOrg(db, userOrg, dta.BackupPolicy)
+		if err != nil {
+			utils.AbortWithError(c, 500, err)
+			return
+		}
+		if !exists {
+			utils.AbortWithStatus(c, 405)
+			return
+		}
+	}
+
 	dsk.Name = dta.Name
 	dsk.Comment = dta.Comment
 	dsk.Instance = dta.Instance
 	dsk.DeleteProtection = dta.DeleteProtection
 	dsk.Index = dta.Index
 	dsk.Backup = dta.Backup
+	dsk.BackupPolicy = dta.BackupPolicy
 
 	if dsk.State == disk.Available && dta.State == disk.Snapshot {
 		dsk.State = disk.Snapshot
@@ -199,6 +215,18 @@ func diskPost(c *gin.Context) {
 		}
 	}
 
+	if !dta.BackupPolicy.IsZero() {
+		exists, err := backup.ExistsOrg(db, userOrg, dta.BackupPolicy)
+		if err != nil {
+			utils.AbortWithError(c, 500, err)
+			return
+		}
+		if !exists {
+			utils.AbortWithStatus(c, 405)
+			return
+		}
+	}
+
 	nde, err := node.Get(db, dta.Node)
 	if err != nil {
 		utils.AbortWithError(c, 500, err)
@@ -269,6 +297,7 @@ func diskPost(c *gin.Context) {
 		Backing:          dta.Backing,
 		Size:             dta.Size,
 		Backup:           dta.Backup,
+		BackupPolicy:     dta.BackupPolicy,
 	}
 
 	errData, err := dsk.Validate(db)
@@ -105,6 +105,20 @@ func Register(engine *gin.Engine) {
 	orgGroup.DELETE("/firewall", firewallsDelete)
 	orgGroup.DELETE("/firewall/:firewall_id", firewallDelete)
 
+	orgGroup.GET("/backup_policy", backupPoliciesGet)
+	orgGroup.GET("/backup_policy/:backup_policy_id", backupPolicyGet)
+	orgGroup.PUT("/backup_policy/:backup_policy_id", backupPolicyPut)
+	orgGroup.POST("/backup_policy", backupPolicyPost)
+	orgGroup.DELETE("/backup_policy", backupPoliciesDelete)
+	orgGroup.DELETE("/backup_policy/:backup_policy_id", backupPolicyDelete)
+
+	orgGroup.GET("/placement", placementsGet)
+	orgGroup.GET("/placement/:placement_id", placementGet)
+	orgGroup.PUT("/placement/:placement_id", placementPut)
+	orgGroup.POST("/placement", placementPost)
+	orgGroup.DELETE("/placement", placementsDelete)
+	orgGroup.DELETE("/placement/:placement_id", placementDelete)
+
 	orgGroup.GET("/image", imagesGet)
 	orgGroup.GET("/image/:image_id", imageGet)
 	orgGroup.PUT("/image/:image_id", imagePut)
@@ -114,7 +128,15 @@ func Register(engine *gin.Engine) {
 	orgGroup.GET("/instance", instancesGet)
 	orgGroup.PUT("/instance", instancesPut)
 	orgGroup.GET("/instance/:instance_id", instanceGet)
+	orgGroup.GET("/instance/:instance_id/metrics", instanceMetricsGet)
+	orgGroup.GET("/instance/:instance_id/console", instanceConsoleGet)
+	orgGroup.GET("/instance/:instance_id/console/log",
+		instanceConsoleLogGet)
+	orgGroup.POST("/instance/:instance_id/vnc", instanceVncPost)
+	orgGroup.GET("/instance/:instance_id/vnc", instanceVncGet)
+	orgGroup.POST("/instance/:instance_id/agent", instanceAgentPost)
 	orgGroup.PUT("/instance/:instance_id", instancePut)
+	orgGroup.PUT("/instance/:instance_id/migrate", instanceMigratePut)
 	orgGroup.POST("/instance", instancePost)
 	orgGroup.DELETE("/instance", instancesDelete)
 	orgGroup.DELETE("/instance/:instance_id", instanceDelete)
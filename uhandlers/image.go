@@ -21,9 +21,10 @@ import (
 )
 
 type imageData struct {
-	Id      primitive.ObjectID `json:"id"`
-	Name    string             `json:"name"`
-	Comment string             `json:"comment"`
+	Id       primitive.ObjectID `json:"id"`
+	Name     string             `json:"name"`
+	Comment  string             `json:"comment"`
+	Firmware string             `json:"firmware"`
 }
 
 type imagesData struct {
@@ -63,10 +64,12 @@ func imagePut(c *gin.Context) {
 
 	img.Name = dta.Name
 	img.Comment = dta.Comment
+	img.Firmware = dta.Firmware
 
 	fields := set.NewSet(
 		"name",
 		"comment",
+		"firmware",
 	)
 
 	errData, err := img.Validate(db)
@@ -105,12 +108,19 @@ func imageDelete(c *gin.Context) {
 		return
 	}
 
-	err := data.DeleteImageOrg(db, userOrg, imageId)
+	chain := c.Query("chain") == "true"
+
+	errData, err := data.DeleteImageOrg(db, userOrg, imageId, chain)
 	if err != nil {
 		utils.AbortWithError(c, 500, err)
 		return
 	}
 
+	if errData != nil {
+		c.JSON(400, errData)
+		return
+	}
+
 	event.PublishDispatch(db, "image.change")
 
 	c.JSON(200, nil)
@@ -131,12 +141,19 @@ func imagesDelete(c *gin.Context) {
 		return
 	}
 
-	err = data.DeleteImagesOrg(db, userOrg, dta)
+	chain := c.Query("chain") == "true"
+
+	errData, err := data.DeleteImagesOrg(db, userOrg, dta, chain)
 	if err != nil {
 		utils.AbortWithError(c, 500, err)
 		return
 	}
 
+	if errData != nil {
+		c.JSON(400, errData)
+		return
+	}
+
 	event.PublishDispatch(db, "image.change")
 
 	c.JSON(200, nil)
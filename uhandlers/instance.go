@@ -20,7 +20,10 @@ import (
 	"github.com/pritunl/pritunl-cloud/event"
 	"github.com/pritunl/pritunl-cloud/image"
 	"github.com/pritunl/pritunl-cloud/instance"
+	"github.com/pritunl/pritunl-cloud/metric"
 	"github.com/pritunl/pritunl-cloud/node"
+	"github.com/pritunl/pritunl-cloud/placement"
+	"github.com/pritunl/pritunl-cloud/scheduler"
 	"github.com/pritunl/pritunl-cloud/storage"
 	"github.com/pritunl/pritunl-cloud/usb"
 	"github.com/pritunl/pritunl-cloud/utils"
@@ -30,27 +33,50 @@ import (
 )
 
 type instanceData struct {
-	Id               primitive.ObjectID `json:"id"`
-	Zone             primitive.ObjectID `json:"zone"`
-	Vpc              primitive.ObjectID `json:"vpc"`
-	Subnet           primitive.ObjectID `json:"subnet"`
-	Node             primitive.ObjectID `json:"node"`
-	Image            primitive.ObjectID `json:"image"`
-	ImageBacking     bool               `json:"image_backing"`
-	Domain           primitive.ObjectID `json:"domain"`
-	Name             string             `json:"name"`
-	Comment          string             `json:"comment"`
-	State            string             `json:"state"`
-	DeleteProtection bool               `json:"delete_protection"`
-	InitDiskSize     int                `json:"init_disk_size"`
-	Memory           int                `json:"memory"`
-	Processors       int                `json:"processors"`
-	NetworkRoles     []string           `json:"network_roles"`
-	UsbDevices       []*usb.Device      `json:"usb_devices"`
-	Vnc              bool               `json:"vnc"`
-	NoPublicAddress  bool               `json:"no_public_address"`
-	NoHostAddress    bool               `json:"no_host_address"`
-	Count            int                `json:"count"`
+	Id               primitive.ObjectID  `json:"id"`
+	Datacenter       primitive.ObjectID  `json:"datacenter"`
+	Zone             primitive.ObjectID  `json:"zone"`
+	Vpc              primitive.ObjectID  `json:"vpc"`
+	Subnet           primitive.ObjectID  `json:"subnet"`
+	Node             primitive.ObjectID  `json:"node"`
+	Image            primitive.ObjectID  `json:"image"`
+	ImageBacking     bool                `json:"image_backing"`
+	Domain           primitive.ObjectID  `json:"domain"`
+	Placement        primitive.ObjectID  `json:"placement"`
+	Strategy         string              `json:"strategy"`
+	Hypervisor       string              `json:"hypervisor"`
+	HighAvailability bool                `json:"high_availability"`
+	DrainPolicy      string              `json:"drain_policy"`
+	Name             string              `json:"name"`
+	Comment          string              `json:"comment"`
+	UserData         string              `json:"user_data"`
+	PreFreezeHook    string              `json:"pre_freeze_hook"`
+	PostThawHook     string              `json:"post_thaw_hook"`
+	State            string              `json:"state"`
+	DeleteProtection bool                `json:"delete_protection"`
+	InitDiskSize     int                 `json:"init_disk_size"`
+	Memory           int                 `json:"memory"`
+	Processors       int                 `json:"processors"`
+	MaxMemory        int                 `json:"max_memory"`
+	MaxProcessors    int                 `json:"max_processors"`
+	NetworkRoles     []string            `json:"network_roles"`
+	Adapters         []*instance.Adapter `json:"adapters"`
+	UsbDevices       []*usb.Device       `json:"usb_devices"`
+	Vnc              bool                `json:"vnc"`
+	Firmware         string              `json:"firmware"`
+	Tpm              bool                `json:"tpm"`
+	CpuModel         string              `json:"cpu_model"`
+	MachineType      string              `json:"machine_type"`
+	Sockets          int                 `json:"sockets"`
+	Cores            int                 `json:"cores"`
+	Threads          int                 `json:"threads"`
+	NestedVirt       bool                `json:"nested_virt"`
+	DedicatedCpus    bool                `json:"dedicated_cpus"`
+	NumaLocal        bool                `json:"numa_local"`
+	Hugepages        bool                `json:"hugepages"`
+	NoPublicAddress  bool                `json:"no_public_address"`
+	NoHostAddress    bool                `json:"no_host_address"`
+	Count            int                 `json:"count"`
 }
 
 type instanceMultiData struct {
@@ -103,6 +129,18 @@ func instancePut(c *gin.Context) {
 		return
 	}
 
+	for _, adapter := range dta.Adapters {
+		exists, err = vpc.ExistsOrg(db, userOrg, adapter.Vpc)
+		if err != nil {
+			utils.AbortWithError(c, 500, err)
+			return
+		}
+		if !exists {
+			utils.AbortWithStatus(c, 405)
+			return
+		}
+	}
+
 	if !dta.Domain.IsZero() {
 		exists, err := domain.ExistsOrg(db, userOrg, dta.Domain)
 		if err != nil {
@@ -115,10 +153,34 @@ func instancePut(c *gin.Context) {
 		}
 	}
 
+	if !dta.Placement.IsZero() {
+		exists, err := placement.ExistsOrg(db, userOrg, dta.Placement)
+		if err != nil {
+			utils.AbortWithError(c, 500, err)
+			return
+		}
+		if !exists {
+			utils.AbortWithStatus(c, 405)
+			return
+		}
+	}
+
+	if dta.State == instance.Migrate && inst.State != instance.Migrate {
+		errData := &errortypes.ErrorData{
+			Error:   "invalid_state",
+			Message: "Invalid instance state",
+		}
+		c.JSON(400, errData)
+		return
+	}
+
 	inst.PreCommit()
 
 	inst.Name = dta.Name
 	inst.Comment = dta.Comment
+	inst.UserData = dta.UserData
+	inst.PreFreezeHook = dta.PreFreezeHook
+	inst.PostThawHook = dta.PostThawHook
 	inst.Vpc = dta.Vpc
 	inst.Subnet = dta.Subnet
 	if dta.State != "" {
@@ -127,16 +189,36 @@ func instancePut(c *gin.Context) {
 	inst.DeleteProtection = dta.DeleteProtection
 	inst.Memory = dta.Memory
 	inst.Processors = dta.Processors
+	inst.MaxMemory = dta.MaxMemory
+	inst.MaxProcessors = dta.MaxProcessors
 	inst.NetworkRoles = dta.NetworkRoles
+	inst.Adapters = dta.Adapters
 	inst.UsbDevices = dta.UsbDevices
 	inst.Vnc = dta.Vnc
+	inst.Firmware = dta.Firmware
+	inst.Tpm = dta.Tpm
+	inst.CpuModel = dta.CpuModel
+	inst.MachineType = dta.MachineType
+	inst.Sockets = dta.Sockets
+	inst.Cores = dta.Cores
+	inst.Threads = dta.Threads
+	inst.NestedVirt = dta.NestedVirt
+	inst.DedicatedCpus = dta.DedicatedCpus
+	inst.NumaLocal = dta.NumaLocal
+	inst.Hugepages = dta.Hugepages
 	inst.Domain = dta.Domain
+	inst.Placement = dta.Placement
+	inst.HighAvailability = dta.HighAvailability
+	inst.DrainPolicy = dta.DrainPolicy
 	inst.NoPublicAddress = dta.NoPublicAddress
 	inst.NoHostAddress = dta.NoHostAddress
 
 	fields := set.NewSet(
 		"name",
 		"comment",
+		"user_data",
+		"pre_freeze_hook",
+		"post_thaw_hook",
 		"vpc",
 		"subnet",
 		"state",
@@ -145,12 +227,29 @@ func instancePut(c *gin.Context) {
 		"delete_protection",
 		"memory",
 		"processors",
+		"max_memory",
+		"max_processors",
 		"network_roles",
+		"adapters",
 		"usb_devices",
 		"vnc",
 		"vnc_display",
 		"vnc_password",
+		"firmware",
+		"tpm",
+		"cpu_model",
+		"machine_type",
+		"sockets",
+		"cores",
+		"threads",
+		"nested_virt",
+		"dedicated_cpus",
+		"numa_local",
+		"hugepages",
 		"domain",
+		"placement",
+		"high_availability",
+		"drain_policy",
 		"no_public_address",
 		"no_host_address",
 	)
@@ -186,53 +285,146 @@ func instancePut(c *gin.Context) {
 	c.JSON(200, inst)
 }
 
-func instancePost(c *gin.Context) {
+func instanceMigratePut(c *gin.Context) {
 	if demo.Blocked(c) {
 		return
 	}
 
 	db := c.MustGet("db").(*database.Database)
 	userOrg := c.MustGet("organization").(primitive.ObjectID)
-	dta := &instanceData{
-		Name: "New Instance",
+
+	instanceId, ok := utils.ParseObjectId(c.Param("instance_id"))
+	if !ok {
+		utils.AbortWithStatus(c, 400)
+		return
 	}
 
-	err := c.Bind(dta)
+	inst, err := instance.GetOrg(db, userOrg, instanceId)
 	if err != nil {
-		err = &errortypes.ParseError{
-			errors.Wrap(err, "handler: Bind error"),
-		}
 		utils.AbortWithError(c, 500, err)
 		return
 	}
 
-	zne, err := zone.Get(db, dta.Zone)
+	curNde, err := node.Get(db, inst.Node)
 	if err != nil {
 		utils.AbortWithError(c, 500, err)
 		return
 	}
 
-	exists, err := datacenter.ExistsOrg(db, userOrg, zne.Datacenter)
+	nde, errData, err := scheduler.Schedule(db, &scheduler.Spec{
+		Organization:  inst.Organization,
+		Instance:      inst.Id,
+		Placement:     inst.Placement,
+		Hypervisor:    curNde.Hypervisor,
+		Zone:          inst.Zone,
+		Processors:    inst.Processors,
+		Memory:        inst.Memory,
+		UsbDevices:    inst.UsbDevices,
+		NetworkRoles:  inst.NetworkRoles,
+		DedicatedCpus: inst.DedicatedCpus,
+		Hugepages:     inst.Hugepages,
+		Exclude: []primitive.ObjectID{
+			inst.Node,
+		},
+	})
 	if err != nil {
 		utils.AbortWithError(c, 500, err)
 		return
 	}
-	if !exists {
-		utils.AbortWithStatus(c, 405)
+
+	if errData == nil {
+		errData, err = inst.Migrate(db, nde.Id)
+		if err != nil {
+			utils.AbortWithError(c, 500, err)
+			return
+		}
+	}
+
+	if errData != nil {
+		c.JSON(400, errData)
 		return
 	}
 
-	nde, err := node.Get(db, dta.Node)
+	err = inst.CommitFields(db, set.NewSet(
+		"state",
+		"migrate_node",
+		"migrate_addr",
+		"migrate_port",
+		"migrate_nbd_port",
+	))
 	if err != nil {
 		utils.AbortWithError(c, 500, err)
 		return
 	}
 
-	if nde.Zone != zne.Id {
+	event.PublishDispatch(db, "instance.change")
+
+	c.JSON(200, inst)
+}
+
+func instancePost(c *gin.Context) {
+	if demo.Blocked(c) {
+		return
+	}
+
+	db := c.MustGet("db").(*database.Database)
+	userOrg := c.MustGet("organization").(primitive.ObjectID)
+	dta := &instanceData{
+		Name: "New Instance",
+	}
+
+	err := c.Bind(dta)
+	if err != nil {
+		err = &errortypes.ParseError{
+			errors.Wrap(err, "handler: Bind error"),
+		}
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	dcId := dta.Datacenter
+	if !dta.Zone.IsZero() {
+		zne, err := zone.Get(db, dta.Zone)
+		if err != nil {
+			utils.AbortWithError(c, 500, err)
+			return
+		}
+
+		dcId = zne.Datacenter
+	}
+
+	exists, err := datacenter.ExistsOrg(db, userOrg, dcId)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+	if !exists {
 		utils.AbortWithStatus(c, 405)
 		return
 	}
 
+	if !dta.Node.IsZero() {
+		nde, err := node.Get(db, dta.Node)
+		if err != nil {
+			utils.AbortWithError(c, 500, err)
+			return
+		}
+
+		if dta.Zone.IsZero() || nde.Zone != dta.Zone {
+			utils.AbortWithStatus(c, 405)
+			return
+		}
+
+		if nde.Maintenance {
+			errData := &errortypes.ErrorData{
+				Error:   "node_maintenance",
+				Message: "Node is in maintenance",
+			}
+			c.JSON(400, errData)
+			return
+		}
+	}
+
 	exists, err = vpc.ExistsOrg(db, userOrg, dta.Vpc)
 	if err != nil {
 		utils.AbortWithError(c, 500, err)
@@ -243,6 +435,18 @@ func instancePost(c *gin.Context) {
 		return
 	}
 
+	for _, adapter := range dta.Adapters {
+		exists, err = vpc.ExistsOrg(db, userOrg, adapter.Vpc)
+		if err != nil {
+			utils.AbortWithError(c, 500, err)
+			return
+		}
+		if !exists {
+			utils.AbortWithStatus(c, 405)
+			return
+		}
+	}
+
 	if !dta.Domain.IsZero() {
 		exists, err := domain.ExistsOrg(db, userOrg, dta.Domain)
 		if err != nil {
@@ -255,6 +459,18 @@ func instancePost(c *gin.Context) {
 		}
 	}
 
+	if !dta.Placement.IsZero() {
+		exists, err := placement.ExistsOrg(db, userOrg, dta.Placement)
+		if err != nil {
+			utils.AbortWithError(c, 500, err)
+			return
+		}
+		if !exists {
+			utils.AbortWithStatus(c, 405)
+			return
+		}
+	}
+
 	img, err := image.GetOrgPublic(db, userOrg, dta.Image)
 	if err != nil {
 		if _, ok := err.(*database.NotFoundError); ok {
@@ -297,6 +513,10 @@ func instancePost(c *gin.Context) {
 		return
 	}
 
+	if dta.Firmware == "" {
+		dta.Firmware = img.Firmware
+	}
+
 	insts := []*instance.Instance{}
 
 	if dta.Count == 0 {
@@ -311,25 +531,76 @@ func instancePost(c *gin.Context) {
 			name = dta.Name
 		}
 
+		zneId := dta.Zone
+		ndeId := dta.Node
+		if ndeId.IsZero() {
+			nde, errData, err := scheduler.Schedule(db, &scheduler.Spec{
+				Organization:  userOrg,
+				Placement:     dta.Placement,
+				Strategy:      dta.Strategy,
+				Hypervisor:    dta.Hypervisor,
+				Datacenter:    dcId,
+				Zone:          dta.Zone,
+				Processors:    dta.Processors,
+				Memory:        dta.Memory,
+				UsbDevices:    dta.UsbDevices,
+				NetworkRoles:  dta.NetworkRoles,
+				DedicatedCpus: dta.DedicatedCpus,
+				Hugepages:     dta.Hugepages,
+			})
+			if err != nil {
+				utils.AbortWithError(c, 500, err)
+				return
+			}
+
+			if errData != nil {
+				c.JSON(400, errData)
+				return
+			}
+
+			zneId = nde.Zone
+			ndeId = nde.Id
+		}
+
 		inst := &instance.Instance{
 			State:            dta.State,
 			Organization:     userOrg,
-			Zone:             dta.Zone,
+			Zone:             zneId,
 			Vpc:              dta.Vpc,
 			Subnet:           dta.Subnet,
-			Node:             dta.Node,
+			Node:             ndeId,
 			Image:            dta.Image,
 			ImageBacking:     dta.ImageBacking,
 			DeleteProtection: dta.DeleteProtection,
 			Name:             name,
 			Comment:          dta.Comment,
+			UserData:         dta.UserData,
+			PreFreezeHook:    dta.PreFreezeHook,
+			PostThawHook:     dta.PostThawHook,
 			InitDiskSize:     dta.InitDiskSize,
 			Memory:           dta.Memory,
 			Processors:       dta.Processors,
+			MaxMemory:        dta.MaxMemory,
+			MaxProcessors:    dta.MaxProcessors,
 			NetworkRoles:     dta.NetworkRoles,
+			Adapters:         dta.Adapters,
 			UsbDevices:       dta.UsbDevices,
 			Vnc:              dta.Vnc,
+			Firmware:         dta.Firmware,
+			Tpm:              dta.Tpm,
+			CpuModel:         dta.CpuModel,
+			MachineType:      dta.MachineType,
+			Sockets:          dta.Sockets,
+			Cores:            dta.Cores,
+			Threads:          dta.Threads,
+			NestedVirt:       dta.NestedVirt,
+			DedicatedCpus:    dta.DedicatedCpus,
+			NumaLocal:        dta.NumaLocal,
+			Hugepages:        dta.Hugepages,
 			Domain:           dta.Domain,
+			Placement:        dta.Placement,
+			HighAvailability: dta.HighAvailability,
+			DrainPolicy:      dta.DrainPolicy,
 			NoPublicAddress:  dta.NoPublicAddress,
 			NoHostAddress:    dta.NoHostAddress,
 		}
@@ -381,6 +652,15 @@ func instancesPut(c *gin.Context) {
 		return
 	}
 
+	if dta.State == instance.Migrate {
+		errData := &errortypes.ErrorData{
+			Error:   "invalid_state",
+			Message: "Invalid instance state",
+		}
+		c.JSON(400, errData)
+		return
+	}
+
 	doc := bson.M{
 		"state": dta.State,
 	}
@@ -507,6 +787,35 @@ func instanceGet(c *gin.Context) {
 	c.JSON(200, inst)
 }
 
+func instanceMetricsGet(c *gin.Context) {
+	db := c.MustGet("db").(*database.Database)
+	userOrg := c.MustGet("organization").(primitive.ObjectID)
+
+	instanceId, ok := utils.ParseObjectId(c.Param("instance_id"))
+	if !ok {
+		utils.AbortWithStatus(c, 400)
+		return
+	}
+
+	exists, err := instance.ExistsOrg(db, userOrg, instanceId)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+	if !exists {
+		utils.AbortWithStatus(c, 405)
+		return
+	}
+
+	metrics, err := metric.GetInstance(db, instanceId, c.Query("period"))
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	c.JSON(200, metrics)
+}
+
 func instancesGet(c *gin.Context) {
 	db := c.MustGet("db").(*database.Database)
 	userOrg := c.MustGet("organization").(primitive.ObjectID)
@@ -18,14 +18,17 @@ import (
 )
 
 type vpcData struct {
-	Id         primitive.ObjectID `json:"id"`
-	Name       string             `json:"name"`
-	Comment    string             `json:"comment"`
-	Network    string             `json:"network"`
-	Subnets    []*vpc.Subnet      `json:"subnets"`
-	Datacenter primitive.ObjectID `json:"datacenter"`
-	Routes     []*vpc.Route       `json:"routes"`
-	LinkUris   []string           `json:"link_uris"`
+	Id            primitive.ObjectID `json:"id"`
+	Name          string             `json:"name"`
+	Comment       string             `json:"comment"`
+	Network       string             `json:"network"`
+	Subnets       []*vpc.Subnet      `json:"subnets"`
+	Datacenter    primitive.ObjectID `json:"datacenter"`
+	Routes        []*vpc.Route       `json:"routes"`
+	DnsServers    []string           `json:"dns_servers"`
+	SearchDomains []string           `json:"search_domains"`
+	NtpServers    []string           `json:"ntp_servers"`
+	LinkUris      []string           `json:"link_uris"`
 }
 
 type vpcsData struct {
@@ -80,6 +83,9 @@ func vpcPut(c *gin.Context) {
 	vc.Name = data.Name
 	vc.Comment = data.Comment
 	vc.Routes = data.Routes
+	vc.DnsServers = data.DnsServers
+	vc.SearchDomains = data.SearchDomains
+	vc.NtpServers = data.NtpServers
 	vc.Subnets = data.Subnets
 	vc.LinkUris = data.LinkUris
 
@@ -87,6 +93,9 @@ func vpcPut(c *gin.Context) {
 		"name",
 		"comment",
 		"routes",
+		"dns_servers",
+		"search_domains",
+		"ntp_servers",
 		"subnets",
 		"link_uris",
 	)
@@ -154,14 +163,17 @@ func vpcPost(c *gin.Context) {
 	}
 
 	vc := &vpc.Vpc{
-		Name:         data.Name,
-		Comment:      data.Comment,
-		Network:      data.Network,
-		Subnets:      data.Subnets,
-		Organization: userOrg,
-		Datacenter:   data.Datacenter,
-		Routes:       data.Routes,
-		LinkUris:     data.LinkUris,
+		Name:          data.Name,
+		Comment:       data.Comment,
+		Network:       data.Network,
+		Subnets:       data.Subnets,
+		Organization:  userOrg,
+		Datacenter:    data.Datacenter,
+		Routes:        data.Routes,
+		DnsServers:    data.DnsServers,
+		SearchDomains: data.SearchDomains,
+		NtpServers:    data.NtpServers,
+		LinkUris:      data.LinkUris,
 	}
 
 	vc.InitVpc()
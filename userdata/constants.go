@@ -0,0 +1,10 @@
+package userdata
+
+const (
+	MaxSize = 65536
+
+	CloudConfig = "text/cloud-config"
+	ShellScript = "text/x-shellscript"
+	Boothook    = "text/cloud-boothook"
+	IncludeUrl  = "text/x-include-url"
+)
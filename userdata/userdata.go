@@ -0,0 +1,199 @@
+package userdata
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"text/template"
+
+	"github.com/dropbox/godropbox/container/set"
+	"github.com/dropbox/godropbox/errors"
+	"github.com/pritunl/pritunl-cloud/errortypes"
+)
+
+var contentTypes = set.NewSet(
+	CloudConfig,
+	ShellScript,
+	Boothook,
+	IncludeUrl,
+)
+
+type Part struct {
+	ContentType string
+	Content     string
+}
+
+type TemplateData struct {
+	InstanceId   string
+	Name         string
+	Hostname     string
+	Organization string
+	PrivateIp    string
+	PrivateIp6   string
+	PublicIp     string
+	PublicIp6    string
+}
+
+func parseMultipart(data string) (parts []*Part, err error) {
+	msg, err := mail.ReadMessage(strings.NewReader(data))
+	if err != nil {
+		err = &errortypes.ParseError{
+			errors.Wrap(err, "userdata: Failed to parse MIME message"),
+		}
+		return
+	}
+
+	mediaType, params, err := mime.ParseMediaType(
+		msg.Header.Get("Content-Type"))
+	if err != nil {
+		err = &errortypes.ParseError{
+			errors.Wrap(err, "userdata: Failed to parse MIME content type"),
+		}
+		return
+	}
+
+	if !strings.HasPrefix(mediaType, "multipart/") ||
+		params["boundary"] == "" {
+
+		err = &errortypes.ParseError{
+			errors.New("userdata: MIME message must be multipart"),
+		}
+		return
+	}
+
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, e := reader.NextPart()
+		if e != nil {
+			if e == io.EOF {
+				break
+			}
+
+			err = &errortypes.ParseError{
+				errors.Wrap(e, "userdata: Failed to read MIME part"),
+			}
+			return
+		}
+
+		partType, _, e := mime.ParseMediaType(
+			part.Header.Get("Content-Type"))
+		if e != nil {
+			err = &errortypes.ParseError{
+				errors.Wrap(e, "userdata: Failed to parse MIME part type"),
+			}
+			return
+		}
+
+		if !contentTypes.Contains(partType) {
+			err = &errortypes.ParseError{
+				errors.Newf("userdata: Unsupported MIME part type '%s'",
+					partType),
+			}
+			return
+		}
+
+		content, e := ioutil.ReadAll(part)
+		if e != nil {
+			err = &errortypes.ParseError{
+				errors.Wrap(e, "userdata: Failed to read MIME part"),
+			}
+			return
+		}
+
+		encoding := part.Header.Get("Content-Transfer-Encoding")
+		if strings.ToLower(encoding) == "base64" {
+			content, e = base64.StdEncoding.DecodeString(
+				strings.Join(strings.Fields(string(content)), ""))
+			if e != nil {
+				err = &errortypes.ParseError{
+					errors.Wrap(e, "userdata: Failed to decode MIME part"),
+				}
+				return
+			}
+		}
+
+		parts = append(parts, &Part{
+			ContentType: partType,
+			Content:     string(content),
+		})
+	}
+
+	if len(parts) == 0 {
+		err = &errortypes.ParseError{
+			errors.New("userdata: MIME message is empty"),
+		}
+		return
+	}
+
+	return
+}
+
+func Parse(data string) (parts []*Part, err error) {
+	parts = []*Part{}
+
+	if data == "" {
+		return
+	}
+
+	if len(data) > MaxSize {
+		err = &errortypes.ParseError{
+			errors.New("userdata: User data too large"),
+		}
+		return
+	}
+
+	switch {
+	case strings.HasPrefix(data, "#cloud-config"):
+		parts = append(parts, &Part{
+			ContentType: CloudConfig,
+			Content:     data,
+		})
+	case strings.HasPrefix(data, "#!"):
+		parts = append(parts, &Part{
+			ContentType: ShellScript,
+			Content:     data,
+		})
+	case strings.HasPrefix(strings.ToLower(data), "content-type:") ||
+		strings.HasPrefix(strings.ToLower(data), "mime-version:"):
+
+		parts, err = parseMultipart(data)
+		if err != nil {
+			return
+		}
+	default:
+		err = &errortypes.ParseError{
+			errors.New("userdata: Unknown user data format"),
+		}
+		return
+	}
+
+	return
+}
+
+func Render(tmpl string, data *TemplateData) (output string, err error) {
+	templ, err := template.New("vendor").Parse(tmpl)
+	if err != nil {
+		err = &errortypes.ParseError{
+			errors.Wrap(err, "userdata: Failed to parse template"),
+		}
+		return
+	}
+
+	buffer := &bytes.Buffer{}
+	err = templ.Execute(buffer, data)
+	if err != nil {
+		err = &errortypes.ParseError{
+			errors.Wrap(err, "userdata: Failed to exec template"),
+		}
+		return
+	}
+
+	output = buffer.String()
+
+	return
+}
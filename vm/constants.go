@@ -1,5 +1,9 @@
 package vm
 
+import (
+	"github.com/dropbox/godropbox/container/set"
+)
+
 const (
 	Starting     = "starting"
 	Running      = "running"
@@ -9,4 +13,18 @@ const (
 	Provisioning = "provisioning"
 	Bridge       = "bridge"
 	Vxlan        = "vxlan"
+
+	Bios       = "bios"
+	Uefi       = "uefi"
+	UefiSecure = "uefi_secure"
+
+	CpuHost = "host"
+)
+
+var (
+	ValidFirmwares = set.NewSet(
+		Bios,
+		Uefi,
+		UefiSecure,
+	)
 )
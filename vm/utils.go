@@ -122,6 +122,10 @@ func GetIfaceVirt(id primitive.ObjectID, n int) string {
 	return fmt.Sprintf("v%s%d", strings.ToLower(hashSum), n)
 }
 
+func GetIfaceVirtAdapter(id primitive.ObjectID, n int) string {
+	return GetIfaceVirt(id, n+3)
+}
+
 func GetIfaceExternal(id primitive.ObjectID, n int) string {
 	hash := md5.New()
 	hash.Write([]byte(id.Hex()))
@@ -17,8 +17,27 @@ type VirtualMachine struct {
 	Image           primitive.ObjectID `json:"image"`
 	Processors      int                `json:"processors"`
 	Memory          int                `json:"memory"`
+	MaxProcessors   int                `json:"max_processors"`
+	MaxMemory       int                `json:"max_memory"`
+	Hotplugged      bool               `json:"hotplugged,omitempty"`
 	Vnc             bool               `json:"vnc"`
 	VncDisplay      int                `json:"vnc_display"`
+	Firmware        string             `json:"firmware"`
+	Tpm             bool               `json:"tpm"`
+	CpuModel        string             `json:"cpu_model"`
+	MachineType     string             `json:"machine_type"`
+	Sockets         int                `json:"sockets"`
+	Cores           int                `json:"cores"`
+	Threads         int                `json:"threads"`
+	NestedVirt      bool               `json:"nested_virt"`
+	DedicatedCpus   bool               `json:"dedicated_cpus"`
+	NumaLocal       bool               `json:"numa_local"`
+	Hugepages       bool               `json:"hugepages"`
+	NetworkRateIn   int                `json:"network_rate_in"`
+	NetworkRateOut  int                `json:"network_rate_out"`
+	PinnedCpus      []int              `json:"pinned_cpus,omitempty"`
+	SharedCpus      []int              `json:"shared_cpus,omitempty"`
+	NumaNode        int                `json:"numa_node"`
 	Disks           []*Disk            `json:"disks"`
 	NetworkAdapters []*NetworkAdapter  `json:"network_adapters"`
 	NoPublicAddress bool               `json:"no_public_address"`
@@ -27,8 +46,10 @@ type VirtualMachine struct {
 }
 
 type Disk struct {
-	Index int    `json:"index"`
-	Path  string `json:"path"`
+	Index          int    `json:"index"`
+	Path           string `json:"path"`
+	IopsLimit      int    `json:"iops_limit"`
+	BandwidthLimit int    `json:"bandwidth_limit"`
 }
 
 type UsbDevice struct {
@@ -127,3 +148,22 @@ func (v *VirtualMachine) CommitState(db *database.Database, state string) (
 
 	return
 }
+
+func (v *VirtualMachine) GetFirmware() string {
+	if v.Firmware == "" {
+		return Bios
+	}
+	return v.Firmware
+}
+
+func (v *VirtualMachine) IsUefi() bool {
+	firmware := v.GetFirmware()
+	return firmware == Uefi || firmware == UefiSecure
+}
+
+func (v *VirtualMachine) GetCpuModel() string {
+	if v.CpuModel == "" {
+		return CpuHost
+	}
+	return v.CpuModel
+}
@@ -4,3 +4,16 @@ const (
 	Instance = "instance"
 	Gateway  = "gateway"
 )
+
+const (
+	MaxDnsServers    = 3
+	MaxSearchDomains = 6
+	MaxNtpServers    = 4
+)
+
+var (
+	DefaultDnsServers = []string{
+		"8.8.8.8",
+		"8.8.4.4",
+	}
+)
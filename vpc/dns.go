@@ -0,0 +1,144 @@
+package vpc
+
+import (
+	"net"
+	"regexp"
+	"strings"
+
+	"github.com/pritunl/mongo-go-driver/bson/primitive"
+	"github.com/pritunl/pritunl-cloud/errortypes"
+)
+
+var hostnameRe = regexp.MustCompile(
+	`^([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)*` +
+		`[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+func validateDns(dnsServers, searchDomains, ntpServers []string) (
+	dnsServersNew, searchDomainsNew, ntpServersNew []string,
+	errData *errortypes.ErrorData) {
+
+	dnsServersNew = []string{}
+	searchDomainsNew = []string{}
+	ntpServersNew = []string{}
+
+	for _, dnsServer := range dnsServers {
+		dnsServer = strings.TrimSpace(dnsServer)
+		if dnsServer == "" {
+			continue
+		}
+
+		addr := net.ParseIP(dnsServer)
+		if addr == nil {
+			errData = &errortypes.ErrorData{
+				Error:   "dns_server_invalid",
+				Message: "DNS server must be an IP address",
+			}
+			return
+		}
+
+		dnsServersNew = append(dnsServersNew, addr.String())
+	}
+
+	if len(dnsServersNew) > MaxDnsServers {
+		errData = &errortypes.ErrorData{
+			Error:   "dns_servers_limit",
+			Message: "Too many DNS servers",
+		}
+		return
+	}
+
+	for _, domain := range searchDomains {
+		domain = strings.Trim(
+			strings.ToLower(strings.TrimSpace(domain)), ".")
+		if domain == "" {
+			continue
+		}
+
+		if len(domain) > 253 || !hostnameRe.MatchString(domain) {
+			errData = &errortypes.ErrorData{
+				Error:   "search_domain_invalid",
+				Message: "Search domain invalid",
+			}
+			return
+		}
+
+		searchDomainsNew = append(searchDomainsNew, domain)
+	}
+
+	if len(searchDomainsNew) > MaxSearchDomains {
+		errData = &errortypes.ErrorData{
+			Error:   "search_domains_limit",
+			Message: "Too many search domains",
+		}
+		return
+	}
+
+	for _, ntpServer := range ntpServers {
+		ntpServer = strings.ToLower(strings.TrimSpace(ntpServer))
+		if ntpServer == "" {
+			continue
+		}
+
+		addr := net.ParseIP(ntpServer)
+		if addr != nil {
+			ntpServer = addr.String()
+		} else if len(ntpServer) > 253 || !hostnameRe.MatchString(ntpServer) {
+			errData = &errortypes.ErrorData{
+				Error:   "ntp_server_invalid",
+				Message: "NTP server must be an IP address or hostname",
+			}
+			return
+		}
+
+		ntpServersNew = append(ntpServersNew, ntpServer)
+	}
+
+	if len(ntpServersNew) > MaxNtpServers {
+		errData = &errortypes.ErrorData{
+			Error:   "ntp_servers_limit",
+			Message: "Too many NTP servers",
+		}
+		return
+	}
+
+	return
+}
+
+func (v *Vpc) GetDnsServers(subnetId primitive.ObjectID) []string {
+	sub := v.GetSubnet(subnetId)
+	if sub != nil && len(sub.DnsServers) > 0 {
+		return sub.DnsServers
+	}
+
+	if len(v.DnsServers) > 0 {
+		return v.DnsServers
+	}
+
+	return DefaultDnsServers
+}
+
+func (v *Vpc) GetSearchDomains(subnetId primitive.ObjectID) []string {
+	sub := v.GetSubnet(subnetId)
+	if sub != nil && len(sub.SearchDomains) > 0 {
+		return sub.SearchDomains
+	}
+
+	if v.SearchDomains != nil {
+		return v.SearchDomains
+	}
+
+	return []string{}
+}
+
+func (v *Vpc) GetNtpServers(subnetId primitive.ObjectID) []string {
+	sub := v.GetSubnet(subnetId)
+	if sub != nil && len(sub.NtpServers) > 0 {
+		return sub.NtpServers
+	}
+
+	if v.NtpServers != nil {
+		return v.NtpServers
+	}
+
+	return []string{}
+}
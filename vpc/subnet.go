@@ -10,9 +10,12 @@ import (
 )
 
 type Subnet struct {
-	Id      primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	Name    string             `bson:"name" json:"name"`
-	Network string             `bson:"network" json:"network"`
+	Id            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name          string             `bson:"name" json:"name"`
+	Network       string             `bson:"network" json:"network"`
+	DnsServers    []string           `bson:"dns_servers" json:"dns_servers"`
+	SearchDomains []string           `bson:"search_domains" json:"search_domains"`
+	NtpServers    []string           `bson:"ntp_servers" json:"ntp_servers"`
 }
 
 func (s *Subnet) GetNetwork() (network *net.IPNet, err error) {
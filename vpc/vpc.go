@@ -38,6 +38,9 @@ type Vpc struct {
 	Organization  primitive.ObjectID `bson:"organization" json:"organization"`
 	Datacenter    primitive.ObjectID `bson:"datacenter" json:"datacenter"`
 	Routes        []*Route           `bson:"routes" json:"routes"`
+	DnsServers    []string           `bson:"dns_servers" json:"dns_servers"`
+	SearchDomains []string           `bson:"search_domains" json:"search_domains"`
+	NtpServers    []string           `bson:"ntp_servers" json:"ntp_servers"`
 	LinkUris      []string           `bson:"link_uris" json:"link_uris"`
 	LinkNode      primitive.ObjectID `bson:"link_node,omitempty" json:"link_node"`
 	LinkTimestamp time.Time          `bson:"link_timestamp" json:"link_timestamp"`
@@ -133,6 +136,16 @@ func (v *Vpc) Validate(db *database.Database) (
 
 		sub.Network = subNetwork.String()
 
+		dnsServers, searchDomains, ntpServers, dnsErrData := validateDns(
+			sub.DnsServers, sub.SearchDomains, sub.NtpServers)
+		if dnsErrData != nil {
+			errData = dnsErrData
+			return
+		}
+		sub.DnsServers = dnsServers
+		sub.SearchDomains = searchDomains
+		sub.NtpServers = ntpServers
+
 		if !utils.NetworkContains(network, subNetwork) {
 			errData = &errortypes.ErrorData{
 				Error:   "subnet_network_range_invalid",
@@ -189,6 +202,12 @@ func (v *Vpc) Validate(db *database.Database) (
 		v.Routes = []*Route{}
 	}
 
+	v.DnsServers, v.SearchDomains, v.NtpServers, errData = validateDns(
+		v.DnsServers, v.SearchDomains, v.NtpServers)
+	if errData != nil {
+		return
+	}
+
 	if v.LinkUris == nil {
 		v.LinkUris = []string{}
 	}